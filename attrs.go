@@ -0,0 +1,84 @@
+package rula
+
+import "fmt"
+
+// An AttrCondition requires an attribute of the agent a rule is run for, or
+// of an agent related to it, to have a value, or with Negate not to have it.
+// An agent without the attribute has the empty value.
+type AttrCondition struct {
+	Relation Relation `json:"relation"`
+	Attr     string   `json:"attr"`
+	Value    string   `json:"value"`
+	Negate   bool     `json:"negate,omitempty"`
+}
+
+func (c AttrCondition) String() string {
+	op := "="
+	if c.Negate {
+		op = "!="
+	}
+	return fmt.Sprintf("%s %s %s %s", c.Relation, c.Attr, op, c.Value)
+}
+
+// holds reports whether the condition holds for value.
+func (c AttrCondition) holds(value string) bool {
+	return (value == c.Value) != c.Negate
+}
+
+// SetAttr sets an attribute of the agent, such as its culture or profession.
+// Attributes describe traits that are not quantities and can be tested by
+// rules with the ifattr directive.
+func (a *Agent) SetAttr(name, value string) {
+	if a.Attrs == nil {
+		a.Attrs = map[string]string{}
+	}
+	a.Attrs[name] = value
+}
+
+// Attr returns the value of an attribute of the agent and whether it is set.
+func (a *Agent) Attr(name string) (string, bool) {
+	v, ok := a.Attrs[name]
+	return v, ok
+}
+
+// related returns the agent related to a by r, resolved as FillRuleContext
+// does, or nil if there is none. A global rule run for each agent has that
+// agent as the context's agent, so each refers to a itself.
+func (a *Agent) related(r Relation) *Agent {
+	if a == nil {
+		return nil
+	}
+	if r == RelationSelf || r == RelationEach {
+		return a
+	}
+	if ra, ok := a.Relations[r]; ok {
+		return ra
+	}
+	if fn, ok := a.RelationFuncs[r]; ok {
+		return fn()
+	}
+	if r == RelationParent {
+		return a.Parent
+	}
+	return nil
+}
+
+// attrValue returns the value of the attribute tested by c in ctx.
+func attrValue(c AttrCondition, ctx RuleContext) string {
+	if ra := ctx.Agent.related(c.Relation); ra != nil {
+		return ra.Attrs[c.Attr]
+	}
+	return ""
+}
+
+// attrBlock returns a Block for the first attribute condition of rule that
+// does not hold in ctx, or nil if they all hold. Attributes are not changed
+// by rules so they are checked once before the rule's rounds.
+func attrBlock(rule *Rule, ctx RuleContext) *Block {
+	for i, c := range rule.AttrConditions {
+		if v := attrValue(c, ctx); !c.holds(v) {
+			return &Block{Attr: &rule.AttrConditions[i], Value: v}
+		}
+	}
+	return nil
+}
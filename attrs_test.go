@@ -0,0 +1,73 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttrConditions(t *testing.T) {
+	rules, err := NewRuleParser([]*Resource{iron}).Parse(strings.NewReader(`
+rule raid
+	ifattr culture = norse
+	ifattr lord faith != pagan
+	out iron 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p := NewAgentParser([]*Resource{iron}, map[string][]*Rule{"raiders": rules})
+	agents, err := p.Parse(strings.NewReader(`
+agent earl
+	attr faith christian
+end
+
+agent ulf
+	attr culture norse
+	pool iron 10
+	relation lord earl
+	rules raiders
+end
+
+agent edwin
+	attr culture saxon
+	pool iron 10
+	relation lord earl
+	rules raiders
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ulf, edwin := agents[1], agents[2]
+	if v, ok := ulf.Attr("culture"); !ok || v != "norse" {
+		t.Errorf("got culture %q, wanted norse", v)
+	}
+
+	runner := NewRunner()
+	for _, a := range []*Agent{ulf, edwin} {
+		if _, err := runner.Run(a.Rules, 1, a.RuleContext()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := ulf.Pools.Quantity(iron); got != 1 {
+		t.Errorf("got norse iron %d, wanted 1", got)
+	}
+	if got := edwin.Pools.Quantity(iron); got != 0 {
+		t.Errorf("got saxon iron %d, wanted 0", got)
+	}
+
+	res, err := runner.RunRule(rules[0], 2, edwin.RuleContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `attribute self culture = norse not met, found "saxon"`; res.Blocked == nil || res.Blocked.String() != want {
+		t.Errorf("got block %v, wanted %s", res.Blocked, want)
+	}
+
+	agents[0].SetAttr("faith", "pagan")
+	if ex := runner.Explain(rules[0], ulf.RuleContext()); ex.CanRun {
+		t.Errorf("got rule can run for pagan lord, wanted it blocked:\n%s", ex)
+	}
+}
@@ -0,0 +1,144 @@
+package rula
+
+// A RuleBuilder constructs a rule with the same defaults as the rule parser.
+//
+//	smelt := NewRule("smelt").In(RelationSelf, ironOre, 3).Out(RelationSelf, iron, 1).Every(5).Build()
+type RuleBuilder struct {
+	rule Rule
+}
+
+// NewRule starts building a rule with the given name that runs every tick.
+func NewRule(name string) *RuleBuilder {
+	return &RuleBuilder{
+		rule: Rule{
+			Name:   name,
+			Period: 1,
+		},
+	}
+}
+
+// If adds a precondition that must hold before any inputs are consumed.
+func (b *RuleBuilder) If(rel Relation, r *Resource, op Op, q int) *RuleBuilder {
+	b.rule.Preconditions = append(b.rule.Preconditions, ResourceCondition{
+		ResourceSpecifier: ResourceSpecifier{Relation: rel, Resource: r, Quantity: q},
+		Op:                op,
+	})
+	return b
+}
+
+// IfNear adds a condition that the related agent is at a location within a
+// distance of the agent's location.
+func (b *RuleBuilder) IfNear(rel Relation, within Length) *RuleBuilder {
+	b.rule.NearConditions = append(b.rule.NearConditions, NearCondition{Relation: rel, Within: within})
+	return b
+}
+
+// IfAttr adds a condition that an attribute of the related agent has a value.
+func (b *RuleBuilder) IfAttr(rel Relation, attr, value string) *RuleBuilder {
+	b.rule.AttrConditions = append(b.rule.AttrConditions, AttrCondition{Relation: rel, Attr: attr, Value: value})
+	return b
+}
+
+// IfFunc adds a custom condition that must hold for the rule to run.
+func (b *RuleBuilder) IfFunc(c Condition) *RuleBuilder {
+	b.rule.CustomConditions = append(b.rule.CustomConditions, c)
+	return b
+}
+
+// Do adds a custom effect applied once the rule fires.
+func (b *RuleBuilder) Do(e Effect) *RuleBuilder {
+	b.rule.CustomEffects = append(b.rule.CustomEffects, e)
+	return b
+}
+
+// In adds an input that is consumed when the rule runs.
+func (b *RuleBuilder) In(rel Relation, r *Resource, q int) *RuleBuilder {
+	b.rule.Inputs = append(b.rule.Inputs, ResourceSpecifier{Relation: rel, Resource: r, Quantity: q})
+	return b
+}
+
+// Out adds an output that alters a resource by q when the rule runs.
+func (b *RuleBuilder) Out(rel Relation, r *Resource, q int) *RuleBuilder {
+	b.rule.Outputs = append(b.rule.Outputs, ResourceSpecifier{Relation: rel, Resource: r, Quantity: q})
+	return b
+}
+
+// Set adds an effect that sets a resource to q when the rule runs.
+func (b *RuleBuilder) Set(rel Relation, r *Resource, q int) *RuleBuilder {
+	b.rule.Sets = append(b.rule.Sets, ResourceSpecifier{Relation: rel, Resource: r, Quantity: q})
+	return b
+}
+
+// Every sets the number of ticks between invocations of the rule. A period of
+// 0 prevents the rule running automatically.
+func (b *RuleBuilder) Every(ticks int) *RuleBuilder {
+	b.rule.Period = ticks
+	return b
+}
+
+// Repeat sets the number of additional times the rule attempts to run on
+// each invocation.
+func (b *RuleBuilder) Repeat(count int) *RuleBuilder {
+	b.rule.Repeat = count
+	b.rule.RepeatFrom = nil
+	return b
+}
+
+// RepeatUsing sets the number of times the rule attempts to run on each
+// invocation to the quantity of a resource.
+func (b *RuleBuilder) RepeatUsing(rel Relation, r *Resource) *RuleBuilder {
+	b.rule.Repeat = 0
+	b.rule.RepeatFrom = &ResourceSource{Relation: rel, Resource: r}
+	return b
+}
+
+// Chance sets the percentage chance that the rule runs each time it is due.
+func (b *RuleBuilder) Chance(percent int) *RuleBuilder {
+	b.rule.Chance = percent
+	return b
+}
+
+// Overflow sets what happens to output that does not fit in its pool.
+func (b *RuleBuilder) Overflow(o Overflow) *RuleBuilder {
+	b.rule.Overflow = &o
+	return b
+}
+
+// OnFail sets the rule to run if the preconditions or inputs of the first
+// round are not satisfied.
+func (b *RuleBuilder) OnFail(r *Rule) *RuleBuilder {
+	b.rule.OnFail = r
+	return b
+}
+
+// Manual marks the rule as one that is only triggered manually, and stops it
+// running automatically.
+func (b *RuleBuilder) Manual() *RuleBuilder {
+	b.rule.Manual = true
+	b.rule.Period = 0
+	return b
+}
+
+// Build returns the rule. The builder may continue to be used and later
+// calls to Build return independent rules.
+func (b *RuleBuilder) Build() *Rule {
+	r := b.rule
+	r.Preconditions = append([]ResourceCondition(nil), b.rule.Preconditions...)
+	r.AttrConditions = append([]AttrCondition(nil), b.rule.AttrConditions...)
+	r.NearConditions = append([]NearCondition(nil), b.rule.NearConditions...)
+	r.Inputs = append([]ResourceSpecifier(nil), b.rule.Inputs...)
+	r.Outputs = append([]ResourceSpecifier(nil), b.rule.Outputs...)
+	r.Sets = append([]ResourceSpecifier(nil), b.rule.Sets...)
+	r.Exchanges = append([]Exchange(nil), b.rule.Exchanges...)
+	r.CustomConditions = append([]Condition(nil), b.rule.CustomConditions...)
+	r.CustomEffects = append([]Effect(nil), b.rule.CustomEffects...)
+	if b.rule.RepeatFrom != nil {
+		rf := *b.rule.RepeatFrom
+		r.RepeatFrom = &rf
+	}
+	if b.rule.Overflow != nil {
+		o := *b.rule.Overflow
+		r.Overflow = &o
+	}
+	return &r
+}
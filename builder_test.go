@@ -0,0 +1,65 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuleBuilder(t *testing.T) {
+	spec := `
+rule idle
+	every 0
+	set workers 0
+end
+
+rule smelt
+	if global iron_ore > 6
+	in iron_ore 3
+	out location iron 1
+	every 5
+	repeat using workers
+	onfail idle
+end
+`
+	parsed, err := NewRuleParser([]*Resource{ironOre, iron, workers}).Parse(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	idle := NewRule("idle").Every(0).Set(RelationSelf, workers, 0).Build()
+	smelt := NewRule("smelt").
+		If(RelationGlobal, ironOre, OpGreaterThan, 6).
+		In(RelationSelf, ironOre, 3).
+		Out(RelationLocation, iron, 1).
+		Every(5).
+		RepeatUsing(RelationSelf, workers).
+		OnFail(idle).
+		Build()
+
+	if diff := cmp.Diff(parsed, []*Rule{idle, smelt}); diff != "" {
+		t.Errorf("Build() mismatch (-parsed +built):\n%s", diff)
+	}
+}
+
+func TestRuleBuilderIndependentBuilds(t *testing.T) {
+	b := NewRule("mine").Out(RelationSelf, ironOre, 1)
+	first := b.Build()
+	second := b.Out(RelationSelf, ironOre, 2).Build()
+
+	if len(first.Outputs) != 1 || len(second.Outputs) != 2 {
+		t.Errorf("got %d and %d outputs, wanted 1 and 2", len(first.Outputs), len(second.Outputs))
+	}
+
+	b = NewRule("mine").
+		IfFunc(ConditionFunc(func(RuleContext) bool { return true })).
+		Do(EffectFunc(func(RuleContext, int) error { return nil }))
+	first = b.Build()
+	first.CustomConditions[0] = nil
+	first.CustomEffects[0] = nil
+	second = b.Build()
+	if second.CustomConditions[0] == nil || second.CustomEffects[0] == nil {
+		t.Errorf("changing the custom conditions or effects of one built rule changed another")
+	}
+}
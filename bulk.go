@@ -0,0 +1,73 @@
+package rula
+
+// bulkRounds returns the number of rounds, up to rounds, of rule that can be
+// staged in tx as a single batch. It returns 0 unless the rule has more than
+// one round to run and the effect of running it n times is the effect of one
+// round multiplied by n, which requires that no pool is used by more than one
+// of the rule's inputs and outputs, that the preconditions do not depend on
+// those pools, that the rule sets no resources and that no output has a carry
+// limit.
+func bulkRounds(tx *txn, rule *Rule, ctx RuleContext, rounds int) int {
+	if rounds < 2 || len(rule.Sets) > 0 {
+		return 0
+	}
+
+	used := map[txnKey]bool{}
+	use := func(rel Relation, r *Resource) (*PoolSet, bool) {
+		ps, ok := ctx.Pools[rel]
+		if !ok {
+			return nil, false
+		}
+		key := keyFor(ps, r)
+		if used[key] {
+			return nil, false
+		}
+		used[key] = true
+		return ps, true
+	}
+
+	n := rounds
+	for _, in := range rule.Inputs {
+		ps, ok := use(in.Relation, in.Resource)
+		if !ok || in.Quantity < 0 {
+			return 0
+		}
+		if in.Quantity > 0 && !ps.AllowsNegative(in.Resource) {
+			if avail := (tx.quantity(ps, in.Resource) - ps.reserved(in.Resource)) / in.Quantity; avail < n {
+				n = avail
+			}
+		}
+	}
+	for _, out := range rule.Outputs {
+		// The carry limit must be checked after each round
+		if ps, ok := use(out.Relation, out.Resource); !ok || ps.hasCarryLimit() {
+			return 0
+		}
+	}
+
+	for _, c := range rule.Preconditions {
+		ps, ok := ctx.Pools[c.Relation]
+		if !ok || used[keyFor(ps, c.Resource)] {
+			return 0
+		}
+		if !c.Op.holds(tx.quantity(ps, c.Resource), c.Quantity) {
+			return 0
+		}
+	}
+
+	return n
+}
+
+// applyBulk stages n rounds of rule in tx. The rule must have been checked
+// with bulkRounds. Outputs are clamped to capacity once, which has the same
+// result as clamping after each round.
+func applyBulk(tx *txn, rule *Rule, ctx RuleContext, n int) {
+	for _, in := range rule.Inputs {
+		ps := ctx.Pools[in.Relation]
+		tx.remove(in.Relation, ps, in.Resource, in.Quantity*n)
+	}
+	for _, out := range rule.Outputs {
+		ps := ctx.Pools[out.Relation]
+		tx.add(out.Relation, ps, out.Resource, out.Quantity*n)
+	}
+}
@@ -0,0 +1,124 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBulkRounds(t *testing.T) {
+	testCases := []struct {
+		name  string
+		rule  *RuleBuilder
+		bulk  bool // whether the rule is eligible for bulk application
+		total int  // rounds expected to run
+	}{
+		{
+			name:  "limited by input",
+			rule:  NewRule("smelt").In(RelationSelf, ironOre, 3).Out(RelationSelf, iron, 1),
+			bulk:  true,
+			total: 6,
+		},
+		{
+			name:  "output clamped",
+			rule:  NewRule("dig").Out(RelationSelf, iron, 4).In(RelationGlobal, workers, 1),
+			bulk:  true,
+			total: 20,
+		},
+		{
+			name:  "precondition on other pool",
+			rule:  NewRule("smelt").If(RelationGlobal, iron, OpLessThan, 5).In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1),
+			bulk:  true,
+			total: 10,
+		},
+		{
+			name:  "precondition on used pool",
+			rule:  NewRule("smelt").If(RelationSelf, iron, OpLessThan, 5).In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1),
+			total: 5,
+		},
+		{
+			name:  "same pool in and out",
+			rule:  NewRule("churn").In(RelationSelf, ironOre, 2).Out(RelationSelf, ironOre, 1),
+			total: 19,
+		},
+		{
+			name:  "sets",
+			rule:  NewRule("smelt").In(RelationSelf, ironOre, 2).Set(RelationSelf, workers, 1),
+			total: 10,
+		},
+	}
+
+	newCtx := func() RuleContext {
+		return RuleContext{Pools: map[Relation]*PoolSet{
+			RelationSelf: NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 100, Quantity: 20},
+				&Pool{Resource: iron, Capacity: 50, Quantity: 0},
+				&Pool{Resource: workers, Capacity: 10},
+			),
+			RelationGlobal: NewPoolSet(
+				&Pool{Resource: iron, Capacity: 100, Quantity: 4},
+				&Pool{Resource: workers, Capacity: 100, Quantity: 20},
+			),
+		}}
+	}
+	quantities := func(ctx RuleContext) map[string]int {
+		q := map[string]int{}
+		for rel, ps := range ctx.Pools {
+			for _, p := range ps.Pools() {
+				q[string(rel)+"."+p.Resource.String()] = p.Quantity
+			}
+		}
+		return q
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := tc.rule.Repeat(99).Build()
+			ctx := newCtx()
+			if n := bulkRounds(newTxn(rule, nil, ctx), rule, ctx, 100); (n > 1) != tc.bulk {
+				t.Errorf("got bulk rounds %d, wanted bulk %v", n, tc.bulk)
+			}
+
+			res, err := NewRunner().RunRule(rule, 1, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Rounds != tc.total {
+				t.Errorf("got %d rounds, wanted %d", res.Rounds, tc.total)
+			}
+			if res.Blocked == nil {
+				t.Errorf("got no block, wanted the final round to be blocked")
+			}
+
+			// The same rule run one round at a time must reach the same state
+			single := tc.rule.Repeat(0).Build()
+			want := newCtx()
+			runner := NewRunner()
+			for tick := int64(1); tick <= 100; tick++ {
+				if _, err := runner.RunRule(single, tick, want); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if diff := cmp.Diff(quantities(want), quantities(ctx)); diff != "" {
+				t.Errorf("quantities mismatch (-single +repeated):\n%s", diff)
+			}
+		})
+	}
+}
+
+func BenchmarkRunRuleRepeatUsing(b *testing.B) {
+	rule := NewRule("work").RepeatUsing(RelationSelf, workers).In(RelationSelf, ironOre, 1).Out(RelationGlobal, iron, 1).Build()
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{
+		RelationSelf: NewPoolSet(
+			&Pool{Resource: workers, Capacity: 10000, Quantity: 10000},
+			&Pool{Resource: ironOre, Capacity: 1 << 40, Quantity: 1 << 40},
+		),
+		RelationGlobal: NewPoolSet(&Pool{Resource: iron, Capacity: 1 << 40}),
+	}}
+
+	runner := NewRunner()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runner.RunRule(rule, int64(i+1), ctx)
+	}
+}
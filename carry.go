@@ -0,0 +1,95 @@
+package rula
+
+import "fmt"
+
+// SetCarryLimit limits the total weight and volume of the resources held by
+// the pool set, counting the Weight and Volume of each unit. A limit of zero
+// is no limit. Rounds of rules that would leave the set holding more than
+// either limit are blocked, although the set may already hold more and rules
+// that lighten it still run.
+func (p *PoolSet) SetCarryLimit(weight, volume int) {
+	defer p.lock()()
+	p.maxWeight, p.maxVolume = weight, volume
+}
+
+// CarryLimit returns the weight and volume limits set by SetCarryLimit.
+func (p *PoolSet) CarryLimit() (weight, volume int) {
+	if p == nil {
+		return 0, 0
+	}
+	defer p.rlock()()
+	return p.maxWeight, p.maxVolume
+}
+
+// Load returns the total weight and volume of the resources held by the set.
+func (p *PoolSet) Load() (weight, volume int) {
+	for _, pool := range p.Snapshot() {
+		weight += pool.Quantity * pool.Resource.Weight
+		volume += pool.Quantity * pool.Resource.Volume
+	}
+	return weight, volume
+}
+
+func (p *PoolSet) hasCarryLimit() bool {
+	weight, volume := p.CarryLimit()
+	return weight > 0 || volume > 0
+}
+
+// load returns the total weight and volume of the resources held by ps as
+// staged in t.
+func (t *txn) load(ps *PoolSet) (weight, volume int) {
+	for _, pool := range ps.Snapshot() {
+		q := t.quantity(ps, pool.Resource)
+		weight += q * pool.Resource.Weight
+		volume += q * pool.Resource.Volume
+	}
+	return weight, volume
+}
+
+// checkCarry returns a Block if a round staged in round would take a pool set
+// filled by rule over its carry limit while adding to its load.
+func (ru *Runner) checkCarry(round *txn, rule *Rule, ctx RuleContext) *Block {
+	var checked map[*PoolSet]bool
+	check := func(rel Relation) *Block {
+		ps, ok := ctx.Pools[rel]
+		if !ok || checked[ps] || !ps.hasCarryLimit() {
+			return nil
+		}
+		if checked == nil {
+			checked = map[*PoolSet]bool{}
+		}
+		checked[ps] = true
+
+		maxWeight, maxVolume := ps.CarryLimit()
+		weight, volume := round.load(ps)
+		oldWeight, oldVolume := round.parent.load(ps)
+		if maxWeight > 0 && weight > maxWeight && weight > oldWeight {
+			ru.logger.Printf("rule %q failed: %s weight %d would exceed limit %d", rule.Name, rel, weight, maxWeight)
+			return &Block{Carry: rel, Quantity: weight}
+		}
+		if maxVolume > 0 && volume > maxVolume && volume > oldVolume {
+			ru.logger.Printf("rule %q failed: %s volume %d would exceed limit %d", rule.Name, rel, volume, maxVolume)
+			return &Block{Carry: rel, Quantity: volume}
+		}
+		return nil
+	}
+
+	for _, out := range rule.Outputs {
+		if block := check(out.Relation); block != nil {
+			return block
+		}
+	}
+	for _, s := range rule.Sets {
+		if block := check(s.Relation); block != nil {
+			return block
+		}
+	}
+	if o := ru.overflowFor(rule); o.Policy == OverflowSpill {
+		return check(o.Spill)
+	}
+	return nil
+}
+
+func (b *Block) carryString() string {
+	return fmt.Sprintf("%s carry limit exceeded, load would be %d", b.Carry, b.Quantity)
+}
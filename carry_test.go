@@ -0,0 +1,58 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCarryLimit(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`resource ingot
+	weight 5
+	volume 1
+end
+
+resource feather
+	volume 2
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ingot, feather := resources[0], resources[1]
+
+	agents, err := NewAgentParser(resources, nil).Parse(strings.NewReader(`agent mule
+	carry 20 10
+	pool ingot 100
+	pool feather 100
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mule := agents[0]
+
+	load := NewRule("load").Repeat(9).Out(RelationSelf, ingot, 1).Build()
+	res, err := NewRunner().RunRule(load, 1, mule.RuleContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Rounds != 4 || res.Blocked == nil || res.Blocked.Carry != RelationSelf {
+		t.Errorf("got %d rounds blocked by %v, wanted 4 blocked by carry limit", res.Rounds, res.Blocked)
+	}
+
+	// Volume is limited separately from weight
+	pack := NewRule("pack").Repeat(9).Out(RelationSelf, feather, 1).Build()
+	if res, _ = NewRunner().RunRule(pack, 1, mule.RuleContext()); res.Rounds != 3 {
+		t.Errorf("got %d rounds of feathers, wanted 3", res.Rounds)
+	}
+	if weight, volume := mule.Pools.Load(); weight != 20 || volume != 10 {
+		t.Errorf("got load %d, %d, wanted 20, 10", weight, volume)
+	}
+
+	// Lightening the load is never blocked
+	mule.Pools.SetCarryLimit(5, 0)
+	unload := NewRule("unload").In(RelationSelf, ingot, 1).Build()
+	if res, _ = NewRunner().RunRule(unload, 1, mule.RuleContext()); res.Rounds != 1 {
+		t.Errorf("unload blocked by %v", res.Blocked)
+	}
+}
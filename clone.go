@@ -0,0 +1,187 @@
+package rula
+
+import "sync"
+
+// Clone returns an independent copy of the pool set holding copies of its
+// pools, so that changing either set does not affect the other. Quantities
+// reserved in the original remain reserved in the copy, but only the original
+// can release them. A dense pool set's copy shares its registry.
+func (p *PoolSet) Clone() *PoolSet {
+	if p == nil {
+		return nil
+	}
+	defer p.rlock()()
+	c := &PoolSet{
+		order:           append([]*Resource(nil), p.order...),
+		registry:        p.registry,
+		defaultCapacity: p.defaultCapacity,
+		autoCreate:      p.autoCreate,
+		maxWeight:       p.maxWeight,
+		maxVolume:       p.maxVolume,
+		parent:          p.parent,
+	}
+	if p.mu != nil {
+		c.mu = &sync.RWMutex{}
+	}
+	if p.registry == nil {
+		if p.ids != nil {
+			c.ids = make(map[string]*Resource, len(p.ids))
+			for id, r := range p.ids {
+				c.ids[id] = r
+			}
+		}
+		c.pools = make(map[*Resource]*Pool, len(p.pools))
+		for r, pool := range p.pools {
+			pool := *pool
+			c.pools[r] = &pool
+		}
+		return c
+	}
+	c.dense = make([]*Pool, len(p.dense))
+	for i, pool := range p.dense {
+		if pool != nil {
+			pool := *pool
+			c.dense[i] = &pool
+		}
+	}
+	return c
+}
+
+// Clone returns a copy of the rule and its onfail chain that shares no
+// slices or pointers with the original apart from the resources.
+func (r *Rule) Clone() *Rule {
+	return r.clone(map[*Rule]*Rule{})
+}
+
+// clone copies the rule, using and recording copies in done so that an
+// onfail cycle is copied as a cycle.
+func (r *Rule) clone(done map[*Rule]*Rule) *Rule {
+	if r == nil {
+		return nil
+	}
+	if c, ok := done[r]; ok {
+		return c
+	}
+	c := *r
+	done[r] = &c
+
+	c.Preconditions = append([]ResourceCondition(nil), r.Preconditions...)
+	c.Inputs = append([]ResourceSpecifier(nil), r.Inputs...)
+	c.Outputs = append([]ResourceSpecifier(nil), r.Outputs...)
+	c.Sets = append([]ResourceSpecifier(nil), r.Sets...)
+	c.Spawns = append([]SpawnSpecifier(nil), r.Spawns...)
+	c.AttrConditions = append([]AttrCondition(nil), r.AttrConditions...)
+	c.NearConditions = append([]NearCondition(nil), r.NearConditions...)
+	c.Offers = append([]OfferSpecifier(nil), r.Offers...)
+	c.Exchanges = append([]Exchange(nil), r.Exchanges...)
+	c.Ships = append([]ResourceSpecifier(nil), r.Ships...)
+	c.CustomConditions = append([]Condition(nil), r.CustomConditions...)
+	c.CustomEffects = append([]Effect(nil), r.CustomEffects...)
+	if r.RepeatFrom != nil {
+		src := *r.RepeatFrom
+		c.RepeatFrom = &src
+	}
+	if r.Overflow != nil {
+		o := *r.Overflow
+		c.Overflow = &o
+	}
+	c.OnFail = r.OnFail.clone(done)
+	return &c
+}
+
+// Clone returns a copy of the agent with a copy of its pools. The copy runs
+// the same rules as the original unless rules is not nil, in which case each
+// rule found in rules is replaced by the rule it maps to. The copy is related
+// to the same agents as the original and shares its relation functions; use
+// CloneAgents to copy a group of related agents.
+// The copy runs the rules of the original's group and is at the original's
+// location but is not added to the group's members or the location's
+// occupants, and its relations are not recorded as inverses.
+func (a *Agent) Clone(rules map[*Rule]*Rule) *Agent {
+	c := &Agent{
+		ID:         a.ID,
+		Name:       a.Name,
+		Pools:      a.Pools.Clone(),
+		Rules:      make([]*Rule, len(a.Rules)),
+		Relations:  make(map[Relation]*Agent, len(a.Relations)),
+		Parent:     a.Parent,
+		Group:      a.Group,
+		Phase:      a.Phase,
+		Location:   a.Location,
+		Speed:      a.Speed,
+		Difficulty: a.Difficulty,
+		Kinds:      append([]string(nil), a.Kinds...),
+		Size:       a.Size,
+		Velocity:   a.Velocity,
+	}
+	if a.Position != nil {
+		c.SetPosition(*a.Position)
+	}
+	if a.Target != nil {
+		t := *a.Target
+		c.Target = &t
+	}
+	for i, r := range a.Rules {
+		if mapped, ok := rules[r]; ok {
+			r = mapped
+		}
+		c.Rules[i] = r
+	}
+	for rel, ra := range a.Relations {
+		c.Relations[rel] = ra
+	}
+	for rel, fn := range a.RelationFuncs {
+		c.AddRelationFunc(rel, fn)
+	}
+	for name, v := range a.Attrs {
+		c.SetAttr(name, v)
+	}
+	for r, o := range a.Overrides {
+		if mapped, ok := rules[r]; ok {
+			r = mapped
+		}
+		c.Override(r, o)
+	}
+	return c
+}
+
+// CloneAgents returns copies of the agents made by Agent.Clone, in the same
+// order. Relations between the agents are remapped to the copies so the
+// copies form an independent world; relations to other agents are kept.
+// Parents are remapped in the same way, along with the pools inherited from
+// them.
+func CloneAgents(agents []*Agent, rules map[*Rule]*Rule) []*Agent {
+	clones := make([]*Agent, len(agents))
+	byAgent := make(map[*Agent]*Agent, len(agents))
+	for i, a := range agents {
+		clones[i] = a.Clone(rules)
+		byAgent[a] = clones[i]
+	}
+	for _, c := range clones {
+		for rel, ra := range c.Relations {
+			if mapped, ok := byAgent[ra]; ok {
+				c.Relations[rel] = mapped
+			}
+		}
+		if mapped, ok := byAgent[c.Parent]; ok {
+			c.SetParent(mapped, c.Pools.Parent() == c.Parent.Pools)
+		}
+	}
+	return clones
+}
+
+// Clone returns a copy of the global with a copy of its pools, remapping its
+// rules as Agent.Clone does.
+func (g *Global) Clone(rules map[*Rule]*Rule) *Global {
+	c := &Global{
+		Pools: g.Pools.Clone(),
+		Rules: make([]*Rule, len(g.Rules)),
+	}
+	for i, r := range g.Rules {
+		if mapped, ok := rules[r]; ok {
+			r = mapped
+		}
+		c.Rules[i] = r
+	}
+	return c
+}
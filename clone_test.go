@@ -0,0 +1,58 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestClone(t *testing.T) {
+	idle := NewRule("idle").Out(RelationSelf, workers, 1).Build()
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out("town", iron, 1).OnFail(idle).Build()
+
+	town := NewAgent("town")
+	town.AddPool(iron, 10, 0)
+	mine := NewAgent("mine")
+	mine.AddPool(ironOre, 10, 6)
+	mine.AddPool(workers, 10, 0)
+	mine.AddRelation("town", town)
+	mine.Rules = []*Rule{smelt}
+
+	smeltCopy := smelt.Clone()
+	if diff := cmp.Diff(smelt, smeltCopy); diff != "" {
+		t.Errorf("rule copy mismatch (-want +got):\n%s", diff)
+	}
+	smeltCopy.Inputs[0].Quantity = 3
+	if smelt.Inputs[0].Quantity != 2 || smeltCopy.OnFail == idle {
+		t.Errorf("rule copy shares state with original")
+	}
+
+	clones := CloneAgents([]*Agent{town, mine}, map[*Rule]*Rule{smelt: smeltCopy})
+	mineCopy := clones[1]
+	if mineCopy.Relations["town"] != clones[0] {
+		t.Errorf("relation not remapped to copy")
+	}
+	if mineCopy.Rules[0] != smeltCopy {
+		t.Errorf("rule not remapped to copy")
+	}
+	opts := []cmp.Option{cmp.AllowUnexported(PoolSet{}), cmpopts.IgnoreFields(PoolSet{}, "version")}
+	if diff := cmp.Diff(mine.Pools, mineCopy.Pools, opts...); diff != "" {
+		t.Errorf("pool copy mismatch (-want +got):\n%s", diff)
+	}
+
+	// Running the copies leaves the originals unchanged
+	ru := NewRunner()
+	if _, err := ru.Step(nil, clones, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := mineCopy.Pools.Quantity(ironOre); got != 3 {
+		t.Errorf("got %d ore in copy, wanted 3", got)
+	}
+	if got := clones[0].Pools.Quantity(iron); got != 1 {
+		t.Errorf("got %d iron in copy, wanted 1", got)
+	}
+	if town.Pools.Quantity(iron) != 0 || mine.Pools.Quantity(ironOre) != 6 {
+		t.Errorf("running copies changed the originals")
+	}
+}
@@ -0,0 +1,278 @@
+// Command rula runs simulations described by rula data files.
+//
+// Usage:
+//
+//	rula run [flags]
+//	rula vet [flags]
+//	rula fmt [-w] files...
+//
+// The run command reads a scenario, with optional separate resource and rule
+// files, runs it for a number of ticks and prints the final state of every
+// pool. Flags:
+//
+//	-resources file  resource declarations used by the rules and scenario
+//	-rules file      rule declarations used by the scenario
+//	-scenario file   the scenario to run (required)
+//	-ticks n         number of ticks to run (default 1)
+//	-seed n          seed for the random source of rules with a chance
+//	-json            print the final state as a JSON world snapshot
+//	-out file        write the final state to file instead of standard output
+//	-csv file        write the quantity of every pool after each tick to file
+//
+// The vet command reads the same files, of which the scenario is optional,
+// and reports likely mistakes in the rules found by rula.Lint, or by
+// rula.LintScenario if a scenario is given. It fails if it finds any.
+//
+// The fmt command prints each file in the canonical form produced by
+// rula.Format, or with -w rewrites the files in place.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iand/rula"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "rula:", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage: rula run|vet|fmt [flags]")
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "run":
+		return runCmd(args[1:], stdout)
+	case "vet":
+		return vetCmd(args[1:], stdout)
+	case "fmt":
+		return fmtCmd(args[1:], stdout)
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func runCmd(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	resourcesFile := fs.String("resources", "", "resource file")
+	rulesFile := fs.String("rules", "", "rule file")
+	scenarioFile := fs.String("scenario", "", "scenario file (required)")
+	ticks := fs.Int("ticks", 1, "number of ticks to run")
+	seed := fs.Int64("seed", 0, "seed for the random source")
+	asJSON := fs.Bool("json", false, "print a JSON world snapshot")
+	outFile := fs.String("out", "", "output file, standard output if empty")
+	csvFile := fs.String("csv", "", "file to record pool quantities to after each tick")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *scenarioFile == "" {
+		return errors.New("run: -scenario is required")
+	}
+
+	w, err := load(*resourcesFile, *rulesFile, *scenarioFile, *seed)
+	if err != nil {
+		return err
+	}
+	var rec *rula.Recorder
+	if *csvFile != "" {
+		f, err := os.Create(*csvFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rec = rula.NewRecorder(f)
+		if err := rec.TrackWorld(w); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < *ticks; i++ {
+		if _, err := w.Tick(); err != nil {
+			return fmt.Errorf("tick %d: %w", w.Time(), err)
+		}
+		if rec != nil {
+			if err := rec.Record(w.Time()); err != nil {
+				return err
+			}
+		}
+	}
+
+	out := stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	snap := w.Snapshot()
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(snap)
+	}
+	return printPools(out, snap)
+}
+
+func vetCmd(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("vet", flag.ContinueOnError)
+	resourcesFile := fs.String("resources", "", "resource file")
+	rulesFile := fs.String("rules", "", "rule file")
+	scenarioFile := fs.String("scenario", "", "scenario file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var issues []rula.LintIssue
+	if *scenarioFile != "" {
+		sc, err := loadScenario(*resourcesFile, *rulesFile, *scenarioFile)
+		if err != nil {
+			return err
+		}
+		issues = rula.LintScenario(sc)
+	} else {
+		resources, rules, err := loadRules(*resourcesFile, *rulesFile)
+		if err != nil {
+			return err
+		}
+		issues = rula.Lint(rules, resources)
+	}
+
+	for _, i := range issues {
+		fmt.Fprintln(stdout, i)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("vet: %d problems found", len(issues))
+	}
+	return nil
+}
+
+func fmtCmd(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write the result to the source file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("fmt: no files given")
+	}
+
+	for _, name := range fs.Args() {
+		var out []byte
+		err := parseFile(name, func(r io.Reader) (err error) {
+			out, err = rula.Format(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if *write {
+			if err := os.WriteFile(name, out, 0o644); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := stdout.Write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the data files and returns the world they describe.
+func load(resourcesFile, rulesFile, scenarioFile string, seed int64) (*rula.World, error) {
+	sc, err := loadScenario(resourcesFile, rulesFile, scenarioFile)
+	if err != nil {
+		return nil, err
+	}
+	return rula.NewScenarioWorld(sc, rula.WithSeed(seed)), nil
+}
+
+// loadScenario reads the scenario file using the resources and rules of the
+// other files, which may be empty.
+func loadScenario(resourcesFile, rulesFile, scenarioFile string) (*rula.Scenario, error) {
+	resources, rules, err := loadRules(resourcesFile, rulesFile)
+	if err != nil {
+		return nil, err
+	}
+	p := rula.NewScenarioParser()
+	p.SetResources(resources)
+	p.SetRules(rules)
+
+	var sc *rula.Scenario
+	err = parseFile(scenarioFile, func(r io.Reader) (err error) {
+		sc, err = p.Parse(r)
+		return err
+	})
+	return sc, err
+}
+
+// loadRules reads the resource and rule files, either of which may be empty.
+func loadRules(resourcesFile, rulesFile string) ([]*rula.Resource, []*rula.Rule, error) {
+
+	var resources []*rula.Resource
+	if resourcesFile != "" {
+		err := parseFile(resourcesFile, func(r io.Reader) (err error) {
+			resources, err = rula.NewResourceParser().Parse(r)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var rules []*rula.Rule
+	if rulesFile != "" {
+		err := parseFile(rulesFile, func(r io.Reader) (err error) {
+			rules, err = rula.NewRuleParser(resources).Parse(r)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return resources, rules, nil
+}
+
+// parseFile opens the named file and passes it to parse, adding the file
+// name to any error.
+func parseFile(name string, parse func(io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := parse(f); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// printPools prints one line for each pool in the snapshot.
+func printPools(out io.Writer, snap *rula.WorldSnapshot) error {
+	fmt.Fprintf(out, "tick %d\n", snap.Tick)
+	for _, p := range snap.Pools {
+		owner := p.Agent
+		if owner == "" {
+			owner = "global"
+		}
+		capacity := fmt.Sprint(p.Capacity)
+		if p.Capacity == rula.CapacityUnlimited {
+			capacity = "unlimited"
+		}
+		if _, err := fmt.Fprintf(out, "%s %s %d/%s\n", owner, p.Resource, p.Quantity, capacity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
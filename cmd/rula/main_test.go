@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"resources.rula": "resource grain\nend\n",
+		"rules.rula":     "rule farm\n\tout grain 2\nend\n",
+		"world.rula":     "agent farmer\n\tpool grain 100 1\n\trules farm\nend\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	err := run([]string{
+		"run",
+		"--resources", filepath.Join(dir, "resources.rula"),
+		"--rules", filepath.Join(dir, "rules.rula"),
+		"--scenario", filepath.Join(dir, "world.rula"),
+		"--ticks", "3",
+	}, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "tick 3\nfarmer grain 7/100\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	csvFile := filepath.Join(dir, "pools.csv")
+	err = run([]string{
+		"run",
+		"--resources", filepath.Join(dir, "resources.rula"),
+		"--rules", filepath.Join(dir, "rules.rula"),
+		"--scenario", filepath.Join(dir, "world.rula"),
+		"--ticks", "2",
+		"--csv", csvFile,
+	}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("tick,farmer grain\n1,3\n2,5\n", string(got)); diff != "" {
+		t.Errorf("csv mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := run([]string{"run"}, &out); err == nil {
+		t.Errorf("got no error without a scenario, wanted one")
+	}
+}
+
+func TestVet(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "rules.rula")
+	resources := filepath.Join(dir, "resources.rula")
+	if err := os.WriteFile(resources, []byte("resource grain\nend\n\nresource bread\nend\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rules, []byte("rule bake\n\tin grain 2\n\tout bread 1\nend\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err := run([]string{"vet", "--resources", resources, "--rules", rules}, &out)
+	if err == nil {
+		t.Fatalf("got no error, wanted one for the problem found")
+	}
+	want := "rule \"bake\": consumes \"grain\" which no rule produces\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFmt(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rules.rula")
+	if err := os.WriteFile(name, []byte("RULE bake\n    OUT bread 1\n  in grain 2\nend\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"fmt", "-w", name}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "rule bake\n\tin grain 2\n\tout bread 1\nend\n\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("file mismatch (-want +got):\n%s", diff)
+	}
+}
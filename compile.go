@@ -0,0 +1,492 @@
+package rula
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Plan is a set of rules compiled for fast evaluation by Runner.RunPlan.
+// Each distinct relation and resource named by the rules is assigned a slot
+// and the rules refer to pools by slot, so a run resolves each pool once
+// rather than looking it up for every precondition, input and output of every
+// round.
+type Plan struct {
+	Rules []*Rule // the compiled rules, in order
+
+	resources map[*Resource]int // dense index of each resource
+	slots     []planSlot
+	rules     []*compiledRule
+}
+
+// A planSlot is a relation and resource pair used by a plan.
+type planSlot struct {
+	relation Relation
+	resource *Resource
+}
+
+type compiledRule struct {
+	rule       *Rule
+	conds      []compiledSpec
+	inputs     []compiledSpec
+	outputs    []compiledSpec
+	sets       []compiledSpec
+	repeatFrom int  // slot of the repeat resource, -1 if none
+	bulk       bool // whether repeated rounds can be applied as one batch
+	generic    bool // whether the rule must be run by the generic path
+}
+
+type compiledSpec struct {
+	slot     int
+	op       Op
+	quantity int
+}
+
+// Compile compiles rules, which may only use the supplied resources, into a
+// plan. The rules are validated as Rule.Validate does, apart from their
+// relations, and a *ValidationError is returned for the first rule with
+// problems. Onfail rules are run by the Runner's generic path and need not be
+// included.
+func Compile(rules []*Rule, resources []*Resource) (*Plan, error) {
+	p := &Plan{
+		Rules:     rules,
+		resources: make(map[*Resource]int, len(resources)),
+	}
+	for i, r := range resources {
+		p.resources[r] = i
+	}
+
+	index := newResourceIndex(resources)
+	slotIndex := map[planSlot]int{}
+	slot := func(rel Relation, r *Resource) (int, error) {
+		if _, ok := p.resources[r]; !ok {
+			// Resources with the same ID share a slot
+			known, ok := index.id(r.ID)
+			if r.ID == "" || !ok {
+				return 0, fmt.Errorf("unknown resource: %s", r)
+			}
+			r = known
+		}
+		key := planSlot{relation: rel, resource: r}
+		i, ok := slotIndex[key]
+		if !ok {
+			i = len(p.slots)
+			slotIndex[key] = i
+			p.slots = append(p.slots, key)
+		}
+		return i, nil
+	}
+	specs := func(dst []compiledSpec, rule *Rule, src []ResourceSpecifier) ([]compiledSpec, error) {
+		for _, s := range src {
+			i, err := slot(s.Relation, s.Resource)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			dst = append(dst, compiledSpec{slot: i, quantity: s.Quantity})
+		}
+		return dst, nil
+	}
+
+	for _, rule := range rules {
+		if err := rule.validateChain(nil); err != nil {
+			return nil, err
+		}
+		cr := &compiledRule{
+			rule:       rule,
+			repeatFrom: -1,
+			generic:    (rule.Overflow != nil && rule.Overflow.Policy != OverflowDiscard) || len(rule.Exchanges) > 0 || len(rule.Ships) > 0 || len(rule.CustomEffects) > 0,
+		}
+
+		var err error
+		for _, c := range rule.Preconditions {
+			i, err := slot(c.Relation, c.Resource)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			cr.conds = append(cr.conds, compiledSpec{slot: i, op: c.Op, quantity: c.Quantity})
+		}
+		if cr.inputs, err = specs(nil, rule, rule.Inputs); err != nil {
+			return nil, err
+		}
+		if cr.outputs, err = specs(nil, rule, rule.Outputs); err != nil {
+			return nil, err
+		}
+		if cr.sets, err = specs(nil, rule, rule.Sets); err != nil {
+			return nil, err
+		}
+		if rule.RepeatFrom != nil {
+			if cr.repeatFrom, err = slot(rule.RepeatFrom.Relation, rule.RepeatFrom.Resource); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+		}
+		cr.bulk = cr.canBulk()
+		p.rules = append(p.rules, cr)
+	}
+	return p, nil
+}
+
+// canBulk reports whether repeated rounds of the rule can be applied as a
+// single batch, under the same conditions as bulkRounds.
+func (cr *compiledRule) canBulk() bool {
+	if len(cr.sets) > 0 {
+		return false
+	}
+	used := map[int]bool{}
+	for _, in := range cr.inputs {
+		if used[in.slot] || in.quantity < 0 {
+			return false
+		}
+		used[in.slot] = true
+	}
+	for _, out := range cr.outputs {
+		if used[out.slot] {
+			return false
+		}
+		used[out.slot] = true
+	}
+	for _, c := range cr.conds {
+		if used[c.slot] {
+			return false
+		}
+	}
+	return true
+}
+
+// A resolvedSlot is a plan slot resolved against a rule context.
+type resolvedSlot struct {
+	ps       *PoolSet // nil if the context has no pool set for the relation
+	pool     *Pool    // nil if the pool set has no pool for the resource
+	capacity int
+}
+
+// resolve resolves the plan's slots against ctx, appending them to dst. It
+// reports false if any pool set is safe for concurrent use, since its pools
+// may only be accessed through its methods, would create a missing pool, has
+// a carry limit, has a parent or has a pool with a linked capacity.
+func (p *Plan) resolve(dst []resolvedSlot, ctx RuleContext) ([]resolvedSlot, bool) {
+	for _, s := range p.slots {
+		var rs resolvedSlot
+		if ps, ok := ctx.Pools[s.relation]; ok {
+			if ps.mu != nil || ps.maxWeight > 0 || ps.maxVolume > 0 || ps.parent != nil {
+				return dst, false
+			}
+			rs.ps = ps
+			if pool, ok := ps.get(s.resource); ok {
+				if pool.Link != nil {
+					// The capacity may change as the rules run
+					return dst, false
+				}
+				rs.pool = pool
+				rs.capacity = ps.capacity(pool)
+			} else if ps.autoCreate || s.resource.Event {
+				return dst, false
+			}
+		}
+		dst = append(dst, rs)
+	}
+	return dst, true
+}
+
+// RunPlan runs the rules of a compiled plan as Run does. When the runner or
+// the rule context uses features that need changes to be staged, such as
+// fixpoint mode, replay logging, watchers, overflow policies other than
+// discard, parallel steps, pool sets safe for concurrent use, pool sets that
+// create missing pools or have parents, carry limits, linked capacities or
+// agents that override rules, the rules are run by Run instead.
+func (ru *Runner) RunPlan(plan *Plan, tick int64, ctx RuleContext) (*RunReport, error) {
+	if ru.fixpoint > 1 || ru.replay != nil || len(ru.watch.byPool) > 0 || len(ru.shared) > 0 || ru.overflow.Policy != OverflowDiscard ||
+		(ctx.Agent != nil && len(ctx.Agent.Overrides) > 0) {
+		return ru.RunContext(context.Background(), plan.Rules, tick, ctx)
+	}
+
+	// Most plans use few slots so they are resolved into a buffer that
+	// does not escape
+	var buf [32]resolvedSlot
+	slots, ok := plan.resolve(buf[:0], ctx)
+	if !ok {
+		return ru.RunContext(context.Background(), plan.Rules, tick, ctx)
+	}
+
+	report := &RunReport{Tick: tick, Passes: 1}
+	for _, cr := range plan.rules {
+		if cr.rule.Period == 0 {
+			continue
+		}
+		var res *RuleResult
+		var err error
+		if cr.generic {
+			res, err = ru.RunRule(cr.rule, tick, ctx)
+		} else {
+			res, err = ru.runCompiled(cr, slots, tick, ctx)
+		}
+		report.Results = append(report.Results, res)
+		report.noteBudget(res)
+		if err != nil {
+			return report, err
+		}
+	}
+	report.Next = len(plan.Rules)
+	return report, nil
+}
+
+// runCompiled runs a compiled rule as RunRule does, reading and changing the
+// resolved pools directly. Every check is made before any pool is changed so
+// each round is applied all together or not at all.
+func (ru *Runner) runCompiled(cr *compiledRule, slots []resolvedSlot, tick int64, ctx RuleContext) (*RuleResult, error) {
+	rule := cr.rule
+	res := &RuleResult{Rule: rule}
+	key := stateKey{agent: ctx.Agent, rule: rule}
+	state := ru.state(key)
+	if ru.nextRun(ctx.Agent, state.LastRun, rule.Period) > tick {
+		return res, nil
+	}
+	res.Due = true
+
+	defer func() {
+		state.LastRun = tick
+		ru.setState(key, state)
+		ru.recordResult(res)
+		ru.hooks.ruleDone(res)
+	}()
+
+	if rule.Chance > 0 && !ru.chance(rule.Chance) {
+		res.Blocked = &Block{Chance: true}
+		return res, nil
+	}
+
+	limit := ru.limit(tick)
+	onfail, err := ru.compiledRounds(cr, slots, ctx, res, limit)
+	ru.spend(tick, limit, res.Rounds)
+	if err != nil || !onfail {
+		return res, err
+	}
+
+	res.OnFail, err = ru.RunRule(rule.OnFail, tick, ctx)
+	return res, err
+}
+
+// compiledRounds runs the rounds of a compiled rule, following runRounds.
+func (ru *Runner) compiledRounds(cr *compiledRule, slots []resolvedSlot, ctx RuleContext, res *RuleResult, limit int) (bool, error) {
+	rule := cr.rule
+	if block := ru.entryBlock(rule, ctx); block != nil {
+		res.Blocked = block
+		return rule.OnFail != nil, nil
+	}
+
+	rounds := rule.Repeat + 1
+	if cr.repeatFrom >= 0 {
+		s := slots[cr.repeatFrom]
+		if s.ps == nil {
+			ru.logger.Printf("rule %q failed: no repeat poolset of type %v", rule.Name, rule.RepeatFrom.Relation)
+			res.Blocked = &Block{Relation: rule.RepeatFrom.Relation}
+			return false, nil
+		}
+		rounds = s.quantity()
+	}
+
+	capped := false
+	if limit >= 0 && rounds > limit {
+		rounds = limit
+		capped = true
+	}
+
+	if cr.bulk && rounds > 1 {
+		if n := cr.bulkRounds(slots, rounds); n > 1 {
+			cr.apply(ru, slots, ctx.Agent, n)
+			res.Rounds += n
+			rounds -= n
+		}
+	}
+
+	for rounds > 0 {
+		block, err := cr.canRun(slots)
+		if err != nil {
+			ru.logger.Printf("rule %q failed: %v", rule.Name, err)
+			return false, err
+		}
+		if block != nil {
+			res.Blocked = block
+			return res.Rounds == 0 && rule.OnFail != nil, nil
+		}
+		if block := cr.canApply(slots); block != nil {
+			res.Blocked = block
+			return false, nil
+		}
+		cr.apply(ru, slots, ctx.Agent, 1)
+
+		res.Rounds++
+		rounds--
+	}
+
+	if capped {
+		res.Blocked = &Block{Budget: true}
+	}
+	return false, nil
+}
+
+func (s resolvedSlot) quantity() int {
+	if s.pool == nil {
+		return 0
+	}
+	return s.pool.Quantity
+}
+
+// available returns the quantity that inputs may remove from the pool.
+func (s resolvedSlot) available() int {
+	if s.pool == nil {
+		return 0
+	}
+	return s.pool.Quantity - s.pool.Reserved
+}
+
+// clamp limits a new quantity for the pool as PoolSet.Add and Set do.
+func (s resolvedSlot) clamp(q int) int {
+	if q > s.capacity {
+		return s.capacity
+	}
+	if q < 0 && !s.pool.AllowNegative {
+		return 0
+	}
+	return q
+}
+
+func (s resolvedSlot) allowsNegative() bool {
+	return s.pool != nil && s.pool.AllowNegative
+}
+
+// canRun checks the preconditions and inputs of one round as Runner.canRun
+// does.
+func (cr *compiledRule) canRun(slots []resolvedSlot) (*Block, error) {
+	rule := cr.rule
+	for i, c := range cr.conds {
+		s := slots[c.slot]
+		if s.ps == nil {
+			return nil, fmt.Errorf("rule %q failed: no precondition poolset of type %v", rule.Name, rule.Preconditions[i].Relation)
+		}
+		if q := s.quantity(); !c.op.holds(q, c.quantity) {
+			return &Block{Precondition: &rule.Preconditions[i], Quantity: q}, nil
+		}
+	}
+	for i, in := range cr.inputs {
+		s := slots[in.slot]
+		if s.ps == nil {
+			return nil, fmt.Errorf("rule %q failed: no input poolset of type %v", rule.Name, rule.Inputs[i].Relation)
+		}
+		if q := s.available(); in.quantity > q && !s.allowsNegative() {
+			return &Block{Input: &rule.Inputs[i], Quantity: q}, nil
+		}
+	}
+	return nil, nil
+}
+
+// canApply checks that the inputs of one round can all be removed together
+// and that every output and set has a pool set, as Runner.apply does.
+func (cr *compiledRule) canApply(slots []resolvedSlot) *Block {
+	rule := cr.rule
+	for i, in := range cr.inputs {
+		s := slots[in.slot]
+		if s.pool == nil {
+			if in.quantity > 0 {
+				return &Block{Input: &rule.Inputs[i]}
+			}
+			continue
+		}
+		// Earlier inputs from the same pool are removed first. Slots of
+		// different relations may refer to the same pool set, so pools are
+		// compared rather than slots
+		q := s.available()
+		for _, prev := range cr.inputs[:i] {
+			if slots[prev.slot].pool == s.pool {
+				q -= prev.quantity
+			}
+		}
+		if q < in.quantity && !s.pool.AllowNegative {
+			return &Block{Input: &rule.Inputs[i], Quantity: q}
+		}
+	}
+	for i, out := range cr.outputs {
+		if slots[out.slot].ps == nil {
+			return &Block{Relation: rule.Outputs[i].Relation}
+		}
+	}
+	for i, set := range cr.sets {
+		if slots[set.slot].ps == nil {
+			return &Block{Relation: rule.Sets[i].Relation}
+		}
+	}
+	return nil
+}
+
+// bulkRounds returns the number of rounds, up to rounds, that can be applied
+// as one batch, as the function of the same name does for the generic path.
+func (cr *compiledRule) bulkRounds(slots []resolvedSlot, rounds int) int {
+	// canBulk checked that no slot is used twice, but slots of different
+	// relations may refer to the same pool
+	used := map[*Pool]bool{}
+	for _, group := range [][]compiledSpec{cr.inputs, cr.outputs, cr.conds} {
+		for _, spec := range group {
+			if pool := slots[spec.slot].pool; pool != nil {
+				if used[pool] {
+					return 0
+				}
+				used[pool] = true
+			}
+		}
+	}
+
+	n := rounds
+	for _, in := range cr.inputs {
+		s := slots[in.slot]
+		if s.ps == nil {
+			return 0
+		}
+		if in.quantity > 0 && !s.allowsNegative() {
+			if avail := s.available() / in.quantity; avail < n {
+				n = avail
+			}
+		}
+	}
+	for _, out := range cr.outputs {
+		if slots[out.slot].ps == nil {
+			return 0
+		}
+	}
+	for _, c := range cr.conds {
+		s := slots[c.slot]
+		if s.ps == nil || !c.op.holds(s.quantity(), c.quantity) {
+			return 0
+		}
+	}
+	return n
+}
+
+// apply applies n rounds of the rule to the pools, which must have been
+// checked with canRun and canApply, or bulkRounds when n is greater than one.
+// Changes are reported to the runner as they are made.
+func (cr *compiledRule) apply(ru *Runner, slots []resolvedSlot, agent *Agent, n int) {
+	rule := cr.rule
+	change := func(rel Relation, s resolvedSlot, q int) {
+		old := s.pool.Quantity
+		s.pool.Quantity = q
+		s.ps.version++
+		ru.changed(ResourceChange{Rule: rule, Agent: agent, Relation: rel, Resource: s.pool.Resource, Old: old, New: q})
+	}
+
+	for i, in := range cr.inputs {
+		if s := slots[in.slot]; s.pool != nil {
+			change(rule.Inputs[i].Relation, s, s.pool.Quantity-in.quantity*n)
+		}
+	}
+	for i, out := range cr.outputs {
+		if s := slots[out.slot]; s.pool != nil {
+			// Any excess is lost
+			q := s.clamp(s.pool.Quantity + out.quantity*n)
+			change(rule.Outputs[i].Relation, s, q)
+		}
+	}
+	for i, set := range cr.sets {
+		if s := slots[set.slot]; s.pool != nil {
+			q := s.clamp(set.quantity)
+			change(rule.Sets[i].Relation, s, q)
+		}
+	}
+}
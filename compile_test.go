@@ -0,0 +1,185 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func BenchmarkRunPlan(b *testing.B) {
+	rule := `
+rule test
+	in self iron_ore 1
+	out self iron_ore 1
+end
+`
+
+	resources := []*Resource{
+		ironOre,
+	}
+
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(rule))
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := Compile(rules, resources)
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := RuleContext{
+		Pools: map[Relation]*PoolSet{
+			RelationSelf: NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 1<<63 - 1, Quantity: 1000},
+			),
+		},
+	}
+
+	runner := NewRunner()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runner.RunPlan(plan, int64(i), ctx)
+	}
+}
+
+func TestRunPlan(t *testing.T) {
+	spec := `
+rule smelt
+	if workers > 1
+	in iron_ore 2
+	in iron_ore 1
+	out global iron 1
+	repeat 3
+end
+
+rule mine
+	every 2
+	in global iron_ore 5
+	out iron_ore 5
+	repeat using workers
+end
+
+rule hire
+	if workers < 3
+	set workers 3
+	onfail rest
+end
+
+rule rest
+	every 0
+	out workers -1
+end
+
+rule export
+	in global iron 1
+	out employer iron 1
+end
+`
+	resources := []*Resource{ironOre, iron, workers}
+
+	// Each rule is run by Run and by RunPlan against identical pools and the
+	// results and pools compared after each tick
+	newCtx := func() RuleContext {
+		return RuleContext{
+			Pools: map[Relation]*PoolSet{
+				RelationSelf: NewPoolSet(
+					&Pool{Resource: ironOre, Capacity: 20, Quantity: 4},
+					&Pool{Resource: workers, Capacity: 5, Quantity: 2},
+				),
+				RelationGlobal: NewPoolSet(
+					&Pool{Resource: ironOre, Capacity: 100, Quantity: 40},
+					&Pool{Resource: iron, Capacity: 4},
+				),
+			},
+		}
+	}
+
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := Compile(rules, resources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	genCtx, planCtx := newCtx(), newCtx()
+	gen, fast := NewRunner(), NewRunner()
+	var genChanges, planChanges []ResourceChange
+	gen.OnResourceChanged(func(c ResourceChange) { genChanges = append(genChanges, c) })
+	fast.OnResourceChanged(func(c ResourceChange) { planChanges = append(planChanges, c) })
+
+	opts := []cmp.Option{cmp.AllowUnexported(PoolSet{}), cmpopts.IgnoreFields(PoolSet{}, "version")}
+	for tick := int64(1); tick <= 6; tick++ {
+		want, err := gen.Run(rules, tick, genCtx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := fast.RunPlan(plan, tick, planCtx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(want, got, opts...); diff != "" {
+			t.Fatalf("tick %d: report mismatch (-run +plan):\n%s", tick, diff)
+		}
+		if diff := cmp.Diff(genCtx, planCtx, opts...); diff != "" {
+			t.Fatalf("tick %d: pools mismatch (-run +plan):\n%s", tick, diff)
+		}
+	}
+	if diff := cmp.Diff(genChanges, planChanges, opts...); diff != "" {
+		t.Errorf("changes mismatch (-run +plan):\n%s", diff)
+	}
+	if diff := cmp.Diff(gen.Stats(), fast.Stats(), opts...); diff != "" {
+		t.Errorf("stats mismatch (-run +plan):\n%s", diff)
+	}
+
+	if _, err := Compile(rules, []*Resource{iron}); err == nil {
+		t.Errorf("got no error for unknown resource, wanted one")
+	}
+}
+
+func TestRunPlanAliasedPools(t *testing.T) {
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}}
+	resources := []*Resource{gold}
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule spend
+	in self gold 3
+	in global gold 3
+end
+
+rule mint
+	in self gold 1
+	out global gold 2
+	repeat 3
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan, err := Compile(rules, resources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newGlobal := func() *Global {
+		g := NewGlobal(nil)
+		g.Pools.AddPool(gold, 100, 4)
+		return g
+	}
+
+	// Self and global refer to the same pool set in the global context, so
+	// the plan must agree with the generic path
+	want := newGlobal()
+	if _, err := NewRunner().Run(rules, 1, want.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := newGlobal()
+	if _, err := NewRunner().RunPlan(plan, 1, got.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w, g := want.Pools.Quantity(gold), got.Pools.Quantity(gold); g != w {
+		t.Errorf("got gold %d from the plan, wanted %d as from Run", g, w)
+	}
+}
@@ -0,0 +1,80 @@
+package rula
+
+import "encoding/json"
+
+// An Exchange trades one resource of the agent a rule is run for for another
+// at a rate held in a pool, so that prices can change as a simulation runs.
+// The rate is the number of units of one side paid for each unit of the
+// other and is read each time the rule is run. A rule with an exchange does
+// not run while the rate is not positive.
+type Exchange struct {
+	Give     *Resource
+	Get      *Resource
+	Quantity int  // the quantity of Get received, or of Give if Sell is true
+	Sell     bool // true if Quantity of Give is sold for Get at the rate per unit
+	Rate     ResourceSource
+}
+
+// amounts returns the quantities given and received at rate.
+func (x Exchange) amounts(rate int) (give, get int) {
+	if x.Sell {
+		return x.Quantity, x.Quantity * rate
+	}
+	return x.Quantity * rate, x.Quantity
+}
+
+// priced returns the rule with its exchanges turned into inputs and outputs
+// at the rates found in ctx, each guarded by a precondition that the rate is
+// positive. It returns rule itself if it has no exchanges.
+func priced(rule *Rule, ctx RuleContext) *Rule {
+	if len(rule.Exchanges) == 0 {
+		return rule
+	}
+	r := *rule
+	r.Preconditions = append([]ResourceCondition(nil), rule.Preconditions...)
+	r.Inputs = append([]ResourceSpecifier(nil), rule.Inputs...)
+	r.Outputs = append([]ResourceSpecifier(nil), rule.Outputs...)
+	for _, x := range rule.Exchanges {
+		r.Preconditions = append(r.Preconditions, ResourceCondition{
+			ResourceSpecifier: ResourceSpecifier{Relation: x.Rate.Relation, Resource: x.Rate.Resource},
+			Op:                OpGreaterThan,
+		})
+		give, get := x.amounts(ctx.Pools[x.Rate.Relation].Quantity(x.Rate.Resource))
+		r.Inputs = append(r.Inputs, ResourceSpecifier{Relation: RelationSelf, Resource: x.Give, Quantity: give})
+		r.Outputs = append(r.Outputs, ResourceSpecifier{Relation: RelationSelf, Resource: x.Get, Quantity: get})
+	}
+	return &r
+}
+
+type jsonExchange struct {
+	Give     string         `json:"give"`
+	Get      string         `json:"get"`
+	Quantity int            `json:"quantity"`
+	Sell     bool           `json:"sell,omitempty"`
+	Rate     ResourceSource `json:"rate"`
+}
+
+func (x Exchange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonExchange{
+		Give:     resourceID(x.Give),
+		Get:      resourceID(x.Get),
+		Quantity: x.Quantity,
+		Sell:     x.Sell,
+		Rate:     x.Rate,
+	})
+}
+
+func (x *Exchange) UnmarshalJSON(data []byte) error {
+	var jx jsonExchange
+	if err := json.Unmarshal(data, &jx); err != nil {
+		return err
+	}
+	*x = Exchange{
+		Give:     resourceRef(jx.Give),
+		Get:      resourceRef(jx.Get),
+		Quantity: jx.Quantity,
+		Sell:     jx.Sell,
+		Rate:     jx.Rate,
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExchange(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+currency gold
+end
+
+resource grain
+end
+
+resource grain_price
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gold, grain, price := resources[0], resources[1], resources[2]
+	if !gold.Currency || grain.Currency {
+		t.Fatalf("currency not parsed")
+	}
+
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule buy
+	exchange gold for 2 grain at global grain_price
+end
+
+rule sell
+	every 0
+	exchange 3 grain for gold at global grain_price
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buy, sell := rules[0], rules[1]
+
+	global := NewGlobal(nil)
+	global.Pools.AddPool(price, 100, 4)
+	a := NewAgent("a")
+	a.Pools.AddPool(gold, 100, 10)
+	a.Pools.AddPool(grain, 100, 0)
+	ctx := agentContext(a, global)
+
+	runner := NewRunner()
+	if _, err := runner.RunRule(buy, 1, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g, q := a.Pools.Quantity(gold), a.Pools.Quantity(grain); g != 2 || q != 2 {
+		t.Errorf("got %d gold and %d grain after buying, wanted 2 and 2", g, q)
+	}
+
+	// The price rises beyond what is left
+	global.Pools.Set(price, 5)
+	res, err := runner.RunRule(buy, 2, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Fired() || res.Blocked == nil || res.Blocked.Input == nil || res.Blocked.Input.Quantity != 10 {
+		t.Errorf("got result %+v, wanted buying blocked by an input of 10 gold", res.Blocked)
+	}
+
+	a.Pools.Add(grain, 1)
+	if _, err := runner.RunRule(sell, 3, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if g, q := a.Pools.Quantity(gold), a.Pools.Quantity(grain); g != 17 || q != 0 {
+		t.Errorf("got %d gold and %d grain after selling, wanted 17 and 0", g, q)
+	}
+
+	global.Pools.Set(price, 0)
+	res, err = runner.RunRule(buy, 4, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Blocked == nil || res.Blocked.Precondition == nil {
+		t.Errorf("got block %v, wanted the rate precondition", res.Blocked)
+	}
+}
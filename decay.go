@@ -0,0 +1,61 @@
+package rula
+
+// applyRates applies the decay and regeneration of resources to the pools of
+// global and agents, committing the changes to each pool set together. They
+// are applied once per tick so a step resumed after cancellation does not
+// apply them again.
+func (ru *Runner) applyRates(global *Global, agents []*Agent, tick int64) error {
+	if ru.rated && ru.ratedTick == tick {
+		return nil
+	}
+	ru.rated, ru.ratedTick = true, tick
+
+	if global != nil {
+		if err := ru.applyPoolRates(global.Pools, nil, tick); err != nil {
+			return err
+		}
+	}
+	for _, a := range agents {
+		if err := ru.applyPoolRates(a.Pools, a, tick); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ru *Runner) applyPoolRates(ps *PoolSet, agent *Agent, tick int64) error {
+	var tx *txn
+	for _, pool := range ps.Snapshot() {
+		r := pool.Resource
+		if r.Decay == 0 && r.Regen == 0 {
+			continue
+		}
+
+		// Decay only takes the unreserved quantity
+		q := pool.Quantity
+		if floor := pool.Reserved; q > floor {
+			q -= r.Decay
+			if q < floor {
+				q = floor
+			}
+		}
+		if capacity := ps.Capacity(r); q < capacity {
+			q += r.Regen
+			if q > capacity {
+				q = capacity
+			}
+		}
+		if q == pool.Quantity {
+			continue
+		}
+
+		if tx == nil {
+			tx = newTxn(nil, nil, RuleContext{Agent: agent})
+		}
+		tx.set(RelationSelf, ps, r, q)
+	}
+	if tx == nil {
+		return nil
+	}
+	return ru.commit(tx, tick)
+}
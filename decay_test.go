@@ -0,0 +1,47 @@
+package rula
+
+import "testing"
+
+func TestStepRates(t *testing.T) {
+	food := &Resource{ID: "food", Name: Name{Singular: "food"}, Decay: 3}
+	health := &Resource{ID: "health", Name: Name{Singular: "health"}, Regen: 2}
+
+	a := NewAgent("a")
+	a.AddPool(food, 10, 5)
+	a.AddPool(health, 5, 2)
+	global := NewGlobal(nil)
+	global.Pools.AddPool(food, 100, 50)
+
+	ru := NewRunner()
+	var changes int
+	ru.OnResourceChanged(func(c ResourceChange) {
+		if c.Rule != nil {
+			t.Errorf("got change from rule %q", c.Rule.Name)
+		}
+		changes++
+	})
+
+	for tick := int64(1); tick <= 2; tick++ {
+		if _, err := ru.Step(global, []*Agent{a}, tick); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// A repeated step for the same tick does not apply the rates again
+	if _, err := ru.Step(global, []*Agent{a}, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Food decays 5, 2, 0 and health regenerates 2, 4, 5
+	if got := a.Pools.Quantity(food); got != 0 {
+		t.Errorf("got food %d, wanted 0", got)
+	}
+	if got := a.Pools.Quantity(health); got != 5 {
+		t.Errorf("got health %d, wanted 5", got)
+	}
+	if got := global.Pools.Quantity(food); got != 44 {
+		t.Errorf("got global food %d, wanted 44", got)
+	}
+	if changes != 6 {
+		t.Errorf("got %d changes, wanted 6", changes)
+	}
+}
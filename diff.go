@@ -0,0 +1,43 @@
+package rula
+
+// Diff returns the change in quantity of each resource from pool set a to pool
+// set b, in the order the pools were added to a followed by any pools only in
+// b. A missing pool counts as holding none of its resource. Resources whose
+// quantity is unchanged are omitted and the relation of each delta is empty.
+func Diff(a, b *PoolSet) []Delta {
+	var deltas []Delta
+	for _, pool := range a.Snapshot() {
+		if d := b.Quantity(pool.Resource) - pool.Quantity; d != 0 {
+			deltas = append(deltas, Delta{Resource: pool.Resource, Quantity: d})
+		}
+	}
+	for _, pool := range b.Snapshot() {
+		if a.Pool(pool.Resource) == nil && pool.Quantity != 0 {
+			deltas = append(deltas, Delta{Resource: pool.Resource, Quantity: pool.Quantity})
+		}
+	}
+	return deltas
+}
+
+// Merge copies the pools of src into dst. Pools that dst already holds take
+// the quantity, capacity and settings of the pool in src and pools that it
+// does not are added. Pools only in dst are left unchanged. Reservations held
+// on dst's pools are kept.
+func Merge(dst, src *PoolSet) {
+	if dst == nil || dst == src {
+		return
+	}
+	pools := src.Snapshot()
+	defer dst.lock()()
+	for _, pool := range pools {
+		if existing, ok := dst.own(pool.Resource); ok {
+			existing.Quantity = pool.Quantity
+			existing.Capacity = pool.Capacity
+			existing.AllowNegative = pool.AllowNegative
+			continue
+		}
+		pool := pool
+		pool.Reserved = 0
+		dst.putPool(&pool)
+	}
+}
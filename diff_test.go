@@ -0,0 +1,48 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffMerge(t *testing.T) {
+	a := NewPoolSet(
+		&Pool{Resource: iron, Capacity: 10, Quantity: 4},
+		&Pool{Resource: ironOre, Capacity: 10, Quantity: 6},
+		&Pool{Resource: workers, Capacity: 10, Quantity: 2},
+	)
+	b := NewPoolSet(
+		&Pool{Resource: workers, Capacity: 20, Quantity: 2},
+		&Pool{Resource: iron, Capacity: 10, Quantity: 7},
+	)
+
+	want := []Delta{
+		{Resource: iron, Quantity: 3},
+		{Resource: ironOre, Quantity: -6},
+	}
+	if diff := cmp.Diff(want, Diff(a, b)); diff != "" {
+		t.Errorf("Diff() mismatch (-want +got):\n%s", diff)
+	}
+
+	Merge(a, b)
+	wantPools := []Pool{
+		{Resource: iron, Capacity: 10, Quantity: 7},
+		{Resource: ironOre, Capacity: 10, Quantity: 6},
+		{Resource: workers, Capacity: 20, Quantity: 2},
+	}
+	if diff := cmp.Diff(wantPools, a.Snapshot()); diff != "" {
+		t.Errorf("pools after Merge() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Merging into an empty set copies the pools rather than sharing them
+	c := NewPoolSet()
+	Merge(c, b)
+	c.Add(iron, 1)
+	if got := b.Quantity(iron); got != 7 {
+		t.Errorf("merged pool shared with source, got %d iron in source", got)
+	}
+	if got := Diff(b, c); len(got) != 1 || got[0].Quantity != 1 {
+		t.Errorf("got diff %v, wanted 1 iron", got)
+	}
+}
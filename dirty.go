@@ -0,0 +1,113 @@
+package rula
+
+// WithSkipUnchanged lets the runner skip evaluating a rule that was blocked
+// by a precondition or input the last time it was due if the pool set that
+// blocked it, and any pool sets holding the rates of its exchanges, have not
+// changed since and the agent's override of the rule is the same. The rule
+// is reported as blocked in the same way without its preconditions and
+// inputs being checked again, which removes most of the work done for idle
+// agents. Only changes made through a pool set's methods or by rules are
+// noticed, so pools must not be changed through the pointers returned by
+// PoolSet.Pool while this is enabled. It has no effect on parallel steps.
+func WithSkipUnchanged() RunnerOption {
+	return func(ru *Runner) {
+		ru.blocked = map[stateKey]blockedRule{}
+	}
+}
+
+// A blockedRule records the pool set that blocked a rule and its version at
+// the time.
+type blockedRule struct {
+	ps       *PoolSet
+	version  uint64
+	rates    []poolVersion // the pool sets holding the rates of the rule's exchanges
+	override RuleOverride  // the agent's override of the rule, zero if none
+	block    *Block
+	onfail   bool // whether the block invoked the rule's onfail rule
+}
+
+type poolVersion struct {
+	ps      *PoolSet
+	version uint64
+}
+
+// rateVersions returns the versions of the pool sets in ctx holding the rates
+// of rule's exchanges.
+func rateVersions(rule *Rule, ctx RuleContext) []poolVersion {
+	var rates []poolVersion
+	for _, x := range rule.Exchanges {
+		if ps, ok := ctx.Pools[x.Rate.Relation]; ok {
+			rates = append(rates, poolVersion{ps: ps, version: ps.changes()})
+		}
+	}
+	return rates
+}
+
+// overrideOf returns a's override of rule, or the zero override if it has
+// none.
+func overrideOf(a *Agent, rule *Rule) RuleOverride {
+	if a == nil || a.Overrides[rule] == nil {
+		return RuleOverride{}
+	}
+	return *a.Overrides[rule]
+}
+
+// unchanged returns a copy of the block recorded for key if the pool sets it
+// depended on have not changed since, and whether the rule's onfail rule
+// should be invoked.
+func (ru *Runner) unchanged(key stateKey, ctx RuleContext) (*Block, bool, bool) {
+	if ru.blocked == nil || ru.parallel > 1 {
+		return nil, false, false
+	}
+	ru.mu.Lock()
+	b, ok := ru.blocked[key]
+	ru.mu.Unlock()
+	if !ok || ctx.Pools[b.block.relation()] != b.ps || b.ps.changes() != b.version || b.override != overrideOf(ctx.Agent, key.rule) {
+		return nil, false, false
+	}
+	rates := rateVersions(key.rule, ctx)
+	if len(rates) != len(b.rates) {
+		return nil, false, false
+	}
+	for i := range rates {
+		if rates[i] != b.rates[i] {
+			return nil, false, false
+		}
+	}
+	block := *b.block
+	return &block, b.onfail, true
+}
+
+// noteBlocked records the outcome of running a rule so that it can be skipped
+// while the pool sets it depended on are unchanged.
+func (ru *Runner) noteBlocked(key stateKey, ctx RuleContext, res *RuleResult, onfail bool) {
+	if ru.blocked == nil || ru.parallel > 1 {
+		return
+	}
+	var b blockedRule
+	if res.Rounds == 0 && res.Blocked != nil {
+		if ps, ok := ctx.Pools[res.Blocked.relation()]; ok {
+			b = blockedRule{ps: ps, version: ps.changes(), rates: rateVersions(key.rule, ctx), override: overrideOf(ctx.Agent, key.rule), block: res.Blocked, onfail: onfail}
+		}
+	}
+
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if b.ps == nil {
+		delete(ru.blocked, key)
+		return
+	}
+	ru.blocked[key] = b
+}
+
+// relation returns the relation of the precondition or input that caused the
+// block, or the empty relation for other blocks.
+func (b *Block) relation() Relation {
+	switch {
+	case b.Precondition != nil:
+		return b.Precondition.Relation
+	case b.Input != nil:
+		return b.Input.Relation
+	}
+	return ""
+}
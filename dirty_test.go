@@ -0,0 +1,101 @@
+package rula
+
+import (
+	"testing"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	tired := &Rule{Name: "tired", Period: 0, Outputs: []ResourceSpecifier{{Relation: RelationSelf, Resource: workers, Quantity: -1}}}
+	smelt := &Rule{
+		Name:    "smelt",
+		Period:  1,
+		Inputs:  []ResourceSpecifier{{Relation: RelationSelf, Resource: ironOre, Quantity: 2}},
+		Outputs: []ResourceSpecifier{{Relation: RelationSelf, Resource: iron, Quantity: 1}},
+		OnFail:  tired,
+	}
+	self := NewPoolSet(
+		&Pool{Resource: ironOre, Capacity: 10, Quantity: 1},
+		&Pool{Resource: iron, Capacity: 10},
+		&Pool{Resource: workers, Capacity: 10, Quantity: 10},
+	)
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self}}
+
+	log := &logRecorder{}
+	ru := NewRunner(WithSkipUnchanged(), WithLogger(log))
+	run := func(tick int64) *RuleResult {
+		res, err := ru.RunRule(smelt, tick, ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return res
+	}
+
+	// The first attempt is evaluated and blocked
+	res := run(1)
+	if res.Blocked == nil || res.Blocked.Input == nil || res.OnFail == nil {
+		t.Fatalf("got result %+v, wanted input block with onfail", res)
+	}
+	evaluated := len(log.lines)
+
+	// The onfail rule changes the self pools, so the next attempt is
+	// evaluated again
+	run(2)
+	if len(log.lines) == evaluated {
+		t.Errorf("attempt after pool change was skipped")
+	}
+
+	// Once nothing changes the attempt is skipped but reported the same way
+	// and the onfail rule still runs
+	tired.Outputs[0].Relation = RelationGlobal
+	ctx.Pools[RelationGlobal] = NewPoolSet(&Pool{Resource: workers, Capacity: 10, Quantity: 10})
+	run(3)
+	evaluated = len(log.lines)
+	res = run(4)
+	if len(log.lines) != evaluated {
+		t.Errorf("unchanged attempt was evaluated: %v", log.lines[evaluated:])
+	}
+	if res.Blocked == nil || res.Blocked.Input == nil || res.Blocked.Quantity != 1 || res.OnFail == nil {
+		t.Errorf("got result %+v, wanted input block with onfail", res)
+	}
+
+	self.Add(ironOre, 1)
+	if res := run(5); res.Rounds != 1 {
+		t.Errorf("got %d rounds after adding ore, wanted 1", res.Rounds)
+	}
+}
+
+func TestSkipUnchangedExchangeRate(t *testing.T) {
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}, Currency: true}
+	grain := &Resource{ID: "grain", Name: Name{Singular: "grain"}}
+	price := &Resource{ID: "grain_price", Name: Name{Singular: "grain_price"}}
+	buy := &Rule{
+		Name:      "buy",
+		Period:    1,
+		Exchanges: []Exchange{{Give: gold, Get: grain, Quantity: 1, Rate: ResourceSource{Relation: RelationGlobal, Resource: price}}},
+	}
+
+	global := NewGlobal(nil)
+	global.Pools.AddPool(price, 100, 5)
+	a := NewAgent("a")
+	a.Pools.AddPool(gold, 100, 4)
+	a.Pools.AddPool(grain, 100, 0)
+	ctx := agentContext(a, global)
+
+	ru := NewRunner(WithSkipUnchanged())
+	res, err := ru.RunRule(buy, 1, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Blocked == nil || res.Blocked.Input == nil {
+		t.Fatalf("got block %v, wanted buying blocked by an input of gold", res.Blocked)
+	}
+
+	// The price falls in the global pools, not the pools that blocked the rule
+	global.Pools.Set(price, 2)
+	if res, err = ru.RunRule(buy, 2, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Rounds != 1 || a.Pools.Quantity(gold) != 2 {
+		t.Errorf("got %d rounds and %d gold after the price fell, wanted 1 and 2", res.Rounds, a.Pools.Quantity(gold))
+	}
+}
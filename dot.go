@@ -0,0 +1,49 @@
+package rula
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// WriteDOT writes the network of m as a Graphviz DOT digraph for viewing
+// with graph tools. Nodes are named by location ID and labelled with the
+// location's name in m, and are pinned at their positions in metres for
+// layout engines such as neato. Connections that can be travelled both
+// ways are drawn without arrows and impassable connections are dashed.
+func WriteDOT(w io.Writer, m *NetworkMap) error {
+	names := map[*Location]string{}
+	for name, l := range m.Locations {
+		names[l] = name
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph network {")
+	for _, l := range m.Network.order {
+		label := names[l]
+		if label == "" {
+			label = strconv.FormatInt(l.id, 10)
+		}
+		pt := metres(l.pos)
+		fmt.Fprintf(bw, "\t%d [label=%q pos=\"%g,%g!\"];\n", l.id, label, pt[0], pt[1])
+	}
+	for _, c := range m.Network.connections() {
+		attrs := fmt.Sprintf("id=%d label=%q distance=%d", c.id, formatLength(c.distance), c.distance)
+		if c.kind != "" {
+			attrs += fmt.Sprintf(" kind=%q", c.kind)
+		}
+		if !c.oneWay {
+			attrs += " dir=none"
+		}
+		if math.IsInf(c.difficulty, 1) {
+			attrs += " style=dashed"
+		} else if c.difficulty != 0 {
+			attrs += fmt.Sprintf(" difficulty=%g", c.difficulty)
+		}
+		fmt.Fprintf(bw, "\t%d -> %d [%s];\n", c.from.id, c.to.id, attrs)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,39 @@
+package rula
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteDOT(t *testing.T) {
+	m, err := NewNetworkParser().Parse(strings.NewReader(`location harbour
+	position 2km -500m
+	connect town 12km kind road
+	connect town 10km kind river difficulty 0.5 oneway
+end
+
+location town
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDOT(&buf, m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `digraph network {
+	1 [label="harbour" pos="2000,-500!"];
+	2 [label="town" pos="0,0!"];
+	1 -> 2 [id=1 label="12km" distance=12000000 kind="road" dir=none];
+	1 -> 2 [id=2 label="10km" distance=10000000 kind="river" difficulty=0.5];
+}
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("WriteDOT() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,83 @@
+package rula
+
+// RelationEach refers, in a global rule, to each agent in turn. A global rule
+// that uses it, such as a tax or a plague, is run once for every agent
+// passed to Runner.Step, so a World applies it to all of its agents.
+const RelationEach Relation = "each"
+
+// perAgent reports whether rule uses the each relation.
+func (r *Rule) perAgent() bool {
+	for _, c := range r.Preconditions {
+		if c.Relation == RelationEach {
+			return true
+		}
+	}
+	for _, c := range r.AttrConditions {
+		if c.Relation == RelationEach {
+			return true
+		}
+	}
+	for _, c := range r.NearConditions {
+		if c.Relation == RelationEach {
+			return true
+		}
+	}
+	for _, specs := range [][]ResourceSpecifier{r.Inputs, r.Outputs, r.Sets, r.Ships} {
+		for _, s := range specs {
+			if s.Relation == RelationEach {
+				return true
+			}
+		}
+	}
+	for _, x := range r.Exchanges {
+		if x.Rate.Relation == RelationEach {
+			return true
+		}
+	}
+	return r.RepeatFrom != nil && r.RepeatFrom.Relation == RelationEach
+}
+
+// splitGlobal separates the global rules that are run once per agent from
+// those run once per tick.
+func splitGlobal(rules []*Rule) (once, each []*Rule) {
+	for i, r := range rules {
+		if r.perAgent() {
+			if each == nil {
+				once = append([]*Rule(nil), rules[:i]...)
+			}
+			each = append(each, r)
+		} else if each != nil {
+			once = append(once, r)
+		}
+	}
+	if each == nil {
+		return rules, nil
+	}
+	return once, each
+}
+
+// runEach runs each of the rules once for every agent, in the global context
+// with the each relation referring to the agent's pools. The runner keeps
+// separate timing state for each agent, as it does for the agent's own
+// rules. The results are added to rr in order of rule, then agent.
+func (ru *Runner) runEach(rules []*Rule, agents []*Agent, tick int64, global *Global, rr *RunReport) error {
+	ctx := global.RuleContext()
+	for _, rule := range rules {
+		if rule.Period == 0 {
+			continue
+		}
+		for _, a := range agents {
+			ctx.Pools[RelationEach] = a.Pools
+			ctx.Agent = a
+			res, err := ru.RunRule(rule, tick, ctx)
+			if res.Due {
+				rr.Results = append(rr.Results, res)
+				rr.noteBudget(res)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
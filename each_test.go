@@ -0,0 +1,59 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobalRuleEach(t *testing.T) {
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}}
+	rules, err := NewRuleParser([]*Resource{gold}).Parse(strings.NewReader(`
+rule tax
+	every 2
+	in each gold 1
+	out gold 1
+end
+
+rule treasury
+	out gold 10
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	global := NewGlobal(rules)
+	global.SetCapacity(gold, 100)
+	w := NewWorld(global)
+	for _, name := range []string{"ann", "bob", "cat"} {
+		a := NewAgent(name)
+		a.Pools.SetCapacity(gold, 10)
+		w.AddAgent(a)
+	}
+	w.Agents()[0].Pools.Add(gold, 5)
+	w.Agents()[1].Pools.Add(gold, 5)
+
+	var report *StepReport
+	for i := 0; i < 2; i++ {
+		if report, err = w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := global.Pools.Quantity(gold); got != 22 {
+		t.Errorf("got treasury %d, wanted 22", got)
+	}
+	for i, want := range []int{4, 4, 0} {
+		if got := w.Agents()[i].Pools.Quantity(gold); got != want {
+			t.Errorf("got %s gold %d, wanted %d", w.Agents()[i].ID, got, want)
+		}
+	}
+
+	// One result for the treasury and one for the tax of each agent
+	if got := len(report.Global.Results); got != 4 {
+		t.Fatalf("got %d global results, wanted 4", got)
+	}
+	if res := report.Global.Results[3]; res.Fired() || res.Blocked == nil || res.Blocked.Input == nil {
+		t.Errorf("got tax of agent without gold %+v, wanted it blocked by its input", res)
+	}
+}
@@ -0,0 +1,91 @@
+package rula
+
+import "fmt"
+
+// An Effect is a custom action attached to a rule, by Go code or by a
+// DirectiveHandler, such as playing a sound or unlocking a technology. The
+// Runner applies a rule's custom effects once each time the rule fires, after
+// its changes to the pools have been made, passing the context the rule was
+// run with and the number of rounds it completed. A runner made with
+// WithParallel may apply the effects of rules run for different agents
+// concurrently.
+type Effect interface {
+	Apply(ctx RuleContext, rounds int) error
+}
+
+// An EffectFunc is a function used as an Effect.
+type EffectFunc func(ctx RuleContext, rounds int) error
+
+func (f EffectFunc) Apply(ctx RuleContext, rounds int) error {
+	return f(ctx, rounds)
+}
+
+// A Condition is a custom condition on a rule that must hold for the rule to
+// run. The Runner checks a rule's custom conditions once each time the rule
+// is due, after its attribute and near conditions and before its
+// preconditions and inputs.
+type Condition interface {
+	Holds(ctx RuleContext) bool
+}
+
+// A ConditionFunc is a function used as a Condition.
+type ConditionFunc func(ctx RuleContext) bool
+
+func (f ConditionFunc) Holds(ctx RuleContext) bool {
+	return f(ctx)
+}
+
+// A DirectiveHandler parses the arguments of a custom rule directive,
+// returning the effect it adds to the rule. See RuleParser.RegisterDirective.
+type DirectiveHandler func(args []string) (Effect, error)
+
+// RegisterDirective makes the parser accept rule directives with the given
+// name, passing their arguments to h and adding the effects it returns to
+// the rule's CustomEffects. The parser's own directives take precedence over
+// registered ones.
+func (p *RuleParser) RegisterDirective(name string, h DirectiveHandler) {
+	if p.handlers == nil {
+		p.handlers = map[string]DirectiveHandler{}
+	}
+	p.handlers[name] = h
+}
+
+// directiveNames returns the names of the directives the parser accepts.
+func (p *RuleParser) directiveNames() []string {
+	names := append([]string(nil), ruleDirectives...)
+	for name := range p.handlers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// conditionBlock returns a Block for the first custom condition of rule that
+// does not hold in ctx, or nil if they all hold.
+func conditionBlock(rule *Rule, ctx RuleContext) *Block {
+	for i, c := range rule.CustomConditions {
+		if !c.Holds(ctx) {
+			return &Block{Condition: rule.CustomConditions[i]}
+		}
+	}
+	return nil
+}
+
+// conditionLabel describes a custom condition using its String method, if it
+// has one.
+func conditionLabel(c Condition) string {
+	if s, ok := c.(fmt.Stringer); ok {
+		return "custom condition " + s.String()
+	}
+	return "custom condition"
+}
+
+// applyEffects applies the custom effects of a rule that completed rounds
+// rounds.
+func applyEffects(rule *Rule, ctx RuleContext, rounds int) error {
+	for _, e := range rule.CustomEffects {
+		if err := e.Apply(ctx, rounds); err != nil {
+			return fmt.Errorf("rule %q: effect: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package rula
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type unlockEffect struct {
+	tech     string
+	unlocked *[]string
+}
+
+func (e unlockEffect) Apply(ctx RuleContext, rounds int) error {
+	*e.unlocked = append(*e.unlocked, ctx.Agent.Name.Singular+":"+e.tech)
+	return nil
+}
+
+func TestRuleEffects(t *testing.T) {
+	science := &Resource{Name: Name{Singular: "science"}}
+	var unlocked []string
+
+	p := NewRuleParser([]*Resource{science})
+	p.RegisterDirective("unlock_tech", func(args []string) (Effect, error) {
+		if len(args) != 1 {
+			return nil, errors.New("wanted one technology")
+		}
+		return unlockEffect{tech: args[0], unlocked: &unlocked}, nil
+	})
+
+	rules, err := p.Parse(strings.NewReader(`
+rule research
+	in science 10
+	unlock_tech bronze
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rules[0].CustomEffects) != 1 {
+		t.Fatalf("got %d effects, wanted 1", len(rules[0].CustomEffects))
+	}
+
+	a := NewAgent("city")
+	a.AddPool(science, 100, 15)
+	ru := NewRunner()
+	for tick := int64(1); tick <= 2; tick++ {
+		if _, err := ru.Run(rules, tick, a.RuleContext()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := strings.Join(unlocked, " "); got != "city:bronze" {
+		t.Errorf("got unlocked %q, wanted the effect applied only when the rule fired", got)
+	}
+
+	for _, spec := range []string{
+		"rule r\n\tunlock_tech\nend\n",
+		"rule r\n\tunlock_science bronze\nend\n",
+	} {
+		if _, err := p.Parse(strings.NewReader(spec)); err == nil {
+			t.Errorf("got no error parsing %q, wanted one", spec)
+		}
+	}
+}
+
+func TestCustomConditionsAndEffects(t *testing.T) {
+	gold := &Resource{Name: Name{Singular: "gold"}}
+	open := false
+	var paid []int
+	trade := NewRule("trade").
+		IfFunc(ConditionFunc(func(ctx RuleContext) bool { return open })).
+		Out(RelationSelf, gold, 1).
+		Repeat(2).
+		Do(EffectFunc(func(ctx RuleContext, rounds int) error {
+			paid = append(paid, rounds)
+			return nil
+		})).
+		Build()
+
+	a := NewAgent("merchant")
+	a.AddPool(gold, 100, 0)
+	ru := NewRunner()
+
+	res, err := ru.RunRule(trade, 1, a.RuleContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Fired() || res.Blocked == nil || res.Blocked.Condition == nil {
+		t.Errorf("got result %+v, wanted rule blocked by its custom condition", res)
+	}
+	if got := ru.Explain(trade, a.RuleContext()).Checks[0].String(); got != "custom condition: not met" {
+		t.Errorf("got check %q, wanted custom condition not met", got)
+	}
+
+	open = true
+	if _, err := ru.RunRule(trade, 2, a.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := a.Pools.Quantity(gold); got != 3 || len(paid) != 1 || paid[0] != 3 {
+		t.Errorf("got gold %d and effects %v, wanted 3 gold and one effect for 3 rounds", got, paid)
+	}
+
+	failing := NewRule("fail").Do(EffectFunc(func(RuleContext, int) error { return errors.New("boom") })).Build()
+	if _, err := ru.RunRule(failing, 1, a.RuleContext()); err == nil {
+		t.Errorf("got no error from a failing effect, wanted one")
+	}
+}
@@ -0,0 +1,78 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource smoke
+end
+
+event alarm
+end
+
+resource guard
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	smoke, alarm, guard := resources[0], resources[1], resources[2]
+	if !alarm.Event || smoke.Event {
+		t.Fatalf("event not parsed")
+	}
+
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule watch
+	in smoke 1
+	emit town alarm 2
+end
+
+rule muster
+	if alarm > 0
+	in alarm 1
+	out guard 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	town := NewAgent("town")
+	town.AddPool(guard, 10, 0)
+	town.AppendRules(rules[1:])
+	tower := NewAgent("tower")
+	tower.AddPool(smoke, 10, 1)
+	tower.AddRelation("town", town)
+	tower.AppendRules(rules[:1])
+
+	ru := NewRunner()
+	if _, err := ru.Step(nil, []*Agent{tower, town}, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// One alarm is handled in the tick it is sent and the other remains
+	// pending
+	if got := town.Pools.Quantity(guard); got != 1 {
+		t.Errorf("got %d guards, wanted 1", got)
+	}
+	if got := town.Pools.Quantity(alarm); got != 1 {
+		t.Errorf("got %d pending alarms, wanted 1", got)
+	}
+
+	if _, err := ru.Step(nil, []*Agent{tower, town}, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := town.Pools.Quantity(guard); got != 2 {
+		t.Errorf("got %d guards, wanted 2", got)
+	}
+	if got := town.Pools.Quantity(alarm); got != 0 {
+		t.Errorf("got %d pending alarms, wanted 0", got)
+	}
+
+	if _, err := NewRuleParser(resources).Parse(strings.NewReader("rule a\n\temit smoke\nend\n")); err == nil {
+		t.Errorf("got no error emitting a resource that is not an event")
+	}
+}
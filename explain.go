@@ -0,0 +1,181 @@
+package rula
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An Explanation describes how one round of a rule evaluates against the
+// current state of the pools.
+type Explanation struct {
+	Rule   *Rule
+	CanRun bool    // true if every condition holds and every input is available
+	Checks []Check // attribute, near and custom conditions, preconditions, then inputs, outputs and sets in rule order
+}
+
+// A Check is the evaluation of one attribute condition, near condition,
+// custom condition, precondition, input, output or set of a rule.
+type Check struct {
+	Kind      string            // attribute, near, condition, precondition, input, output or set
+	Attr      *AttrCondition    // the condition of an attribute check
+	Value     string            // the value of the attribute found
+	Near      *NearCondition    // the condition of a near check
+	Distance  Length            // the distance found for a near check
+	Condition Condition         // the condition of a custom condition check
+	Spec      ResourceSpecifier // the relation, resource and quantity named by the rule
+	Op        Op                // the operator of a precondition
+	Missing   bool              // the relation has no pool set in the rule context, or an agent of a near check has no position
+	Actual    int               // quantity in the pool before the check
+	After     int               // quantity after the round for inputs, outputs and sets
+	Lost      int               // quantity of an output or set lost to the pool's capacity
+	OK        bool              // whether the check passed
+}
+
+// Explain evaluates one round of rule against the pools in ctx without
+// changing them. Inputs, outputs and sets are evaluated in order so that
+// later checks see the changes made by earlier ones. As in RunRule, any
+// override of the rule by the context's agent is applied. The rule's period,
+// chance and repeats are not considered.
+func (ru *Runner) Explain(rule *Rule, ctx RuleContext) *Explanation {
+	ex := &Explanation{Rule: rule, CanRun: true}
+	tx := newTxn(rule, nil, ctx)
+	tx.preview = true
+	rule = expand(ctx.Agent.overridden(rule), ctx)
+
+	for i, c := range rule.AttrConditions {
+		chk := Check{Kind: "attribute", Attr: &rule.AttrConditions[i], Value: attrValue(c, ctx)}
+		chk.OK = c.holds(chk.Value)
+		ex.add(chk)
+	}
+
+	for i, c := range rule.NearConditions {
+		chk := Check{Kind: "near", Near: &rule.NearConditions[i]}
+		d, ok := nearDistance(c, ctx)
+		chk.Distance, chk.Missing = d, !ok
+		chk.OK = ok && d <= c.Within
+		ex.add(chk)
+	}
+
+	for _, c := range rule.CustomConditions {
+		ex.add(Check{Kind: "condition", Condition: c, OK: c.Holds(ctx)})
+	}
+
+	for _, c := range rule.Preconditions {
+		chk := Check{Kind: "precondition", Spec: c.ResourceSpecifier, Op: c.Op}
+		if ps, ok := ctx.Pools[c.Relation]; ok {
+			chk.Actual = ps.Quantity(c.Resource)
+			chk.OK = c.Op.holds(chk.Actual, c.Quantity)
+		} else {
+			chk.Missing = true
+		}
+		ex.add(chk)
+	}
+
+	effects := []struct {
+		kind  string
+		specs []ResourceSpecifier
+	}{
+		{"input", rule.Inputs},
+		{"output", rule.Outputs},
+		{"set", rule.Sets},
+	}
+	for _, group := range effects {
+		for _, s := range group.specs {
+			chk := Check{Kind: group.kind, Spec: s}
+			ps, ok := ctx.Pools[s.Relation]
+			if !ok {
+				chk.Missing = true
+				ex.add(chk)
+				continue
+			}
+
+			chk.Actual = tx.quantity(ps, s.Resource)
+			switch group.kind {
+			case "input":
+				chk.OK = tx.remove(s.Relation, ps, s.Resource, s.Quantity) == 0
+			case "output":
+				chk.Lost = tx.add(s.Relation, ps, s.Resource, s.Quantity)
+				chk.OK = true
+			case "set":
+				chk.Lost = tx.set(s.Relation, ps, s.Resource, s.Quantity)
+				chk.OK = true
+			}
+			chk.After = tx.quantity(ps, s.Resource)
+			ex.add(chk)
+		}
+	}
+
+	return ex
+}
+
+func (ex *Explanation) add(chk Check) {
+	ex.Checks = append(ex.Checks, chk)
+	if !chk.OK {
+		ex.CanRun = false
+	}
+}
+
+// String formats the explanation with one line per check.
+func (ex *Explanation) String() string {
+	var b strings.Builder
+	if ex.CanRun {
+		fmt.Fprintf(&b, "rule %q can run\n", ex.Rule.Name)
+	} else {
+		fmt.Fprintf(&b, "rule %q cannot run\n", ex.Rule.Name)
+	}
+	for _, chk := range ex.Checks {
+		b.WriteString("  ")
+		b.WriteString(chk.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (chk Check) String() string {
+	s := chk.Spec
+	if chk.Kind == "attribute" {
+		head := fmt.Sprintf("ifattr %s", chk.Attr)
+		if chk.OK {
+			return fmt.Sprintf("%s: found %q", head, chk.Value)
+		}
+		return fmt.Sprintf("%s: found %q, not met", head, chk.Value)
+	}
+	if chk.Kind == "near" {
+		head := fmt.Sprintf("ifnear %s", chk.Near)
+		switch {
+		case chk.Missing:
+			return fmt.Sprintf("%s: no position", head)
+		case chk.OK:
+			return fmt.Sprintf("%s: found %s", head, formatLength(chk.Distance))
+		}
+		return fmt.Sprintf("%s: found %s, not met", head, formatLength(chk.Distance))
+	}
+	if chk.Kind == "condition" {
+		if chk.OK {
+			return conditionLabel(chk.Condition) + ": met"
+		}
+		return conditionLabel(chk.Condition) + ": not met"
+	}
+	if chk.Kind == "precondition" {
+		head := fmt.Sprintf("if %s %s %s %d", s.Relation, s.Resource, chk.Op, s.Quantity)
+		switch {
+		case chk.Missing:
+			return fmt.Sprintf("%s: no %s pool set", head, s.Relation)
+		case chk.OK:
+			return fmt.Sprintf("%s: found %d", head, chk.Actual)
+		}
+		return fmt.Sprintf("%s: found %d, not met", head, chk.Actual)
+	}
+
+	verb := map[string]string{"input": "in", "output": "out", "set": "set"}[chk.Kind]
+	head := fmt.Sprintf("%s %s %s %d", verb, s.Relation, s.Resource, s.Quantity)
+	switch {
+	case chk.Missing:
+		return fmt.Sprintf("%s: no %s pool set", head, s.Relation)
+	case !chk.OK:
+		return fmt.Sprintf("%s: found %d, not enough", head, chk.Actual)
+	case chk.Lost > 0:
+		return fmt.Sprintf("%s: %d -> %d, %d lost to capacity", head, chk.Actual, chk.After, chk.Lost)
+	}
+	return fmt.Sprintf("%s: %d -> %d", head, chk.Actual, chk.After)
+}
@@ -0,0 +1,48 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplain(t *testing.T) {
+	smelt := NewRule("smelt").
+		If(RelationSelf, workers, OpGreaterThan, 0).
+		If(RelationGlobal, iron, OpLessThan, 5).
+		In(RelationSelf, ironOre, 2).
+		In(RelationSelf, ironOre, 2).
+		Out(RelationSelf, iron, 3).
+		Set(RelationLocation, workers, 1).
+		Build()
+
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{
+		RelationSelf: NewPoolSet(
+			&Pool{Resource: ironOre, Capacity: 10, Quantity: 3},
+			&Pool{Resource: iron, Capacity: 4, Quantity: 2},
+			&Pool{Resource: workers, Capacity: 10, Quantity: 1},
+		),
+		RelationGlobal: NewPoolSet(&Pool{Resource: iron, Capacity: 10, Quantity: 7}),
+	}}
+
+	ex := NewRunner().Explain(smelt, ctx)
+	if ex.CanRun {
+		t.Errorf("got can run, wanted cannot")
+	}
+
+	want := `rule "smelt" cannot run
+  if self workers > 0: found 1
+  if global iron < 5: found 7, not met
+  in self iron_ore 2: 3 -> 1
+  in self iron_ore 2: found 1, not enough
+  out self iron 3: 2 -> 4, 1 lost to capacity
+  set location workers 1: no location pool set
+`
+	if diff := cmp.Diff(want, ex.String()); diff != "" {
+		t.Errorf("explanation mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := ctx.Pools[RelationSelf].Quantity(ironOre); got != 3 {
+		t.Errorf("explain changed iron_ore to %d", got)
+	}
+}
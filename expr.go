@@ -0,0 +1,616 @@
+package rula
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+
+Quantity expressions
+
+Expressions compute an integer from the pools and agents visible in a
+RuleContext.
+
+  <relation>.<resource>
+  	the quantity of a resource in the related pool set
+  <resource>
+  	the quantity of a resource in the self pool set
+  <relation>.@<attr>
+  	the value of an attribute of the related agent, which must be an
+  	integer. an agent without the attribute, or a missing agent, gives 0
+  @<attr>
+  	the value of an attribute of the agent the rule is run for
+
+Integer literals, parentheses and the operators below are supported, listed
+from lowest to highest precedence. Comparisons and logical operators evaluate
+to 1 for true and 0 for false. Division by zero is an error.
+
+  ||
+  &&
+  = == != < <= > >=
+  + -
+  * / %
+  unary - !
+
+Functions:
+
+  min(a, b, ...)  max(a, b, ...)  abs(a)  clamp(x, lo, hi)
+  pow(a, b)  sqrt(a)
+
+sqrt rounds down. Negative arguments to sqrt, negative exponents and powers
+too large to hold in an int are errors.
+
+*/
+
+// An Expr is a parsed quantity expression.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ParseExpr parses a quantity expression.
+func ParseExpr(s string) (*Expr, error) {
+	toks, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// Eval evaluates the expression against the pools in ctx.
+func (e *Expr) Eval(ctx RuleContext) (int, error) {
+	return e.root.eval(ctx)
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// EvalExpr parses and evaluates a quantity expression against the pools in
+// ctx, using the same pool lookups as the Runner.
+func EvalExpr(expr string, ctx RuleContext) (int, error) {
+	e, err := ParseExpr(expr)
+	if err != nil {
+		return 0, err
+	}
+	return e.Eval(ctx)
+}
+
+type exprNode interface {
+	eval(ctx RuleContext) (int, error)
+}
+
+type numNode int
+
+func (n numNode) eval(RuleContext) (int, error) {
+	return int(n), nil
+}
+
+type refNode struct {
+	relation Relation
+	name     string
+	res      *Resource // the resource named, if bound when the rule was parsed
+}
+
+func (n *refNode) eval(ctx RuleContext) (int, error) {
+	poolset, ok := ctx.Pools[n.relation]
+	if !ok {
+		return 0, fmt.Errorf("no poolset of type %v", n.relation)
+	}
+	if n.res != nil {
+		return poolset.Quantity(n.res), nil
+	}
+	// Resolve the name against the pools of the set and of the parents it
+	// falls through to, then read the quantity as the Runner does
+	for ps := poolset; ps != nil; ps = ps.Parent() {
+		for _, pool := range ps.Snapshot() {
+			r := pool.Resource
+			// Sub-pools share the name of their resource so only match by ID
+			if strings.EqualFold(r.ID, n.name) || (r.Base == nil && strings.EqualFold(r.Name.Singular, n.name)) {
+				return poolset.Quantity(r), nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+type attrNode struct {
+	relation Relation
+	name     string
+}
+
+func (n *attrNode) eval(ctx RuleContext) (int, error) {
+	ra := ctx.Agent.related(n.relation)
+	if ra == nil {
+		return 0, nil
+	}
+	v, ok := ra.Attr(n.name)
+	if !ok {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("attribute %q is not a number: %q", n.name, v)
+	}
+	return i, nil
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) eval(ctx RuleContext) (int, error) {
+	x, err := n.x.eval(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return boolInt(x == 0), nil
+	}
+	return -x, nil
+}
+
+type binaryNode struct {
+	op   string
+	x, y exprNode
+}
+
+func (n *binaryNode) eval(ctx RuleContext) (int, error) {
+	x, err := n.x.eval(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Logical operators short circuit
+	switch n.op {
+	case "&&":
+		if x == 0 {
+			return 0, nil
+		}
+	case "||":
+		if x != 0 {
+			return 1, nil
+		}
+	}
+
+	y, err := n.y.eval(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return boolInt(y != 0), nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if n.op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "=", "==":
+		return boolInt(x == y), nil
+	case "!=":
+		return boolInt(x != y), nil
+	case "<":
+		return boolInt(x < y), nil
+	case "<=":
+		return boolInt(x <= y), nil
+	case ">":
+		return boolInt(x > y), nil
+	case ">=":
+		return boolInt(x >= y), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", n.op)
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n *callNode) eval(ctx RuleContext) (int, error) {
+	args := make([]int, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(ctx)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	switch n.name {
+	case "min", "max":
+		v := args[0]
+		for _, a := range args[1:] {
+			if (n.name == "min") == (a < v) {
+				v = a
+			}
+		}
+		return v, nil
+	case "abs":
+		if args[0] < 0 {
+			return -args[0], nil
+		}
+		return args[0], nil
+	case "clamp":
+		v := args[0]
+		if v < args[1] {
+			v = args[1]
+		}
+		if v > args[2] {
+			v = args[2]
+		}
+		return v, nil
+	case "pow":
+		if args[1] < 0 {
+			return 0, fmt.Errorf("negative exponent %d", args[1])
+		}
+		return powInt(args[0], args[1])
+	case "sqrt":
+		if args[0] < 0 {
+			return 0, fmt.Errorf("square root of negative number %d", args[0])
+		}
+		v := int(math.Sqrt(float64(args[0])))
+		// Correct any rounding in the conversion to floating point
+		for v*v > args[0] {
+			v--
+		}
+		for (v+1)*(v+1) <= args[0] {
+			v++
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown function %q", n.name)
+}
+
+// exprFuncArity gives the minimum and maximum number of arguments accepted by
+// each function. A maximum of -1 means unlimited.
+var exprFuncArity = map[string][2]int{
+	"min":   {1, -1},
+	"max":   {1, -1},
+	"abs":   {1, 1},
+	"clamp": {3, 3},
+	"pow":   {2, 2},
+	"sqrt":  {1, 1},
+}
+
+// powInt returns a raised to the non-negative power b, computed by repeated
+// squaring, or an error if the result overflows an int.
+func powInt(a, b int) (int, error) {
+	v := 1
+	for b > 0 {
+		var ok bool
+		if b&1 == 1 {
+			if v, ok = mulInt(v, a); !ok {
+				return 0, fmt.Errorf("pow overflows")
+			}
+		}
+		b >>= 1
+		if b > 0 {
+			if a, ok = mulInt(a, a); !ok {
+				return 0, fmt.Errorf("pow overflows")
+			}
+		}
+	}
+	return v, nil
+}
+
+// mulInt returns a*b and whether it was computed without overflow.
+func mulInt(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	v := a * b
+	return v, v/b == a && (v < 0) == ((a < 0) != (b < 0))
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func lexExpr(s string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(s) && unicode.IsDigit(rune(s[i])) {
+				i++
+			}
+			toks = append(toks, token{kind: tokNum, text: s[start:i], pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || s[i] == '_') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: s[start:i], pos: start})
+		default:
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					toks = append(toks, token{kind: tokOp, text: two, pos: i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%()<>=!,.@", c) {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: string(c), pos: i})
+			i++
+		}
+	}
+	toks = append(toks, token{kind: tokEOF, text: "end of expression", pos: len(s)})
+	return toks, nil
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *exprParser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is an operator in ops.
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q but found %q at offset %d", op, tok.text, tok.pos)
+	}
+	return nil
+}
+
+// binary parses a left associative sequence of operands separated by ops.
+func (p *exprParser) binary(operand func() (exprNode, error), ops ...string) (exprNode, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return x, nil
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.binary(p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.binary(p.parseCompare, "&&")
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("=", "==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return x, nil
+	}
+	y, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: op, x: x, y: y}, nil
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	return p.binary(p.parseProduct, "+", "-")
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	return p.binary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if op, ok := p.accept("-", "!"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNum:
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number at offset %d: %v", tok.pos, err)
+		}
+		return numNode(n), nil
+	case tokIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok)
+		}
+		if _, ok := p.accept("."); ok {
+			if _, ok := p.accept("@"); ok {
+				return p.parseAttr(Relation(strings.ToLower(tok.text)))
+			}
+			res := p.next()
+			if res.kind != tokIdent {
+				return nil, fmt.Errorf("expected resource name but found %q at offset %d", res.text, res.pos)
+			}
+			return &refNode{relation: Relation(strings.ToLower(tok.text)), name: res.text}, nil
+		}
+		return &refNode{relation: RelationSelf, name: tok.text}, nil
+	case tokOp:
+		if tok.text == "@" {
+			return p.parseAttr(RelationSelf)
+		}
+		if tok.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+// parseAttr parses the name of an attribute of the agent related by rel,
+// following its @.
+func (p *exprParser) parseAttr(rel Relation) (exprNode, error) {
+	name := p.next()
+	if name.kind != tokIdent {
+		return nil, fmt.Errorf("expected attribute name but found %q at offset %d", name.text, name.pos)
+	}
+	return &attrNode{relation: rel, name: name.text}, nil
+}
+
+func (p *exprParser) parseCall(fn token) (exprNode, error) {
+	name := strings.ToLower(fn.text)
+	arity, ok := exprFuncArity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", fn.text, fn.pos)
+	}
+
+	call := &callNode{name: name}
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(call.args) < arity[0] || (arity[1] >= 0 && len(call.args) > arity[1]) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at offset %d: %d", name, fn.pos, len(call.args))
+	}
+	return call, nil
+}
+
+// leaves calls fn for each pool and attribute reference in the expression and
+// returns the first error it returns.
+func (e *Expr) leaves(fn func(exprNode) error) error {
+	var walk func(n exprNode) error
+	walk = func(n exprNode) error {
+		switch n := n.(type) {
+		case *refNode, *attrNode:
+			return fn(n)
+		case *unaryNode:
+			return walk(n.x)
+		case *binaryNode:
+			if err := walk(n.x); err != nil {
+				return err
+			}
+			return walk(n.y)
+		case *callNode:
+			for _, a := range n.args {
+				if err := walk(a); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(e.root)
+}
+
+// An ExprCondition is a Condition that holds when its expression evaluates to
+// a value other than zero. It does not hold if the expression cannot be
+// evaluated, such as when it divides by zero or names a relation that has no
+// pool set.
+type ExprCondition struct {
+	Expr *Expr
+}
+
+func (c ExprCondition) Holds(ctx RuleContext) bool {
+	v, err := c.Expr.Eval(ctx)
+	return err == nil && v != 0
+}
+
+func (c ExprCondition) String() string {
+	return "expr " + c.Expr.String()
+}
@@ -0,0 +1,143 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalExpr(t *testing.T) {
+	chief := NewAgent("chief")
+	chief.SetAttr("level", "3")
+	chief.SetAttr("culture", "norse")
+	ctx := RuleContext{
+		Agent: chief,
+		Pools: map[Relation]*PoolSet{
+			RelationSelf: NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 100, Quantity: 12},
+				&Pool{Resource: workers, Capacity: 100, Quantity: 4},
+			),
+			RelationGlobal: NewPoolSet(
+				&Pool{Resource: iron, Capacity: 100, Quantity: 7},
+			),
+		},
+	}
+
+	testCases := []struct {
+		expr string
+		want int
+		err  bool
+	}{
+		{expr: "3", want: 3},
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "-4 + 10 % 4", want: -2},
+		{expr: "iron_ore", want: 12},
+		{expr: "self.iron_ore / workers", want: 3},
+		{expr: "global.iron - 2", want: 5},
+		{expr: "global.workers", want: 0},
+		{expr: "min(iron_ore, global.iron, 9)", want: 7},
+		{expr: "max(1, 2) + abs(-3)", want: 5},
+		{expr: "iron_ore > 10 && global.iron <= 7", want: 1},
+		{expr: "iron_ore = 11 || !workers", want: 0},
+		{expr: "workers != 4", want: 0},
+		{expr: "1 / 0", err: true},
+		{expr: "location.iron", err: true},
+		{expr: "1 +", err: true},
+		{expr: "(1 + 2", err: true},
+		{expr: "sqrt(10) + pow(2, 10)", want: 1027},
+		{expr: "pow(1, 100000000000) + pow(-1, 99999999999)", want: 0},
+		{expr: "pow(-3, 3) + pow(5, 0)", want: -26},
+		{expr: "clamp(iron_ore, 0, 10) + clamp(-5, 0, 10)", want: 10},
+		{expr: "@level * 2 + self.@missing", want: 6},
+		{expr: "neighbour.@level", want: 0},
+		{expr: "@culture", err: true},
+		{expr: "sqrt(-1)", err: true},
+		{expr: "pow(2, -1)", err: true},
+		{expr: "pow(2, 100)", err: true},
+		{expr: "pow(3, 40)", err: true},
+		{expr: "log(4)", err: true},
+		{expr: "abs(1, 2)", err: true},
+		{expr: "2 $ 3", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			got, err := EvalExpr(tc.expr, ctx)
+			if tc.err {
+				if err == nil {
+					t.Errorf("got no error, wanted one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %d, wanted %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvalExprParent(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	city := NewAgent("city")
+	city.AddPool(food, 100, 7)
+	worker := NewAgent("worker")
+	worker.SetParent(city, true)
+	ctx := worker.RuleContext()
+
+	got, err := EvalExpr("food", ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := ctx.Pools[RelationSelf].Quantity(food); got != want || got != 7 {
+		t.Errorf("got %d, wanted the inherited quantity %d", got, want)
+	}
+}
+
+func TestRuleExprCondition(t *testing.T) {
+	population := &Resource{Name: Name{Singular: "population"}}
+	houses := &Resource{Name: Name{Singular: "houses"}}
+	unrest := &Resource{Name: Name{Singular: "unrest"}}
+	p := NewRuleParser([]*Resource{population, houses, unrest})
+	rules, err := p.Parse(strings.NewReader(`
+rule overcrowding
+	if expr population / houses > 4
+	out unrest 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a := NewAgent("town")
+	a.AddPool(population, 100, 20)
+	a.AddPool(houses, 10, 5)
+	a.AddPool(unrest, 10, 0)
+	ru := NewRunner()
+	for tick, h := range []int{5, 4, 0} {
+		a.Pools.Set(houses, h)
+		res, err := ru.RunRule(rules[0], int64(tick+1), a.RuleContext())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := h == 4; res.Fired() != want {
+			t.Errorf("%d houses: got fired %v, wanted %v", h, res.Fired(), want)
+		}
+		if !res.Fired() && res.Blocked.String() != "custom condition expr population / houses > 4 not met" {
+			t.Errorf("got block %q", res.Blocked)
+		}
+	}
+
+	p.Strict()
+	for _, spec := range []string{
+		"rule r\n\tif expr population / cottages > 4\nend\n",
+		"rule r\n\tif expr population >\nend\n",
+		"rule r\n\tif expr neighbour.population > 4\nend\n",
+	} {
+		if _, err := p.Parse(strings.NewReader(spec)); err == nil {
+			t.Errorf("got no error parsing %q, wanted one", spec)
+		}
+	}
+}
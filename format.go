@@ -0,0 +1,87 @@
+package rula
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/iand/loon"
+)
+
+// directiveRanks gives the canonical order of the directives of each object
+// type whose directives can be reordered without changing their meaning.
+// Directives that add to the same list, such as out and emit, share a rank
+// so that their order is kept.
+var directiveRanks = map[string]map[string]int{
+	"rule": {
+		"every":    0,
+		"chance":   1,
+		"repeat":   2,
+		"if":       3,
+		"ifattr":   4,
+		"ifnear":   5,
+		"in":       6,
+		"out":      7,
+		"emit":     7,
+		"set":      8,
+		"exchange": 9,
+		"buy":      10,
+		"sell":     10,
+		"ship":     11,
+		"spawn":    12,
+		"move":     13,
+		"destroy":  14,
+		"overflow": 15,
+		"onfail":   16,
+	},
+	"resource": resourceRanks,
+	"event":    resourceRanks,
+	"currency": resourceRanks,
+}
+
+var resourceRanks = map[string]int{
+	"singular": 0,
+	"plural":   1,
+	"decay":    2,
+	"regen":    3,
+	"weight":   4,
+	"volume":   5,
+}
+
+// Format reads a rula data file and returns it in canonical form: object
+// types and directive names in lower case, directives indented by a tab and,
+// in rules and resources, sorted into a fixed order. Unknown directives
+// follow the known ones in their original order. Comments are kept with the
+// objects and directives they precede. The directives of agents, templates
+// and locations are not reordered since their order can matter.
+func Format(r io.Reader) ([]byte, error) {
+	doc, err := loon.NewParser(r).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+
+	for i := range doc.Objects {
+		obj := &doc.Objects[i]
+		obj.Type = strings.ToLower(obj.Type)
+		obj.Name = strings.TrimSpace(obj.Name)
+		for j := range obj.Directives {
+			obj.Directives[j].Name = strings.ToLower(obj.Directives[j].Name)
+		}
+
+		ranks, ok := directiveRanks[obj.Type]
+		if !ok {
+			continue
+		}
+		rank := func(name string) int {
+			if n, ok := ranks[name]; ok {
+				return n
+			}
+			return len(ranks)
+		}
+		sort.SliceStable(obj.Directives, func(a, b int) bool {
+			return rank(obj.Directives[a].Name) < rank(obj.Directives[b].Name)
+		})
+	}
+	return loon.Print(doc), nil
+}
@@ -0,0 +1,71 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormat(t *testing.T) {
+	in := `# Farming rules
+RULE farm
+  out grain 2
+    # only in summer
+    IfAttr season = summer
+  EVERY 2
+  Frobnicate 3
+end
+
+resource grain
+  plural grain
+  decay 1
+  singular grain
+end
+
+agent farmer
+  rules farm
+  pool grain 10
+end
+`
+	want := `# Farming rules
+rule farm
+	every 2
+	# only in summer
+	ifattr season = summer
+	out grain 2
+	frobnicate 3
+end
+
+resource grain
+	singular grain
+	plural grain
+	decay 1
+end
+
+agent farmer
+	rules farm
+	pool grain 10
+end
+
+`
+	got, err := Format(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Format() mismatch (-want +got):\n%s", diff)
+	}
+
+	again, err := Format(strings.NewReader(string(got)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(string(got), string(again)); diff != "" {
+		t.Errorf("Format() is not idempotent (-first +second):\n%s", diff)
+	}
+
+	if _, err := Format(strings.NewReader("rule\nend\n")); err == nil {
+		t.Errorf("got no error for a rule without a name, wanted one")
+	}
+}
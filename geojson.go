@@ -0,0 +1,195 @@
+package rula
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// GeoJSON documents hold a network as a feature collection with a Point
+// feature for each location and a LineString feature for each connection.
+// Coordinates and distances are in metres east and north of the centre of
+// the map rather than longitude and latitude.
+//
+// Location features have the properties id and name. Connection features
+// have the properties id, from and to, holding the IDs of the locations they
+// join, distance, kind, difficulty, oneway and impassable, which marks a
+// connection with the Impassable difficulty.
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties geoJSONProps    `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+type geoJSONProps struct {
+	ID         int64    `json:"id,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	From       int64    `json:"from,omitempty"`
+	To         int64    `json:"to,omitempty"`
+	Distance   *float64 `json:"distance,omitempty"`
+	Kind       string   `json:"kind,omitempty"`
+	Difficulty float64  `json:"difficulty,omitempty"`
+	OneWay     bool     `json:"oneway,omitempty"`
+	Impassable bool     `json:"impassable,omitempty"`
+}
+
+// WriteGeoJSON writes the network of m as a GeoJSON feature collection,
+// naming locations as they are named in m.
+func WriteGeoJSON(w io.Writer, m *NetworkMap) error {
+	names := map[*Location]string{}
+	for name, l := range m.Locations {
+		names[l] = name
+	}
+
+	doc := geoJSONCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	for _, l := range m.Network.order {
+		coords, _ := json.Marshal(metres(l.pos))
+		doc.Features = append(doc.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "Point", Coordinates: coords},
+			Properties: geoJSONProps{ID: l.id, Name: names[l]},
+		})
+	}
+	for _, c := range m.Network.connections() {
+		coords, _ := json.Marshal([][2]float64{metres(c.from.pos), metres(c.to.pos)})
+		distance := float64(c.distance) / float64(Metre)
+		props := geoJSONProps{
+			ID:       c.id,
+			From:     c.from.id,
+			To:       c.to.id,
+			Distance: &distance,
+			Kind:     c.kind,
+			OneWay:   c.oneWay,
+		}
+		if math.IsInf(c.difficulty, 1) {
+			props.Impassable = true
+		} else {
+			props.Difficulty = c.difficulty
+		}
+		doc.Features = append(doc.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: coords},
+			Properties: props,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// ReadGeoJSON reads a network from a GeoJSON feature collection written by
+// WriteGeoJSON or by another tool. Locations and connections keep their IDs
+// where they have them and are given new IDs otherwise. A connection without
+// a distance is as long as its line. Features of other types are ignored.
+func ReadGeoJSON(r io.Reader) (*NetworkMap, error) {
+	var doc geoJSONCollection
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("unexpected GeoJSON type: %q", doc.Type)
+	}
+
+	m := &NetworkMap{
+		Network:   NewNetwork(),
+		Locations: map[string]*Location{},
+	}
+	g := m.Network
+
+	for i, f := range doc.Features {
+		if f.Geometry.Type != "Point" {
+			continue
+		}
+		var pt [2]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &pt); err != nil {
+			return nil, fmt.Errorf("feature %d: invalid coordinates: %w", i, err)
+		}
+		id := f.Properties.ID
+		if id == 0 {
+			id = g.lastLoc + 1
+		}
+		if g.locations[id] != nil {
+			return nil, fmt.Errorf("feature %d: duplicate location id %d", i, id)
+		}
+		l := g.addLocation(id, position(pt))
+		if name := f.Properties.Name; name != "" {
+			if _, dup := m.Locations[name]; dup {
+				return nil, fmt.Errorf("feature %d: duplicate location name %q", i, name)
+			}
+			m.Locations[name] = l
+		}
+	}
+
+	seen := map[int64]bool{}
+	for i, f := range doc.Features {
+		if f.Geometry.Type != "LineString" {
+			continue
+		}
+		p := f.Properties
+		from, to := g.locations[p.From], g.locations[p.To]
+		if from == nil || to == nil {
+			return nil, fmt.Errorf("feature %d: connection joins unknown locations %d and %d", i, p.From, p.To)
+		}
+		id := p.ID
+		if id == 0 {
+			id = g.lastConn + 1
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("feature %d: duplicate connection id %d", i, id)
+		}
+		seen[id] = true
+
+		var distance Length
+		if p.Distance != nil {
+			distance = fromMetres(*p.Distance)
+		} else {
+			var line [][2]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &line); err != nil {
+				return nil, fmt.Errorf("feature %d: invalid coordinates: %w", i, err)
+			}
+			for j := 1; j < len(line); j++ {
+				distance += fromMetres(math.Hypot(line[j][0]-line[j-1][0], line[j][1]-line[j-1][1]))
+			}
+		}
+		if distance < 0 || p.Difficulty < 0 {
+			return nil, fmt.Errorf("feature %d: negative distance or difficulty", i)
+		}
+
+		c := g.connect(id, from, to, distance)
+		c.kind = p.Kind
+		c.difficulty = p.Difficulty
+		c.oneWay = p.OneWay
+		if p.Impassable {
+			c.difficulty = Impassable
+		}
+	}
+
+	return m, nil
+}
+
+// metres returns the coordinates of pos in metres.
+func metres(pos Position) [2]float64 {
+	return [2]float64{float64(pos.East) / float64(Metre), float64(pos.North) / float64(Metre)}
+}
+
+// position returns the position at coordinates in metres.
+func position(pt [2]float64) Position {
+	return Position{East: fromMetres(pt[0]), North: fromMetres(pt[1])}
+}
+
+func fromMetres(m float64) Length {
+	return Length(math.Round(m * float64(Metre)))
+}
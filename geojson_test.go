@@ -0,0 +1,79 @@
+package rula
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGeoJSONRoundtrip(t *testing.T) {
+	m, err := NewNetworkParser().Parse(strings.NewReader(`location harbour
+	position 2km -500m
+	connect town 12km kind road
+	connect town 10km kind river difficulty 0.5 oneway
+end
+
+location town
+	connect mill 750m
+end
+
+location mill
+	position 3km 250cm
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.Network.SetDifficulty(m.Network.Connection(m.Locations["town"].ID(), m.Locations["mill"].ID())[0], Impassable)
+
+	var buf bytes.Buffer
+	if err := WriteGeoJSON(&buf, m); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	got, err := ReadGeoJSON(&buf)
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	var want, gotText bytes.Buffer
+	NewNetworkWriter().Write(&want, m)
+	NewNetworkWriter().Write(&gotText, got)
+	if diff := cmp.Diff(want.String(), gotText.String()); diff != "" {
+		t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+	}
+	for _, c := range m.Network.connections() {
+		gc := got.Network.connections()[c.ID()-1]
+		if gc.ID() != c.ID() || gc.From().ID() != c.From().ID() || gc.Difficulty() != c.Difficulty() {
+			t.Errorf("connection %d not preserved", c.ID())
+		}
+	}
+}
+
+func TestReadGeoJSON(t *testing.T) {
+	doc := `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "geometry": {"type": "Point", "coordinates": [0, 0]}, "properties": {"id": 7, "name": "a"}},
+    {"type": "Feature", "geometry": {"type": "Point", "coordinates": [300, 400]}, "properties": {"name": "b"}},
+    {"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [300, 400]]}, "properties": {"from": 7, "to": 8}}
+  ]
+}`
+	m, err := ReadGeoJSON(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a, b := m.Locations["a"], m.Locations["b"]
+	if a.ID() != 7 || b.ID() != 8 || b.Position() != (Position{East: 300 * Metre, North: 400 * Metre}) {
+		t.Errorf("got locations %d and %d at %v", a.ID(), b.ID(), b.Position())
+	}
+	conns := m.Network.Connection(7, 8)
+	if len(conns) != 1 || conns[0].Distance() != 500*Metre {
+		t.Errorf("got connections %v, wanted one of 500m measured along its line", conns)
+	}
+
+	if _, err := ReadGeoJSON(strings.NewReader(`{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": {"type": "LineString", "coordinates": []}, "properties": {"from": 1, "to": 2}}]}`)); err == nil {
+		t.Errorf("got no error for connection between unknown locations, wanted one")
+	}
+}
@@ -0,0 +1,72 @@
+package rula
+
+// NewGridNetwork returns a network of width columns and height rows of
+// locations spacing apart, each connected to the locations beside, above and
+// below it by connections of length spacing. The first location is at the
+// centre of the map with columns running east and rows running north. The
+// location in column x of row y, counting from 0, has ID y*width+x+1.
+func NewGridNetwork(width, height int, spacing Length) *Graph {
+	g := NewNetwork()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			g.AddLocation(Position{East: Length(x) * spacing, North: Length(y) * spacing})
+		}
+	}
+	at := latticeLocator(g, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x+1 < width {
+				g.Connect(at(x, y), at(x+1, y), spacing)
+			}
+			if y+1 < height {
+				g.Connect(at(x, y), at(x, y+1), spacing)
+			}
+		}
+	}
+	return g
+}
+
+// NewHexNetwork returns a network of width columns and height rows of
+// locations on a hexagonal lattice, each connected to its six neighbours by
+// connections of length spacing. Rows run north and odd rows are shifted
+// half a column east, as for hexes with a point at the top. Locations are
+// numbered as for NewGridNetwork and are at the positions of the hexes
+// returned by HexOffset.
+func NewHexNetwork(width, height int, spacing Length) *Graph {
+	g := NewNetwork()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			g.AddLocation(HexOffset(x, y).Position(spacing))
+		}
+	}
+	at := latticeLocator(g, width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x+1 < width {
+				g.Connect(at(x, y), at(x+1, y), spacing)
+			}
+			if y+1 == height {
+				continue
+			}
+			// The neighbours in the next row are the locations in the
+			// same column and the one before it, or after it for odd rows
+			other := x - 1
+			if y%2 == 1 {
+				other = x + 1
+			}
+			g.Connect(at(x, y), at(x, y+1), spacing)
+			if other >= 0 && other < width {
+				g.Connect(at(x, y), at(other, y+1), spacing)
+			}
+		}
+	}
+	return g
+}
+
+// latticeLocator returns a function giving the location in column x of row y
+// of a lattice network of the given width.
+func latticeLocator(g *Graph, width int) func(x, y int) *Location {
+	return func(x, y int) *Location {
+		return g.locations[int64(y*width+x+1)]
+	}
+}
@@ -0,0 +1,40 @@
+package rula
+
+import "testing"
+
+func TestGridNetwork(t *testing.T) {
+	g := NewGridNetwork(4, 3, Kilometre)
+	if got := len(g.Locations()); got != 12 {
+		t.Fatalf("got %d locations, wanted 12", got)
+	}
+	if got := len(g.connections()); got != 3*3+4*2 {
+		t.Errorf("got %d connections, wanted 17", got)
+	}
+	if got := g.Location(7).Position(); got != (Position{East: 2 * Kilometre, North: Kilometre}) {
+		t.Errorf("got position %v for location 7", got)
+	}
+	if _, length, _ := g.Route(1, 12); length != 5*Kilometre {
+		t.Errorf("got corner to corner length %d, wanted 5km", length)
+	}
+}
+
+func TestHexNetwork(t *testing.T) {
+	g := NewHexNetwork(4, 3, Kilometre)
+	if got := len(g.Locations()); got != 12 {
+		t.Fatalf("got %d locations, wanted 12", got)
+	}
+	// An inner location has six neighbours, each a spacing away
+	inner := g.Location(6)
+	ns := g.Neighbours(inner.ID())
+	if len(ns) != 6 {
+		t.Errorf("got %d neighbours, wanted 6", len(ns))
+	}
+	for _, n := range ns {
+		if d := inner.Position().Distance(n.Position()); d < Kilometre-Millimetre || d > Kilometre+Millimetre {
+			t.Errorf("neighbour %d is %d away, wanted 1km", n.ID(), d)
+		}
+	}
+	if got := len(g.Neighbours(1)); got != 2 {
+		t.Errorf("got %d neighbours for the corner, wanted 2", got)
+	}
+}
@@ -0,0 +1,61 @@
+package rula
+
+// An AgentGroup attaches a set of rules to many agents at once, such as
+// thousands of identical villagers, so the rules are held by the group
+// rather than copied into each agent. A Runner runs the group's rules for
+// each member, before the member's own rules, keeping separate state for each
+// member as it does for rules held by the agents themselves.
+type AgentGroup struct {
+	Name    string
+	Rules   []*Rule
+	Members []*Agent
+}
+
+// NewAgentGroup returns an empty group running the given rules.
+func NewAgentGroup(name string, rules []*Rule) *AgentGroup {
+	return &AgentGroup{
+		Name:  name,
+		Rules: rules,
+	}
+}
+
+// Add makes the agents members of the group, removing them from any group
+// they belong to.
+func (g *AgentGroup) Add(agents ...*Agent) {
+	for _, a := range agents {
+		if a.Group == g {
+			continue
+		}
+		if a.Group != nil {
+			a.Group.Remove(a)
+		}
+		a.Group = g
+		g.Members = append(g.Members, a)
+	}
+}
+
+// Remove removes an agent from the group. It has no effect if the agent is
+// not a member.
+func (g *AgentGroup) Remove(a *Agent) {
+	for i, m := range g.Members {
+		if m == a {
+			g.Members = append(g.Members[:i], g.Members[i+1:]...)
+			a.Group = nil
+			return
+		}
+	}
+}
+
+// AllRules returns the rules run for the agent: the rules of its group, if
+// any, followed by its own.
+func (a *Agent) AllRules() []*Rule {
+	if a.Group == nil || len(a.Group.Rules) == 0 {
+		return a.Rules
+	}
+	if len(a.Rules) == 0 {
+		return a.Group.Rules
+	}
+	rules := make([]*Rule, 0, len(a.Group.Rules)+len(a.Rules))
+	rules = append(rules, a.Group.Rules...)
+	return append(rules, a.Rules...)
+}
@@ -0,0 +1,45 @@
+package rula
+
+import "testing"
+
+func TestAgentGroup(t *testing.T) {
+	grain := &Resource{Name: Name{Singular: "grain"}}
+	farm := NewRule("farm").Out(RelationSelf, grain, 1).Build()
+	farm.Period = 2
+	sell := NewRule("sell").In(RelationSelf, grain, 1).Build()
+
+	g := NewAgentGroup("villagers", []*Rule{farm})
+	var villagers []*Agent
+	for _, name := range []string{"ann", "bob"} {
+		a := NewAgent(name)
+		a.AddPool(grain, 10, 0)
+		villagers = append(villagers, a)
+	}
+	g.Add(villagers...)
+	villagers[1].AppendRules([]*Rule{sell})
+
+	ru := NewRunner()
+	if _, err := ru.Step(nil, villagers[:1], 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ru.Step(nil, villagers, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Each member keeps its own schedule for the group's rules so bob farms
+	// at tick 3 while ann, having farmed at tick 2, does not
+	if got := villagers[0].Pools.Quantity(grain); got != 1 {
+		t.Errorf("got ann grain %d, wanted 1", got)
+	}
+	if got := villagers[1].Pools.Quantity(grain); got != 0 {
+		t.Errorf("got bob grain %d, wanted 0 after selling", got)
+	}
+
+	g.Remove(villagers[0])
+	if villagers[0].Group != nil || len(g.Members) != 1 {
+		t.Errorf("agent not removed from group")
+	}
+	if got := len(villagers[0].AllRules()); got != 0 {
+		t.Errorf("got %d rules after leaving group, wanted 0", got)
+	}
+}
@@ -0,0 +1,99 @@
+package rula
+
+import "math"
+
+// A Hex is a hex on a map of hexes with a point at the top, such as the one
+// built by NewHexNetwork, in axial coordinates. Q counts hexes east and R
+// counts rows north. Hexes are positioned spacing apart, with hex 0,0 at
+// the centre of the map.
+type Hex struct {
+	Q, R int
+}
+
+// A Cube is a hex in cube coordinates, whose components always sum to zero.
+// Cube coordinates make distances and rounding simpler than axial ones.
+type Cube struct {
+	X, Y, Z int
+}
+
+func (h Hex) Cube() Cube {
+	return Cube{X: h.Q, Y: -h.Q - h.R, Z: h.R}
+}
+
+func (c Cube) Hex() Hex {
+	return Hex{Q: c.X, R: c.Z}
+}
+
+// HexOffset returns the hex in column col of row row of a lattice built by
+// NewHexNetwork, in which odd rows are shifted half a column east.
+func HexOffset(col, row int) Hex {
+	return Hex{Q: col - (row-row&1)/2, R: row}
+}
+
+// Offset returns the column and row of the hex in a lattice built by
+// NewHexNetwork.
+func (h Hex) Offset() (col, row int) {
+	return h.Q + (h.R-h.R&1)/2, h.R
+}
+
+// Distance returns the number of steps between h and o.
+func (h Hex) Distance(o Hex) int {
+	a, b := h.Cube(), o.Cube()
+	return (absInt(a.X-b.X) + absInt(a.Y-b.Y) + absInt(a.Z-b.Z)) / 2
+}
+
+var hexDirections = [6]Hex{{1, 0}, {1, -1}, {0, -1}, {-1, 0}, {-1, 1}, {0, 1}}
+
+// Neighbours returns the six hexes next to h, starting with the hex to the
+// east and going clockwise.
+func (h Hex) Neighbours() [6]Hex {
+	var ns [6]Hex
+	for i, d := range hexDirections {
+		ns[i] = Hex{Q: h.Q + d.Q, R: h.R + d.R}
+	}
+	return ns
+}
+
+// Position returns the position of the centre of h on a map with hexes
+// spacing apart.
+func (h Hex) Position(spacing Length) Position {
+	return Position{
+		East:  Length(math.Round(float64(spacing) * (float64(h.Q) + float64(h.R)/2))),
+		North: Length(math.Round(float64(spacing) * math.Sqrt(3) / 2 * float64(h.R))),
+	}
+}
+
+// HexAt returns the hex containing pos on a map with hexes spacing apart.
+func HexAt(pos Position, spacing Length) Hex {
+	r := float64(pos.North) / (float64(spacing) * math.Sqrt(3) / 2)
+	q := float64(pos.East)/float64(spacing) - r/2
+
+	// Round to the nearest cube, fixing the component that moved furthest
+	// so the components still sum to zero
+	x, z := q, r
+	y := -x - z
+	rx, ry, rz := math.Round(x), math.Round(y), math.Round(z)
+	dx, dy, dz := math.Abs(rx-x), math.Abs(ry-y), math.Abs(rz-z)
+	switch {
+	case dx > dy && dx > dz:
+		rx = -ry - rz
+	case dy > dz:
+		ry = -rx - rz
+	default:
+		rz = -rx - ry
+	}
+	return Cube{X: int(rx), Y: int(ry), Z: int(rz)}.Hex()
+}
+
+// HexDistance returns the distance between the hexes containing a and b when
+// travelling from hex to hex on a map with hexes spacing apart.
+func HexDistance(a, b Position, spacing Length) Length {
+	return Length(HexAt(a, spacing).Distance(HexAt(b, spacing))) * spacing
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,47 @@
+package rula
+
+import "testing"
+
+func TestHex(t *testing.T) {
+	h := Hex{Q: 2, R: -1}
+	if c := h.Cube(); c.X+c.Y+c.Z != 0 || c.Hex() != h {
+		t.Errorf("got cube %v for %v", c, h)
+	}
+	if got := h.Distance(Hex{Q: -1, R: 1}); got != 3 {
+		t.Errorf("got distance %d, wanted 3", got)
+	}
+	for _, n := range h.Neighbours() {
+		if h.Distance(n) != 1 {
+			t.Errorf("neighbour %v is not next to %v", n, h)
+		}
+	}
+
+	for q := -3; q <= 3; q++ {
+		for r := -3; r <= 3; r++ {
+			h := Hex{Q: q, R: r}
+			pos := h.Position(Kilometre)
+			if got := HexAt(pos, Kilometre); got != h {
+				t.Errorf("HexAt(%v) = %v, wanted %v", pos, got, h)
+			}
+			// A point a little way from the centre is in the same hex
+			off := Position{East: pos.East + 300*Metre, North: pos.North - 200*Metre}
+			if got := HexAt(off, Kilometre); got != h {
+				t.Errorf("HexAt(%v) = %v, wanted %v", off, got, h)
+			}
+			if col, row := h.Offset(); HexOffset(col, row) != h {
+				t.Errorf("offset %d,%d does not convert back to %v", col, row, h)
+			}
+		}
+	}
+
+	a, b := (Hex{0, 0}).Position(Kilometre), (Hex{3, -2}).Position(Kilometre)
+	if got := HexDistance(a, b, Kilometre); got != 3*Kilometre {
+		t.Errorf("got hex distance %d, wanted 3km", got)
+	}
+
+	// The hex lattice network puts locations at their hexes
+	g := NewHexNetwork(3, 3, Kilometre)
+	if got := HexAt(g.Location(6).Position(), Kilometre); got != HexOffset(2, 1) {
+		t.Errorf("got hex %v for location 6, wanted %v", got, HexOffset(2, 1))
+	}
+}
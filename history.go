@@ -0,0 +1,124 @@
+package rula
+
+import "sync"
+
+// A Sample is the quantity of a resource in a pool at the end of a tick.
+type Sample struct {
+	Tick     int64
+	Quantity int
+}
+
+// A History records the quantities of selected pools at the end of each tick,
+// retaining a fixed number of the most recent ticks for each, for graphs and
+// balancing analysis. Pass it to a Runner with WithHistory to record every
+// Step, or call Record directly. A History is safe for concurrent use.
+type History struct {
+	mu     sync.Mutex
+	size   int
+	series map[txnKey]*series
+}
+
+// series is a ring buffer of samples.
+type series struct {
+	samples []Sample
+	next    int // index of the oldest sample once the buffer is full
+}
+
+// NewHistory returns a history retaining up to size ticks for each pool.
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+	return &History{
+		size:   size,
+		series: map[txnKey]*series{},
+	}
+}
+
+// WithHistory records the pools tracked by h at the end of every Step.
+func WithHistory(h *History) RunnerOption {
+	return func(ru *Runner) {
+		ru.history = h
+	}
+}
+
+// Track starts recording the quantity of resource r in ps. Tracking a pool
+// that is already tracked has no effect.
+func (h *History) Track(ps *PoolSet, r *Resource) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := keyFor(ps, r)
+	if _, ok := h.series[key]; !ok {
+		h.series[key] = &series{samples: make([]Sample, 0, h.size)}
+	}
+}
+
+// Untrack stops recording the quantity of resource r in ps and discards its
+// samples.
+func (h *History) Untrack(ps *PoolSet, r *Resource) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.series, keyFor(ps, r))
+}
+
+// Record samples the quantity of every tracked pool at tick. Recording the
+// same tick again, as when a cancelled step is resumed, replaces its samples.
+func (h *History) Record(tick int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, s := range h.series {
+		s.add(Sample{Tick: tick, Quantity: key.ps.Quantity(key.r)})
+	}
+}
+
+func (s *series) add(sm Sample) {
+	if n := len(s.samples); n > 0 {
+		last := s.next - 1
+		if last < 0 {
+			last = n - 1
+		}
+		if s.samples[last].Tick == sm.Tick {
+			s.samples[last] = sm
+			return
+		}
+	}
+	if len(s.samples) < cap(s.samples) {
+		s.samples = append(s.samples, sm)
+		return
+	}
+	s.samples[s.next] = sm
+	s.next = (s.next + 1) % len(s.samples)
+}
+
+// Last returns up to the last n samples recorded for resource r in ps, oldest
+// first. All retained samples are returned if n is less than 1. It returns
+// nil if the pool is not tracked.
+func (h *History) Last(ps *PoolSet, r *Resource, n int) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[keyFor(ps, r)]
+	if !ok {
+		return nil
+	}
+	count := len(s.samples)
+	if n < 1 || n > count {
+		n = count
+	}
+	samples := make([]Sample, n)
+	for i := range samples {
+		samples[i] = s.samples[(s.next+count-n+i)%count]
+	}
+	return samples
+}
+
+// Range returns the samples recorded for resource r in ps from tick from up
+// to and including tick to, oldest first.
+func (h *History) Range(ps *PoolSet, r *Resource, from, to int64) []Sample {
+	var samples []Sample
+	for _, sm := range h.Last(ps, r, 0) {
+		if sm.Tick >= from && sm.Tick <= to {
+			samples = append(samples, sm)
+		}
+	}
+	return samples
+}
@@ -0,0 +1,45 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistory(t *testing.T) {
+	a := NewAgent("smith")
+	a.AddPool(iron, 100, 0)
+	a.Rules = []*Rule{NewRule("forge").Every(1).Out(RelationSelf, iron, 2).Build()}
+
+	h := NewHistory(3)
+	h.Track(a.Pools, iron)
+	ru := NewRunner(WithHistory(h))
+	for tick := int64(1); tick <= 5; tick++ {
+		if _, err := ru.Step(nil, []*Agent{a}, tick); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := []Sample{{Tick: 4, Quantity: 8}, {Tick: 5, Quantity: 10}}
+	if diff := cmp.Diff(want, h.Last(a.Pools, iron, 2)); diff != "" {
+		t.Errorf("Last() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Only the last three ticks are retained
+	want = []Sample{{Tick: 3, Quantity: 6}, {Tick: 4, Quantity: 8}}
+	if diff := cmp.Diff(want, h.Range(a.Pools, iron, 1, 4)); diff != "" {
+		t.Errorf("Range() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Recording a tick again replaces its sample
+	a.Pools.Set(iron, 1)
+	h.Record(5)
+	want = []Sample{{Tick: 3, Quantity: 6}, {Tick: 4, Quantity: 8}, {Tick: 5, Quantity: 1}}
+	if diff := cmp.Diff(want, h.Last(a.Pools, iron, 0)); diff != "" {
+		t.Errorf("Last() after re-recording mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := h.Last(a.Pools, ironOre, 1); got != nil {
+		t.Errorf("got samples %v for untracked pool", got)
+	}
+}
@@ -0,0 +1,77 @@
+package rula
+
+import "sync"
+
+// A ResourceChange describes a change to the quantity of a resource made by a
+// rule, or by the decay or regeneration of the resource.
+type ResourceChange struct {
+	Rule     *Rule  // nil for decay and regeneration
+	Agent    *Agent // the agent the rule was run for, nil for global rules
+	Relation Relation
+	Resource *Resource
+	Old      int // quantity before the change
+	New      int // quantity after the change
+}
+
+type hooks struct {
+	mu      sync.Mutex // serializes calls to the hooks during parallel steps
+	fired   []func(*RuleResult)
+	blocked []func(*RuleResult)
+	changed []func(ResourceChange)
+}
+
+// OnRuleFired registers fn to be called each time a rule completes at least
+// one round. It is called once per invocation of the rule, after all rounds
+// have run.
+func (ru *Runner) OnRuleFired(fn func(*RuleResult)) {
+	ru.hooks.fired = append(ru.hooks.fired, fn)
+}
+
+// OnRuleBlocked registers fn to be called each time a due rule is stopped
+// by a precondition, a missing input or a missing relation. A rule that
+// completes some rounds before being blocked is reported to both
+// OnRuleFired and OnRuleBlocked.
+func (ru *Runner) OnRuleBlocked(fn func(*RuleResult)) {
+	ru.hooks.blocked = append(ru.hooks.blocked, fn)
+}
+
+// OnResourceChanged registers fn to be called each time a rule, decay or
+// regeneration changes the quantity of a resource.
+func (ru *Runner) OnResourceChanged(fn func(ResourceChange)) {
+	ru.hooks.changed = append(ru.hooks.changed, fn)
+}
+
+func (h *hooks) ruleDone(res *RuleResult) {
+	if res.Fired() {
+		h.ruleFired(res)
+	}
+	if res.Blocked != nil && len(h.blocked) > 0 {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for _, fn := range h.blocked {
+			fn(res)
+		}
+	}
+}
+
+func (h *hooks) ruleFired(res *RuleResult) {
+	if len(h.fired) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, fn := range h.fired {
+		fn(res)
+	}
+}
+
+func (h *hooks) resourceChanged(c ResourceChange) {
+	if c.Old == c.New || len(h.changed) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, fn := range h.changed {
+		fn(c)
+	}
+}
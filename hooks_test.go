@@ -0,0 +1,54 @@
+package rula
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunnerHooks(t *testing.T) {
+	idle := NewRule("idle").Manual().Set(RelationSelf, workers, 0).Build()
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Repeat(1).OnFail(idle).Build()
+
+	ctx := RuleContext{
+		Pools: map[Relation]*PoolSet{
+			RelationSelf: NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 10, Quantity: 3},
+				&Pool{Resource: iron, Capacity: 10},
+				&Pool{Resource: workers, Capacity: 10, Quantity: 4},
+			),
+		},
+	}
+
+	var events []string
+	runner := NewRunner()
+	runner.OnRuleFired(func(res *RuleResult) {
+		events = append(events, fmt.Sprintf("fired %s %d", res.Rule.Name, res.Rounds))
+	})
+	runner.OnRuleBlocked(func(res *RuleResult) {
+		events = append(events, fmt.Sprintf("blocked %s: %s", res.Rule.Name, res.Blocked))
+	})
+	runner.OnResourceChanged(func(c ResourceChange) {
+		events = append(events, fmt.Sprintf("%s %s %s %d->%d", c.Rule.Name, c.Relation, c.Resource, c.Old, c.New))
+	})
+
+	for tick := int64(1); tick <= 2; tick++ {
+		if _, err := runner.Run([]*Rule{smelt}, tick, ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := []string{
+		"smelt self iron_ore 3->1",
+		"smelt self iron 0->1",
+		"fired smelt 1",
+		"blocked smelt: not enough self iron_ore, found 1 wanted 2",
+		"idle self workers 4->0",
+		"fired idle 1",
+		"blocked smelt: not enough self iron_ore, found 1 wanted 2",
+	}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
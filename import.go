@@ -0,0 +1,197 @@
+package rula
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A PoolImporter sets the initial pools of agents from delimited text with
+// one pool per row. Each row holds an agent ID, resource ID, quantity and
+// capacity, in that order. The first row may be a header starting with the
+// word agent.
+type PoolImporter struct {
+	// Comma is the field delimiter, set to '\t' for TSV. Defaults to ','.
+	Comma rune
+
+	agents    map[string]*Agent
+	resources *resourceIndex
+}
+
+func NewPoolImporter(agents []*Agent, resources []*Resource) *PoolImporter {
+	im := &PoolImporter{
+		Comma:     ',',
+		agents:    map[string]*Agent{},
+		resources: newResourceIndex(resources),
+	}
+	for _, a := range agents {
+		im.agents[agentLabel(a)] = a
+	}
+	return im
+}
+
+// A PoolChange is the change made to one pool by an import.
+type PoolChange struct {
+	Row         int // record number within the input, counting from 1
+	Agent       *Agent
+	Resource    *Resource
+	Created     bool // true if the agent had no pool for the resource
+	OldQuantity int
+	OldCapacity int
+	Quantity    int
+	Capacity    int
+}
+
+// An ImportError is a problem with a single row of an import.
+type ImportError struct {
+	Row int // record number within the input, counting from 1
+	Err error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// An ImportReport describes the outcome of an import.
+type ImportReport struct {
+	Rows    int
+	Changes []PoolChange
+	Errors  []*ImportError
+}
+
+// ErrInvalidImport is returned when any row of an import fails validation.
+var ErrInvalidImport = errors.New("invalid import")
+
+// Import validates every row read from r and, only if all rows are valid,
+// applies them to the agents' pools. The report lists the changes made or, if
+// validation failed, the problems found in which case no pools are changed and
+// ErrInvalidImport is returned.
+func (im *PoolImporter) Import(r io.Reader) (*ImportReport, error) {
+	report, err := im.DryRun(r)
+	if err != nil {
+		return report, err
+	}
+
+	for _, c := range report.Changes {
+		if !c.Created {
+			c.Agent.Pools.SetCapacity(c.Resource, c.Capacity)
+			c.Agent.Pools.setQuantity(c.Resource, c.Quantity)
+			continue
+		}
+		c.Agent.AddPool(c.Resource, c.Capacity, c.Quantity)
+	}
+	return report, nil
+}
+
+// DryRun validates every row read from r and reports the changes an Import
+// would make without changing any pools.
+func (im *PoolImporter) DryRun(r io.Reader) (*ImportReport, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = im.Comma
+	cr.FieldsPerRecord = 4
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	report := &ImportReport{}
+	seen := map[*Agent]map[*Resource]int{}
+
+	row := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) && perr.Err == csv.ErrFieldCount {
+				report.Errors = append(report.Errors, &ImportError{Row: row, Err: fmt.Errorf("expected 4 fields, found %d", len(rec))})
+				continue
+			}
+			return report, err
+		}
+
+		if row == 1 {
+			if strings.EqualFold(strings.TrimSpace(rec[0]), "agent") {
+				continue
+			}
+		}
+
+		report.Rows++
+		change, err := im.row(rec)
+		if err != nil {
+			report.Errors = append(report.Errors, &ImportError{Row: row, Err: err})
+			continue
+		}
+		change.Row = row
+
+		if seen[change.Agent] == nil {
+			seen[change.Agent] = map[*Resource]int{}
+		}
+		if prev, dup := seen[change.Agent][change.Resource]; dup {
+			report.Errors = append(report.Errors, &ImportError{Row: row, Err: fmt.Errorf("duplicate of row %d", prev)})
+			continue
+		}
+		seen[change.Agent][change.Resource] = row
+
+		report.Changes = append(report.Changes, change)
+	}
+
+	if len(report.Errors) > 0 {
+		return report, ErrInvalidImport
+	}
+	return report, nil
+}
+
+func (im *PoolImporter) row(rec []string) (PoolChange, error) {
+	a, ok := im.agents[strings.TrimSpace(rec[0])]
+	if !ok {
+		return PoolChange{}, fmt.Errorf("unknown agent: %q", rec[0])
+	}
+
+	res, ok := im.resources.id(strings.TrimSpace(rec[1]))
+	if !ok {
+		return PoolChange{}, fmt.Errorf("unknown resource: %q", rec[1])
+	}
+
+	quantity, err := strconv.Atoi(strings.TrimSpace(rec[2]))
+	if err != nil {
+		return PoolChange{}, fmt.Errorf("invalid quantity: %v", err)
+	}
+
+	capacity, err := strconv.Atoi(strings.TrimSpace(rec[3]))
+	if err != nil {
+		return PoolChange{}, fmt.Errorf("invalid capacity: %v", err)
+	}
+
+	if quantity < 0 {
+		return PoolChange{}, fmt.Errorf("negative quantity: %d", quantity)
+	}
+	if capacity < 0 {
+		return PoolChange{}, fmt.Errorf("negative capacity: %d", capacity)
+	}
+	if quantity > capacity {
+		return PoolChange{}, fmt.Errorf("quantity %d exceeds capacity %d", quantity, capacity)
+	}
+
+	pool := a.Pools.Pool(res)
+	change := PoolChange{
+		Agent:    a,
+		Resource: res,
+		Created:  pool == nil,
+		Quantity: quantity,
+		Capacity: capacity,
+	}
+	if pool != nil {
+		change.OldQuantity = pool.Quantity
+		change.OldCapacity = pool.Capacity
+	}
+	return change, nil
+}
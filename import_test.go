@@ -0,0 +1,82 @@
+package rula
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPoolImporter(t *testing.T) {
+	ore := &Resource{ID: "iron_ore", Name: Name{Singular: "iron_ore"}}
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}}
+
+	mine := NewAgent("mine")
+	mine.AddPool(ore, 10, 2)
+	town := NewAgent("town")
+
+	im := NewPoolImporter([]*Agent{mine, town}, []*Resource{ore, gold})
+	im.Comma = '\t'
+
+	data := "agent\tresource\tquantity\tcapacity\nmine\tiron_ore\t50\t100\ntown\tgold\t5\t20\n"
+
+	report, err := im.DryRun(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Rows != 2 || len(report.Changes) != 2 {
+		t.Fatalf("got %d rows and %d changes, wanted 2 and 2", report.Rows, len(report.Changes))
+	}
+	if c := report.Changes[0]; c.Created || c.OldQuantity != 2 || c.Quantity != 50 {
+		t.Errorf("got change %+v, wanted existing pool changing from 2 to 50", c)
+	}
+	if !report.Changes[1].Created {
+		t.Errorf("got change %+v, wanted new pool", report.Changes[1])
+	}
+	if mine.Pools.Quantity(ore) != 2 || town.Pools.Capacity(gold) != 0 {
+		t.Errorf("dry run changed pools")
+	}
+
+	if _, err := im.Import(strings.NewReader(data)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mine.Pools.Quantity(ore) != 50 || mine.Pools.Capacity(ore) != 100 {
+		t.Errorf("got mine iron_ore %d/%d, wanted 50/100", mine.Pools.Quantity(ore), mine.Pools.Capacity(ore))
+	}
+	if town.Pools.Quantity(gold) != 5 || town.Pools.Capacity(gold) != 20 {
+		t.Errorf("got town gold %d/%d, wanted 5/20", town.Pools.Quantity(gold), town.Pools.Capacity(gold))
+	}
+}
+
+func TestPoolImporterInvalid(t *testing.T) {
+	ore := &Resource{ID: "iron_ore", Name: Name{Singular: "iron_ore"}}
+	mine := NewAgent("mine")
+
+	data := strings.Join([]string{
+		"mine,iron_ore,1,10",
+		"quarry,iron_ore,1,10",
+		"mine,stone,1,10",
+		"mine,iron_ore,x,10",
+		"mine,iron_ore,11,10",
+		"mine,iron_ore,1",
+		"mine,iron_ore,2,10",
+	}, "\n")
+
+	report, err := NewPoolImporter([]*Agent{mine}, []*Resource{ore}).Import(strings.NewReader(data))
+	if !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("got error %v, wanted ErrInvalidImport", err)
+	}
+
+	var rows []int
+	for _, e := range report.Errors {
+		rows = append(rows, e.Row)
+	}
+	if diff := cmp.Diff([]int{2, 3, 4, 5, 6, 7}, rows); diff != "" {
+		t.Errorf("error rows mismatch (-want +got):\n%s", diff)
+	}
+
+	if mine.Pools.Pool(ore) != nil {
+		t.Errorf("invalid import changed pools")
+	}
+}
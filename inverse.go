@@ -0,0 +1,70 @@
+package rula
+
+// AddRelationInverse relates the agent to c as AddRelation does and records
+// the agent on c under the inverse relation, so that one-to-many relations
+// such as employer and employees can be followed in both directions. The
+// inverse is kept up to date when the relation is replaced or removed, or
+// either agent is detached.
+func (a *Agent) AddRelationInverse(r Relation, c *Agent, inverse Relation) {
+	a.AddRelation(r, c)
+	if a.InverseOf == nil {
+		a.InverseOf = map[Relation]Relation{}
+	}
+	a.InverseOf[r] = inverse
+	if c.Inverses == nil {
+		c.Inverses = map[Relation][]*Agent{}
+	}
+	c.Inverses[inverse] = append(c.Inverses[inverse], a)
+}
+
+// Inverse returns the agents related to this one by relations whose inverse
+// is the named relation, such as the employees of an employer.
+func (a *Agent) Inverse(inverse Relation) []*Agent {
+	return append([]*Agent(nil), a.Inverses[inverse]...)
+}
+
+// RemoveRelation removes relation r of the agent and its inverse, if any.
+func (a *Agent) RemoveRelation(r Relation) {
+	a.dropInverse(r)
+	delete(a.Relations, r)
+	delete(a.RelationFuncs, r)
+}
+
+// Detach removes the agent's relations that have inverses, and the relations
+// of other agents to this one that have inverses, such as when the agent is
+// destroyed. Relations without inverses are not tracked and are left as they
+// are.
+func (a *Agent) Detach() {
+	for r := range a.InverseOf {
+		a.RemoveRelation(r)
+	}
+	for inverse, related := range a.Inverses {
+		for _, b := range related {
+			for r, inv := range b.InverseOf {
+				if inv == inverse && b.Relations[r] == a {
+					delete(b.Relations, r)
+					delete(b.InverseOf, r)
+				}
+			}
+		}
+	}
+	a.Inverses = nil
+}
+
+// dropInverse removes the agent from the inverse of relation r on the agent
+// it is related to.
+func (a *Agent) dropInverse(r Relation) {
+	inverse, ok := a.InverseOf[r]
+	if !ok {
+		return
+	}
+	delete(a.InverseOf, r)
+	c := a.Relations[r]
+	if c == nil {
+		return
+	}
+	c.Inverses[inverse] = without(c.Inverses[inverse], a)
+	if len(c.Inverses[inverse]) == 0 {
+		delete(c.Inverses, inverse)
+	}
+}
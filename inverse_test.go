@@ -0,0 +1,47 @@
+package rula
+
+import "testing"
+
+func TestRelationInverse(t *testing.T) {
+	mill := NewAgent("mill")
+	farm := NewAgent("farm")
+	ann := NewAgent("ann")
+	bob := NewAgent("bob")
+	ann.AddRelationInverse("employer", mill, "employees")
+	bob.AddRelationInverse("employer", mill, "employees")
+
+	if got := mill.Inverse("employees"); len(got) != 2 || got[0] != ann || got[1] != bob {
+		t.Errorf("got employees %v, wanted ann and bob", got)
+	}
+
+	// Changing employer moves ann to the new employer's employees
+	ann.AddRelationInverse("employer", farm, "employees")
+	if got := mill.Inverse("employees"); len(got) != 1 || got[0] != bob {
+		t.Errorf("got mill employees %v, wanted bob", got)
+	}
+	if got := farm.Inverse("employees"); len(got) != 1 || got[0] != ann {
+		t.Errorf("got farm employees %v, wanted ann", got)
+	}
+
+	// Destroying the mill leaves bob unemployed
+	w := NewWorld(nil)
+	for _, a := range []*Agent{mill, farm, ann, bob} {
+		w.AddAgent(a)
+	}
+	w.RemoveAgent(mill)
+	if _, ok := bob.Relations["employer"]; ok {
+		t.Errorf("bob still related to destroyed employer")
+	}
+
+	// Destroying an employee removes it from the employer's employees
+	w.RemoveAgent(ann)
+	if got := farm.Inverse("employees"); len(got) != 0 {
+		t.Errorf("got farm employees %v, wanted none", got)
+	}
+
+	ann.AddRelationInverse("employer", farm, "employees")
+	ann.AddRelation("employer", mill)
+	if got := farm.Inverse("employees"); len(got) != 0 {
+		t.Errorf("got farm employees %v after replacing relation, wanted none", got)
+	}
+}
@@ -0,0 +1,134 @@
+package rula
+
+// A Journey is the travel of an agent between two locations along a route
+// of the world's network. The agent is at no location while it travels, so
+// its rules have no location relation, and it arrives at the end of the tick
+// given by Arrives.
+type Journey struct {
+	Agent    *Agent
+	From     *Location
+	To       *Location
+	Route    []*Connection
+	Distance Length // the cost of the route, its length weighted by difficulty
+	Departed int64  // the tick the agent set out
+	Arrives  int64  // the tick the agent arrives
+}
+
+// Remaining returns the number of ticks left in the journey after tick.
+func (j *Journey) Remaining(tick int64) int64 {
+	if tick >= j.Arrives {
+		return 0
+	}
+	return j.Arrives - tick
+}
+
+// travelTicks returns the number of ticks taken to cover distance d at speed,
+// which is at least one.
+func travelTicks(d, speed Length) int64 {
+	n := int64((d + speed - 1) / speed)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// travel moves agent a to l, setting out on a journey along the cheapest
+// route for the agent if the agent has a speed, is at a location and the
+// world has a network. Otherwise the agent moves at once. The agent stays
+// where it is if l has no space for it.
+func (w *World) travel(a *Agent, l *Location) error {
+	if l != nil && !l.fits(a) {
+		// Another agent took the last space since the rule was run
+		return nil
+	}
+	from := a.Location
+	if a.Speed <= 0 || from == nil || l == nil || w.Network == nil || from == l {
+		a.MoveTo(l)
+		return nil
+	}
+	route, d, err := w.Network.RouteFor(from.ID(), l.ID(), a.routeDifficulty())
+	if err != nil {
+		return err
+	}
+	j := &Journey{
+		Agent:    a,
+		From:     from,
+		To:       l,
+		Route:    route,
+		Distance: d,
+		Departed: w.tick,
+		Arrives:  w.tick + travelTicks(d, a.Speed),
+	}
+	a.MoveTo(nil)
+	a.Journey = j
+	l.incoming += a.size()
+	w.journeys = append(w.journeys, j)
+	return nil
+}
+
+// routeDifficulty returns the difficulty of connections for agent a, from
+// its Difficulty and Kinds, or nil if the agent uses the connections' own
+// difficulties.
+func (a *Agent) routeDifficulty() DifficultyFunc {
+	if a.Difficulty == nil && len(a.Kinds) == 0 {
+		return nil
+	}
+	return func(c *Connection) float64 {
+		if len(a.Kinds) > 0 && !a.travels(c.kind) {
+			return Impassable
+		}
+		if a.Difficulty != nil {
+			return a.Difficulty(c)
+		}
+		return c.difficulty
+	}
+}
+
+// travels reports whether agent a can travel connections of kind.
+func (a *Agent) travels(kind string) bool {
+	for _, k := range a.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// arrive completes the journeys that end at the current tick.
+func (w *World) arrive() {
+	journeys := w.journeys[:0]
+	for _, j := range w.journeys {
+		if j.Arrives > w.tick {
+			journeys = append(journeys, j)
+			continue
+		}
+		j.Agent.Journey = nil
+		if j.To != nil {
+			j.To.incoming -= j.Agent.size()
+		}
+		j.Agent.MoveTo(j.To)
+	}
+	w.journeys = journeys
+}
+
+// Journeys returns the journeys under way in the order they began.
+func (w *World) Journeys() []*Journey {
+	return append([]*Journey(nil), w.journeys...)
+}
+
+// abandon ends any journey of agent a without it arriving.
+func (w *World) abandon(a *Agent) {
+	if a.Journey == nil {
+		return
+	}
+	for i, j := range w.journeys {
+		if j == a.Journey {
+			w.journeys = append(w.journeys[:i], w.journeys[i+1:]...)
+			if j.To != nil {
+				j.To.incoming -= a.size()
+			}
+			break
+		}
+	}
+	a.Journey = nil
+}
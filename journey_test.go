@@ -0,0 +1,56 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJourney(t *testing.T) {
+	rules, err := NewRuleParser(nil).Parse(strings.NewReader(`
+rule go
+	move port
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNetwork()
+	home := n.AddLocation(Position{})
+	inn := n.AddLocation(Position{})
+	port := n.AddLocation(Position{})
+	n.Connect(home, inn, 7*Kilometre)
+	n.Connect(inn, port, 8*Kilometre)
+
+	w := NewWorld(nil)
+	w.Network = n
+	w.AddLocation("port", port)
+
+	walker := NewAgent("walker")
+	walker.Speed = 4 * Kilometre
+	walker.AppendRules(rules)
+	walker.MoveTo(home)
+	w.AddAgent(walker)
+
+	// 15km at 4km a tick takes 4 ticks, arriving at the end of tick 5
+	for tick := int64(1); tick <= 5; tick++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tick < 5 {
+			j := walker.Journey
+			if j == nil || walker.Location != nil {
+				t.Fatalf("tick %d: walker not travelling", tick)
+			}
+			if j.Distance != 15*Kilometre || len(j.Route) != 2 || j.Remaining(tick) != 5-tick {
+				t.Errorf("tick %d: got journey of %d over %d connections with %d ticks left", tick, j.Distance, len(j.Route), j.Remaining(tick))
+			}
+		}
+	}
+	if walker.Location != port || walker.Journey != nil || len(w.Journeys()) != 0 {
+		t.Errorf("walker has not arrived at port")
+	}
+	if got := port.Occupants(); len(got) != 1 {
+		t.Errorf("got %d occupants at port, wanted 1", len(got))
+	}
+}
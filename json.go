@@ -0,0 +1,482 @@
+package rula
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+
+JSON encoding
+
+Resources are encoded in full. Everywhere else a resource is referenced by its ID,
+a rule by its name and an agent by its singular name. Decoding with the UnmarshalJSON
+methods alone leaves placeholders in place of those references that carry only the
+identifier. UnmarshalRules and UnmarshalAgents decode and bind the references to a
+known set of resources, rules and agents.
+
+*/
+
+// MarshalText encodes the operator as its rule file symbol.
+func (o Op) MarshalText() ([]byte, error) {
+	s, ok := opSymbols[o]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %d", int(o))
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText decodes an operator from its rule file symbol.
+func (o *Op) UnmarshalText(text []byte) error {
+	op, ok := parseOp(string(text))
+	if !ok {
+		return fmt.Errorf("unknown operator: %q", string(text))
+	}
+	*o = op
+	return nil
+}
+
+type jsonPool struct {
+	Resource string `json:"resource"`
+	Quantity int    `json:"quantity"`
+	Capacity int    `json:"capacity"`
+	Reserved int    `json:"reserved,omitempty"`
+
+	AllowNegative bool `json:"allowNegative,omitempty"`
+
+	CapacitySource string `json:"capacitySource,omitempty"`
+	CapacityFactor int    `json:"capacityFactor,omitempty"`
+}
+
+func (p *Pool) MarshalJSON() ([]byte, error) {
+	jp := jsonPool{
+		Resource: resourceID(p.Resource),
+		Quantity: p.Quantity,
+		Capacity: p.Capacity,
+		Reserved: p.Reserved,
+
+		AllowNegative: p.AllowNegative,
+	}
+	if p.Link != nil {
+		jp.CapacitySource = resourceID(p.Link.Source)
+		jp.CapacityFactor = p.Link.Factor
+	}
+	return json.Marshal(jp)
+}
+
+func (p *Pool) UnmarshalJSON(data []byte) error {
+	var jp jsonPool
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	*p = Pool{
+		Resource: resourceRef(jp.Resource),
+		Quantity: jp.Quantity,
+		Capacity: jp.Capacity,
+		Reserved: jp.Reserved,
+
+		AllowNegative: jp.AllowNegative,
+	}
+	if jp.CapacitySource != "" {
+		p.Link = &CapacityLink{Source: resourceRef(jp.CapacitySource), Factor: jp.CapacityFactor}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the poolset as a list of pools in the order they were
+// added to the set.
+func (p *PoolSet) MarshalJSON() ([]byte, error) {
+	pools := p.Snapshot()
+	if pools == nil {
+		pools = []Pool{}
+	}
+	return json.Marshal(pools)
+}
+
+func (p *PoolSet) UnmarshalJSON(data []byte) error {
+	var pools []*Pool
+	if err := json.Unmarshal(data, &pools); err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		if pool.Resource == nil {
+			return fmt.Errorf("pool has no resource")
+		}
+	}
+	*p = *NewPoolSet(pools...)
+	return nil
+}
+
+type jsonSpecifier struct {
+	Relation Relation `json:"relation"`
+	Resource string   `json:"resource"`
+	Quantity int      `json:"quantity"`
+}
+
+func (s ResourceSpecifier) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSpecifier{
+		Relation: s.Relation,
+		Resource: resourceID(s.Resource),
+		Quantity: s.Quantity,
+	})
+}
+
+func (s *ResourceSpecifier) UnmarshalJSON(data []byte) error {
+	var js jsonSpecifier
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	*s = ResourceSpecifier{
+		Relation: js.Relation,
+		Resource: resourceRef(js.Resource),
+		Quantity: js.Quantity,
+	}
+	return nil
+}
+
+type jsonCondition struct {
+	jsonSpecifier
+	Op Op `json:"op"`
+}
+
+func (c ResourceCondition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonCondition{
+		jsonSpecifier: jsonSpecifier{
+			Relation: c.Relation,
+			Resource: resourceID(c.Resource),
+			Quantity: c.Quantity,
+		},
+		Op: c.Op,
+	})
+}
+
+func (c *ResourceCondition) UnmarshalJSON(data []byte) error {
+	var jc jsonCondition
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return err
+	}
+	*c = ResourceCondition{
+		ResourceSpecifier: ResourceSpecifier{
+			Relation: jc.Relation,
+			Resource: resourceRef(jc.Resource),
+			Quantity: jc.Quantity,
+		},
+		Op: jc.Op,
+	}
+	return nil
+}
+
+type jsonSource struct {
+	Relation Relation `json:"relation"`
+	Resource string   `json:"resource"`
+}
+
+func (s ResourceSource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonSource{
+		Relation: s.Relation,
+		Resource: resourceID(s.Resource),
+	})
+}
+
+func (s *ResourceSource) UnmarshalJSON(data []byte) error {
+	var js jsonSource
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	*s = ResourceSource{
+		Relation: js.Relation,
+		Resource: resourceRef(js.Resource),
+	}
+	return nil
+}
+
+// jsonRuleFields has the same fields as Rule but none of its methods so it
+// can be encoded without recursing into Rule.MarshalJSON.
+type jsonRuleFields Rule
+
+type jsonRule struct {
+	*jsonRuleFields
+	OnFail string   `json:"onfail,omitempty"`
+	Exprs  []string `json:"exprs,omitempty"` // the sources of the rule's ExprConditions
+}
+
+// MarshalJSON encodes a rule. Its ExprConditions are encoded by their source;
+// other custom conditions and custom effects are not encoded.
+func (r *Rule) MarshalJSON() ([]byte, error) {
+	jr := jsonRule{jsonRuleFields: (*jsonRuleFields)(r)}
+	if r.OnFail != nil {
+		jr.OnFail = r.OnFail.Name
+	}
+	for _, c := range r.CustomConditions {
+		if c, ok := c.(ExprCondition); ok {
+			jr.Exprs = append(jr.Exprs, c.Expr.String())
+		}
+	}
+	return json.Marshal(jr)
+}
+
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var fields jsonRuleFields
+	jr := jsonRule{jsonRuleFields: &fields}
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return err
+	}
+	*r = Rule(fields)
+	if jr.OnFail != "" {
+		r.OnFail = &Rule{Name: jr.OnFail}
+	}
+	for _, src := range jr.Exprs {
+		e, err := ParseExpr(src)
+		if err != nil {
+			return fmt.Errorf("rule %q: invalid expression %q: %w", r.Name, src, err)
+		}
+		r.CustomConditions = append(r.CustomConditions, ExprCondition{Expr: e})
+	}
+	return nil
+}
+
+type jsonAgent struct {
+	ID        string              `json:"id,omitempty"`
+	Name      Name                `json:"name"`
+	Pools     *PoolSet            `json:"pools"`
+	Rules     []string            `json:"rules,omitempty"`
+	Relations map[Relation]string `json:"relations,omitempty"`
+	Phase     int                 `json:"phase,omitempty"`
+	Attrs     map[string]string   `json:"attrs,omitempty"`
+	Position  *Position           `json:"position,omitempty"`
+	Velocity  *Position           `json:"velocity,omitempty"`
+	Target    *Position           `json:"target,omitempty"`
+}
+
+func (a *Agent) MarshalJSON() ([]byte, error) {
+	ja := jsonAgent{
+		ID:       a.ID,
+		Name:     a.Name,
+		Pools:    a.Pools,
+		Phase:    a.Phase,
+		Attrs:    a.Attrs,
+		Position: a.Position,
+		Target:   a.Target,
+	}
+	if a.Velocity != (Position{}) {
+		ja.Velocity = &a.Velocity
+	}
+	for _, r := range a.Rules {
+		ja.Rules = append(ja.Rules, r.Name)
+	}
+	if len(a.Relations) > 0 {
+		ja.Relations = make(map[Relation]string, len(a.Relations))
+		for rel, ra := range a.Relations {
+			ja.Relations[rel] = agentLabel(ra)
+		}
+	}
+	return json.Marshal(ja)
+}
+
+func (a *Agent) UnmarshalJSON(data []byte) error {
+	var ja jsonAgent
+	if err := json.Unmarshal(data, &ja); err != nil {
+		return err
+	}
+	*a = *NewAgent(ja.Name.Singular)
+	a.ID = ja.ID
+	a.Name = ja.Name
+	a.Phase = ja.Phase
+	a.Attrs = ja.Attrs
+	a.Position = ja.Position
+	a.Target = ja.Target
+	if ja.Velocity != nil {
+		a.Velocity = *ja.Velocity
+	}
+	if ja.Pools != nil {
+		a.Pools = ja.Pools
+	}
+	for _, name := range ja.Rules {
+		a.Rules = append(a.Rules, &Rule{Name: name})
+	}
+	for rel, name := range ja.Relations {
+		a.Relations[rel] = &Agent{ID: name, Name: Name{Singular: name}}
+	}
+	return nil
+}
+
+// UnmarshalRules decodes a JSON array of rules, binding resource references to
+// the supplied resources and onfail references to rules within the array.
+func UnmarshalRules(data []byte, resources []*Resource) ([]*Rule, error) {
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	b := newBinder(resources, rules)
+	for _, r := range rules {
+		if err := b.bindRule(r); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// UnmarshalAgents decodes a JSON array of agents, binding pools to the supplied
+// resources, rules by name and relations to agents within the array.
+func UnmarshalAgents(data []byte, resources []*Resource, rules []*Rule) ([]*Agent, error) {
+	var agents []*Agent
+	if err := json.Unmarshal(data, &agents); err != nil {
+		return nil, err
+	}
+
+	b := newBinder(resources, rules)
+	for _, a := range agents {
+		b.agents[agentLabel(a)] = a
+	}
+
+	for _, a := range agents {
+		if err := b.bindAgent(a); err != nil {
+			return nil, err
+		}
+	}
+	return agents, nil
+}
+
+// resourceID returns the identifier used to refer to r in encoded form.
+func resourceID(r *Resource) string {
+	if r == nil {
+		return ""
+	}
+	return r.ID
+}
+
+// resourceRef returns a placeholder resource for the given identifier.
+func resourceRef(id string) *Resource {
+	if id == "" {
+		return nil
+	}
+	return &Resource{ID: id}
+}
+
+// A binder replaces placeholder references produced by decoding with the
+// values they refer to.
+type binder struct {
+	resources *resourceIndex
+	rules     map[string]*Rule
+	agents    map[string]*Agent
+}
+
+func newBinder(resources []*Resource, rules []*Rule) *binder {
+	b := &binder{
+		resources: newResourceIndex(resources),
+		rules:     map[string]*Rule{},
+		agents:    map[string]*Agent{},
+	}
+	for _, r := range rules {
+		b.rules[r.Name] = r
+	}
+	return b
+}
+
+func (b *binder) resource(r **Resource) error {
+	if *r == nil {
+		return nil
+	}
+	res, ok := b.resources.id((*r).ID)
+	if !ok {
+		return fmt.Errorf("unknown resource: %q", (*r).ID)
+	}
+	*r = res
+	return nil
+}
+
+func (b *binder) bindRule(r *Rule) error {
+	for i := range r.Preconditions {
+		if err := b.resource(&r.Preconditions[i].Resource); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	for _, specs := range [][]ResourceSpecifier{r.Inputs, r.Outputs, r.Sets, r.Ships} {
+		for i := range specs {
+			if err := b.resource(&specs[i].Resource); err != nil {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+		}
+	}
+	if r.RepeatFrom != nil {
+		if err := b.resource(&r.RepeatFrom.Resource); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	for i := range r.Exchanges {
+		x := &r.Exchanges[i]
+		for _, res := range []**Resource{&x.Give, &x.Get, &x.Rate.Resource} {
+			if err := b.resource(res); err != nil {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+		}
+	}
+	for i := range r.Offers {
+		if err := b.resource(&r.Offers[i].Resource); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		if err := b.resource(&r.Offers[i].Currency); err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	for _, c := range r.CustomConditions {
+		c, ok := c.(ExprCondition)
+		if !ok {
+			continue
+		}
+		err := c.Expr.leaves(func(n exprNode) error {
+			ref, ok := n.(*refNode)
+			if !ok {
+				return nil
+			}
+			if ref.res, ok = b.resources.name(ref.name); !ok {
+				return fmt.Errorf("unknown resource: %q", ref.name)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+	}
+	if r.OnFail != nil {
+		onFail, ok := b.rules[r.OnFail.Name]
+		if !ok {
+			return fmt.Errorf("rule %q: unknown onfail rule: %q", r.Name, r.OnFail.Name)
+		}
+		r.OnFail = onFail
+	}
+	return nil
+}
+
+func (b *binder) bindAgent(a *Agent) error {
+	pools := a.Pools.Pools()
+	for _, pool := range pools {
+		if err := b.resource(&pool.Resource); err != nil {
+			return fmt.Errorf("agent %q: %w", a.Name.Singular, err)
+		}
+		if pool.Link != nil {
+			if err := b.resource(&pool.Link.Source); err != nil {
+				return fmt.Errorf("agent %q: %w", a.Name.Singular, err)
+			}
+		}
+	}
+	a.Pools = NewPoolSet(pools...)
+
+	for i, r := range a.Rules {
+		rule, ok := b.rules[r.Name]
+		if !ok {
+			return fmt.Errorf("agent %q: unknown rule: %q", a.Name.Singular, r.Name)
+		}
+		a.Rules[i] = rule
+	}
+
+	for rel, ra := range a.Relations {
+		related, ok := b.agents[agentLabel(ra)]
+		if !ok {
+			return fmt.Errorf("agent %q: unknown %s agent: %q", a.Name.Singular, rel, agentLabel(ra))
+		}
+		a.Relations[rel] = related
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package rula
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRulesJSONRoundtrip(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource iron_ore
+end
+
+resource iron
+end
+
+resource workers
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule smelt
+	if global iron_ore > 6
+	in iron_ore 3
+	out iron 1
+	repeat using workers
+	onfail idle
+end
+
+rule idle
+	every 0
+	set location workers 0
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+
+	got, err := UnmarshalRules(data, resources)
+	if err != nil {
+		t.Fatalf("unexpected unmarshal error: %v", err)
+	}
+
+	if diff := cmp.Diff(rules, got); diff != "" {
+		t.Errorf("UnmarshalRules() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got[0].OnFail != got[1] {
+		t.Errorf("onfail rule was not bound to decoded rule")
+	}
+}
+
+func TestAgentsJSONRoundtrip(t *testing.T) {
+	ore := &Resource{ID: "iron_ore", Name: Name{Singular: "iron_ore"}}
+	rule := &Rule{Name: "mine", Period: 1}
+
+	town := NewAgent("town")
+	town.AddPool(ore, 100, 20)
+
+	mine := NewAgent("mine")
+	mine.AddPool(ore, 10, 5)
+	mine.Pools.Reserve(ore, 2)
+	mine.AppendRules([]*Rule{rule})
+	mine.AddRelation(RelationLocation, town)
+
+	data, err := json.Marshal([]*Agent{town, mine})
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+
+	got, err := UnmarshalAgents(data, []*Resource{ore}, []*Rule{rule})
+	if err != nil {
+		t.Fatalf("unexpected unmarshal error: %v", err)
+	}
+
+	if diff := cmp.Diff([]*Agent{town, mine}, got, cmp.AllowUnexported(PoolSet{}), cmpopts.IgnoreFields(PoolSet{}, "version")); diff != "" {
+		t.Errorf("UnmarshalAgents() mismatch (-want +got):\n%s", diff)
+	}
+
+	if got[1].Relations[RelationLocation] != got[0] {
+		t.Errorf("relation was not bound to decoded agent")
+	}
+}
+
+func TestUnmarshalRulesUnknownResource(t *testing.T) {
+	_, err := UnmarshalRules([]byte(`[{"name":"test","period":1,"inputs":[{"relation":"self","resource":"gold","quantity":1}]}]`), nil)
+	if err == nil {
+		t.Errorf("got no error, wanted unknown resource error")
+	}
+}
+
+func TestUnmarshalRulesUnknownOnFail(t *testing.T) {
+	_, err := UnmarshalRules([]byte(`[{"name":"test","period":1,"onfail":"idle"}]`), nil)
+	want := `rule "test": unknown onfail rule: "idle"`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, wanted %s", err, want)
+	}
+}
+
+func TestRulesJSONExprCondition(t *testing.T) {
+	population := &Resource{ID: "population", Name: Name{Singular: "population"}}
+	houses := &Resource{ID: "houses", Name: Name{Singular: "houses"}}
+	resources := []*Resource{population, houses}
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule overcrowding
+	if expr population / houses > 4
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		t.Fatalf("unexpected marshal error: %v", err)
+	}
+	var encoded []struct{ Exprs []string }
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"population / houses > 4"}, encoded[0].Exprs); diff != "" {
+		t.Errorf("encoded exprs mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := UnmarshalRules(data, resources)
+	if err != nil {
+		t.Fatalf("unexpected unmarshal error: %v", err)
+	}
+	if len(got[0].CustomConditions) != 1 {
+		t.Fatalf("got %d custom conditions, wanted 1", len(got[0].CustomConditions))
+	}
+	a := NewAgent("town")
+	a.AddPool(population, 100, 20)
+	a.AddPool(houses, 10, 4)
+	if !got[0].CustomConditions[0].Holds(a.RuleContext()) {
+		t.Errorf("got condition not holding with 5 people per house, wanted it to hold")
+	}
+
+	if _, err := UnmarshalRules([]byte(`[{"name":"r","exprs":["population / cottages > 4"]}]`), resources); err == nil {
+		t.Errorf("got no error for unknown resource in expression, wanted one")
+	}
+	if _, err := UnmarshalRules([]byte(`[{"name":"r","exprs":["population >"]}]`), resources); err == nil {
+		t.Errorf("got no error for malformed expression, wanted one")
+	}
+}
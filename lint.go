@@ -0,0 +1,178 @@
+package rula
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A LintIssue is a likely mistake in a set of rules found by Lint.
+type LintIssue struct {
+	Rule    string // the name of the rule concerned
+	Message string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("rule %q: %s", i.Rule, i.Message)
+}
+
+// Lint looks for likely mistakes in rules that parse and validate but will
+// not behave as intended: resources used by rules that are not among
+// resources, rules that are never run because they have no period and are
+// neither manual nor the onfail rule of another rule, onfail cycles, and
+// resources that rules consume but no rule produces and that do not
+// regenerate. Issues are returned in rule order.
+func Lint(rules []*Rule, resources []*Resource) []LintIssue {
+	var issues []LintIssue
+	add := func(r *Rule, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Rule: r.Name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	known := func(res *Resource) bool {
+		for _, k := range resources {
+			if res.Is(k) {
+				return true
+			}
+		}
+		return false
+	}
+	produced := map[string]bool{}
+	for _, res := range resources {
+		if res.Regen > 0 {
+			produced[resourceID(res)] = true
+		}
+	}
+
+	targets := map[*Rule]bool{}
+	for _, r := range rules {
+		if r.OnFail != nil {
+			targets[r.OnFail] = true
+		}
+		for _, res := range r.produces() {
+			produced[resourceID(res)] = true
+		}
+	}
+
+	for _, r := range rules {
+		reported := map[string]bool{}
+		for _, res := range r.resources() {
+			if res == nil {
+				continue
+			}
+			if id := resourceID(res); !reported[id] && !known(res) {
+				reported[id] = true
+				add(r, "unknown resource %q", id)
+			}
+		}
+
+		if r.Period == 0 && !r.Manual && !targets[r] {
+			add(r, "never runs: it has no period and is not the onfail rule of another rule")
+		}
+
+		seen := map[*Rule]bool{}
+		for f := r; f != nil; f = f.OnFail {
+			if seen[f] {
+				add(r, "onfail cycle through rule %q", f.Name)
+				break
+			}
+			seen[f] = true
+		}
+
+		var unproduced []string
+		for _, res := range r.consumes() {
+			if id := resourceID(res); !produced[id] && !reported[id] {
+				reported[id] = true
+				unproduced = append(unproduced, id)
+			}
+		}
+		sort.Strings(unproduced)
+		for _, id := range unproduced {
+			add(r, "consumes %q which no rule produces", id)
+		}
+	}
+	return issues
+}
+
+// LintScenario is like Lint for the rules and resources of a scenario, and
+// also reports rules whose outputs in a single round exceed the capacity of
+// the pools of the global pools or agents that run them.
+func LintScenario(sc *Scenario) []LintIssue {
+	issues := Lint(sc.Rules, sc.Resources)
+
+	check := func(owner string, ps *PoolSet, rules []*Rule) {
+		for _, r := range rules {
+			for _, o := range r.Outputs {
+				if o.Relation != RelationSelf && o.Relation != "" {
+					continue
+				}
+				if pool := ps.poolFor(o.Resource); pool != nil && o.Quantity > pool.Capacity {
+					issues = append(issues, LintIssue{
+						Rule:    r.Name,
+						Message: fmt.Sprintf("output of %d %s exceeds the capacity %d of %s", o.Quantity, resourceID(o.Resource), pool.Capacity, owner),
+					})
+				}
+			}
+		}
+	}
+	if sc.Global != nil {
+		check("global", sc.Global.Pools, sc.Global.Rules)
+	}
+	for _, a := range sc.Agents {
+		check(fmt.Sprintf("agent %q", agentLabel(a)), a.Pools, a.Rules)
+	}
+	return issues
+}
+
+// resources returns every resource the rule refers to.
+func (r *Rule) resources() []*Resource {
+	var res []*Resource
+	for _, c := range r.Preconditions {
+		res = append(res, c.Resource)
+	}
+	for _, specs := range [][]ResourceSpecifier{r.Inputs, r.Outputs, r.Sets, r.Ships} {
+		for _, s := range specs {
+			res = append(res, s.Resource)
+		}
+	}
+	for _, x := range r.Exchanges {
+		res = append(res, x.Give, x.Get, x.Rate.Resource)
+	}
+	for _, o := range r.Offers {
+		res = append(res, o.Resource, o.Currency)
+	}
+	if r.RepeatFrom != nil {
+		res = append(res, r.RepeatFrom.Resource)
+	}
+	return res
+}
+
+// produces returns the resources the rule can add to pools. Trades and
+// shipments move resources between pools rather than producing them.
+func (r *Rule) produces() []*Resource {
+	var res []*Resource
+	for _, specs := range [][]ResourceSpecifier{r.Outputs, r.Sets} {
+		for _, s := range specs {
+			if s.Quantity > 0 {
+				res = append(res, s.Resource)
+			}
+		}
+	}
+	for _, x := range r.Exchanges {
+		res = append(res, x.Get)
+	}
+	return res
+}
+
+// consumes returns the resources the rule takes from pools.
+func (r *Rule) consumes() []*Resource {
+	var res []*Resource
+	for _, s := range r.Inputs {
+		res = append(res, s.Resource)
+	}
+	for _, s := range r.Ships {
+		res = append(res, s.Resource)
+	}
+	for _, x := range r.Exchanges {
+		res = append(res, x.Give)
+	}
+	return res
+}
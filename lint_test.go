@@ -0,0 +1,93 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLint(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource ore
+	regen 1
+end
+
+resource iron
+end
+
+resource coal
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule smelt
+	in ore 2
+	in coal 1
+	out iron 1
+end
+
+rule forge
+	in iron 1
+end
+
+rule idle
+	every 0
+	out iron 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The onfail cycle is built by hand since the parser rejects it
+	a := NewRule("a").Every(1).Build()
+	b := NewRule("b").Every(0).Build()
+	a.OnFail, b.OnFail = b, a
+	rules = append(rules, a, b)
+	// A resource the linter is not told about
+	slag := &Resource{ID: "slag"}
+	rules = append(rules, NewRule("dump").Out(RelationSelf, slag, 1).Build())
+
+	got := Lint(rules, resources)
+	var msgs []string
+	for _, i := range got {
+		msgs = append(msgs, i.String())
+	}
+	want := []string{
+		`rule "smelt": consumes "coal" which no rule produces`,
+		`rule "idle": never runs: it has no period and is not the onfail rule of another rule`,
+		`rule "a": onfail cycle through rule "a"`,
+		`rule "b": onfail cycle through rule "b"`,
+		`rule "dump": unknown resource "slag"`,
+	}
+	if diff := cmp.Diff(want, msgs); diff != "" {
+		t.Errorf("Lint() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLintScenario(t *testing.T) {
+	sc, err := NewScenarioParser().Parse(strings.NewReader(`
+resource grain
+	regen 1
+end
+
+rule harvest
+	out grain 50
+end
+
+agent farm
+	pool grain 20
+	rules harvest
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := LintScenario(sc)
+	if len(got) != 1 || got[0].String() != `rule "harvest": output of 50 grain exceeds the capacity 20 of agent "farm"` {
+		t.Errorf("got issues %v", got)
+	}
+}
@@ -0,0 +1,242 @@
+package rula
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// An Offer is an agent's offer to buy or sell a quantity of a resource at a
+// price per unit paid in another resource, such as gold.
+type Offer struct {
+	Agent    *Agent
+	Sell     bool // true for an offer to sell, false for an offer to buy
+	Resource *Resource
+	Quantity int
+	Price    int
+	Currency *Resource
+}
+
+func (o Offer) String() string {
+	verb := "buy"
+	if o.Sell {
+		verb = "sell"
+	}
+	return fmt.Sprintf("%s %s %d %s at %d %s", agentLabel(o.Agent), verb, o.Quantity, o.Resource, o.Price, o.Currency)
+}
+
+// A Trade is an exchange made when a market is cleared.
+type Trade struct {
+	Buyer    *Agent
+	Seller   *Agent
+	Resource *Resource
+	Quantity int
+	Price    int // per unit
+	Currency *Resource
+}
+
+// Pricing decides the price at which a matched buy and sell offer trade.
+type Pricing int
+
+const (
+	PriceAsk      Pricing = 0 // the seller's price
+	PriceBid      Pricing = 1 // the buyer's price
+	PriceMidpoint Pricing = 2 // halfway between the two, rounded down
+)
+
+func (p Pricing) price(bid, ask int) int {
+	switch p {
+	case PriceBid:
+		return bid
+	case PriceMidpoint:
+		return ask + (bid-ask)/2
+	}
+	return ask
+}
+
+// A Market collects offers and clears them by matching buyers with sellers.
+// Offers can be posted directly or by rules with buy and sell directives run
+// by a World, which clears its market at the end of each tick.
+type Market struct {
+	Pricing Pricing
+	offers  []Offer
+}
+
+// NewMarket returns an empty market that trades at prices set by p.
+func NewMarket(p Pricing) *Market {
+	return &Market{Pricing: p}
+}
+
+// Post adds an offer to the market. Offers without an agent or with a
+// quantity less than one are ignored.
+func (m *Market) Post(o Offer) {
+	if o.Agent == nil || o.Quantity < 1 {
+		return
+	}
+	m.offers = append(m.offers, o)
+}
+
+// Offers returns the offers waiting to be cleared in the order they were
+// posted.
+func (m *Market) Offers() []Offer {
+	return append([]Offer(nil), m.offers...)
+}
+
+// Withdraw removes the offers of an agent.
+func (m *Market) Withdraw(a *Agent) {
+	offers := m.offers[:0]
+	for _, o := range m.offers {
+		if o.Agent != a {
+			offers = append(offers, o)
+		}
+	}
+	m.offers = offers
+}
+
+// Clear matches the offers for each resource and currency and carries out
+// the trades, returning them in the order they were made. Buy offers are
+// filled in order of highest price and sell offers in order of lowest price,
+// earlier offers first at the same price, while the buyer's price is at least
+// the seller's. The quantity traded is limited by what the seller has, what
+// the buyer can pay and the space each has for what they receive. Agents do
+// not trade with themselves. All offers are removed once the market is
+// cleared, so agents post offers again each tick they want to trade.
+func (m *Market) Clear() []Trade {
+	type pair struct{ resource, currency *Resource }
+	var pairs []pair
+	books := map[pair][]*Offer{}
+	for i := range m.offers {
+		o := m.offers[i]
+		p := pair{o.Resource, o.Currency}
+		if _, ok := books[p]; !ok {
+			pairs = append(pairs, p)
+		}
+		books[p] = append(books[p], &o)
+	}
+	m.offers = nil
+
+	var trades []Trade
+	for _, p := range pairs {
+		var bids, asks []*Offer
+		for _, o := range books[p] {
+			if o.Sell {
+				asks = append(asks, o)
+			} else {
+				bids = append(bids, o)
+			}
+		}
+		sort.SliceStable(bids, func(i, j int) bool { return bids[i].Price > bids[j].Price })
+		sort.SliceStable(asks, func(i, j int) bool { return asks[i].Price < asks[j].Price })
+
+		for _, bid := range bids {
+			for _, ask := range asks {
+				if bid.Quantity == 0 || ask.Price > bid.Price {
+					break
+				}
+				if ask.Quantity == 0 || ask.Agent == bid.Agent {
+					continue
+				}
+				t := Trade{
+					Buyer:    bid.Agent,
+					Seller:   ask.Agent,
+					Resource: p.resource,
+					Price:    m.Pricing.price(bid.Price, ask.Price),
+					Currency: p.currency,
+				}
+				t.Quantity = tradable(t, minInt(bid.Quantity, ask.Quantity))
+				if t.Quantity == 0 {
+					continue
+				}
+				t.exchange()
+				bid.Quantity -= t.Quantity
+				ask.Quantity -= t.Quantity
+				trades = append(trades, t)
+			}
+		}
+	}
+	return trades
+}
+
+// tradable returns how much of up to n units the parties to t can exchange.
+func tradable(t Trade, n int) int {
+	buyer, seller := t.Buyer.Pools, t.Seller.Pools
+	n = minInt(n, seller.Available(t.Resource))
+	n = minInt(n, space(buyer, t.Resource))
+	if t.Price > 0 {
+		n = minInt(n, buyer.Available(t.Currency)/t.Price)
+		n = minInt(n, space(seller, t.Currency)/t.Price)
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// exchange moves the goods and payment of t between the buyer and seller.
+func (t Trade) exchange() {
+	buyer, seller := t.Buyer.Pools, t.Seller.Pools
+	seller.Remove(t.Resource, t.Quantity)
+	buyer.Add(t.Resource, t.Quantity)
+	if cost := t.Quantity * t.Price; cost > 0 {
+		buyer.Remove(t.Currency, cost)
+		seller.Add(t.Currency, cost)
+	}
+}
+
+// space returns the quantity of resource r that can be added to ps.
+func space(ps *PoolSet, r *Resource) int {
+	if ps.poolFor(r) == nil {
+		return 0
+	}
+	return ps.Capacity(r) - ps.Quantity(r)
+}
+
+// An OfferSpecifier posts an offer to a World's market on behalf of the agent
+// a rule is run for, multiplied by the number of rounds the rule completes.
+type OfferSpecifier struct {
+	Sell     bool
+	Resource *Resource
+	Quantity int
+	Price    int
+	Currency *Resource
+}
+
+type jsonOffer struct {
+	Sell     bool   `json:"sell,omitempty"`
+	Resource string `json:"resource"`
+	Quantity int    `json:"quantity"`
+	Price    int    `json:"price"`
+	Currency string `json:"currency"`
+}
+
+func (s OfferSpecifier) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonOffer{
+		Sell:     s.Sell,
+		Resource: resourceID(s.Resource),
+		Quantity: s.Quantity,
+		Price:    s.Price,
+		Currency: resourceID(s.Currency),
+	})
+}
+
+func (s *OfferSpecifier) UnmarshalJSON(data []byte) error {
+	var jo jsonOffer
+	if err := json.Unmarshal(data, &jo); err != nil {
+		return err
+	}
+	*s = OfferSpecifier{
+		Sell:     jo.Sell,
+		Resource: resourceRef(jo.Resource),
+		Quantity: jo.Quantity,
+		Price:    jo.Price,
+		Currency: resourceRef(jo.Currency),
+	}
+	return nil
+}
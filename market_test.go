@@ -0,0 +1,118 @@
+package rula
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMarketClear(t *testing.T) {
+	grain := &Resource{ID: "grain", Name: Name{Singular: "grain"}}
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}}
+	trader := func(name string, g, au int) *Agent {
+		a := NewAgent(name)
+		a.Pools.AddPool(grain, 100, g)
+		a.Pools.AddPool(gold, 100, au)
+		return a
+	}
+
+	testCases := []struct {
+		pricing Pricing
+		want    []string
+	}{
+		{PriceAsk, []string{"ann<-cat 5@2", "ann<-dan 3@4", "bob<-dan 1@4"}},
+		{PriceBid, []string{"ann<-cat 5@6", "ann<-dan 1@6", "bob<-dan 3@5"}},
+		{PriceMidpoint, []string{"ann<-cat 5@4", "ann<-dan 3@5", "bob<-dan 1@4"}},
+	}
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			ann, bob := trader("ann", 0, 40), trader("bob", 0, 20)
+			cat, dan := trader("cat", 5, 0), trader("dan", 10, 0)
+
+			m := NewMarket(tc.pricing)
+			m.Post(Offer{Agent: bob, Resource: grain, Quantity: 10, Price: 5, Currency: gold})
+			m.Post(Offer{Agent: ann, Resource: grain, Quantity: 8, Price: 6, Currency: gold})
+			m.Post(Offer{Agent: dan, Sell: true, Resource: grain, Quantity: 4, Price: 4, Currency: gold})
+			m.Post(Offer{Agent: cat, Sell: true, Resource: grain, Quantity: 9, Price: 2, Currency: gold})
+			m.Post(Offer{Agent: cat, Sell: true, Resource: grain, Quantity: 9, Price: 7, Currency: gold})
+
+			var got []string
+			for _, tr := range m.Clear() {
+				got = append(got, fmt.Sprintf("%s<-%s %d@%d", tr.Buyer.ID, tr.Seller.ID, tr.Quantity, tr.Price))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Clear() mismatch (-want +got):\n%s", diff)
+			}
+			if len(m.Offers()) != 0 {
+				t.Errorf("got %d offers after clearing, wanted none", len(m.Offers()))
+			}
+
+			total := 0
+			for _, a := range []*Agent{ann, bob, cat, dan} {
+				total += a.Pools.Quantity(gold)
+			}
+			if total != 60 {
+				t.Errorf("got %d gold in total, wanted 60", total)
+			}
+		})
+	}
+}
+
+func TestWorldMarket(t *testing.T) {
+	grain := &Resource{ID: "grain", Name: Name{Singular: "grain"}}
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}, Currency: true}
+	rules, err := NewRuleParser([]*Resource{grain, gold}).Parse(strings.NewReader(`
+rule farm
+	out grain 2
+	sell grain 2 at 3 gold
+end
+
+rule eat
+	in grain 1
+	onfail shop
+end
+
+rule shop
+	every 0
+	buy grain 2 at 5 gold
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := NewWorld(nil)
+	w.Market = NewMarket(PriceAsk)
+	farmer := NewAgent("farmer")
+	farmer.Pools.AddPool(grain, 10, 0)
+	farmer.Pools.AddPool(gold, 100, 0)
+	farmer.AppendRules(rules[:1])
+	smith := NewAgent("smith")
+	smith.Pools.AddPool(grain, 10, 0)
+	smith.Pools.AddPool(gold, 100, 20)
+	smith.AppendRules(rules[1:2])
+	w.AddAgent(farmer)
+	w.AddAgent(smith)
+
+	var trades []Trade
+	w.OnTrade(func(tr Trade) { trades = append(trades, tr) })
+	for i := 0; i < 4; i++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The smith has no grain to eat so shops on the first tick, eats what
+	// was bought on the next two and shops again on the fourth
+	if len(trades) != 2 {
+		t.Fatalf("got %d trades, wanted 2", len(trades))
+	}
+	if got := smith.Pools.Quantity(gold); got != 8 {
+		t.Errorf("got smith gold %d, wanted 8", got)
+	}
+	if got := farmer.Pools.Quantity(gold); got != 12 {
+		t.Errorf("got farmer gold %d, wanted 12", got)
+	}
+}
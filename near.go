@@ -0,0 +1,43 @@
+package rula
+
+import "fmt"
+
+// A NearCondition requires the agent related to the one a rule is run for to
+// be no further than Within in a straight line from the agent. Agents with a
+// position of their own are where their position is and other agents are at
+// their location. The condition does not hold if either agent is nowhere.
+type NearCondition struct {
+	Relation Relation `json:"relation"`
+	Within   Length   `json:"within"`
+}
+
+func (c NearCondition) String() string {
+	return fmt.Sprintf("%s within %s", c.Relation, formatLength(c.Within))
+}
+
+// nearDistance returns the distance between the context's agent and the
+// agent related to it by c, or false if either is nowhere.
+func nearDistance(c NearCondition, ctx RuleContext) (Length, bool) {
+	ra := ctx.Agent.related(c.Relation)
+	if ra == nil {
+		return 0, false
+	}
+	p, ok := ctx.Agent.where()
+	q, rok := ra.where()
+	if !ok || !rok {
+		return 0, false
+	}
+	return p.Distance(q), true
+}
+
+// nearBlock returns a Block for the first near condition of rule that does
+// not hold in ctx, or nil if they all hold.
+func nearBlock(rule *Rule, ctx RuleContext) *Block {
+	for i, c := range rule.NearConditions {
+		d, ok := nearDistance(c, ctx)
+		if !ok || d > c.Within {
+			return &Block{Near: &rule.NearConditions[i], Distance: d, Missing: !ok}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNearCondition(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource supplies
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	supplies := resources[0]
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule resupply
+	ifnear depot 5km
+	in depot supplies 1
+	out supplies 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resupply := rules[0]
+
+	n := NewNetwork()
+	base := n.AddLocation(Position{})
+	front := n.AddLocation(Position{East: 3 * Kilometre, North: 4 * Kilometre})
+	far := n.AddLocation(Position{East: 6 * Kilometre})
+
+	depot := NewAgent("depot")
+	depot.Pools.AddPool(supplies, 100, 10)
+	depot.MoveTo(base)
+	army := NewAgent("army")
+	army.Pools.AddPool(supplies, 100, 0)
+	army.AddRelation("depot", depot)
+
+	testCases := []struct {
+		at   *Location
+		want bool
+	}{
+		{nil, false},
+		{front, true}, // exactly 5km away
+		{far, false},
+	}
+	ru := NewRunner()
+	for i, tc := range testCases {
+		army.MoveTo(tc.at)
+		res, err := ru.RunRule(resupply, int64(i+1), army.RuleContext())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.Fired() != tc.want {
+			t.Errorf("at %v: got fired %v, wanted %v (blocked by %v)", tc.at, res.Fired(), tc.want, res.Blocked)
+		}
+		if !tc.want && (res.Blocked == nil || res.Blocked.Near == nil) {
+			t.Errorf("at %v: got block %v, wanted the near condition", tc.at, res.Blocked)
+		}
+		if ex := ru.Explain(resupply, army.RuleContext()); ex.CanRun != tc.want {
+			t.Errorf("at %v: explanation %s", tc.at, ex)
+		}
+	}
+}
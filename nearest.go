@@ -0,0 +1,199 @@
+package rula
+
+import (
+	"math"
+	"sort"
+)
+
+// Distance returns the straight line distance between p and o.
+func (p Position) Distance(o Position) Length {
+	return Length(math.Round(p.distance(o)))
+}
+
+func (p Position) distance(o Position) float64 {
+	return math.Hypot(float64(p.East-o.East), float64(p.North-o.North))
+}
+
+// Nearest returns the location closest to pos in a straight line, the one
+// with the lowest ID if several are equally close, or nil if the network has
+// no locations.
+func (g *Graph) Nearest(pos Position) *Location {
+	return g.grid().nearest(pos)
+}
+
+// Within returns the locations no further than radius from pos in a straight
+// line, nearest first and by ID at the same distance.
+func (g *Graph) Within(pos Position, radius Length) []*Location {
+	return g.grid().within(pos, radius)
+}
+
+// grid returns the spatial index of the network's locations, building it if
+// locations have been added since it was last built.
+func (g *Graph) grid() *spatialGrid {
+	if g.index == nil {
+		g.index = newSpatialGrid(g.order)
+	}
+	return g.index
+}
+
+type gridKey struct {
+	x, y int64
+}
+
+// A spatialGrid indexes locations by the square cell of a grid they lie in,
+// so that queries only look at the cells around a position. The cells are
+// sized so that there is about one location to a cell.
+type spatialGrid struct {
+	cell  Length
+	cells map[gridKey][]*Location
+	min   gridKey // the lowest cell holding a location
+	max   gridKey // the highest cell holding a location
+}
+
+func newSpatialGrid(locs []*Location) *spatialGrid {
+	s := &spatialGrid{cell: 1, cells: map[gridKey][]*Location{}}
+	if len(locs) == 0 {
+		return s
+	}
+	lo, hi := locs[0].pos, locs[0].pos
+	for _, l := range locs[1:] {
+		lo.East = minLength(lo.East, l.pos.East)
+		lo.North = minLength(lo.North, l.pos.North)
+		hi.East = maxLength(hi.East, l.pos.East)
+		hi.North = maxLength(hi.North, l.pos.North)
+	}
+	extent := maxLength(hi.East-lo.East, hi.North-lo.North)
+	if c := extent / Length(math.Ceil(math.Sqrt(float64(len(locs))))); c > 1 {
+		s.cell = c
+	}
+
+	s.min, s.max = s.key(locs[0].pos), s.key(locs[0].pos)
+	for _, l := range locs {
+		k := s.key(l.pos)
+		s.cells[k] = append(s.cells[k], l)
+		s.min.x, s.min.y = minInt64(s.min.x, k.x), minInt64(s.min.y, k.y)
+		s.max.x, s.max.y = maxInt64(s.max.x, k.x), maxInt64(s.max.y, k.y)
+	}
+	return s
+}
+
+// key returns the cell holding pos.
+func (s *spatialGrid) key(pos Position) gridKey {
+	return gridKey{floorDiv(int64(pos.East), int64(s.cell)), floorDiv(int64(pos.North), int64(s.cell))}
+}
+
+func (s *spatialGrid) nearest(pos Position) *Location {
+	if len(s.cells) == 0 {
+		return nil
+	}
+	var best *Location
+	var bestDist float64
+	consider := func(k gridKey) {
+		for _, l := range s.cells[k] {
+			d := pos.distance(l.pos)
+			if best == nil || d < bestDist || (d == bestDist && l.id < best.id) {
+				best, bestDist = l, d
+			}
+		}
+	}
+
+	// Search rings of cells around pos, starting with the first ring that
+	// reaches the occupied cells. Every location in a ring beyond ring r is
+	// at least r cells from pos.
+	c := s.key(pos)
+	first := maxInt64(maxInt64(s.min.x-c.x, c.x-s.max.x), maxInt64(s.min.y-c.y, c.y-s.max.y))
+	last := maxInt64(maxInt64(c.x-s.min.x, s.max.x-c.x), maxInt64(c.y-s.min.y, s.max.y-c.y))
+	for r := maxInt64(first, 0); r <= last; r++ {
+		if best != nil && bestDist <= float64(r-1)*float64(s.cell) {
+			break
+		}
+		x0, x1 := maxInt64(c.x-r, s.min.x), minInt64(c.x+r, s.max.x)
+		for _, y := range []int64{c.y - r, c.y + r} {
+			if y >= s.min.y && y <= s.max.y {
+				for x := x0; x <= x1; x++ {
+					consider(gridKey{x, y})
+				}
+			}
+			if r == 0 {
+				break
+			}
+		}
+		y0, y1 := maxInt64(c.y-r+1, s.min.y), minInt64(c.y+r-1, s.max.y)
+		for _, x := range []int64{c.x - r, c.x + r} {
+			if r == 0 || x < s.min.x || x > s.max.x {
+				continue
+			}
+			for y := y0; y <= y1; y++ {
+				consider(gridKey{x, y})
+			}
+		}
+	}
+	return best
+}
+
+func (s *spatialGrid) within(pos Position, radius Length) []*Location {
+	if len(s.cells) == 0 || radius < 0 {
+		return nil
+	}
+	lo := s.key(Position{East: pos.East - radius, North: pos.North - radius})
+	hi := s.key(Position{East: pos.East + radius, North: pos.North + radius})
+	lo.x, lo.y = maxInt64(lo.x, s.min.x), maxInt64(lo.y, s.min.y)
+	hi.x, hi.y = minInt64(hi.x, s.max.x), minInt64(hi.y, s.max.y)
+
+	var found []*Location
+	dist := map[*Location]float64{}
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			for _, l := range s.cells[gridKey{x, y}] {
+				if d := pos.distance(l.pos); d <= float64(radius) {
+					found = append(found, l)
+					dist[l] = d
+				}
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if dist[found[i]] != dist[found[j]] {
+			return dist[found[i]] < dist[found[j]]
+		}
+		return found[i].id < found[j].id
+	})
+	return found
+}
+
+// floorDiv returns a divided by b rounded towards negative infinity.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func minLength(a, b Length) Length {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxLength(a, b Length) Length {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+package rula
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestNearestAndWithin(t *testing.T) {
+	n := NewNetwork()
+	if n.Nearest(Position{}) != nil || len(n.Within(Position{}, Kilometre)) != 0 {
+		t.Fatalf("got locations from an empty network")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		n.AddLocation(Position{
+			East:  Length(rng.Int63n(200)-100) * Kilometre,
+			North: Length(rng.Int63n(100)-50) * Kilometre,
+		})
+	}
+
+	for i := 0; i < 100; i++ {
+		pos := Position{
+			East:  Length(rng.Int63n(600)-300) * Kilometre,
+			North: Length(rng.Int63n(300)-150) * Kilometre,
+		}
+		radius := Length(rng.Int63n(40)) * Kilometre
+
+		var want *Location
+		wantWithin := 0
+		for _, l := range n.Locations() {
+			d := pos.distance(l.Position())
+			if want == nil || d < pos.distance(want.Position()) {
+				want = l
+			}
+			if d <= float64(radius) {
+				wantWithin++
+			}
+		}
+		if got := n.Nearest(pos); got != want {
+			t.Errorf("Nearest(%v) = %d, wanted %d", pos, got.ID(), want.ID())
+		}
+
+		within := n.Within(pos, radius)
+		if len(within) != wantWithin {
+			t.Errorf("Within(%v, %d) found %d locations, wanted %d", pos, radius, len(within), wantWithin)
+		}
+		for j := 1; j < len(within); j++ {
+			if pos.distance(within[j].Position()) < pos.distance(within[j-1].Position()) {
+				t.Errorf("Within(%v, %d) not ordered by distance", pos, radius)
+			}
+		}
+	}
+
+	// A new location is found once added
+	l := n.AddLocation(Position{East: 500 * Kilometre})
+	if got := n.Nearest(Position{East: 490 * Kilometre}); got != l {
+		t.Errorf("got nearest %v, wanted the new location", got)
+	}
+	if got := (Position{East: 3 * Metre}).Distance(Position{North: 4 * Metre}); got != 5*Metre {
+		t.Errorf("got distance %d, wanted 5m", got)
+	}
+}
@@ -0,0 +1,83 @@
+package rula
+
+// Validate checks the network for problems that are likely to be mistakes
+// in a map: locations without connections, connections of zero or negative
+// distance, duplicate connections joining the same locations with the same
+// kind and direction, and connected locations split into more than one
+// component. It returns a *ValidationError describing any problems.
+func (g *Graph) Validate() error {
+	verr := &ValidationError{Subject: "network"}
+
+	for _, l := range g.order {
+		if len(g.adjacent[l.id]) == 0 {
+			verr.addf("location %d has no connections", l.id)
+		}
+	}
+
+	type connKey struct {
+		a, b   int64
+		kind   string
+		oneWay bool
+	}
+	seen := map[connKey]*Connection{}
+	for _, c := range g.connections() {
+		if c.distance <= 0 {
+			verr.addf("connection %d has distance %d", c.id, c.distance)
+		}
+		k := connKey{c.from.id, c.to.id, c.kind, c.oneWay}
+		if !c.oneWay && k.a > k.b {
+			k.a, k.b = k.b, k.a
+		}
+		if d, dup := seen[k]; dup {
+			verr.addf("connection %d duplicates connection %d", c.id, d.id)
+		} else {
+			seen[k] = c
+		}
+	}
+
+	parts := 0
+	for _, comp := range g.Components() {
+		if len(comp) > 1 {
+			parts++
+		}
+	}
+	if parts > 1 {
+		verr.addf("network is split into %d separate components", parts)
+	}
+
+	return verr.err()
+}
+
+// Components returns the groups of locations joined to each other by
+// connections, ignoring their direction. Each component lists its locations
+// in the order they were added to the network and the components are in the
+// order of their first locations. A location without connections is a
+// component on its own.
+func (g *Graph) Components() [][]*Location {
+	comp := map[int64]int{}
+	var comps [][]*Location
+	for _, l := range g.order {
+		if _, done := comp[l.id]; done {
+			continue
+		}
+		n := len(comps)
+		comp[l.id] = n
+		stack := []*Location{l}
+		for len(stack) > 0 {
+			here := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, c := range g.adjacent[here.id] {
+				o := c.Other(here)
+				if _, done := comp[o.id]; !done {
+					comp[o.id] = n
+					stack = append(stack, o)
+				}
+			}
+		}
+		comps = append(comps, nil)
+	}
+	for _, l := range g.order {
+		comps[comp[l.id]] = append(comps[comp[l.id]], l)
+	}
+	return comps
+}
@@ -0,0 +1,42 @@
+package rula
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNetworkValidate(t *testing.T) {
+	g := NewGridNetwork(2, 2, Kilometre)
+	if err := g.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	island := g.AddLocation(Position{})
+	a := g.AddLocation(Position{})
+	b := g.AddLocation(Position{})
+	g.Connect(a, b, 0)
+	g.Connect(b, a, Kilometre)
+	g.ConnectOneWay(a, b, Kilometre)
+
+	err := g.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got error %v, wanted a validation error", err)
+	}
+	want := []string{
+		"location 5 has no connections",
+		"connection 5 has distance 0",
+		"connection 6 duplicates connection 5",
+		"network is split into 2 separate components",
+	}
+	if diff := cmp.Diff(want, verr.Problems); diff != "" {
+		t.Errorf("Validate() mismatch (-want +got):\n%s", diff)
+	}
+
+	comps := g.Components()
+	if len(comps) != 3 || len(comps[0]) != 4 || len(comps[1]) != 1 || comps[1][0] != island || len(comps[2]) != 2 {
+		t.Errorf("got components %v", comps)
+	}
+}
@@ -0,0 +1,210 @@
+package rula
+
+import "sort"
+
+// A Graph is a Network held in memory. Each location keeps a list of the
+// connections that end at it so the neighbours of a location are found
+// without searching the whole network.
+type Graph struct {
+	locations map[int64]*Location
+	order     []*Location             // locations in the order they were added
+	adjacent  map[int64][]*Connection // connections ending at each location, by location ID
+	lastLoc   int64                   // ID of the last location added
+	lastConn  int64                   // ID of the last connection made
+
+	routes map[routeKey]route // cached routes, see Route
+	index  *spatialGrid       // index of location positions, see Nearest
+}
+
+var _ Network = (*Graph)(nil)
+
+// NewNetwork returns an empty network.
+func NewNetwork() *Graph {
+	return &Graph{
+		locations: map[int64]*Location{},
+		adjacent:  map[int64][]*Connection{},
+	}
+}
+
+// AddLocation adds a location at pos to the network, returning it. Locations
+// are given IDs from 1 in the order they are added.
+func (g *Graph) AddLocation(pos Position) *Location {
+	return g.addLocation(g.lastLoc+1, pos)
+}
+
+// addLocation adds a location with the given ID, which must not be in use.
+// Later locations are given IDs after the highest in use.
+func (g *Graph) addLocation(id int64, pos Position) *Location {
+	if id > g.lastLoc {
+		g.lastLoc = id
+	}
+	l := newLocation(id, pos)
+	g.index = nil
+	g.locations[l.id] = l
+	g.order = append(g.order, l)
+	return l
+}
+
+// Connect joins two locations of the network with a connection of the given
+// distance, returning it. Locations may be joined by more than one
+// connection, such as a road and a river. It panics if either location is
+// not in the network.
+func (g *Graph) Connect(a, b *Location, distance Length) *Connection {
+	return g.connect(g.lastConn+1, a, b, distance)
+}
+
+// connect is like Connect but gives the connection an ID, which must not be
+// in use. Later connections are given IDs after the highest in use.
+func (g *Graph) connect(id int64, a, b *Location, distance Length) *Connection {
+	if g.locations[a.id] != a || g.locations[b.id] != b {
+		panic("location not in network")
+	}
+	if id > g.lastConn {
+		g.lastConn = id
+	}
+	c := &Connection{id: id, from: a, to: b, distance: distance}
+	g.changed()
+	g.adjacent[a.id] = append(g.adjacent[a.id], c)
+	if b != a {
+		g.adjacent[b.id] = append(g.adjacent[b.id], c)
+	}
+	return c
+}
+
+// SetDifficulty sets the difficulty of connection c, which changes the routes
+// through it.
+func (g *Graph) SetDifficulty(c *Connection, difficulty float64) {
+	c.difficulty = difficulty
+	g.changed()
+}
+
+// ConnectOneWay is like Connect but the connection can only be travelled
+// from a to b, such as a river flowing downstream.
+func (g *Graph) ConnectOneWay(a, b *Location, distance Length) *Connection {
+	c := g.Connect(a, b, distance)
+	c.oneWay = true
+	return c
+}
+
+// SetKind sets the kind of connection c, such as road, river or sea.
+// Agents can be limited to some kinds of connection with Agent.Kinds.
+func (g *Graph) SetKind(c *Connection, kind string) {
+	c.kind = kind
+	g.changed()
+}
+
+// SetDistance sets the distance of connection c, which changes the routes
+// through it.
+func (g *Graph) SetDistance(c *Connection, distance Length) {
+	c.distance = distance
+	g.changed()
+}
+
+// Disconnect removes connection c from the network. Journeys and shipments
+// whose routes follow c are rerouted by the World at the end of the tick.
+// It has no effect if c is not in the network.
+func (g *Graph) Disconnect(c *Connection) {
+	if c.removed || g.locations[c.from.id] != c.from {
+		return
+	}
+	g.adjacent[c.from.id] = withoutConnection(g.adjacent[c.from.id], c)
+	g.adjacent[c.to.id] = withoutConnection(g.adjacent[c.to.id], c)
+	c.removed = true
+	g.changed()
+}
+
+// RemoveLocation removes location l and its connections from the network.
+// The agents at l are moved off the map and l is removed from its region.
+// It has no effect if l is not in the network.
+func (g *Graph) RemoveLocation(l *Location) {
+	if g.locations[l.id] != l {
+		return
+	}
+	for _, c := range g.Connections(l.id) {
+		g.Disconnect(c)
+	}
+	for _, a := range append([]*Agent(nil), l.occupants...) {
+		a.MoveTo(nil)
+	}
+	if l.region != nil {
+		l.region.Remove(l)
+	}
+	delete(g.locations, l.id)
+	delete(g.adjacent, l.id)
+	for i, o := range g.order {
+		if o == l {
+			g.order = append(g.order[:i], g.order[i+1:]...)
+			break
+		}
+	}
+	l.removed = true
+	g.index = nil
+	g.changed()
+}
+
+func withoutConnection(conns []*Connection, c *Connection) []*Connection {
+	for i, o := range conns {
+		if o == c {
+			return append(conns[:i:i], conns[i+1:]...)
+		}
+	}
+	return conns
+}
+
+// connections returns every connection of the network ordered by ID.
+func (g *Graph) connections() []*Connection {
+	var conns []*Connection
+	for _, l := range g.order {
+		for _, c := range g.adjacent[l.id] {
+			if c.from == l {
+				conns = append(conns, c)
+			}
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+// Location returns the location with the given ID, or nil if there is none.
+func (g *Graph) Location(id int64) *Location {
+	return g.locations[id]
+}
+
+// Locations returns the locations in the order they were added.
+func (g *Graph) Locations() []*Location {
+	return append([]*Location(nil), g.order...)
+}
+
+// Connection returns the connections between the locations with IDs a and
+// b, in the order they were made.
+func (g *Graph) Connection(a, b int64) []*Connection {
+	var conns []*Connection
+	for _, c := range g.adjacent[a] {
+		if (c.from.id == a && c.to.id == b) || (c.from.id == b && c.to.id == a) {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// Connections returns the connections ending at the location with the given
+// ID, in the order they were made.
+func (g *Graph) Connections(id int64) []*Connection {
+	return append([]*Connection(nil), g.adjacent[id]...)
+}
+
+// Neighbours returns the locations joined to the location with the given ID
+// by at least one connection, ordered by ID.
+func (g *Graph) Neighbours(id int64) []*Location {
+	l := g.locations[id]
+	seen := map[*Location]bool{}
+	var ns []*Location
+	for _, c := range g.adjacent[id] {
+		if o := c.Other(l); !seen[o] {
+			seen[o] = true
+			ns = append(ns, o)
+		}
+	}
+	sort.Slice(ns, func(i, j int) bool { return ns[i].id < ns[j].id })
+	return ns
+}
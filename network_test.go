@@ -0,0 +1,83 @@
+package rula
+
+import "testing"
+
+func TestNetwork(t *testing.T) {
+	n := NewNetwork()
+	york := n.AddLocation(Position{East: 0, North: 0})
+	leeds := n.AddLocation(Position{East: -30 * Kilometre, North: -5 * Kilometre})
+	hull := n.AddLocation(Position{East: 50 * Kilometre, North: -20 * Kilometre})
+
+	road := n.Connect(york, leeds, 40*Kilometre)
+	n.Connect(york, hull, 60*Kilometre)
+	river := n.Connect(leeds, york, 45*Kilometre)
+
+	if got := n.Location(leeds.ID()); got != leeds {
+		t.Errorf("Location(%d) = %v, wanted leeds", leeds.ID(), got)
+	}
+	if got := n.Location(99); got != nil {
+		t.Errorf("Location(99) = %v, wanted nil", got)
+	}
+	if got := n.Locations(); len(got) != 3 || got[0] != york || got[2] != hull {
+		t.Errorf("Locations() = %v, wanted york, leeds and hull", got)
+	}
+
+	conns := n.Connection(york.ID(), leeds.ID())
+	if len(conns) != 2 || conns[0] != road || conns[1] != river {
+		t.Fatalf("Connection() = %v, wanted road and river", conns)
+	}
+	if got := n.Connection(leeds.ID(), hull.ID()); len(got) != 0 {
+		t.Errorf("got %d connections between leeds and hull, wanted none", len(got))
+	}
+	if road.Other(leeds) != york || road.Distance() != 40*Kilometre {
+		t.Errorf("road does not lead from leeds to york in 40km")
+	}
+
+	if got := n.Neighbours(york.ID()); len(got) != 2 || got[0] != leeds || got[1] != hull {
+		t.Errorf("Neighbours() = %v, wanted leeds and hull", got)
+	}
+	if got := len(n.Connections(york.ID())); got != 3 {
+		t.Errorf("got %d connections at york, wanted 3", got)
+	}
+}
+
+func TestAgentLocation(t *testing.T) {
+	n := NewNetwork()
+	farm := n.AddLocation(Position{})
+	town := n.AddLocation(Position{})
+	farm.Pools().AddPool(ironOre, 100, 50)
+
+	dig := &Rule{
+		Name:    "dig",
+		Period:  1,
+		Inputs:  []ResourceSpecifier{{Relation: RelationLocation, Resource: ironOre, Quantity: 5}},
+		Outputs: []ResourceSpecifier{{Relation: RelationSelf, Resource: ironOre, Quantity: 5}},
+	}
+	miner := NewAgent("miner")
+	miner.Pools.AddPool(ironOre, 100, 0)
+	miner.AppendRules([]*Rule{dig})
+	miner.MoveTo(farm)
+
+	runner := NewRunner()
+	if _, err := runner.Run(miner.Rules, 1, miner.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := farm.Pools().Quantity(ironOre); got != 45 {
+		t.Errorf("got %d ore at farm, wanted 45", got)
+	}
+
+	miner.MoveTo(town)
+	if got := farm.Occupants(); len(got) != 0 {
+		t.Errorf("got farm occupants %v, wanted none", got)
+	}
+	if got := town.Occupants(); len(got) != 1 || got[0] != miner {
+		t.Errorf("got town occupants %v, wanted the miner", got)
+	}
+	rr, err := runner.Run(miner.Rules, 2, miner.RuleContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rr.Results[0].Fired() {
+		t.Errorf("rule fired in town without ore")
+	}
+}
@@ -0,0 +1,247 @@
+package rula
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iand/loon"
+)
+
+/*
+
+Network files use loon syntax (see github.com/iand/loon) and declare the
+locations of a map and the connections between them.
+
+  location <id>
+  	declares a new location
+
+  end
+  	ends a declaration
+
+Directives for location declarations:
+
+  position <east> <north>
+  	the position of the location as distances from the centre of the map,
+  	with an optional unit suffix of mm, cm, m, km, yd or mi
+
+  region <id>
+  	adds the location to the named region, which is created the first
+  	time it is named. see Region
+
+  connect <location> <distance> (kind <kind>)? (difficulty <n>)? oneway?
+  	connects the location to another, which may be declared later in the
+  	file, by a connection of the given distance. kind names the kind of
+  	connection, such as road or river, and difficulty sets how hard it is
+  	to travel. oneway allows the connection to be travelled only from
+  	this location. see Graph
+
+*/
+
+// A NetworkMap is a network read from a network file with the names of its
+// locations and its regions.
+type NetworkMap struct {
+	Network   *Graph
+	Locations map[string]*Location
+	Regions   map[string]*Region
+}
+
+type NetworkParser struct{}
+
+func NewNetworkParser() *NetworkParser {
+	return &NetworkParser{}
+}
+
+func (p *NetworkParser) Parse(r io.Reader) (*NetworkMap, error) {
+	pp := loon.NewParser(r)
+	doc, err := pp.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &NetworkMap{
+		Network:   NewNetwork(),
+		Locations: map[string]*Location{},
+		Regions:   map[string]*Region{},
+	}
+
+	// Locations are added before their directives are processed so
+	// connections can refer to locations declared later in the file
+	locs := make([]*Location, len(doc.Objects))
+	for i, obj := range doc.Objects {
+		if obj.Type != "location" {
+			return nil, fmt.Errorf("unexpected token at line %d (expecting a location to be started)", obj.Line)
+		}
+		name := strings.TrimSpace(obj.Name)
+		if _, dup := m.Locations[name]; dup {
+			return nil, fmt.Errorf("duplicate location at line %d: %s", obj.Line, name)
+		}
+		locs[i] = m.Network.AddLocation(Position{})
+		m.Locations[name] = locs[i]
+	}
+
+	for i, obj := range doc.Objects {
+		for _, dir := range obj.Directives {
+			switch dir.Name {
+			case "position":
+				if len(dir.Args) != 2 {
+					return nil, fmt.Errorf("malformed position directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				east, err := ParseLength(dir.Args[0])
+				if err != nil {
+					return nil, fmt.Errorf("invalid position at line %d: %v", dir.Line, err)
+				}
+				north, err := ParseLength(dir.Args[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid position at line %d: %v", dir.Line, err)
+				}
+				locs[i].pos = Position{East: east, North: north}
+			case "region":
+				if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed region directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				r, ok := m.Regions[dir.Args[0]]
+				if !ok {
+					r = NewRegion(dir.Args[0])
+					m.Regions[r.Name] = r
+				}
+				r.Add(locs[i])
+			case "connect":
+				if err := m.connect(locs[i], dir); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// connect adds the connection declared by a connect directive of location l.
+func (m *NetworkMap) connect(l *Location, dir loon.Directive) error {
+	if len(dir.Args) < 2 {
+		return fmt.Errorf("malformed connect directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+	}
+	to, ok := m.Locations[dir.Args[0]]
+	if !ok {
+		names := make([]string, 0, len(m.Locations))
+		for name := range m.Locations {
+			names = append(names, name)
+		}
+		return fmt.Errorf("unknown location at line %d: %q%s", dir.Line, dir.Args[0], didYouMean(dir.Args[0], names))
+	}
+	distance, err := ParseLength(dir.Args[1])
+	if err != nil || distance < 0 {
+		return fmt.Errorf("invalid distance at line %d: %s", dir.Line, dir.Args[1])
+	}
+
+	var kind string
+	var difficulty float64
+	oneWay := false
+	for args := dir.Args[2:]; len(args) > 0; {
+		switch {
+		case args[0] == "kind" && len(args) > 1:
+			kind = args[1]
+			args = args[2:]
+		case args[0] == "difficulty" && len(args) > 1:
+			difficulty, err = strconv.ParseFloat(args[1], 64)
+			if err != nil || difficulty < 0 || math.IsNaN(difficulty) {
+				return fmt.Errorf("invalid difficulty at line %d: %s", dir.Line, args[1])
+			}
+			args = args[2:]
+		case args[0] == "oneway" && len(args) == 1:
+			oneWay = true
+			args = nil
+		default:
+			return fmt.Errorf("malformed connect directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+		}
+	}
+
+	var c *Connection
+	if oneWay {
+		c = m.Network.ConnectOneWay(l, to, distance)
+	} else {
+		c = m.Network.Connect(l, to, distance)
+	}
+	c.kind = kind
+	c.difficulty = difficulty
+	return nil
+}
+
+// A NetworkWriter writes a network in the syntax read by NetworkParser.
+type NetworkWriter struct{}
+
+func NewNetworkWriter() *NetworkWriter {
+	return &NetworkWriter{}
+}
+
+// Write writes the locations of m in the order they were added to the
+// network, each with the connections made from it. Locations without a name
+// in m are declared using their ID.
+func (w *NetworkWriter) Write(out io.Writer, m *NetworkMap) error {
+	names := map[*Location]string{}
+	for name, l := range m.Locations {
+		names[l] = name
+	}
+	name := func(l *Location) string {
+		if n, ok := names[l]; ok {
+			return n
+		}
+		return strconv.FormatInt(l.id, 10)
+	}
+
+	g := m.Network
+	doc := &loon.Doc{Version: 1}
+	for _, l := range g.order {
+		obj := loon.Object{Type: "location", Name: name(l)}
+		if l.pos != (Position{}) {
+			obj.Directives = append(obj.Directives, directive("position", formatLength(l.pos.East)+" "+formatLength(l.pos.North)))
+		}
+		if l.region != nil {
+			obj.Directives = append(obj.Directives, directive("region", l.region.Name))
+		}
+
+		var conns []*Connection
+		for _, c := range g.adjacent[l.id] {
+			if c.from == l {
+				conns = append(conns, c)
+			}
+		}
+		sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+		for _, c := range conns {
+			args := name(c.to) + " " + formatLength(c.distance)
+			if c.kind != "" {
+				args += " kind " + c.kind
+			}
+			if c.difficulty != 0 {
+				args += " difficulty " + strconv.FormatFloat(c.difficulty, 'g', -1, 64)
+			}
+			if c.oneWay {
+				args += " oneway"
+			}
+			obj.Directives = append(obj.Directives, directive("connect", args))
+		}
+		doc.Objects = append(doc.Objects, obj)
+	}
+	_, err := out.Write(loon.Print(doc))
+	return err
+}
+
+// formatLength formats l in the largest metric unit that holds it exactly,
+// as read by ParseLength.
+func formatLength(l Length) string {
+	for _, u := range []struct {
+		suffix string
+		unit   Length
+	}{{"km", Kilometre}, {"m", Metre}, {"cm", Centimetre}} {
+		if l != 0 && l%u.unit == 0 {
+			return strconv.FormatInt(int64(l/u.unit), 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(l), 10) + "mm"
+}
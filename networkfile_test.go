@@ -0,0 +1,75 @@
+package rula
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNetworkFileRoundtrip(t *testing.T) {
+	spec := `location harbour
+	position 2km -500m
+	connect town 12km kind road
+	connect town 10km kind river difficulty 0.5 oneway
+end
+
+location town
+	region valley
+	connect mill 750m
+end
+
+location mill
+	region valley
+end
+`
+
+	m, err := NewNetworkParser().Parse(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	harbour, town := m.Locations["harbour"], m.Locations["town"]
+	if r := m.Regions["valley"]; r == nil || town.Region() != r || len(r.Locations()) != 2 || harbour.Region() != nil {
+		t.Errorf("valley region not parsed")
+	}
+	if harbour.Position() != (Position{East: 2 * Kilometre, North: -500 * Metre}) {
+		t.Errorf("got harbour position %v", harbour.Position())
+	}
+	conns := m.Network.Connection(harbour.ID(), town.ID())
+	if len(conns) != 2 || conns[1].Kind() != "river" || !conns[1].OneWay() || conns[1].Difficulty() != 0.5 {
+		t.Fatalf("got connections %v between harbour and town", conns)
+	}
+	if _, cost, _ := m.Network.Route(town.ID(), harbour.ID()); cost != 12*Kilometre {
+		t.Errorf("got cost %d from town to harbour, wanted the road of 12km", cost)
+	}
+
+	var buf bytes.Buffer
+	if err := NewNetworkWriter().Write(&buf, m); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	if diff := cmp.Diff(strings.TrimSpace(spec), strings.TrimSpace(buf.String())); diff != "" {
+		t.Errorf("Write() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNetworkParserErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		spec string
+	}{
+		{"unknown location", "location a\n\tconnect b 1km\nend\n"},
+		{"bad distance", "location a\n\tconnect a far\nend\n"},
+		{"bad option", "location a\n\tconnect a 1km fast\nend\n"},
+		{"negative difficulty", "location a\n\tconnect a 1km difficulty -1\nend\n"},
+		{"duplicate", "location a\nend\nlocation a\nend\n"},
+		{"not a location", "agent a\nend\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewNetworkParser().Parse(strings.NewReader(tc.spec)); err == nil {
+				t.Errorf("got no error, wanted one")
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package rula
+
+// SetCapacity limits the total size of the agents at the location, counting
+// those on their way to it, to n. A location with a capacity of 0 has no
+// limit. Rules of a World that would move agents to a location without space
+// for them are blocked.
+func (l *Location) SetCapacity(n int) {
+	l.capacity = n
+}
+
+// Capacity returns the capacity set with SetCapacity.
+func (l *Location) Capacity() int {
+	return l.capacity
+}
+
+// Occupancy returns the total size of the agents at the location and on
+// journeys to it. See Agent.Size.
+func (l *Location) Occupancy() int {
+	n := l.incoming
+	for _, a := range l.occupants {
+		n += a.size()
+	}
+	return n
+}
+
+// Space returns the size of the agents the location has room for, which is
+// CapacityUnlimited if it has no capacity.
+func (l *Location) Space() int {
+	if l.capacity == 0 {
+		return CapacityUnlimited
+	}
+	if n := l.capacity - l.Occupancy(); n > 0 {
+		return n
+	}
+	return 0
+}
+
+// fits reports whether agent a can move to l.
+func (l *Location) fits(a *Agent) bool {
+	return a.Location == l || l.Space() >= a.size()
+}
+
+// size returns the space the agent takes up at a location, which is 1 for
+// an agent without a size.
+func (a *Agent) size() int {
+	if a.Size > 0 {
+		return a.Size
+	}
+	return 1
+}
+
+// moveBlock returns a Block if rule moves the context's agent to a location
+// without space for it. Moves are only checked for a runner used by a World.
+// Other problems with the destination are reported when the World moves the
+// agent.
+func (ru *Runner) moveBlock(rule *Rule, ctx RuleContext) *Block {
+	a := ctx.Agent
+	if rule.Move == "" || ru.destination == nil || a == nil || a.Journey != nil {
+		return nil
+	}
+	l, err := ru.destination(a, rule.Move)
+	if err != nil || l.fits(a) {
+		return nil
+	}
+	return &Block{Full: l, Quantity: l.Space()}
+}
@@ -0,0 +1,65 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocationCapacity(t *testing.T) {
+	rules, err := NewRuleParser(nil).Parse(strings.NewReader(`
+rule lodge
+	move inn
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNetwork()
+	road := n.AddLocation(Position{})
+	inn := n.AddLocation(Position{})
+	inn.SetCapacity(3)
+
+	w := NewWorld(nil)
+	w.Network = n
+	w.AddLocation("inn", inn)
+
+	family := NewAgent("family")
+	family.Size = 2
+	pilgrim := NewAgent("pilgrim")
+	pilgrim.Size = 2
+	for _, a := range []*Agent{family, pilgrim} {
+		a.AppendRules(rules)
+		a.MoveTo(road)
+		w.AddAgent(a)
+	}
+
+	// Both rules run before either agent moves, so the pilgrim finds the
+	// inn full when it comes to move and stays on the road
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if family.Location != inn || pilgrim.Location != road {
+		t.Fatalf("got family at %v and pilgrim at %v", family.Location, pilgrim.Location)
+	}
+	if inn.Occupancy() != 2 || inn.Space() != 1 || road.Space() != CapacityUnlimited {
+		t.Errorf("got occupancy %d and space %d", inn.Occupancy(), inn.Space())
+	}
+
+	// On the next tick the pilgrim's rule is blocked
+	report, err := w.Tick()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var blocked *Block
+	for _, rr := range report.Agents {
+		for _, res := range rr.Results {
+			if res.Blocked != nil {
+				blocked = res.Blocked
+			}
+		}
+	}
+	if blocked == nil || blocked.Full != inn || blocked.Quantity != 1 {
+		t.Errorf("got block %v, wanted the inn to be full", blocked)
+	}
+}
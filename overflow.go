@@ -0,0 +1,130 @@
+package rula
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An OverflowPolicy decides what happens to the part of a rule's output that
+// does not fit in a pool.
+type OverflowPolicy int
+
+const (
+	OverflowDiscard  OverflowPolicy = 0 // the excess is lost
+	OverflowFail     OverflowPolicy = 1 // the round fails and none of its changes are made
+	OverflowSpill    OverflowPolicy = 2 // the excess is added to the same resource in another relation's pool
+	OverflowCallback OverflowPolicy = 3 // the excess is lost and reported to the runner's overflow function
+)
+
+var overflowNames = map[OverflowPolicy]string{
+	OverflowDiscard:  "discard",
+	OverflowFail:     "fail",
+	OverflowSpill:    "spill",
+	OverflowCallback: "callback",
+}
+
+// String returns the name used for the policy in rule files.
+func (p OverflowPolicy) String() string {
+	if s, ok := overflowNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// parseOverflowPolicy returns the policy for a rule file name.
+func parseOverflowPolicy(s string) (OverflowPolicy, bool) {
+	for p, name := range overflowNames {
+		if name == strings.ToLower(s) {
+			return p, true
+		}
+	}
+	return 0, false
+}
+
+// An Overflow selects the overflow policy for a rule's outputs.
+type Overflow struct {
+	Policy OverflowPolicy `json:"policy"`
+	Spill  Relation       `json:"spill,omitempty"` // relation that receives the excess under OverflowSpill
+}
+
+// An OverflowEvent reports output lost to a pool's capacity under the
+// OverflowCallback policy.
+type OverflowEvent struct {
+	Rule     *Rule
+	Agent    *Agent // the agent the rule was run for, nil for global rules
+	Relation Relation
+	Resource *Resource
+	Excess   int
+}
+
+// WithOverflow sets the overflow policy for rules that do not choose their
+// own. The default is OverflowDiscard.
+func WithOverflow(o Overflow) RunnerOption {
+	return func(ru *Runner) {
+		ru.overflow = o
+	}
+}
+
+// WithOverflowFunc sets the function called with the excess output of rules
+// whose overflow policy is OverflowCallback. It is called once the rule's
+// changes have been applied, and not at all by Preview.
+func WithOverflowFunc(fn func(OverflowEvent)) RunnerOption {
+	return func(ru *Runner) {
+		ru.overflowFn = fn
+	}
+}
+
+// overflowFor returns the overflow policy that applies to rule.
+func (ru *Runner) overflowFor(rule *Rule) Overflow {
+	if rule.Overflow != nil {
+		return *rule.Overflow
+	}
+	return ru.overflow
+}
+
+// addOutput stages an output of rule in tx, handling any excess according to
+// the rule's overflow policy. It returns a Block if the round cannot be
+// completed.
+func (ru *Runner) addOutput(tx *txn, rule *Rule, ctx RuleContext, out *ResourceSpecifier, ps *PoolSet) *Block {
+	excess := tx.add(out.Relation, ps, out.Resource, out.Quantity)
+	if excess <= 0 || ps.Pool(out.Resource) == nil {
+		return nil
+	}
+
+	o := ru.overflowFor(rule)
+	switch o.Policy {
+	case OverflowFail:
+		ru.logger.Printf("rule %q failed: %d %s would overflow %s pool", rule.Name, excess, out.Resource, out.Relation)
+		return &Block{Overflow: out, Quantity: tx.quantity(ps, out.Resource)}
+	case OverflowSpill:
+		spill, ok := ctx.Pools[o.Spill]
+		if !ok {
+			ru.logger.Printf("rule %q failed: no spill poolset of type %v", rule.Name, o.Spill)
+			return &Block{Relation: o.Spill}
+		}
+		// Any excess beyond the spill pool's capacity is lost
+		tx.add(o.Spill, spill, out.Resource, excess)
+	case OverflowCallback:
+		tx.overflows = append(tx.overflows, OverflowEvent{
+			Rule:     rule,
+			Agent:    tx.agent,
+			Relation: out.Relation,
+			Resource: out.Resource,
+			Excess:   excess,
+		})
+	}
+	return nil
+}
+
+// reportOverflows passes the overflows recorded by a committed root txn to the
+// runner's overflow function.
+func (ru *Runner) reportOverflows(tx *txn) {
+	if ru.overflowFn == nil || len(tx.overflows) == 0 {
+		return
+	}
+	ru.hooks.mu.Lock()
+	defer ru.hooks.mu.Unlock()
+	for _, ev := range tx.overflows {
+		ru.overflowFn(ev)
+	}
+}
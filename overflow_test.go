@@ -0,0 +1,120 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOverflow(t *testing.T) {
+	produce := func(o *Overflow) *Rule {
+		return &Rule{
+			Name:     "produce",
+			Period:   1,
+			Repeat:   2,
+			Inputs:   []ResourceSpecifier{{Relation: RelationSelf, Resource: ironOre, Quantity: 1}},
+			Outputs:  []ResourceSpecifier{{Relation: RelationSelf, Resource: iron, Quantity: 2}},
+			Overflow: o,
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		rule       *Rule
+		runner     Overflow
+		wantRounds int
+		wantBlock  bool
+		wantOre    int
+		wantIron   int
+		wantSpill  int
+		wantEvents []int
+	}{
+		{
+			name:       "discard",
+			rule:       produce(nil),
+			wantRounds: 3,
+			wantOre:    7,
+			wantIron:   5,
+		},
+		{
+			name:       "fail",
+			rule:       produce(&Overflow{Policy: OverflowFail}),
+			wantRounds: 2,
+			wantBlock:  true,
+			wantOre:    8,
+			wantIron:   4,
+		},
+		{
+			name:       "runner fail",
+			rule:       produce(nil),
+			runner:     Overflow{Policy: OverflowFail},
+			wantRounds: 2,
+			wantBlock:  true,
+			wantOre:    8,
+			wantIron:   4,
+		},
+		{
+			name:       "rule overrides runner",
+			rule:       produce(&Overflow{Policy: OverflowDiscard}),
+			runner:     Overflow{Policy: OverflowFail},
+			wantRounds: 3,
+			wantOre:    7,
+			wantIron:   5,
+		},
+		{
+			name:       "spill",
+			rule:       produce(&Overflow{Policy: OverflowSpill, Spill: RelationGlobal}),
+			wantRounds: 3,
+			wantOre:    7,
+			wantIron:   5,
+			wantSpill:  1,
+		},
+		{
+			name:       "callback",
+			rule:       produce(&Overflow{Policy: OverflowCallback}),
+			wantRounds: 3,
+			wantOre:    7,
+			wantIron:   5,
+			wantEvents: []int{1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			self := NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 10, Quantity: 10},
+				&Pool{Resource: iron, Capacity: 5},
+			)
+			global := NewPoolSet(&Pool{Resource: iron, Capacity: 10})
+			ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self, RelationGlobal: global}}
+
+			var events []int
+			ru := NewRunner(WithOverflow(tc.runner), WithOverflowFunc(func(ev OverflowEvent) {
+				events = append(events, ev.Excess)
+			}))
+			res, err := ru.RunRule(tc.rule, 1, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.Rounds != tc.wantRounds {
+				t.Errorf("got %d rounds, wanted %d", res.Rounds, tc.wantRounds)
+			}
+			if gotBlock := res.Blocked != nil && res.Blocked.Overflow != nil; gotBlock != tc.wantBlock {
+				t.Errorf("got overflow block %v, wanted %v", res.Blocked, tc.wantBlock)
+			}
+			if got := self.Quantity(ironOre); got != tc.wantOre {
+				t.Errorf("got iron_ore %d, wanted %d", got, tc.wantOre)
+			}
+			if got := self.Quantity(iron); got != tc.wantIron {
+				t.Errorf("got iron %d, wanted %d", got, tc.wantIron)
+			}
+			if got := global.Quantity(iron); got != tc.wantSpill {
+				t.Errorf("got global iron %d, wanted %d", got, tc.wantSpill)
+			}
+			if diff := cmp.Diff(tc.wantEvents, events); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
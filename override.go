@@ -0,0 +1,69 @@
+package rula
+
+// A RuleOverride changes how a shared rule runs for a single agent, such as
+// doubling the output of an upgraded smelter, without changing the rule
+// itself. It is applied each time the rule is run for the agent. Zero fields
+// leave the rule unchanged.
+type RuleOverride struct {
+	Period       int // replaces the rule's period, which must not be zero
+	Repeat       int // replaces the rule's repeat count
+	Chance       int // replaces the rule's chance
+	InputFactor  int // multiplies the quantity of each input
+	OutputFactor int // multiplies the quantity of each output
+}
+
+// Override overrides rule when it is run for the agent. A nil override
+// removes any override of the rule.
+func (a *Agent) Override(rule *Rule, o *RuleOverride) {
+	if o == nil {
+		delete(a.Overrides, rule)
+		return
+	}
+	if a.Overrides == nil {
+		a.Overrides = map[*Rule]*RuleOverride{}
+	}
+	a.Overrides[rule] = o
+}
+
+// overridden returns the rule as it runs for the agent, which is rule itself
+// if the agent does not override it.
+func (a *Agent) overridden(rule *Rule) *Rule {
+	if a == nil || len(a.Overrides) == 0 {
+		return rule
+	}
+	o, ok := a.Overrides[rule]
+	if !ok {
+		return rule
+	}
+	return o.apply(rule)
+}
+
+// apply returns a copy of rule with the override applied.
+func (o *RuleOverride) apply(rule *Rule) *Rule {
+	r := *rule
+	if o.Period != 0 && r.Period != 0 {
+		r.Period = o.Period
+	}
+	if o.Repeat != 0 {
+		r.Repeat = o.Repeat
+	}
+	if o.Chance != 0 {
+		r.Chance = o.Chance
+	}
+	if o.InputFactor != 0 {
+		r.Inputs = scaleSpecifiers(r.Inputs, o.InputFactor)
+	}
+	if o.OutputFactor != 0 {
+		r.Outputs = scaleSpecifiers(r.Outputs, o.OutputFactor)
+	}
+	return &r
+}
+
+func scaleSpecifiers(specs []ResourceSpecifier, factor int) []ResourceSpecifier {
+	scaled := make([]ResourceSpecifier, len(specs))
+	for i, s := range specs {
+		s.Quantity *= factor
+		scaled[i] = s
+	}
+	return scaled
+}
@@ -0,0 +1,89 @@
+package rula
+
+import "testing"
+
+func TestRuleOverride(t *testing.T) {
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Build()
+
+	newSmelter := func(name string) *Agent {
+		a := NewAgent(name)
+		a.AddPool(ironOre, 100, 20)
+		a.AddPool(iron, 100, 0)
+		a.AppendRules([]*Rule{smelt})
+		return a
+	}
+	plain := newSmelter("plain")
+	upgraded := newSmelter("upgraded")
+	upgraded.Override(smelt, &RuleOverride{OutputFactor: 2, Repeat: 1})
+
+	ru := NewRunner()
+	if _, err := ru.Step(nil, []*Agent{plain, upgraded}, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := plain.Pools.Quantity(iron); got != 1 {
+		t.Errorf("got plain iron %d, wanted 1", got)
+	}
+	if got := upgraded.Pools.Quantity(iron); got != 4 {
+		t.Errorf("got upgraded iron %d, wanted 4", got)
+	}
+	if got := upgraded.Pools.Quantity(ironOre); got != 16 {
+		t.Errorf("got upgraded iron ore %d, wanted 16", got)
+	}
+	if smelt.Outputs[0].Quantity != 1 || smelt.Repeat != 0 {
+		t.Errorf("shared rule was changed")
+	}
+
+	upgraded.Override(smelt, nil)
+	if _, err := ru.Step(nil, []*Agent{upgraded}, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := upgraded.Pools.Quantity(iron); got != 5 {
+		t.Errorf("got iron %d after removing override, wanted 5", got)
+	}
+}
+
+func TestRuleOverrideExplainPreview(t *testing.T) {
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Build()
+	a := NewAgent("smelter")
+	a.AddPool(ironOre, 100, 5)
+	a.AddPool(iron, 100, 0)
+	a.Override(smelt, &RuleOverride{InputFactor: 3})
+
+	ru := NewRunner()
+	if ex := ru.Explain(smelt, a.RuleContext()); ex.CanRun {
+		t.Errorf("got explanation that smelt can run, wanted the overridden input of 6 to block it")
+	}
+	res, deltas, err := ru.Preview(smelt, a.RuleContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Fired() || len(deltas) != 0 {
+		t.Errorf("got preview firing with deltas %v, wanted the overridden input to block it", deltas)
+	}
+}
+
+func TestRuleOverrideSkipUnchanged(t *testing.T) {
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Build()
+	a := NewAgent("smelter")
+	a.AddPool(ironOre, 100, 3)
+	a.AddPool(iron, 100, 0)
+	a.Override(smelt, &RuleOverride{InputFactor: 2})
+
+	ru := NewRunner(WithSkipUnchanged())
+	res, err := ru.RunRule(smelt, 1, a.RuleContext())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Fired() {
+		t.Fatalf("got smelt fired, wanted the overridden input of 4 to block it")
+	}
+
+	a.Override(smelt, nil)
+	if res, err = ru.RunRule(smelt, 2, a.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Rounds != 1 {
+		t.Errorf("got %d rounds after removing the override, wanted 1", res.Rounds)
+	}
+}
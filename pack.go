@@ -0,0 +1,426 @@
+package rula
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iand/loon"
+)
+
+/*
+
+Content pack manifests use loon syntax (see github.com/iand/loon)
+
+  pack <id>
+  	declares a new content pack
+
+  end
+  	ends a pack declaration
+
+Directives:
+
+  version <major>.<minor>.<patch>
+  	version of the pack, defaults to 0.0.0
+
+  resources <file>
+  	a resource file belonging to the pack, relative to the manifest
+
+  rules <file>
+  	a rule file belonging to the pack, relative to the manifest
+
+  agents <file>
+  	an agent file belonging to the pack, relative to the manifest. the
+  	rules directive of each agent may name any rule of the packs or the
+  	ID of a pack to use all of its rules
+
+  requires <id> (<op> <version>)?
+  	declares a dependency on another pack, optionally constrained to a range of
+  	versions. op is one of =, >, <, >=, <=. Repeat the directive to constrain
+  	both ends of a range.
+
+*/
+
+// A Version is a pack version made of major, minor and patch numbers.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses a version of the form major.minor.patch. Minor and patch
+// numbers may be omitted and default to zero.
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("malformed version: %q", s)
+	}
+
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("malformed version: %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 depending on whether v is less than, equal to or
+// greater than w.
+func (v Version) Compare(w Version) int {
+	for _, d := range [3]int{v.Major - w.Major, v.Minor - w.Minor, v.Patch - w.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// A VersionConstraint restricts the versions of a pack that satisfy a dependency.
+type VersionConstraint struct {
+	Op      Op
+	Version Version
+}
+
+// Allows reports whether version v satisfies the constraint.
+func (c VersionConstraint) Allows(v Version) bool {
+	return c.Op.holds(v.Compare(c.Version), 0)
+}
+
+func (c VersionConstraint) String() string {
+	return c.Op.String() + " " + c.Version.String()
+}
+
+// A PackDependency declares that a pack requires another pack.
+type PackDependency struct {
+	ID          string
+	Constraints []VersionConstraint // conjunctive, all must apply
+}
+
+// Allows reports whether version v satisfies all the dependency's constraints.
+func (d PackDependency) Allows(v Version) bool {
+	for _, c := range d.Constraints {
+		if !c.Allows(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d PackDependency) String() string {
+	s := d.ID
+	for _, c := range d.Constraints {
+		s += " " + c.String()
+	}
+	return s
+}
+
+// A Pack is a versioned bundle of content files.
+type Pack struct {
+	ID        string
+	Version   Version
+	Dir       string // directory holding the manifest, content files are relative to this
+	Resources []string
+	Rules     []string
+	Agents    []string
+	Requires  []PackDependency
+}
+
+type ManifestParser struct{}
+
+func NewManifestParser() *ManifestParser {
+	return &ManifestParser{}
+}
+
+func (p *ManifestParser) Parse(r io.Reader) ([]*Pack, error) {
+	var packs []*Pack
+
+	pp := loon.NewParser(r)
+	doc, err := pp.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range doc.Objects {
+		if obj.Type != "pack" {
+			return nil, fmt.Errorf("unexpected token at line %d (expecting a pack to be started)", obj.Line)
+		}
+
+		pack := &Pack{
+			ID: strings.TrimSpace(obj.Name),
+		}
+
+		// Dependencies may be split across several requires directives
+		deps := map[string]int{}
+
+		for _, dir := range obj.Directives {
+			switch dir.Name {
+			case "version":
+				if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed version directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				v, err := ParseVersion(dir.Args[0])
+				if err != nil {
+					return nil, fmt.Errorf("invalid version at line %d: %v", dir.Line, err)
+				}
+				pack.Version = v
+			case "resources", "rules", "agents":
+				if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed %s directive at line %d: %s %s", dir.Name, dir.Line, dir.Name, dir.ArgText)
+				}
+				switch dir.Name {
+				case "resources":
+					pack.Resources = append(pack.Resources, dir.Args[0])
+				case "rules":
+					pack.Rules = append(pack.Rules, dir.Args[0])
+				case "agents":
+					pack.Agents = append(pack.Agents, dir.Args[0])
+				}
+			case "requires":
+				if len(dir.Args) != 1 && len(dir.Args) != 3 {
+					return nil, fmt.Errorf("malformed requires directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+
+				idx, ok := deps[dir.Args[0]]
+				if !ok {
+					idx = len(pack.Requires)
+					deps[dir.Args[0]] = idx
+					pack.Requires = append(pack.Requires, PackDependency{ID: dir.Args[0]})
+				}
+
+				if len(dir.Args) == 3 {
+					op, ok := parseOp(dir.Args[1])
+					if !ok {
+						return nil, fmt.Errorf("unknown operator at line %d: %s", dir.Line, dir.Args[1])
+					}
+					v, err := ParseVersion(dir.Args[2])
+					if err != nil {
+						return nil, fmt.Errorf("invalid version at line %d: %v", dir.Line, err)
+					}
+					pack.Requires[idx].Constraints = append(pack.Requires[idx].Constraints, VersionConstraint{Op: op, Version: v})
+				}
+			default:
+				return nil, fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
+			}
+		}
+
+		packs = append(packs, pack)
+	}
+
+	return packs, nil
+}
+
+// A ResolveError describes every problem found while resolving pack dependencies.
+type ResolveError struct {
+	Missing   []string // dependencies on packs that are not present, as "pack: dependency"
+	Conflicts []string // duplicate packs and dependencies not satisfied by the version present
+	Cycle     []string // IDs of packs that depend on one another
+}
+
+func (e *ResolveError) Error() string {
+	var problems []string
+	for _, m := range e.Missing {
+		problems = append(problems, "missing dependency "+m)
+	}
+	for _, c := range e.Conflicts {
+		problems = append(problems, "version conflict "+c)
+	}
+	if len(e.Cycle) > 0 {
+		problems = append(problems, "dependency cycle between "+strings.Join(e.Cycle, ", "))
+	}
+	return "unable to resolve packs: " + strings.Join(problems, "; ")
+}
+
+// ResolvePacks orders packs so that every pack follows the packs it depends on.
+// Packs with no ordering constraint between them are ordered by ID. A
+// *ResolveError is returned if any dependency is missing, any version
+// constraint is not satisfied or the dependencies form a cycle.
+func ResolvePacks(packs []*Pack) ([]*Pack, error) {
+	rerr := &ResolveError{}
+
+	index := map[string]*Pack{}
+	for _, p := range packs {
+		if dup, exists := index[p.ID]; exists {
+			rerr.Conflicts = append(rerr.Conflicts, fmt.Sprintf("%s: declared twice with versions %s and %s", p.ID, dup.Version, p.Version))
+			continue
+		}
+		index[p.ID] = p
+	}
+
+	ids := make([]string, 0, len(index))
+	for id := range index {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		p := index[id]
+		for _, dep := range p.Requires {
+			target, ok := index[dep.ID]
+			if !ok {
+				rerr.Missing = append(rerr.Missing, fmt.Sprintf("%s: %s", p.ID, dep))
+				continue
+			}
+			if !dep.Allows(target.Version) {
+				rerr.Conflicts = append(rerr.Conflicts, fmt.Sprintf("%s: requires %s but found %s", p.ID, dep, target.Version))
+			}
+		}
+	}
+
+	if len(rerr.Missing) > 0 || len(rerr.Conflicts) > 0 {
+		return nil, rerr
+	}
+
+	// Depth first topological sort, visiting packs in ID order for stability
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	ordered := make([]*Pack, 0, len(index))
+	var stack []string
+
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		switch state[id] {
+		case visited:
+			return true
+		case visiting:
+			for i := range stack {
+				if stack[i] == id {
+					rerr.Cycle = append(rerr.Cycle, stack[i:]...)
+					break
+				}
+			}
+			return false
+		}
+		state[id] = visiting
+		stack = append(stack, id)
+
+		p := index[id]
+		deps := make([]string, 0, len(p.Requires))
+		for _, dep := range p.Requires {
+			deps = append(deps, dep.ID)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if !visit(dep) {
+				return false
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+		ordered = append(ordered, p)
+		return true
+	}
+
+	for _, id := range ids {
+		if !visit(id) {
+			return nil, rerr
+		}
+	}
+
+	return ordered, nil
+}
+
+// PackContent is the combined content of a set of resolved packs.
+type PackContent struct {
+	Packs     []*Pack // in dependency order
+	Resources []*Resource
+	Rules     []*Rule
+	Agents    []*Agent
+}
+
+// LoadPacks resolves the dependencies between packs and parses their content
+// from fsys. Resources of every pack are parsed before any rules, and rules
+// before any agents, so that content may refer to content declared by any
+// other pack.
+func LoadPacks(fsys fs.FS, packs []*Pack) (*PackContent, error) {
+	ordered, err := ResolvePacks(packs)
+	if err != nil {
+		return nil, err
+	}
+
+	content := &PackContent{Packs: ordered}
+	owner := map[string]string{}
+
+	rp := NewResourceParser()
+	for _, p := range ordered {
+		for _, name := range p.Resources {
+			var resources []*Resource
+			err := readPackFile(fsys, p, name, func(r io.Reader) (err error) {
+				resources, err = rp.Parse(r)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range resources {
+				if prev, exists := owner[r.ID]; exists {
+					return nil, fmt.Errorf("pack %s: resource %q already declared by pack %s", p.ID, r.ID, prev)
+				}
+				owner[r.ID] = p.ID
+				content.Resources = append(content.Resources, r)
+			}
+		}
+	}
+
+	groups := map[string][]*Rule{}
+	rulep := NewRuleParser(content.Resources)
+	for _, p := range ordered {
+		for _, name := range p.Rules {
+			err := readPackFile(fsys, p, name, func(r io.Reader) error {
+				rules, err := rulep.Parse(r)
+				groups[p.ID] = append(groups[p.ID], rules...)
+				content.Rules = append(content.Rules, rules...)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	agentp := NewAgentParser(content.Resources, groups)
+	for _, p := range ordered {
+		for _, name := range p.Agents {
+			err := readPackFile(fsys, p, name, func(r io.Reader) error {
+				agents, err := agentp.Parse(r)
+				content.Agents = append(content.Agents, agents...)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return content, nil
+}
+
+// readPackFile opens a file belonging to pack p and passes it to read.
+func readPackFile(fsys fs.FS, p *Pack, name string, read func(io.Reader) error) error {
+	f, err := fsys.Open(path.Join(p.Dir, name))
+	if err != nil {
+		return fmt.Errorf("pack %s: %w", p.ID, err)
+	}
+	defer f.Close()
+
+	if err := read(f); err != nil {
+		return fmt.Errorf("pack %s: %s: %w", p.ID, name, err)
+	}
+	return nil
+}
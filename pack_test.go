@@ -0,0 +1,176 @@
+package rula
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestManifestParser(t *testing.T) {
+	packs, err := NewManifestParser().Parse(strings.NewReader(`
+pack industry
+	version 1.2
+	resources resources.rula
+	rules rules.rula
+	requires core >= 1.0
+	requires core < 2.0
+	requires extras
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []*Pack{
+		{
+			ID:        "industry",
+			Version:   Version{Major: 1, Minor: 2},
+			Resources: []string{"resources.rula"},
+			Rules:     []string{"rules.rula"},
+			Requires: []PackDependency{
+				{
+					ID: "core",
+					Constraints: []VersionConstraint{
+						{Op: OpGreaterThanOrEqual, Version: Version{Major: 1}},
+						{Op: OpLessThan, Version: Version{Major: 2}},
+					},
+				},
+				{ID: "extras"},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, packs); diff != "" {
+		t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolvePacks(t *testing.T) {
+	v := func(s string) Version {
+		ver, err := ParseVersion(s)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return ver
+	}
+	dep := func(id string, op Op, ver string) PackDependency {
+		return PackDependency{ID: id, Constraints: []VersionConstraint{{Op: op, Version: v(ver)}}}
+	}
+
+	testCases := []struct {
+		name      string
+		packs     []*Pack
+		order     []string
+		missing   int
+		conflicts int
+		cycle     []string
+	}{
+		{
+			name: "ordered",
+			packs: []*Pack{
+				{ID: "c", Requires: []PackDependency{{ID: "b"}}},
+				{ID: "b", Requires: []PackDependency{{ID: "a"}}},
+				{ID: "a"},
+				{ID: "d"},
+			},
+			order: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "missing",
+			packs: []*Pack{
+				{ID: "a", Requires: []PackDependency{{ID: "x"}, {ID: "y"}}},
+			},
+			missing: 2,
+		},
+		{
+			name: "version conflict",
+			packs: []*Pack{
+				{ID: "a", Version: v("1.4.0")},
+				{ID: "b", Requires: []PackDependency{dep("a", OpGreaterThanOrEqual, "2.0")}},
+			},
+			conflicts: 1,
+		},
+		{
+			name: "duplicate",
+			packs: []*Pack{
+				{ID: "a", Version: v("1.0")},
+				{ID: "a", Version: v("1.1")},
+			},
+			conflicts: 1,
+		},
+		{
+			name: "cycle",
+			packs: []*Pack{
+				{ID: "a", Requires: []PackDependency{{ID: "b"}}},
+				{ID: "b", Requires: []PackDependency{{ID: "c"}}},
+				{ID: "c", Requires: []PackDependency{{ID: "b"}}},
+			},
+			cycle: []string{"b", "c"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ordered, err := ResolvePacks(tc.packs)
+			if tc.order != nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				var got []string
+				for _, p := range ordered {
+					got = append(got, p.ID)
+				}
+				if diff := cmp.Diff(tc.order, got); diff != "" {
+					t.Errorf("ResolvePacks() mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+
+			var rerr *ResolveError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("got error %v, wanted *ResolveError", err)
+			}
+			if len(rerr.Missing) != tc.missing {
+				t.Errorf("got %d missing, wanted %d", len(rerr.Missing), tc.missing)
+			}
+			if len(rerr.Conflicts) != tc.conflicts {
+				t.Errorf("got %d conflicts, wanted %d", len(rerr.Conflicts), tc.conflicts)
+			}
+			if diff := cmp.Diff(tc.cycle, rerr.Cycle); diff != "" {
+				t.Errorf("cycle mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadPacks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"core/resources.rula":     {Data: []byte("resource iron_ore\nend\n")},
+		"industry/resources.rula": {Data: []byte("resource iron\nend\n")},
+		"industry/rules.rula":     {Data: []byte("rule smelt\n\tin iron_ore 2\n\tout iron 1\nend\n")},
+		"industry/agents.rula":    {Data: []byte("agent smelter\n\tpool iron 10\n\trules industry\nend\n")},
+	}
+
+	packs := []*Pack{
+		{ID: "industry", Dir: "industry", Resources: []string{"resources.rula"}, Rules: []string{"rules.rula"}, Agents: []string{"agents.rula"}, Requires: []PackDependency{{ID: "core"}}},
+		{ID: "core", Dir: "core", Resources: []string{"resources.rula"}},
+	}
+
+	content, err := LoadPacks(fsys, packs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(content.Resources) != 2 || content.Resources[0].ID != "iron_ore" {
+		t.Errorf("got resources %v, wanted iron_ore followed by iron", content.Resources)
+	}
+	if len(content.Rules) != 1 || content.Rules[0].Inputs[0].Resource != content.Resources[0] {
+		t.Errorf("smelt rule not bound to core resource")
+	}
+	if len(content.Agents) != 1 || len(content.Agents[0].Rules) != 1 || content.Agents[0].Rules[0] != content.Rules[0] {
+		t.Errorf("smelter agent not bound to industry rules")
+	}
+}
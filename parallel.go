@@ -0,0 +1,169 @@
+package rula
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// WithParallel lets Step run the rules of up to workers agents concurrently.
+// Agents whose rule contexts share a pool set, other than the global pools,
+// are always run one after another in the order supplied. Rules that use the
+// global pools are run one at a time. Hooks are never called concurrently but
+// may be called from any goroutine, and the order of random decisions, and so
+// the outcome of rules with a chance, depends on scheduling.
+func WithParallel(workers int) RunnerOption {
+	return func(ru *Runner) {
+		ru.parallel = workers
+	}
+}
+
+// lockShared locks the runner's shared pool mutex if rule uses a shared pool
+// set, including through the rates and pools of its exchanges and the pools
+// its shipments are taken from. It returns the function to unlock it.
+func (ru *Runner) lockShared(rule *Rule, ctx RuleContext) func() {
+	if len(ru.shared) == 0 || !ru.usesShared(rule, ctx) {
+		return func() {}
+	}
+	ru.sharedMu.Lock()
+	return ru.sharedMu.Unlock
+}
+
+func (ru *Runner) usesShared(rule *Rule, ctx RuleContext) bool {
+	uses := func(rel Relation) bool {
+		return ru.shared[ctx.Pools[rel]]
+	}
+	for _, c := range rule.Preconditions {
+		if uses(c.Relation) {
+			return true
+		}
+	}
+	for _, group := range [][]ResourceSpecifier{rule.Inputs, rule.Outputs, rule.Sets} {
+		for _, s := range group {
+			if uses(s.Relation) {
+				return true
+			}
+		}
+	}
+	for _, x := range rule.Exchanges {
+		if uses(x.Rate.Relation) || uses(RelationSelf) {
+			return true
+		}
+	}
+	if len(rule.Ships) > 0 && uses(RelationSelf) {
+		return true
+	}
+	return rule.RepeatFrom != nil && uses(rule.RepeatFrom.Relation)
+}
+
+// agentGroups partitions agents into groups whose rule contexts share no pool
+// sets, ignoring the shared pool sets. Agents keep their relative order within
+// each group.
+func agentGroups(agents []*Agent, contexts []RuleContext, shared map[*PoolSet]bool) [][]int {
+	parent := make([]int, len(agents))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owner := map[*PoolSet]int{}
+	for i, ctx := range contexts {
+		for _, ps := range ctx.Pools {
+			// Pools inherited from parents are shared too
+			for ; ps != nil; ps = ps.parent {
+				if shared[ps] {
+					continue
+				}
+				if j, ok := owner[ps]; ok {
+					parent[find(i)] = find(j)
+					continue
+				}
+				owner[ps] = i
+			}
+		}
+	}
+
+	var groups [][]int
+	index := map[int]int{}
+	for i := range agents {
+		root := find(i)
+		g, ok := index[root]
+		if !ok {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// stepParallel runs the rules of each agent, running independent groups of
+// agents concurrently. On failure the report holds nil for agents that were
+// not run.
+func (ru *Runner) stepParallel(ctx context.Context, global *Global, agents []*Agent, tick int64, report *StepReport) error {
+	contexts := make([]RuleContext, len(agents))
+	for i, a := range agents {
+		contexts[i] = agentContext(a, global)
+	}
+
+	ru.shared = map[*PoolSet]bool{}
+	if global != nil {
+		ru.shared[global.Pools] = true
+	}
+	defer func() { ru.shared = nil }()
+
+	groups := agentGroups(agents, contexts, ru.shared)
+	report.Agents = make([]*RunReport, len(agents))
+
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+		work     = make(chan []int)
+	)
+	workers := ru.parallel
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range work {
+				for _, i := range group {
+					rr, err := ru.RunContext(ctx, agents[i].AllRules(), tick, contexts[i])
+					report.Agents[i] = rr
+					if err != nil {
+						errMu.Lock()
+						if firstErr == nil {
+							firstErr = fmt.Errorf("agent %q: %w", agents[i].Name.Singular, err)
+						}
+						errMu.Unlock()
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- group
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,108 @@
+package rula
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStepParallel(t *testing.T) {
+	mine := NewRule("mine").In(RelationGlobal, ironOre, 1).Out(RelationSelf, ironOre, 1).Build()
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationLocation, iron, 1).Every(2).Build()
+
+	world := func() (*Global, []*Agent) {
+		global := NewGlobal(nil)
+		global.Pools.AddPool(ironOre, 1000, 150)
+
+		var agents []*Agent
+		for i := 0; i < 20; i++ {
+			town := NewAgent(fmt.Sprintf("town%d", i))
+			town.AddPool(iron, 1000, 0)
+			agents = append(agents, town)
+			// Pairs of smelters share a town
+			for j := 0; j < 2; j++ {
+				a := NewAgent(fmt.Sprintf("smelter%d.%d", i, j))
+				a.AddPool(ironOre, 100, 0)
+				a.AddRelation(RelationLocation, town)
+				a.AppendRules([]*Rule{mine, smelt})
+				agents = append(agents, a)
+			}
+		}
+		return global, agents
+	}
+
+	run := func(opts ...RunnerOption) (*Global, []*Agent) {
+		global, agents := world()
+		runner := NewRunner(opts...)
+		for tick := int64(1); tick <= 4; tick++ {
+			report, err := runner.Step(global, agents, tick)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(report.Agents) != len(agents) {
+				t.Fatalf("got %d agent reports, wanted %d", len(report.Agents), len(agents))
+			}
+		}
+		return global, agents
+	}
+
+	global, agents := run(WithParallel(4))
+	if got := global.Pools.Quantity(ironOre); got != 0 {
+		t.Errorf("got global iron_ore %d, wanted 0", got)
+	}
+
+	// Every unit mined from the global pool ends up as ore held by a
+	// smelter or as iron in a town, which takes two ore
+	total := 0
+	for _, a := range agents {
+		total += a.Pools.Quantity(ironOre) + 2*a.Pools.Quantity(iron)
+	}
+	if total != 150 {
+		t.Errorf("got %d iron_ore accounted for, wanted 150", total)
+	}
+}
+
+func TestAgentGroups(t *testing.T) {
+	shared := NewPoolSet()
+	town := NewAgent("town")
+	a, b, c := NewAgent("a"), NewAgent("b"), NewAgent("c")
+	a.AddRelation(RelationLocation, town)
+	c.AddRelation(RelationLocation, town)
+	agents := []*Agent{a, b, c, town}
+
+	var contexts []RuleContext
+	for _, ag := range agents {
+		ctx := ag.RuleContext()
+		ctx.Pools[RelationGlobal] = shared
+		contexts = append(contexts, ctx)
+	}
+
+	got := agentGroups(agents, contexts, map[*PoolSet]bool{shared: true})
+	want := [][]int{{0, 2, 3}, {1}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("groups mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUsesSharedExchangeRate(t *testing.T) {
+	price := &Resource{ID: "grain_price", Name: Name{Singular: "grain_price"}}
+	buy := &Rule{
+		Name:      "buy",
+		Period:    1,
+		Exchanges: []Exchange{{Give: ironOre, Get: iron, Quantity: 1, Rate: ResourceSource{Relation: RelationGlobal, Resource: price}}},
+	}
+	shared := NewPoolSet(&Pool{Resource: price, Capacity: 10, Quantity: 2})
+	ctx := NewAgent("a").RuleContext()
+	ctx.Pools[RelationGlobal] = shared
+
+	ru := NewRunner()
+	ru.shared = map[*PoolSet]bool{shared: true}
+	if !ru.usesShared(buy, ctx) {
+		t.Errorf("got rule reading a shared rate not using shared pools, wanted it to")
+	}
+	ru.shared = map[*PoolSet]bool{NewPoolSet(): true}
+	if ru.usesShared(buy, ctx) {
+		t.Errorf("got rule using shared pools, wanted it not to")
+	}
+}
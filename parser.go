@@ -3,6 +3,7 @@ package rula
 import (
 	"fmt"
 	"io"
+	"io/fs"
 	"strconv"
 	"strings"
 
@@ -37,6 +38,23 @@ Directives:
   	holds before any inputs are consumed.
   	op is one of =, >, <, >=, <=
 
+  if expr <expression>
+  	declares a condition written as an expression over the quantities in
+  	pools and the numeric attributes of agents, such as
+  	if expr population / houses > 4. the rule will only run if the
+  	expression evaluates to a value other than zero. see ParseExpr
+
+  ifattr <relation>? <attr> <op> <value>
+  	declares a condition on an attribute of the related agent. the rule
+  	will only run if the condition holds. op is = or !=. an agent
+  	without the attribute has an empty value
+
+  ifnear <relation> <distance>
+  	declares a condition that the related agent is within distance of the
+  	agent in a straight line, measured between their positions or
+  	locations. distance has an optional unit suffix of mm, cm, m, km, yd
+  	or mi
+
   out <relation>? <resource> <quantity>
   	declares that a resource should be altered by specific quantity (may be negative) upon successful rule evaluation
 
@@ -56,28 +74,174 @@ Directives:
   onfail <id>
   	id of a rule to run if preconditions or inputs fail to be satisfied
 
+  chance <percent>
+  	percentage chance, from 1 to 100, that the rule runs each time it is
+  	due. the percent sign is optional. the onfail rule is not run when the
+  	chance does not come up
+
+  overflow discard|fail|callback
+  overflow spill <relation>
+  	what happens to output that does not fit in its pool: it is discarded,
+  	the round fails, it is reported to the runner's overflow function or it
+  	is added to the same resource in the related pool. defaults to the
+  	runner's policy
+
+  emit <relation>? <event> <count>?
+  	sends count events, defaulting to 1, to the related agent upon
+  	successful rule evaluation. the resource must be declared as an
+  	event. it is an output that creates the event's pool if needed.
+  	rules react to pending events with a condition such as
+  	if <event> > 0 and consume them as an input such as in <event> 1
+
+  spawn <template> <count>?
+  	creates count agents, defaulting to 1, from the named agent template
+  	each round. agents are created by a World at the end of the tick
+
+  destroy self
+  	destroys the agent the rule is run for once the rule fires. agents
+  	are destroyed by a World at the end of the tick
+
+  buy <resource> <quantity> at <price> <currency>
+  sell <resource> <quantity> at <price> <currency>
+  	offers to buy or sell quantity of a resource, for each round, at a
+  	price per unit paid in currency, which must be declared as a
+  	currency. offers are posted to the market of a
+  	World, which clears them at the end of the tick. see Market
+
+  exchange <give> for <quantity> <get> at <relation>? <rate>
+  exchange <quantity> <give> for <get> at <relation>? <rate>
+  	trades one resource for another at a rate held in the related pool
+  	of the rate resource, read each time the rule runs. the first form
+  	receives quantity of get paying rate units of give for each. the
+  	second sells quantity of give receiving rate units of get for each.
+  	the rule does not run while the rate is not positive. see Exchange
+
+  move <location|relation>
+  	moves the agent the rule is run for once the rule fires, to the
+  	location of the related agent or to a location named in the World.
+  	agents are moved by a World at the end of the tick
+
+  ship <relation> <resource> <quantity>
+  	sends quantity of a resource from the agent's pool to the related
+  	agent. the resource is consumed like an input and arrives when a
+  	World delivers the shipment, after travelling the route between the
+  	agents' locations at the agent's speed. see Shipment
+
+  other directives may be registered with RuleParser.RegisterDirective to
+  attach custom effects to rules. see Effect
+
+Relations:
+
+  self, global and location are always available. target refers to an agent
+  chosen by the caller each time the rule is run, see RuleContext.WithTarget.
+  in a global rule, each refers to every agent in turn, so that a rule such
+  as in each gold 1 taxes all agents. see RelationEach. region refers to
+  the pools of the region holding the agent's location, see Region. parent
+  refers to the pools of the agent's parent, see Agent.SetParent.
+  Any other relation is looked up in the agent's relations.
+
+Resources:
+
+  a resource may be followed by @ and a pool name, such as iron@warehouse, to
+  refer to a sub-pool of the resource. see Resource.Sub.
 
 
 
 */
 
+// ruleDirectives lists the directives accepted in a rule declaration.
+var ruleDirectives = []string{"in", "out", "set", "if", "ifattr", "ifnear", "every", "repeat", "onfail", "chance", "overflow", "emit", "spawn", "destroy", "buy", "sell", "exchange", "move", "ship"}
+
 type RuleParser struct {
-	rm map[string]*Resource
+	resources *resourceIndex
+	relations map[Relation]bool           // declared relations, nil unless strict
+	handlers  map[string]DirectiveHandler // custom directives, see RegisterDirective
 }
 
 func NewRuleParser(resources []*Resource) *RuleParser {
-	p := &RuleParser{
-		rm: make(map[string]*Resource),
+	return &RuleParser{
+		resources: newResourceIndex(resources),
 	}
+}
 
-	for _, r := range resources {
-		p.rm[strings.ToLower(r.Name.Singular)] = r
+// Strict restricts the relations that rules may use to the self, global,
+// location, parent, target, each and region relations plus the relations
+// supplied. Rules naming any other relation fail to parse.
+func (p *RuleParser) Strict(relations ...Relation) {
+	p.relations = map[Relation]bool{
+		RelationSelf:     true,
+		RelationGlobal:   true,
+		RelationLocation: true,
+		RelationParent:   true,
+		RelationTarget:   true,
+		RelationEach:     true,
+		RelationRegion:   true,
 	}
+	for _, rel := range relations {
+		p.relations[Relation(strings.ToLower(string(rel)))] = true
+	}
+}
 
-	return p
+// resource returns the resource with the given name.
+func (p *RuleParser) resource(name string, line int) (*Resource, error) {
+	res, ok := p.resources.name(name)
+	if !ok {
+		resname := strings.ToLower(name)
+		return nil, fmt.Errorf("unknown resource at line %d: %q%s", line, resname, didYouMean(resname, p.resources.names()))
+	}
+	return res, nil
+}
+
+// expr parses an expression, checking its relations as relation does and
+// binding the resources it names so they need not be looked up each time it
+// is evaluated.
+func (p *RuleParser) expr(src string, line int) (*Expr, error) {
+	e, err := ParseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression at line %d: %v", line, err)
+	}
+	err = e.leaves(func(n exprNode) error {
+		switch n := n.(type) {
+		case *refNode:
+			if _, err := p.relation(string(n.relation), line); err != nil {
+				return err
+			}
+			n.res, err = p.resource(n.name, line)
+			return err
+		case *attrNode:
+			_, err := p.relation(string(n.relation), line)
+			return err
+		}
+		return nil
+	})
+	return e, err
+}
+
+// relation returns the relation with the given name, checking it has been
+// declared if the parser is strict.
+func (p *RuleParser) relation(name string, line int) (Relation, error) {
+	rel := Relation(strings.ToLower(name))
+	if p.relations != nil && !p.relations[rel] {
+		names := make([]string, 0, len(p.relations))
+		for r := range p.relations {
+			names = append(names, string(r))
+		}
+		return "", fmt.Errorf("unknown relation at line %d: %q%s", line, rel, didYouMean(string(rel), names))
+	}
+	return rel, nil
 }
 
 func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
+	pp := loon.NewParser(r)
+	doc, err := pp.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseObjects(doc.Objects)
+}
+
+func (p *RuleParser) parseObjects(objs []loon.Object) ([]*Rule, error) {
 	type rulespec struct {
 		Rule
 		onFailRuleName string
@@ -87,13 +251,7 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 
 	var rule *rulespec
 
-	pp := loon.NewParser(r)
-	doc, err := pp.Parse()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, obj := range doc.Objects {
+	for _, obj := range objs {
 		if obj.Type != "rule" {
 			return nil, fmt.Errorf("unexpected token at line %d (expecting a rule to be started)", obj.Line)
 		}
@@ -106,6 +264,7 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 		}
 
 		for _, dir := range obj.Directives {
+			var err error
 			switch dir.Name {
 			case "in", "out", "set":
 				if len(dir.Args) != 2 && len(dir.Args) != 3 {
@@ -114,15 +273,16 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 
 				relation := RelationSelf
 				if len(dir.Args) == 3 {
-					relation = Relation(strings.ToLower(dir.Args[0]))
+					relation, err = p.relation(dir.Args[0], dir.Line)
+					if err != nil {
+						return nil, err
+					}
 					dir.Args = dir.Args[1:]
 				}
 
-				resname := strings.ToLower(dir.Args[0])
-
-				res, ok := p.rm[resname]
-				if !ok {
-					return nil, fmt.Errorf("unknown resource at line %d: %q", dir.Line, resname)
+				res, err := p.resource(dir.Args[0], dir.Line)
+				if err != nil {
+					return nil, err
 				}
 
 				quantity, err := strconv.Atoi(dir.Args[1])
@@ -145,21 +305,30 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 				}
 
 			case "if":
+				if len(dir.Args) > 1 && strings.ToLower(dir.Args[0]) == "expr" {
+					e, err := p.expr(strings.TrimSpace(dir.ArgText[len(dir.Args[0]):]), dir.Line)
+					if err != nil {
+						return nil, err
+					}
+					rule.CustomConditions = append(rule.CustomConditions, ExprCondition{Expr: e})
+					continue
+				}
 				if len(dir.Args) != 3 && len(dir.Args) != 4 {
 					return nil, fmt.Errorf("malformed resource condition at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
 				}
 
 				relation := RelationSelf
 				if len(dir.Args) == 4 {
-					relation = Relation(strings.ToLower(dir.Args[0]))
+					relation, err = p.relation(dir.Args[0], dir.Line)
+					if err != nil {
+						return nil, err
+					}
 					dir.Args = dir.Args[1:]
 				}
 
-				resname := strings.ToLower(dir.Args[0])
-
-				res, ok := p.rm[resname]
-				if !ok {
-					return nil, fmt.Errorf("unknown resource at line %d: %q", dir.Line, resname)
+				res, err := p.resource(dir.Args[0], dir.Line)
+				if err != nil {
+					return nil, err
 				}
 
 				var op Op
@@ -175,7 +344,7 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 				case "<=":
 					op = OpLessThanOrEqual
 				default:
-					return nil, fmt.Errorf("unknown operator at line %d: %s", dir.Line, dir.Args[2])
+					return nil, fmt.Errorf("unknown operator at line %d: %s", dir.Line, dir.Args[1])
 				}
 
 				quantity, err := strconv.Atoi(dir.Args[2])
@@ -193,6 +362,42 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 				}
 
 				rule.Preconditions = append(rule.Preconditions, cond)
+			case "ifattr":
+				if len(dir.Args) != 3 && len(dir.Args) != 4 {
+					return nil, fmt.Errorf("malformed attribute condition at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+
+				relation := RelationSelf
+				if len(dir.Args) == 4 {
+					relation, err = p.relation(dir.Args[0], dir.Line)
+					if err != nil {
+						return nil, err
+					}
+					dir.Args = dir.Args[1:]
+				}
+
+				cond := AttrCondition{Relation: relation, Attr: dir.Args[0], Value: dir.Args[2]}
+				switch dir.Args[1] {
+				case "=":
+				case "!=":
+					cond.Negate = true
+				default:
+					return nil, fmt.Errorf("unknown operator at line %d: %s", dir.Line, dir.Args[1])
+				}
+				rule.AttrConditions = append(rule.AttrConditions, cond)
+			case "ifnear":
+				if len(dir.Args) != 2 {
+					return nil, fmt.Errorf("malformed near condition at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				relation, err := p.relation(dir.Args[0], dir.Line)
+				if err != nil {
+					return nil, err
+				}
+				within, err := ParseLength(dir.Args[1])
+				if err != nil || within < 0 {
+					return nil, fmt.Errorf("invalid distance at line %d: %s", dir.Line, dir.Args[1])
+				}
+				rule.NearConditions = append(rule.NearConditions, NearCondition{Relation: relation, Within: within})
 			case "every":
 				if len(dir.Args) != 1 {
 					return nil, fmt.Errorf("malformed every directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
@@ -220,14 +425,16 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 					// must be repeat using <relation>? <resource>
 					relation := RelationSelf
 					if len(dir.Args) == 2 {
-						relation = Relation(strings.ToLower(dir.Args[0]))
+						relation, err = p.relation(dir.Args[0], dir.Line)
+						if err != nil {
+							return nil, err
+						}
 						dir.Args = dir.Args[1:]
 					}
 
-					resname := strings.ToLower(dir.Args[0])
-					res, ok := p.rm[resname]
-					if !ok {
-						return nil, fmt.Errorf("unknown resource at line %d: %q", obj.Line, resname)
+					res, err := p.resource(dir.Args[0], dir.Line)
+					if err != nil {
+						return nil, err
 					}
 
 					rule.RepeatFrom = &ResourceSource{
@@ -244,8 +451,181 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 					return nil, fmt.Errorf("malformed onfail directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
 				}
 				rule.onFailRuleName = dir.Args[0]
+			case "chance":
+				if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed chance directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				percent, err := strconv.Atoi(strings.TrimSuffix(dir.Args[0], "%"))
+				if err != nil {
+					return nil, fmt.Errorf("invalid chance at line %d: %v", dir.Line, err)
+				}
+				if percent < 1 || percent > 100 {
+					return nil, fmt.Errorf("chance out of range at line %d: %d", dir.Line, percent)
+				}
+				rule.Chance = percent
+			case "overflow":
+				if len(dir.Args) == 0 {
+					return nil, fmt.Errorf("malformed overflow directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				policy, ok := parseOverflowPolicy(dir.Args[0])
+				if !ok {
+					return nil, fmt.Errorf("unknown overflow policy at line %d: %s", dir.Line, dir.Args[0])
+				}
+				o := &Overflow{Policy: policy}
+				if policy == OverflowSpill {
+					if len(dir.Args) != 2 {
+						return nil, fmt.Errorf("malformed overflow directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+					}
+					o.Spill, err = p.relation(dir.Args[1], dir.Line)
+					if err != nil {
+						return nil, err
+					}
+				} else if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed overflow directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				rule.Overflow = o
+			case "emit":
+				if len(dir.Args) < 1 || len(dir.Args) > 3 {
+					return nil, fmt.Errorf("malformed emit directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				count := 1
+				if n := len(dir.Args); n > 1 {
+					if c, err := strconv.Atoi(dir.Args[n-1]); err == nil {
+						if c < 1 {
+							return nil, fmt.Errorf("invalid emit count at line %d: %d", dir.Line, c)
+						}
+						count = c
+						dir.Args = dir.Args[:n-1]
+					}
+				}
+				if len(dir.Args) > 2 {
+					return nil, fmt.Errorf("malformed emit directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+
+				relation := RelationSelf
+				if len(dir.Args) == 2 {
+					relation, err = p.relation(dir.Args[0], dir.Line)
+					if err != nil {
+						return nil, err
+					}
+					dir.Args = dir.Args[1:]
+				}
+				res, err := p.resource(dir.Args[0], dir.Line)
+				if err != nil {
+					return nil, err
+				}
+				if !res.Event {
+					return nil, fmt.Errorf("emit of resource that is not an event at line %d: %s", dir.Line, dir.Args[0])
+				}
+				rule.Outputs = append(rule.Outputs, ResourceSpecifier{Relation: relation, Resource: res, Quantity: count})
+			case "spawn":
+				if len(dir.Args) != 1 && len(dir.Args) != 2 {
+					return nil, fmt.Errorf("malformed spawn directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				spawn := SpawnSpecifier{Template: dir.Args[0], Count: 1}
+				if len(dir.Args) == 2 {
+					spawn.Count, err = strconv.Atoi(dir.Args[1])
+					if err != nil || spawn.Count < 1 {
+						return nil, fmt.Errorf("invalid spawn count at line %d: %s", dir.Line, dir.Args[1])
+					}
+				}
+				rule.Spawns = append(rule.Spawns, spawn)
+			case "destroy":
+				if len(dir.Args) != 1 || Relation(strings.ToLower(dir.Args[0])) != RelationSelf {
+					return nil, fmt.Errorf("malformed destroy directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				rule.Destroy = true
+			case "buy", "sell":
+				if len(dir.Args) != 5 || strings.ToLower(dir.Args[2]) != "at" {
+					return nil, fmt.Errorf("malformed %s directive at line %d: %s %s", dir.Name, dir.Line, dir.Name, dir.ArgText)
+				}
+				offer := OfferSpecifier{Sell: dir.Name == "sell"}
+				if offer.Resource, err = p.resource(dir.Args[0], dir.Line); err != nil {
+					return nil, err
+				}
+				offer.Quantity, err = strconv.Atoi(dir.Args[1])
+				if err != nil || offer.Quantity < 1 {
+					return nil, fmt.Errorf("invalid quantity at line %d: %s", dir.Line, dir.Args[1])
+				}
+				offer.Price, err = strconv.Atoi(dir.Args[3])
+				if err != nil || offer.Price < 0 {
+					return nil, fmt.Errorf("invalid price at line %d: %s", dir.Line, dir.Args[3])
+				}
+				if offer.Currency, err = p.resource(dir.Args[4], dir.Line); err != nil {
+					return nil, err
+				}
+				if !offer.Currency.Currency {
+					return nil, fmt.Errorf("price in resource that is not a currency at line %d: %s", dir.Line, dir.Args[4])
+				}
+				rule.Offers = append(rule.Offers, offer)
+			case "exchange":
+				if (len(dir.Args) != 6 && len(dir.Args) != 7) || strings.ToLower(dir.Args[4]) != "at" {
+					return nil, fmt.Errorf("malformed exchange directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				// The quantity is given on the side that is fixed
+				var x Exchange
+				var give, get, quantity string
+				switch {
+				case strings.ToLower(dir.Args[1]) == "for":
+					give, quantity, get = dir.Args[0], dir.Args[2], dir.Args[3]
+				case strings.ToLower(dir.Args[2]) == "for":
+					quantity, give, get = dir.Args[0], dir.Args[1], dir.Args[3]
+					x.Sell = true
+				default:
+					return nil, fmt.Errorf("malformed exchange directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				x.Quantity, err = strconv.Atoi(quantity)
+				if err != nil || x.Quantity < 1 {
+					return nil, fmt.Errorf("invalid quantity at line %d: %s", dir.Line, quantity)
+				}
+				if x.Give, err = p.resource(give, dir.Line); err != nil {
+					return nil, err
+				}
+				if x.Get, err = p.resource(get, dir.Line); err != nil {
+					return nil, err
+				}
+				x.Rate.Relation = RelationSelf
+				if len(dir.Args) == 7 {
+					if x.Rate.Relation, err = p.relation(dir.Args[5], dir.Line); err != nil {
+						return nil, err
+					}
+				}
+				if x.Rate.Resource, err = p.resource(dir.Args[len(dir.Args)-1], dir.Line); err != nil {
+					return nil, err
+				}
+				rule.Exchanges = append(rule.Exchanges, x)
+			case "move":
+				if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed move directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				rule.Move = dir.Args[0]
+			case "ship":
+				if len(dir.Args) != 3 {
+					return nil, fmt.Errorf("malformed ship directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				relation, err := p.relation(dir.Args[0], dir.Line)
+				if err != nil {
+					return nil, err
+				}
+				res, err := p.resource(dir.Args[1], dir.Line)
+				if err != nil {
+					return nil, err
+				}
+				quantity, err := strconv.Atoi(dir.Args[2])
+				if err != nil || quantity < 1 {
+					return nil, fmt.Errorf("invalid quantity at line %d: %s", dir.Line, dir.Args[2])
+				}
+				rule.Ships = append(rule.Ships, ResourceSpecifier{Relation: relation, Resource: res, Quantity: quantity})
 			default:
-				return nil, fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
+				h, ok := p.handlers[dir.Name]
+				if !ok {
+					return nil, fmt.Errorf("unknown directive at line %d: %s%s", dir.Line, dir.Name, didYouMean(dir.Name, p.directiveNames()))
+				}
+				effect, err := h(dir.Args)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s directive at line %d: %w", dir.Name, dir.Line, err)
+				}
+				rule.CustomEffects = append(rule.CustomEffects, effect)
 			}
 		}
 
@@ -258,17 +638,30 @@ func (p *RuleParser) Parse(r io.Reader) ([]*Rule, error) {
 		if r.onFailRuleName != "" {
 			onFail, exists := ruleIndex[r.onFailRuleName]
 			if !exists {
-				return nil, fmt.Errorf("%s: unknown onfail rule: %q", r.Name, r.onFailRuleName)
+				names := make([]string, 0, len(ruleIndex))
+				for name := range ruleIndex {
+					names = append(names, name)
+				}
+				return nil, fmt.Errorf("%s: unknown onfail rule: %q%s", r.Name, r.onFailRuleName, didYouMean(r.onFailRuleName, names))
 			}
 			r.Rule.OnFail = &onFail.Rule
 		}
 		rules = append(rules, &r.Rule)
 	}
 
+	// Relations were checked as they were parsed, if the parser is strict
+	for _, r := range rules {
+		if err := r.validateChain(nil); err != nil {
+			return nil, err
+		}
+	}
+
 	return rules, nil
 }
 
-type ResourceParser struct{}
+type ResourceParser struct {
+	registry *ResourceRegistry
+}
 
 func NewResourceParser() *ResourceParser {
 	p := &ResourceParser{}
@@ -276,19 +669,30 @@ func NewResourceParser() *ResourceParser {
 	return p
 }
 
-func (p *ResourceParser) Parse(r io.Reader) ([]*Resource, error) {
-	var resources []*Resource
-
-	var res *Resource
+// SetRegistry registers the parsed resources with reg, so that resources
+// whose IDs are already registered fail to parse.
+func (p *ResourceParser) SetRegistry(reg *ResourceRegistry) {
+	p.registry = reg
+}
 
+func (p *ResourceParser) Parse(r io.Reader) ([]*Resource, error) {
 	pp := loon.NewParser(r)
 	doc, err := pp.Parse()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, obj := range doc.Objects {
-		if obj.Type != "resource" {
+	return p.parseObjects(doc.Objects)
+}
+
+func (p *ResourceParser) parseObjects(objs []loon.Object) ([]*Resource, error) {
+	var resources []*Resource
+	seen := map[string]bool{}
+
+	var res *Resource
+
+	for _, obj := range objs {
+		if obj.Type != "resource" && obj.Type != "event" && obj.Type != "currency" {
 			return nil, fmt.Errorf("unexpected token at line %d (expecting a resource to be started)", obj.Line)
 		}
 
@@ -298,6 +702,8 @@ func (p *ResourceParser) Parse(r io.Reader) ([]*Resource, error) {
 				Singular: strings.TrimSpace(obj.Name),
 				Plural:   strings.TrimSpace(obj.Name),
 			},
+			Event:    obj.Type == "event",
+			Currency: obj.Type == "currency",
 		}
 		for _, dir := range obj.Directives {
 			switch dir.Name {
@@ -305,14 +711,427 @@ func (p *ResourceParser) Parse(r io.Reader) ([]*Resource, error) {
 				res.Name.Singular = dir.ArgText
 			case "plural":
 				res.Name.Plural = dir.ArgText
+			case "decay", "regen", "weight", "volume":
+				if len(dir.Args) != 1 {
+					return nil, fmt.Errorf("malformed %s directive at line %d: %s %s", dir.Name, dir.Line, dir.Name, dir.ArgText)
+				}
+				n, err := strconv.Atoi(dir.Args[0])
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid %s at line %d: %s", dir.Name, dir.Line, dir.Args[0])
+				}
+				switch dir.Name {
+				case "decay":
+					res.Decay = n
+				case "regen":
+					res.Regen = n
+				case "weight":
+					res.Weight = n
+				case "volume":
+					res.Volume = n
+				}
 			default:
 				return nil, fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
 			}
 		}
 
+		if _, dup := seen[res.ID]; dup {
+			return nil, fmt.Errorf("duplicate resource at line %d: %q", obj.Line, res.ID)
+		}
+		seen[res.ID] = true
 		resources = append(resources, res)
+	}
 
+	if p.registry != nil {
+		// Check every resource first so none are registered on failure
+		for _, res := range resources {
+			if _, dup := p.registry.ByID(res.ID); dup {
+				return nil, fmt.Errorf("duplicate resource id %q", res.ID)
+			}
+		}
+		for _, res := range resources {
+			if err := p.registry.Register(res); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return resources, nil
 }
+
+/*
+
+Agent files use loon syntax (see github.com/iand/loon)
+
+  agent <id>
+  	declares a new agent
+
+  template <id>
+  	declares a template for agents, which takes the same directives as
+  	an agent. a template may use templates declared before it. see
+  	AgentTemplate
+
+  end
+  	ends an agent or template declaration
+
+Directives:
+
+  pool <resource> <capacity> <quantity>? negative?
+  	declares a pool of resource with the given capacity and initial
+  	quantity. the quantity defaults to 0. negative allows the quantity
+  	to fall below zero. the resource may name a sub-pool, such as
+  	iron@warehouse
+
+  carry <weight> <volume>?
+  	limits the total weight and volume of the agent's resources. a limit
+  	of 0 is no limit
+
+  relation <relation> <id>
+  	relates the agent to another agent declared in the same file
+
+  attr <name> <value>
+  	sets an attribute of the agent, such as its culture or profession,
+  	that rules can test with ifattr
+
+  parent <id> inherit?
+  	places the agent below another agent declared in the same file, whose
+  	pools are available to rules through the parent relation. inherit
+  	makes resources the agent has no pool for fall through to the pools
+  	of its parent and their parents
+
+  rules <group|id|file>+
+  	appends rules to the rules of the agent. each argument names a group
+  	of rules, a single rule or, if the parser was given a file system, a
+  	rule file to parse. rules parsed from a file are shared by every agent
+  	that names the file
+
+  template <id>
+  	adds the pools, rules and relations of a template declared in the
+  	same file or an earlier one parsed by the same parser. pools declared
+  	afterwards replace those of the template
+
+*/
+
+type AgentParser struct {
+	resources []*Resource
+	groups    map[string][]*Rule
+	fsys      fs.FS
+	templates map[string]*AgentTemplate
+}
+
+// NewAgentParser returns a parser for agent files. The rules directive may
+// name any of the groups or any of the rules they contain.
+func NewAgentParser(resources []*Resource, groups map[string][]*Rule) *AgentParser {
+	return &AgentParser{
+		resources: resources,
+		groups:    groups,
+		templates: map[string]*AgentTemplate{},
+	}
+}
+
+// Template returns the named template from the files parsed so far.
+func (p *AgentParser) Template(name string) (*AgentTemplate, bool) {
+	t, ok := p.templates[name]
+	return t, ok
+}
+
+// SetRuleFS sets the file system used to read rule files named by rules
+// directives that do not match a group or rule.
+func (p *AgentParser) SetRuleFS(fsys fs.FS) {
+	p.fsys = fsys
+}
+
+func (p *AgentParser) Parse(r io.Reader) ([]*Agent, error) {
+	pp := loon.NewParser(r)
+	doc, err := pp.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+	for _, g := range p.groups {
+		rules = append(rules, g...)
+	}
+
+	b := newAgentBuilder(p.resources, rules, p.groups)
+	b.fsys = p.fsys
+	for name, t := range p.templates {
+		b.templates[name] = t
+	}
+
+	var agents []*Agent
+	var agentObjs, templateObjs []loon.Object
+	for _, obj := range doc.Objects {
+		switch obj.Type {
+		case "agent":
+			name := strings.TrimSpace(obj.Name)
+			if _, exists := b.agents[name]; exists {
+				return nil, fmt.Errorf("duplicate agent at line %d: %s", obj.Line, name)
+			}
+			a := NewAgent(name)
+			b.agents[name] = a
+			agents = append(agents, a)
+			agentObjs = append(agentObjs, obj)
+		case "template":
+			templateObjs = append(templateObjs, obj)
+		default:
+			return nil, fmt.Errorf("unexpected token at line %d (expecting an agent to be started)", obj.Line)
+		}
+	}
+
+	// Directives are processed once all agents exist so relations can refer
+	// to agents declared later in the file. Templates are built first so
+	// agents can use templates declared later in the file.
+	parsed := map[string]*AgentTemplate{}
+	for _, obj := range templateObjs {
+		name := strings.TrimSpace(obj.Name)
+		if _, exists := parsed[name]; exists {
+			return nil, fmt.Errorf("duplicate template at line %d: %s", obj.Line, name)
+		}
+		// A template is built as an agent so it takes the same directives
+		proto := NewAgent(name)
+		for _, dir := range obj.Directives {
+			if err := b.directive(proto, dir); err != nil {
+				return nil, err
+			}
+		}
+		t := &AgentTemplate{
+			Name:      name,
+			Pools:     proto.Pools,
+			Rules:     proto.Rules,
+			Relations: proto.Relations,
+			Parent:    proto.Parent,
+			Attrs:     proto.Attrs,
+		}
+		parsed[name] = t
+		b.templates[name] = t
+	}
+	for i, obj := range agentObjs {
+		for _, dir := range obj.Directives {
+			if err := b.directive(agents[i], dir); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, t := range parsed {
+		p.templates[name] = t
+	}
+	return agents, nil
+}
+
+// agentBuilder applies agent directives, resolving the names of resources,
+// rules and other agents.
+type agentBuilder struct {
+	resources *resourceIndex
+	rules     map[string]*Rule
+	groups    map[string][]*Rule
+	agents    map[string]*Agent
+	fsys      fs.FS
+	files     map[string][]*Rule // rules parsed from files, by file name
+	templates map[string]*AgentTemplate
+}
+
+func newAgentBuilder(resources []*Resource, rules []*Rule, groups map[string][]*Rule) *agentBuilder {
+	b := &agentBuilder{
+		resources: newResourceIndex(resources),
+		rules:     map[string]*Rule{},
+		groups:    groups,
+		agents:    map[string]*Agent{},
+		files:     map[string][]*Rule{},
+		templates: map[string]*AgentTemplate{},
+	}
+	for _, r := range rules {
+		b.rules[r.Name] = r
+	}
+	return b
+}
+
+func (b *agentBuilder) directive(a *Agent, dir loon.Directive) error {
+	switch dir.Name {
+	case "pool":
+		return b.addPool(a.Pools, dir)
+	case "rules":
+		rules, err := b.ruleList(dir)
+		if err != nil {
+			return err
+		}
+		a.AppendRules(rules)
+	case "carry":
+		if len(dir.Args) != 1 && len(dir.Args) != 2 {
+			return fmt.Errorf("malformed carry directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+		}
+		var limits [2]int
+		for i, arg := range dir.Args {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid carry limit at line %d: %s", dir.Line, arg)
+			}
+			limits[i] = n
+		}
+		a.SetCarryLimit(limits[0], limits[1])
+	case "relation":
+		if len(dir.Args) != 2 {
+			return fmt.Errorf("malformed relation directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+		}
+		ra, ok := b.agents[dir.Args[1]]
+		if !ok {
+			return fmt.Errorf("unknown agent at line %d: %q", dir.Line, dir.Args[1])
+		}
+		a.AddRelation(Relation(strings.ToLower(dir.Args[0])), ra)
+	case "attr":
+		if len(dir.Args) != 2 {
+			return fmt.Errorf("malformed attr directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+		}
+		a.SetAttr(dir.Args[0], dir.Args[1])
+	case "template":
+		if len(dir.Args) != 1 {
+			return fmt.Errorf("malformed template directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+		}
+		t, ok := b.templates[dir.Args[0]]
+		if !ok {
+			return fmt.Errorf("unknown template at line %d: %q", dir.Line, dir.Args[0])
+		}
+		t.apply(a)
+	case "parent":
+		if len(dir.Args) != 1 && (len(dir.Args) != 2 || strings.ToLower(dir.Args[1]) != "inherit") {
+			return fmt.Errorf("malformed parent directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+		}
+		parent, ok := b.agents[dir.Args[0]]
+		if !ok {
+			return fmt.Errorf("unknown agent at line %d: %q", dir.Line, dir.Args[0])
+		}
+		for p := parent; p != nil; p = p.Parent {
+			if p == a {
+				return fmt.Errorf("parent directive at line %d makes agent its own ancestor: %s", dir.Line, dir.ArgText)
+			}
+		}
+		a.SetParent(parent, len(dir.Args) == 2)
+	default:
+		return fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
+	}
+	return nil
+}
+
+func (b *agentBuilder) addPool(ps *PoolSet, dir loon.Directive) error {
+	negative := false
+	if n := len(dir.Args); n > 0 && strings.ToLower(dir.Args[n-1]) == "negative" {
+		negative = true
+		dir.Args = dir.Args[:n-1]
+	}
+	if len(dir.Args) != 2 && len(dir.Args) != 3 {
+		return fmt.Errorf("malformed pool directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+	}
+
+	res, ok := b.resources.name(dir.Args[0])
+	if !ok {
+		resname := strings.ToLower(dir.Args[0])
+		return fmt.Errorf("unknown resource at line %d: %q%s", dir.Line, resname, didYouMean(resname, b.resources.names()))
+	}
+
+	capacity, err := strconv.Atoi(dir.Args[1])
+	if err != nil {
+		return fmt.Errorf("invalid capacity at line %d: %v", dir.Line, err)
+	}
+
+	quantity := 0
+	if len(dir.Args) == 3 {
+		quantity, err = strconv.Atoi(dir.Args[2])
+		if err != nil {
+			return fmt.Errorf("invalid quantity at line %d: %v", dir.Line, err)
+		}
+	}
+
+	if quantity < 0 && !negative {
+		return fmt.Errorf("negative quantity at line %d: %d", dir.Line, quantity)
+	}
+
+	ps.AddPool(res, capacity, quantity)
+	ps.SetAllowNegative(res, negative)
+	return nil
+}
+
+func (b *agentBuilder) ruleList(dir loon.Directive) ([]*Rule, error) {
+	if len(dir.Args) == 0 {
+		return nil, fmt.Errorf("malformed rules directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+	}
+
+	var rules []*Rule
+	for _, name := range dir.Args {
+		if g, ok := b.groups[name]; ok {
+			rules = append(rules, g...)
+			continue
+		}
+		if r, ok := b.rules[name]; ok {
+			rules = append(rules, r)
+			continue
+		}
+		if b.fsys == nil {
+			return nil, fmt.Errorf("unknown rule at line %d: %q", dir.Line, name)
+		}
+
+		frules, ok := b.files[name]
+		if !ok {
+			f, err := b.fsys.Open(name)
+			if err != nil {
+				return nil, fmt.Errorf("unknown rule at line %d: %q: %w", dir.Line, name, err)
+			}
+			frules, err = NewRuleParser(b.resources.resources()).Parse(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("rule file %s: %w", name, err)
+			}
+			b.files[name] = frules
+		}
+		rules = append(rules, frules...)
+	}
+	return rules, nil
+}
+
+// didYouMean returns a suggestion naming the candidate closest to name, or an
+// empty string if no candidate is close enough to be a likely misspelling.
+func didYouMean(name string, candidates []string) string {
+	best := ""
+	bestDist := len(name)/3 + 1 // allow roughly one edit per three characters
+	for _, c := range candidates {
+		d := levenshtein(name, c)
+		if d < bestDist || (d == bestDist && (best == "" || c < best)) {
+			best, bestDist = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -3,6 +3,7 @@ package rula
 import (
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -336,6 +337,38 @@ end
 			},
 		},
 	},
+
+	{
+		spec: `
+rule test
+	chance 25%
+end
+`,
+
+		rules: []*Rule{
+			{
+				Name:   "test",
+				Period: 1,
+				Chance: 25,
+			},
+		},
+	},
+
+	{
+		spec: `
+rule test
+	overflow spill global
+end
+`,
+
+		rules: []*Rule{
+			{
+				Name:     "test",
+				Period:   1,
+				Overflow: &Overflow{Policy: OverflowSpill, Spill: RelationGlobal},
+			},
+		},
+	},
 }
 
 func TestRuleParser(t *testing.T) {
@@ -402,3 +435,203 @@ func TestResourceParser(t *testing.T) {
 		})
 	}
 }
+
+func TestAgentParser(t *testing.T) {
+	smelt := &Rule{Name: "smelt", Period: 1}
+	haul := &Rule{Name: "haul", Period: 1}
+	mine := &Rule{Name: "mine", Period: 1}
+
+	p := NewAgentParser([]*Resource{ironOre, iron}, map[string][]*Rule{
+		"industry": {smelt, haul},
+		"mining":   {mine},
+	})
+	p.SetRuleFS(fstest.MapFS{
+		"extra.rula": {Data: []byte("rule sell\n\tin iron 1\nend\n")},
+	})
+
+	agents, err := p.Parse(strings.NewReader(`
+agent smelter
+	pool iron_ore 10 4
+	pool iron 5
+	relation employer town
+	rules industry mine extra.rula
+end
+
+agent town
+	pool iron 20 -3 negative
+	rules extra.rula
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(agents) != 2 {
+		t.Fatalf("got %d agents, wanted 2", len(agents))
+	}
+	smelter, town := agents[0], agents[1]
+
+	if got := smelter.Pools.Quantity(ironOre); got != 4 {
+		t.Errorf("got iron_ore quantity %d, wanted 4", got)
+	}
+	if got := smelter.Pools.Capacity(iron); got != 5 {
+		t.Errorf("got iron capacity %d, wanted 5", got)
+	}
+	if got := town.Pools.Quantity(iron); got != -3 || !town.Pools.AllowsNegative(iron) {
+		t.Errorf("got town iron %d, wanted -3 with negative allowed", got)
+	}
+	if smelter.Pools.AllowsNegative(iron) {
+		t.Errorf("smelter iron allows negative quantities")
+	}
+	if smelter.Relations["employer"] != town {
+		t.Errorf("employer relation not bound to town")
+	}
+
+	var names []string
+	for _, r := range smelter.Rules {
+		names = append(names, r.Name)
+	}
+	if diff := cmp.Diff([]string{"smelt", "haul", "mine", "sell"}, names); diff != "" {
+		t.Errorf("rules mismatch (-want +got):\n%s", diff)
+	}
+
+	if smelter.Rules[3] != town.Rules[0] {
+		t.Errorf("rules from the same file were not shared")
+	}
+}
+
+func TestAgentParserErrors(t *testing.T) {
+	testCases := []string{
+		"agent a\n\trelation boss b\nend\n",
+		"agent a\n\tpool gold 1 1\nend\n",
+		"agent a\n\tpool iron x\nend\n",
+		"agent a\n\tpool iron 5 -1\nend\n",
+		"agent a\n\trules missing\nend\n",
+		"agent a\n\tcolour red\nend\n",
+		"agent a\n\tparent b\nend\n",
+		"agent a\n\tparent a\nend\n",
+		"agent a\n\tparent b always\nend\nagent b\nend\n",
+		"agent a\n\tattr culture\nend\n",
+		"rule a\nend\n",
+	}
+
+	p := NewAgentParser([]*Resource{iron}, nil)
+	for _, spec := range testCases {
+		t.Run("", func(t *testing.T) {
+			if _, err := p.Parse(strings.NewReader(spec)); err == nil {
+				t.Errorf("got no error, wanted one")
+			}
+		})
+	}
+}
+
+func TestRuleParserStrict(t *testing.T) {
+	testCases := []struct {
+		spec    string
+		strict  bool
+		errText string
+	}{
+		{
+			spec:    "rule test\n\tin iron_or 3\nend\n",
+			errText: `(did you mean "iron_ore"?)`,
+		},
+		{
+			spec:    "rule test\n\tin employer iron 3\nend\n",
+			strict:  true,
+			errText: `unknown relation at line 0: "employer" (did you mean "employee"?)`,
+		},
+		{
+			spec:   "rule test\n\tin employee iron 3\n\tif location iron > 3\nend\n",
+			strict: true,
+		},
+		{
+			spec:   "rule test\n\tout target workers -5\nend\n",
+			strict: true,
+		},
+		{
+			spec:   "rule test\n\tin parent iron 3\nend\n",
+			strict: true,
+		},
+		{
+			spec: "rule test\n\tin employer iron 3\nend\n",
+		},
+		{
+			spec:    "rule test\n\tinn iron 3\nend\n",
+			errText: `unknown directive at line 0: inn (did you mean "in"?)`,
+		},
+		{
+			spec:    "rule test\n\tonfail tset\nend\n",
+			errText: `(did you mean "test"?)`,
+		},
+		{
+			spec:    "rule test\n\tchance 0\nend\n",
+			errText: "chance out of range at line 0: 0",
+		},
+		{
+			spec:    "rule test\n\toverflow drop\nend\n",
+			errText: "unknown overflow policy at line 0: drop",
+		},
+		{
+			spec:    "rule test\n\tif iron ~ 3\nend\n",
+			errText: "unknown operator at line 0: ~",
+		},
+		{
+			spec:    "rule a\n\tonfail b\nend\nrule b\n\tonfail a\nend\n",
+			errText: `onfail cycle through rule "a"`,
+		},
+		{
+			spec:    "rule test\n\toverflow spill\nend\n",
+			errText: "malformed overflow directive at line 0: overflow spill",
+		},
+		{
+			spec:    "rule test\n\tin gold 3\nend\n",
+			errText: `unknown resource at line 0: "gold"`,
+		},
+		{
+			spec:    "rule test\n\tspawn rabbit 0\nend\n",
+			errText: "invalid spawn count at line 0: 0",
+		},
+		{
+			spec:    "rule test\n\tdestroy target\nend\n",
+			errText: "malformed destroy directive at line 0: destroy target",
+		},
+		{
+			spec:    "rule test\n\tbuy iron 3 for 2 workers\nend\n",
+			errText: "malformed buy directive at line 0: buy iron 3 for 2 workers",
+		},
+		{
+			spec:    "rule test\n\tsell iron 3 at -1 workers\nend\n",
+			errText: "invalid price at line 0: -1",
+		},
+		{
+			spec:    "rule test\n\tbuy iron 3 at 2 workers\nend\n",
+			errText: "price in resource that is not a currency at line 0: workers",
+		},
+		{
+			spec:    "rule test\n\texchange iron 3 workers at iron_ore\nend\n",
+			errText: "malformed exchange directive at line 0: exchange iron 3 workers at iron_ore",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run("", func(t *testing.T) {
+			p := NewRuleParser([]*Resource{ironOre, iron, workers})
+			if tc.strict {
+				p.Strict("employee")
+			}
+			_, err := p.Parse(strings.NewReader(tc.spec))
+			if tc.errText == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got no error, wanted one containing %q", tc.errText)
+			}
+			if !strings.Contains(err.Error(), tc.errText) {
+				t.Errorf("got error %q, wanted one containing %q", err.Error(), tc.errText)
+			}
+		})
+	}
+}
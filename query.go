@@ -0,0 +1,146 @@
+package rula
+
+// worldIndex indexes the agents of a world by ID and tag. It is updated as
+// agents are added, removed and tagged.
+type worldIndex struct {
+	byID  map[string]*Agent
+	byTag map[string][]*Agent
+	tags  map[*Agent][]string
+}
+
+func (x *worldIndex) add(a *Agent) {
+	if x.byID == nil {
+		x.byID = map[string]*Agent{}
+	}
+	x.byID[a.ID] = a
+}
+
+func (x *worldIndex) remove(a *Agent) {
+	delete(x.byID, a.ID)
+	for _, tag := range x.tags[a] {
+		x.untag(a, tag)
+	}
+	delete(x.tags, a)
+}
+
+func (x *worldIndex) tag(a *Agent, tag string) {
+	for _, t := range x.tags[a] {
+		if t == tag {
+			return
+		}
+	}
+	if x.byTag == nil {
+		x.byTag = map[string][]*Agent{}
+		x.tags = map[*Agent][]string{}
+	}
+	x.byTag[tag] = append(x.byTag[tag], a)
+	x.tags[a] = append(x.tags[a], tag)
+}
+
+func (x *worldIndex) untag(a *Agent, tag string) {
+	x.byTag[tag] = without(x.byTag[tag], a)
+	if len(x.byTag[tag]) == 0 {
+		delete(x.byTag, tag)
+	}
+	tags := x.tags[a][:0]
+	for _, t := range x.tags[a] {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	x.tags[a] = tags
+}
+
+// without returns agents without a, reusing its array.
+func without(agents []*Agent, a *Agent) []*Agent {
+	for i, x := range agents {
+		if x == a {
+			return append(agents[:i], agents[i+1:]...)
+		}
+	}
+	return agents
+}
+
+// FindAgents returns the agents in the world for which match returns true,
+// in the order they were added. Predicates such as HasAtLeast and RelatedTo
+// can be combined with MatchAll.
+func (w *World) FindAgents(match func(*Agent) bool) []*Agent {
+	var found []*Agent
+	for _, a := range w.agents {
+		if match(a) {
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// Agent returns the agent in the world with the given ID.
+func (w *World) Agent(id string) (*Agent, bool) {
+	a, ok := w.index.byID[id]
+	return a, ok
+}
+
+// Tag tags an agent in the world, such as to mark it as hostile or as a
+// player's unit. Tagging an agent that is not in the world has no effect.
+func (w *World) Tag(a *Agent, tag string) {
+	if w.index.byID[a.ID] == a {
+		w.index.tag(a, tag)
+	}
+}
+
+// Untag removes a tag from an agent.
+func (w *World) Untag(a *Agent, tag string) {
+	w.index.untag(a, tag)
+}
+
+// HasTag reports whether an agent has been given a tag.
+func (w *World) HasTag(a *Agent, tag string) bool {
+	for _, t := range w.index.tags[a] {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Tagged returns the agents with a tag in the order they were tagged. It
+// uses an index rather than examining every agent.
+func (w *World) Tagged(tag string) []*Agent {
+	return append([]*Agent(nil), w.index.byTag[tag]...)
+}
+
+// HasAtLeast matches agents holding at least quantity q of resource r.
+func HasAtLeast(r *Resource, q int) func(*Agent) bool {
+	return func(a *Agent) bool {
+		return a.Pools.Pool(r) != nil && a.Pools.Quantity(r) >= q
+	}
+}
+
+// HasLessThan matches agents holding a pool of resource r with less than
+// quantity q, such as agents that are starving.
+func HasLessThan(r *Resource, q int) func(*Agent) bool {
+	return func(a *Agent) bool {
+		return a.Pools.Pool(r) != nil && a.Pools.Quantity(r) < q
+	}
+}
+
+// RelatedTo matches agents related to b by relation rel. If b is nil it
+// matches agents with any agent in that relation.
+func RelatedTo(rel Relation, b *Agent) func(*Agent) bool {
+	return func(a *Agent) bool {
+		ra, ok := a.Relations[rel]
+		return ok && (b == nil || ra == b)
+	}
+}
+
+// MatchAll matches agents matched by all of the predicates.
+func MatchAll(preds ...func(*Agent) bool) func(*Agent) bool {
+	return func(a *Agent) bool {
+		for _, p := range preds {
+			if !p(a) {
+				return false
+			}
+		}
+		return true
+	}
+}
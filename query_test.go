@@ -0,0 +1,61 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWorldFindAgents(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	w := NewWorld(nil)
+	town := NewAgent("town")
+	w.AddAgent(town)
+	var peasants []*Agent
+	for i, name := range []string{"ann", "bob", "cat"} {
+		a := NewAgent(name)
+		a.AddPool(food, 10, i*3)
+		a.AddRelation("home", town)
+		w.AddAgent(a)
+		peasants = append(peasants, a)
+	}
+	ann, bob, cat := peasants[0], peasants[1], peasants[2]
+
+	names := func(agents []*Agent) []string {
+		var names []string
+		for _, a := range agents {
+			names = append(names, a.Name.Singular)
+		}
+		return names
+	}
+
+	if diff := cmp.Diff([]string{"ann", "bob"}, names(w.FindAgents(HasLessThan(food, 5)))); diff != "" {
+		t.Errorf("starving agents mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"bob", "cat"}, names(w.FindAgents(MatchAll(HasAtLeast(food, 1), RelatedTo("home", town))))); diff != "" {
+		t.Errorf("fed residents mismatch (-want +got):\n%s", diff)
+	}
+
+	w.Tag(cat, "sick")
+	w.Tag(ann, "sick")
+	w.Tag(ann, "sick")
+	w.Tag(NewAgent("stranger"), "sick")
+	if diff := cmp.Diff([]string{"cat", "ann"}, names(w.Tagged("sick"))); diff != "" {
+		t.Errorf("tagged agents mismatch (-want +got):\n%s", diff)
+	}
+	w.Untag(cat, "sick")
+	if w.HasTag(cat, "sick") || !w.HasTag(ann, "sick") {
+		t.Errorf("tag not removed")
+	}
+
+	w.RemoveAgent(ann)
+	if got := w.Tagged("sick"); len(got) != 0 {
+		t.Errorf("got %d sick agents after removing ann, wanted 0", len(got))
+	}
+	if _, ok := w.Agent("ann"); ok {
+		t.Errorf("removed agent found by name")
+	}
+	if a, ok := w.Agent("bob"); !ok || a != bob {
+		t.Errorf("agent not found by name")
+	}
+}
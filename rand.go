@@ -0,0 +1,59 @@
+package rula
+
+import "math/rand"
+
+// splitmix64 is a rand.Source64 whose entire state is a single integer, so the
+// random state of a Runner is cheap to copy and restore.
+type splitmix64 struct {
+	state uint64
+}
+
+func newSplitmix64(seed int64) *splitmix64 {
+	return &splitmix64{state: uint64(seed)}
+}
+
+func (s *splitmix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitmix64) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitmix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// WithSeed seeds the runner's source of randomness. Runners created with the
+// same seed make the same random decisions when run with the same rules and
+// pools. The default seed is 0.
+func WithSeed(seed int64) RunnerOption {
+	return func(ru *Runner) {
+		ru.src = newSplitmix64(seed)
+		ru.rand = rand.New(ru.src)
+	}
+}
+
+// WithRandSource sets the source used for all of the runner's random
+// decisions.
+func WithRandSource(src rand.Source) RunnerOption {
+	return func(ru *Runner) {
+		ru.src = src
+		ru.rand = rand.New(src)
+	}
+}
+
+// chance reports whether an event with the given percentage chance of
+// happening occurs.
+func (ru *Runner) chance(percent int) bool {
+	if percent >= 100 {
+		return true
+	}
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	return ru.rand.Intn(100) < percent
+}
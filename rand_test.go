@@ -0,0 +1,40 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunnerSeed(t *testing.T) {
+	find := NewRule("find").Out(RelationSelf, iron, 1).Chance(30).Build()
+
+	run := func(seed int64) []int {
+		ps := NewPoolSet(&Pool{Resource: iron, Capacity: 1000})
+		ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+		runner := NewRunner(WithSeed(seed))
+
+		var found []int
+		for tick := int64(1); tick <= 200; tick++ {
+			if _, err := runner.Run([]*Rule{find}, tick, ctx); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			found = append(found, ps.Quantity(iron))
+		}
+		return found
+	}
+
+	a, b := run(42), run(42)
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("runs with the same seed differ (-first +second):\n%s", diff)
+	}
+
+	// A 30% chance over 200 ticks should find some but not all
+	if n := a[len(a)-1]; n == 0 || n == 200 {
+		t.Errorf("got %d finds in 200 ticks, wanted around 60", n)
+	}
+
+	if diff := cmp.Diff(a, run(43)); diff == "" {
+		t.Errorf("runs with different seeds are identical")
+	}
+}
@@ -0,0 +1,104 @@
+package rula
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// A Recorder writes the quantities of selected pools at the end of each tick
+// as rows of CSV, one column per pool after the tick, so that the balance of
+// a simulation can be studied in a spreadsheet or notebook. Pass it to a
+// Runner with WithRecorder to record every Step, or call Record directly. A
+// Recorder is safe for concurrent use.
+type Recorder struct {
+	mu      sync.Mutex
+	out     *csv.Writer
+	columns []recorderColumn
+	started bool // whether the header has been written
+}
+
+type recorderColumn struct {
+	name string
+	ps   *PoolSet
+	r    *Resource
+}
+
+// NewRecorder returns a recorder writing CSV to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{out: csv.NewWriter(w)}
+}
+
+// WithRecorder records the pools tracked by rec at the end of every Step. An
+// error writing the record is returned by Step.
+func WithRecorder(rec *Recorder) RunnerOption {
+	return func(ru *Runner) {
+		ru.recorder = rec
+	}
+}
+
+// Track adds a column named name holding the quantity of resource r in ps.
+// Columns are written in the order they are tracked. It returns an error
+// once the first row has been recorded.
+func (rec *Recorder) Track(name string, ps *PoolSet, r *Resource) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.started {
+		return errors.New("recorder has already started")
+	}
+	rec.columns = append(rec.columns, recorderColumn{name: name, ps: ps, r: r})
+	return nil
+}
+
+// TrackWorld tracks every pool of the world's global and agents, named by
+// the owner and resource as in a snapshot, such as "global gold" or
+// "farmer grain". Pools and agents added to the world later are not
+// tracked.
+func (rec *Recorder) TrackWorld(w *World) error {
+	track := func(owner string, ps *PoolSet) error {
+		for _, pool := range ps.Snapshot() {
+			if err := rec.Track(owner+" "+resourceLabel(pool.Resource), ps, pool.Resource); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := track("global", w.Global.Pools); err != nil {
+		return err
+	}
+	for _, a := range w.agents {
+		if err := track(agentLabel(a), a.Pools); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record writes a row holding tick and the quantity of every tracked pool,
+// preceded by a header row naming the columns if it is the first.
+func (rec *Recorder) Record(tick int64) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !rec.started {
+		header := []string{"tick"}
+		for _, c := range rec.columns {
+			header = append(header, c.name)
+		}
+		if err := rec.out.Write(header); err != nil {
+			return err
+		}
+		rec.started = true
+	}
+
+	row := []string{strconv.FormatInt(tick, 10)}
+	for _, c := range rec.columns {
+		row = append(row, strconv.Itoa(c.ps.Quantity(c.r)))
+	}
+	if err := rec.out.Write(row); err != nil {
+		return err
+	}
+	rec.out.Flush()
+	return rec.out.Error()
+}
@@ -0,0 +1,43 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecorder(t *testing.T) {
+	food := &Resource{ID: "food", Name: Name{Singular: "food"}}
+	var b strings.Builder
+	rec := NewRecorder(&b)
+
+	farm := NewRule("farm").Out(RelationSelf, food, 2).Build()
+	farmer := NewAgent("farmer")
+	farmer.Pools = NewPoolSet(&Pool{Resource: food, Capacity: 100})
+	farmer.Rules = []*Rule{farm}
+
+	w := NewWorld(nil, WithRecorder(rec))
+	w.Global.Pools = NewPoolSet(&Pool{Resource: iron, Capacity: 10, Quantity: 3})
+	if err := w.AddAgent(farmer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rec.TrackWorld(w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := "tick,global iron,farmer food\n1,3,2\n2,3,4\n3,3,6\n"
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("recorded CSV mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := rec.Track("farmer iron", farmer.Pools, iron); err == nil {
+		t.Errorf("got no error tracking a pool after recording started, wanted one")
+	}
+}
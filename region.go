@@ -0,0 +1,79 @@
+package rula
+
+// RelationRegion refers to the pools of the region holding the location of
+// the agent a rule is run for.
+const RelationRegion Relation = "region"
+
+// A Region is a named group of locations, such as a province, with pools of
+// its own that rules of agents at its locations use through the region
+// relation. A location belongs to at most one region.
+type Region struct {
+	Name      string
+	Pools     *PoolSet
+	locations []*Location
+}
+
+// NewRegion returns a region with no locations and empty pools.
+func NewRegion(name string) *Region {
+	return &Region{Name: name, Pools: NewPoolSet()}
+}
+
+// Add adds locations to the region, removing them from any region they
+// belong to.
+func (r *Region) Add(locs ...*Location) {
+	for _, l := range locs {
+		if l.region == r {
+			continue
+		}
+		if l.region != nil {
+			l.region.Remove(l)
+		}
+		l.region = r
+		r.locations = append(r.locations, l)
+	}
+}
+
+// Remove removes a location from the region. It has no effect if the
+// location is not in the region.
+func (r *Region) Remove(l *Location) {
+	for i, m := range r.locations {
+		if m == l {
+			r.locations = append(r.locations[:i], r.locations[i+1:]...)
+			l.region = nil
+			return
+		}
+	}
+}
+
+// Locations returns the locations of the region in the order they were
+// added.
+func (r *Region) Locations() []*Location {
+	return append([]*Location(nil), r.locations...)
+}
+
+// Total returns the total quantity of a resource held in the region, in the
+// pools of its locations and of the agents at them. Pools shared by a
+// location and an agent are counted once.
+func (r *Region) Total(res *Resource) int {
+	seen := map[*PoolSet]bool{}
+	total := 0
+	add := func(ps *PoolSet) {
+		if ps != nil && !seen[ps] {
+			seen[ps] = true
+			total += ps.Quantity(res)
+		}
+	}
+	for _, l := range r.locations {
+		add(l.pools)
+		for _, a := range l.occupants {
+			add(a.Pools)
+		}
+	}
+	return total
+}
+
+// Region returns the region the location belongs to, or nil if it belongs to
+// none. See Region.Add.
+func (l *Location) Region() *Region {
+	return l.region
+}
@@ -0,0 +1,68 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegion(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource food
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	food := resources[0]
+
+	p := NewRuleParser(resources)
+	p.Strict()
+	rules, err := p.Parse(strings.NewReader(`
+rule tithe
+	in food 1
+	out region food 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNetwork()
+	farm := n.AddLocation(Position{})
+	village := n.AddLocation(Position{})
+	farm.Pools().AddPool(food, 100, 10)
+	village.Pools().AddPool(food, 100, 5)
+
+	valley := NewRegion("valley")
+	valley.Pools.AddPool(food, 100, 0)
+	valley.Add(farm, village)
+
+	farmer := NewAgent("farmer")
+	farmer.Pools.AddPool(food, 100, 3)
+	farmer.MoveTo(farm)
+
+	if got := valley.Total(food); got != 18 {
+		t.Errorf("got valley total %d, wanted 18", got)
+	}
+
+	ru := NewRunner()
+	if _, err := ru.RunRule(rules[0], 1, farmer.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := valley.Pools.Quantity(food); got != 1 {
+		t.Errorf("got valley food %d, wanted 1", got)
+	}
+
+	hills := NewRegion("hills")
+	hills.Add(farm)
+	if farm.Region() != hills || len(valley.Locations()) != 1 || valley.Total(food) != 5 {
+		t.Errorf("farm not moved to the hills")
+	}
+	if _, ok := farmer.RuleContext().Pools[RelationRegion]; !ok {
+		t.Errorf("no region pools for the farmer in the hills")
+	}
+	hills.Remove(farm)
+	if _, ok := farmer.RuleContext().Pools[RelationRegion]; ok || farm.Region() != nil {
+		t.Errorf("got region pools for a location in no region")
+	}
+}
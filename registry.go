@@ -0,0 +1,244 @@
+package rula
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A ResourceRegistry assigns each resource registered with it a dense index,
+// stored in the resource's Index field, so that pool sets created with
+// NewDensePoolSet can hold their pools in a slice. It also looks resources up
+// by ID and by name, and no two registered resources may share an ID. A
+// registry is not safe for concurrent registration.
+type ResourceRegistry struct {
+	resources []*Resource
+	index     resourceIndex
+}
+
+// NewResourceRegistry returns a registry holding the supplied resources. It
+// panics if any of them is registered with another registry or shares an ID
+// with another.
+func NewResourceRegistry(resources ...*Resource) *ResourceRegistry {
+	reg := &ResourceRegistry{}
+	for _, r := range resources {
+		if err := reg.Register(r); err != nil {
+			panic(err.Error())
+		}
+	}
+	return reg
+}
+
+// Register assigns r the next index in the registry. Registering a resource
+// again has no effect. It returns an error if r is registered with another
+// registry or another registered resource has the same ID.
+func (g *ResourceRegistry) Register(r *Resource) error {
+	if g.contains(r) {
+		return nil
+	}
+	if r.Index != 0 {
+		return fmt.Errorf("resource %q is registered with another registry", r.Name.Singular)
+	}
+	if _, dup := g.index.id(r.ID); dup && r.ID != "" {
+		return fmt.Errorf("duplicate resource id %q", r.ID)
+	}
+	g.resources = append(g.resources, r)
+	g.index.add(r)
+	r.Index = len(g.resources)
+	return nil
+}
+
+// ByID returns the registered resource with the given ID.
+func (g *ResourceRegistry) ByID(id string) (*Resource, bool) {
+	return g.index.id(id)
+}
+
+// ByName returns the registered resource with the given singular name,
+// ignoring case. If several resources share a name the last registered is
+// returned.
+func (g *ResourceRegistry) ByName(name string) (*Resource, bool) {
+	return g.index.name(name)
+}
+
+// Resources returns the registered resources in index order.
+func (g *ResourceRegistry) Resources() []*Resource {
+	return append([]*Resource(nil), g.resources...)
+}
+
+// Len returns the number of registered resources.
+func (g *ResourceRegistry) Len() int {
+	return len(g.resources)
+}
+
+// indexOf returns the index of r in the registry, or of the registered
+// resource with the same ID, or 0 if neither is registered.
+func (g *ResourceRegistry) indexOf(r *Resource) int {
+	if g.contains(r) {
+		return r.Index
+	}
+	if r == nil || r.ID == "" {
+		return 0
+	}
+	if reg, ok := g.index.id(r.ID); ok {
+		return reg.Index
+	}
+	return 0
+}
+
+// contains reports whether r was registered with the registry.
+func (g *ResourceRegistry) contains(r *Resource) bool {
+	return r != nil && r.Index > 0 && r.Index <= len(g.resources) && g.resources[r.Index-1] == r
+}
+
+// A resourceIndex looks resources up by ID and by singular name, ignoring
+// case, as parsers and decoders do. The zero value is an empty index.
+type resourceIndex struct {
+	list   []*Resource
+	byID   map[string]*Resource
+	byName map[string]*Resource
+}
+
+func newResourceIndex(resources []*Resource) *resourceIndex {
+	x := &resourceIndex{}
+	for _, r := range resources {
+		x.add(r)
+	}
+	return x
+}
+
+// add adds r to the index, replacing any resource with the same ID or name.
+func (x *resourceIndex) add(r *Resource) {
+	if x.byID == nil {
+		x.byID = map[string]*Resource{}
+		x.byName = map[string]*Resource{}
+	}
+	x.list = append(x.list, r)
+	if r.ID != "" {
+		x.byID[r.ID] = r
+	}
+	x.byName[strings.ToLower(r.Name.Singular)] = r
+}
+
+// id returns the resource with the given ID. The ID of a sub-pool, such as
+// iron@warehouse, refers to the sub-pool of the resource with the ID before
+// the @.
+func (x *resourceIndex) id(id string) (*Resource, bool) {
+	if r, ok := x.byID[id]; ok {
+		return r, true
+	}
+	if base, pool := splitSubPool(id); pool != "" {
+		if r, ok := x.byID[base]; ok {
+			return r.Sub(pool), true
+		}
+	}
+	return nil, false
+}
+
+// name returns the resource with the given singular name, ignoring case, or
+// its sub-pool if the name is followed by @ and a pool name.
+func (x *resourceIndex) name(name string) (*Resource, bool) {
+	base, pool := splitSubPool(name)
+	r, ok := x.byName[strings.ToLower(base)]
+	if ok && pool != "" {
+		r = r.Sub(pool)
+	}
+	return r, ok
+}
+
+// label returns the resource identified by a label made by resourceLabel.
+func (x *resourceIndex) label(l string) (*Resource, bool) {
+	if r, ok := x.id(l); ok {
+		return r, true
+	}
+	base, pool := splitSubPool(l)
+	for _, r := range x.list {
+		if r.ID == "" && r.Name.Singular == base {
+			if pool != "" {
+				return r.Sub(pool), true
+			}
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// names returns the lower case singular names of the indexed resources, for
+// suggesting corrections to unknown names.
+func (x *resourceIndex) names() []string {
+	names := make([]string, 0, len(x.byName))
+	for n := range x.byName {
+		names = append(names, n)
+	}
+	return names
+}
+
+// resources returns the indexed resources in the order they were added.
+func (x *resourceIndex) resources() []*Resource {
+	return append([]*Resource(nil), x.list...)
+}
+
+// BindResources replaces each resource with an ID that the rules and pools of
+// global and agents refer to, including onfail rules, with the resource in
+// resources that has the same ID. Resources are matched by ID so a world
+// assembled from separately decoded or declared parts works either way, but
+// binding lets code that compares resources by pointer, or reads their names,
+// see a single value for each. Resources without an ID are left unchanged. It
+// returns an error, having changed nothing, if an ID is not in resources.
+// global may be nil.
+func BindResources(resources []*Resource, global *Global, agents []*Agent) error {
+	index := newResourceIndex(resources)
+
+	var sets []*PoolSet
+	var rules []*Rule
+	if global != nil {
+		sets = append(sets, global.Pools)
+		rules = append(rules, global.Rules...)
+	}
+	for _, a := range agents {
+		sets = append(sets, a.Pools)
+		rules = append(rules, a.AllRules()...)
+	}
+
+	// Gather every reference so that nothing is changed unless all bind
+	var refs []**Resource
+	seen := map[*Rule]bool{}
+	for _, r := range rules {
+		for rule := r; rule != nil && !seen[rule]; rule = rule.OnFail {
+			seen[rule] = true
+			for i := range rule.Preconditions {
+				refs = append(refs, &rule.Preconditions[i].Resource)
+			}
+			for _, specs := range [][]ResourceSpecifier{rule.Inputs, rule.Outputs, rule.Sets} {
+				for i := range specs {
+					refs = append(refs, &specs[i].Resource)
+				}
+			}
+			if rule.RepeatFrom != nil {
+				refs = append(refs, &rule.RepeatFrom.Resource)
+			}
+		}
+	}
+	for _, ps := range sets {
+		for _, pool := range ps.Snapshot() {
+			if _, ok := index.id(pool.Resource.ID); pool.Resource.ID != "" && !ok {
+				return fmt.Errorf("unknown resource: %q", pool.Resource.ID)
+			}
+		}
+	}
+	for _, ref := range refs {
+		if r := *ref; r != nil && r.ID != "" {
+			if _, ok := index.id(r.ID); !ok {
+				return fmt.Errorf("unknown resource: %q", r.ID)
+			}
+		}
+	}
+
+	for _, ref := range refs {
+		if r := *ref; r != nil && r.ID != "" {
+			*ref, _ = index.id(r.ID)
+		}
+	}
+	for _, ps := range sets {
+		ps.rebind(index)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResourceRegistry(t *testing.T) {
+	reg := NewResourceRegistry()
+	p := NewResourceParser()
+	p.SetRegistry(reg)
+
+	resources, err := p.Parse(strings.NewReader(`resource iron_ore
+	singular Iron Ore
+end
+
+resource coal
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r, ok := reg.ByID("iron_ore"); !ok || r != resources[0] {
+		t.Errorf("ByID(iron_ore) = %v, %v", r, ok)
+	}
+	if r, ok := reg.ByName("iron ore"); !ok || r != resources[0] {
+		t.Errorf("ByName(iron ore) = %v, %v", r, ok)
+	}
+	if _, ok := reg.ByID("Iron Ore"); ok {
+		t.Errorf("ByID found resource by name")
+	}
+	if resources[1].Index != 2 {
+		t.Errorf("got coal index %d, wanted 2", resources[1].Index)
+	}
+
+	// IDs must be unique in the registry and within a file
+	if err := reg.Register(&Resource{ID: "coal", Name: Name{Singular: "charcoal"}}); err == nil {
+		t.Errorf("got no error registering duplicate id")
+	}
+	if _, err := p.Parse(strings.NewReader("resource coal\nend\n")); err == nil {
+		t.Errorf("got no error parsing registered id")
+	}
+	if _, err := NewResourceParser().Parse(strings.NewReader("resource tin\nend\nresource tin\nend\n")); err == nil {
+		t.Errorf("got no error parsing duplicate resource")
+	}
+	if reg.Len() != 2 {
+		t.Errorf("got %d registered, wanted 2", reg.Len())
+	}
+}
+
+func TestResourceIdentity(t *testing.T) {
+	coal := &Resource{ID: "coal", Name: Name{Singular: "coal"}}
+	decoded := &Resource{ID: "coal", Name: Name{Singular: "coal"}}
+	if !coal.Is(decoded) || coal.Is(&Resource{Name: coal.Name}) {
+		t.Errorf("Is does not match by id")
+	}
+
+	ps := NewPoolSet(&Pool{Resource: coal, Capacity: 10, Quantity: 5})
+	if ps.Pool(decoded) == nil || ps.Quantity(decoded) != 5 {
+		t.Errorf("pool not found by id")
+	}
+
+	// A rule mixing both values changes the one pool
+	rule := NewRule("burn").In(RelationSelf, decoded, 2).In(RelationSelf, coal, 2).Build()
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+	res, err := NewRunner().RunRule(rule, 1, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Rounds != 1 || ps.Quantity(coal) != 1 {
+		t.Errorf("got %d rounds leaving %d coal, wanted 1 round leaving 1", res.Rounds, ps.Quantity(coal))
+	}
+
+	a := &Agent{Name: Name{Singular: "a"}, Pools: NewPoolSet(&Pool{Resource: decoded, Capacity: 10}), Rules: []*Rule{rule}}
+	if err := BindResources([]*Resource{coal}, nil, []*Agent{a}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Pools.Pools()[0].Resource != coal || rule.Inputs[0].Resource != coal {
+		t.Errorf("resources not bound")
+	}
+	if err := BindResources(nil, nil, []*Agent{a}); err == nil {
+		t.Errorf("got no error binding unknown resource")
+	}
+}
@@ -0,0 +1,168 @@
+package rula
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A LogEntry records the changes made to the pools by one application of a
+// rule. The replay log written by a Runner holds one JSON encoded entry per
+// line.
+type LogEntry struct {
+	Tick    int64       `json:"tick"`
+	Agent   string      `json:"agent,omitempty"` // ID of the agent the rule ran for, empty for global rules
+	Rule    string      `json:"rule"`            // empty for decay and regeneration
+	Each    bool        `json:"each,omitempty"`  // a global rule run for the agent through the each relation
+	Changes []LogChange `json:"changes"`
+}
+
+// A LogChange is the net change made to one pool by a rule application.
+type LogChange struct {
+	Relation Relation `json:"relation"`
+	Resource string   `json:"resource"` // resource ID
+	Old      int      `json:"old"`
+	New      int      `json:"new"`
+}
+
+// WithReplayLog records every rule application that changes a pool to w as
+// a LogEntry, for later use with Replay. Rules are identified by name and
+// agents by singular name so both should be unique. An error writing the log
+// is returned by the Run or Step that caused it.
+func WithReplayLog(w io.Writer) RunnerOption {
+	return func(ru *Runner) {
+		ru.replay = &replayLog{enc: json.NewEncoder(w)}
+	}
+}
+
+type replayLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (l *replayLog) write(tick int64, rule *Rule, agent *Agent, each bool, changes []ResourceChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	e := LogEntry{Tick: tick, Each: each}
+	if rule != nil {
+		e.Rule = rule.Name
+	}
+	if agent != nil {
+		e.Agent = agentLabel(agent)
+	}
+	for _, c := range changes {
+		e.Changes = append(e.Changes, LogChange{
+			Relation: c.Relation,
+			Resource: resourceLabel(c.Resource),
+			Old:      c.Old,
+			New:      c.New,
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(e); err != nil {
+		return fmt.Errorf("write replay log: %w", err)
+	}
+	return nil
+}
+
+// commit applies the changes staged in a root txn and records them in the
+// replay log.
+func (ru *Runner) commit(tx *txn, tick int64) error {
+	ru.touch(tx)
+	if ru.replay == nil {
+		tx.commit(ru.changed)
+		ru.reportOverflows(tx)
+		return nil
+	}
+	net := tx.net()
+	tx.commit(ru.changed)
+	ru.reportOverflows(tx)
+	return ru.replay.write(tick, tx.rule, tx.agent, tx.each, net)
+}
+
+// A DivergenceError reports that a pool did not hold the quantity recorded in
+// a replay log before a change was replayed.
+type DivergenceError struct {
+	Line   int // line of the log holding the entry, counting from 1
+	Entry  LogEntry
+	Change LogChange
+	Found  int // quantity found in the pool
+}
+
+func (e *DivergenceError) Error() string {
+	who := "global"
+	if e.Entry.Agent != "" {
+		who = fmt.Sprintf("agent %q", e.Entry.Agent)
+	}
+	return fmt.Sprintf("line %d: tick %d rule %q for %s: %s %s was %d, log expects %d", e.Line, e.Entry.Tick, e.Entry.Rule, who, e.Change.Relation, e.Change.Resource, e.Found, e.Change.Old)
+}
+
+// Replay applies the changes recorded in a replay log to the pools of global
+// and agents, which should hold the state the log was recorded from. Each
+// change is checked against the quantity the log expects to find and a
+// *DivergenceError is returned at the first mismatch. Resources are looked up
+// by ID, or by singular name for resources without an ID. Entries for global
+// rules run through the each relation are replayed in the global context with
+// the each relation referring to the agent's pools, as the Runner runs them.
+func Replay(r io.Reader, global *Global, agents []*Agent, resources []*Resource) error {
+	byName := map[string]*Agent{}
+	for _, a := range agents {
+		byName[agentLabel(a)] = a
+	}
+	index := newResourceIndex(resources)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e LogEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var ctx RuleContext
+		if (e.Agent == "" || e.Each) && global == nil {
+			return fmt.Errorf("line %d: global rule %q but no global", line, e.Rule)
+		}
+		if e.Agent == "" {
+			ctx = global.RuleContext()
+		} else {
+			a, ok := byName[e.Agent]
+			if !ok {
+				return fmt.Errorf("line %d: unknown agent: %q", line, e.Agent)
+			}
+			if e.Each {
+				ctx = global.RuleContext()
+				ctx.Pools[RelationEach] = a.Pools
+				ctx.Agent = a
+			} else {
+				ctx = agentContext(a, global)
+			}
+		}
+
+		for _, c := range e.Changes {
+			ps, ok := ctx.Pools[c.Relation]
+			if !ok {
+				return fmt.Errorf("line %d: no poolset of type %v", line, c.Relation)
+			}
+			res, ok := index.label(c.Resource)
+			if !ok || ps.Pool(res) == nil {
+				return fmt.Errorf("line %d: no %s pool for resource %q", line, c.Relation, c.Resource)
+			}
+			if q := ps.Quantity(res); q != c.Old {
+				return &DivergenceError{Line: line, Entry: e, Change: c, Found: q}
+			}
+			ps.setQuantity(res, c.New)
+		}
+	}
+	return sc.Err()
+}
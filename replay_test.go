@@ -0,0 +1,135 @@
+package rula
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const replaySpec = `
+resource iron_ore
+end
+
+resource iron
+end
+
+rule mine
+	in global iron_ore 1
+	out iron_ore 1
+end
+
+rule smelt
+	every 2
+	in iron_ore 2
+	out location iron 1
+end
+
+global world
+	pool iron_ore 1000 500
+end
+
+agent smelter
+	pool iron_ore 10
+	relation location town
+	rules mine smelt
+end
+
+location town
+	pool iron 100
+end
+`
+
+func TestReplay(t *testing.T) {
+	parse := func() *Scenario {
+		sc, err := NewScenarioParser().Parse(strings.NewReader(replaySpec))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return sc
+	}
+
+	var log bytes.Buffer
+	rec := parse()
+	w := NewScenarioWorld(rec, WithReplayLog(&log))
+	for i := 0; i < 6; i++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	play := parse()
+	if err := Replay(bytes.NewReader(log.Bytes()), play.Global, play.Agents, play.Resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, r := range rec.Resources {
+		if got, want := play.Global.Pools.Quantity(play.Resources[i]), rec.Global.Pools.Quantity(r); got != want {
+			t.Errorf("global %s: got %d, wanted %d", r.Name.Singular, got, want)
+		}
+		for j, a := range rec.Agents {
+			if got, want := play.Agents[j].Pools.Quantity(play.Resources[i]), a.Pools.Quantity(r); got != want {
+				t.Errorf("%s %s: got %d, wanted %d", a.Name.Singular, r.Name.Singular, got, want)
+			}
+		}
+	}
+
+	// Replaying onto the final state diverges at the first entry
+	err := Replay(bytes.NewReader(log.Bytes()), play.Global, play.Agents, play.Resources)
+	var div *DivergenceError
+	if !errors.As(err, &div) {
+		t.Fatalf("got error %v, wanted a DivergenceError", err)
+	}
+	if div.Line != 1 {
+		t.Errorf("got divergence at line %d, wanted 1", div.Line)
+	}
+}
+
+func TestReplayEach(t *testing.T) {
+	spec := `
+resource gold
+end
+
+rule tax
+	in each gold 1
+	out gold 1
+end
+
+global world
+	pool gold 100
+	rules tax
+end
+
+agent ann
+	pool gold 10 5
+end
+`
+	parse := func() *Scenario {
+		sc, err := NewScenarioParser().Parse(strings.NewReader(spec))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return sc
+	}
+
+	var log bytes.Buffer
+	rec := parse()
+	w := NewScenarioWorld(rec, WithReplayLog(&log))
+	for i := 0; i < 3; i++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	play := parse()
+	if err := Replay(bytes.NewReader(log.Bytes()), play.Global, play.Agents, play.Resources); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gold := play.Resources[0]
+	if got := play.Global.Pools.Quantity(gold); got != 3 {
+		t.Errorf("got global gold %d, wanted 3", got)
+	}
+	if got := play.Agents[0].Pools.Quantity(gold); got != 2 {
+		t.Errorf("got ann gold %d, wanted 2", got)
+	}
+}
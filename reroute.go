@@ -0,0 +1,85 @@
+package rula
+
+// OnCut registers fn to be called for each journey or shipment whose route
+// follows a connection removed from the network, after it has been
+// rerouted. Exactly one of j and s is not nil. A journey with no other route
+// turns back and ends at the location it set out from that tick, or off the
+// map if that location was removed too. A shipment with no other route is
+// lost: fn is called with its route empty and it is not delivered.
+func (w *World) OnCut(fn func(j *Journey, s *Shipment)) {
+	w.onCut = append(w.onCut, fn)
+}
+
+// cut reports whether a route follows a connection removed from its network.
+func cut(route []*Connection) bool {
+	for _, c := range route {
+		if c.removed {
+			return true
+		}
+	}
+	return false
+}
+
+// findRoute returns the cheapest route from one location to another for
+// agent a, or false if there is none.
+func (w *World) findRoute(a *Agent, from, to *Location) ([]*Connection, Length, bool) {
+	if w.Network == nil || from.removed || to.removed {
+		return nil, 0, false
+	}
+	route, d, err := w.Network.RouteFor(from.ID(), to.ID(), a.routeDifficulty())
+	return route, d, err == nil
+}
+
+// arrival returns the tick of arrival after covering distance d at speed
+// from the departed tick, which is no earlier than tick. Travellers that
+// have lost their speed arrive at tick.
+func arrival(departed int64, d, speed Length, tick int64) int64 {
+	if speed <= 0 {
+		return tick
+	}
+	return maxInt64(departed+travelTicks(d, speed), tick)
+}
+
+// reroute finds new routes for the journeys and shipments whose routes were
+// cut by changes to the network. Travel time is measured from the start of
+// the journey or shipment along the new route, so a detour found early costs
+// no more than one found late.
+func (w *World) reroute() {
+	for _, j := range w.journeys {
+		if !cut(j.Route) {
+			continue
+		}
+		if route, d, ok := w.findRoute(j.Agent, j.From, j.To); ok {
+			j.Route, j.Distance = route, d
+			j.Arrives = arrival(j.Departed, d, j.Agent.Speed, w.tick)
+		} else {
+			j.To.incoming -= j.Agent.size()
+			j.To = j.From
+			if j.To.removed {
+				j.To = nil
+			}
+			j.Route, j.Distance, j.Arrives = nil, 0, w.tick
+		}
+		for _, fn := range w.onCut {
+			fn(j, nil)
+		}
+	}
+
+	shipments := w.shipments[:0]
+	for _, s := range w.shipments {
+		if !cut(s.Route) {
+			shipments = append(shipments, s)
+			continue
+		}
+		route, d, ok := w.findRoute(s.From, s.Origin, s.Dest)
+		s.Route = route
+		if ok {
+			s.Arrives = arrival(s.Departed, d, s.From.Speed, w.tick)
+			shipments = append(shipments, s)
+		}
+		for _, fn := range w.onCut {
+			fn(nil, s)
+		}
+	}
+	w.shipments = shipments
+}
@@ -0,0 +1,93 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReroute(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource grain
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	grain := resources[0]
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule send
+	ship market grain 1
+end
+
+rule go
+	move town
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNetwork()
+	farm := n.AddLocation(Position{})
+	town := n.AddLocation(Position{})
+	bridge := n.Connect(farm, town, 4*Kilometre)
+	n.Connect(farm, town, 8*Kilometre)
+
+	w := NewWorld(nil)
+	w.Network = n
+	w.AddLocation("town", town)
+
+	buyer := NewAgent("buyer")
+	buyer.Pools.AddPool(grain, 100, 0)
+	buyer.MoveTo(town)
+	w.AddAgent(buyer)
+
+	farmer := NewAgent("farmer")
+	farmer.Speed = Kilometre
+	farmer.Pools.AddPool(grain, 100, 1)
+	farmer.Relations = map[Relation]*Agent{"market": buyer}
+	farmer.AppendRules([]*Rule{rules[0]})
+	farmer.MoveTo(farm)
+	w.AddAgent(farmer)
+
+	walker := NewAgent("walker")
+	walker.Speed = Kilometre
+	walker.AppendRules([]*Rule{rules[1]})
+	walker.MoveTo(farm)
+	w.AddAgent(walker)
+
+	var cuts int
+	w.OnCut(func(j *Journey, s *Shipment) { cuts++ })
+
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if walker.Journey == nil || walker.Journey.Arrives != 5 || len(w.Shipments()) != 1 || w.Shipments()[0].Arrives != 5 {
+		t.Fatalf("walker and shipment not taking the bridge")
+	}
+
+	// Both take the long way round once the bridge falls, measured from
+	// when they set out
+	n.Disconnect(bridge)
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cuts != 2 || walker.Journey.Arrives != 9 || w.Shipments()[0].Arrives != 9 {
+		t.Errorf("got %d cuts, walker arriving at %d", cuts, walker.Journey.Arrives)
+	}
+
+	// With no way to town the walker turns back and the shipment is lost
+	n.RemoveLocation(town)
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cuts != 4 || walker.Journey != nil || walker.Location != farm || len(w.Shipments()) != 0 {
+		t.Errorf("got %d cuts, walker at %v and %d shipments", cuts, walker.Location, len(w.Shipments()))
+	}
+	if buyer.Location != nil || len(n.Locations()) != 1 || len(n.Connections(farm.ID())) != 0 {
+		t.Errorf("town not removed")
+	}
+	if buyer.Pools.Quantity(grain) != 0 {
+		t.Errorf("lost shipment was delivered")
+	}
+}
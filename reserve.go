@@ -0,0 +1,119 @@
+package rula
+
+// A Reservation holds a quantity of a resource in a pool set so that rules
+// and PoolSet.Remove cannot take it, such as cargo a caravan is loading over
+// several ticks. The quantity stays in the pool, counting towards its
+// quantity and preconditions, until the reservation is committed.
+type Reservation struct {
+	Resource *Resource
+	Quantity int
+
+	ps   *PoolSet
+	done bool // released or committed
+}
+
+// Reserve reserves quantity q of resource r, which must not be more than the
+// unreserved quantity in its pool unless the pool allows negative quantities.
+// It returns false and reserves nothing if the quantity is not available.
+func (p *PoolSet) Reserve(r *Resource, q int) (*Reservation, bool) {
+	if p == nil || r == nil || q < 0 {
+		return nil, false
+	}
+	defer p.lock()()
+	pool, ok := p.get(r)
+	if !ok {
+		return nil, false
+	}
+	if pool.Quantity-pool.Reserved < q && !pool.AllowNegative {
+		return nil, false
+	}
+	pool.Reserved += q
+	return &Reservation{Resource: r, Quantity: q, ps: p}, true
+}
+
+// Release returns the quantity held by a reservation made by the pool set to
+// its pool, leaving the quantity unchanged. Releasing a reservation that has
+// already been released or committed has no effect.
+func (p *PoolSet) Release(res *Reservation) {
+	if p == nil || res == nil || res.ps != p {
+		return
+	}
+	defer p.lock()()
+	if res.done {
+		return
+	}
+	res.done = true
+	if pool, ok := p.get(res.Resource); ok {
+		pool.Reserved -= res.Quantity
+	}
+}
+
+// CommitReservation removes the quantity held by a reservation made by the
+// pool set from its pool, returning the amount that could not be removed.
+// This is only non-zero if the quantity was since set below the reserved
+// amount, in which case the pool is emptied. Committing a reservation that
+// has already been released or committed removes nothing.
+func (p *PoolSet) CommitReservation(res *Reservation) int {
+	if p == nil || res == nil || res.ps != p {
+		return 0
+	}
+	defer p.lock()()
+	if res.done {
+		return 0
+	}
+	res.done = true
+	pool, ok := p.get(res.Resource)
+	if !ok {
+		return res.Quantity
+	}
+	pool.Reserved -= res.Quantity
+
+	q := res.Quantity
+	if pool.Quantity < q && !pool.AllowNegative {
+		q = pool.Quantity
+		if q < 0 {
+			q = 0
+		}
+	}
+	pool.Quantity -= q
+	return res.Quantity - q
+}
+
+// Available returns the quantity of resource r that is not held by
+// reservations.
+func (p *PoolSet) Available(r *Resource) int {
+	if p == nil || r == nil {
+		return 0
+	}
+	defer p.rlock()()
+	pool, ok := p.get(r)
+	if !ok {
+		return 0
+	}
+	return pool.Quantity - pool.Reserved
+}
+
+// reserved returns the quantity of resource r held by reservations.
+func (p *PoolSet) reserved(r *Resource) int {
+	if p == nil || r == nil {
+		return 0
+	}
+	defer p.rlock()()
+	pool, ok := p.get(r)
+	if !ok {
+		return 0
+	}
+	return pool.Reserved
+}
+
+// hasReservations reports whether any pool held by the set has a reserved
+// quantity.
+func (p *PoolSet) hasReservations() bool {
+	defer p.rlock()()
+	for _, r := range p.order {
+		if pool, _ := p.own(r); pool.Reserved != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package rula
+
+import "testing"
+
+func TestReserve(t *testing.T) {
+	ps := NewPoolSet(&Pool{Resource: iron, Capacity: 20, Quantity: 10})
+
+	res, ok := ps.Reserve(iron, 6)
+	if !ok {
+		t.Fatalf("could not reserve 6 of 10")
+	}
+	if _, ok := ps.Reserve(iron, 5); ok {
+		t.Errorf("reserved 5 with only 4 available")
+	}
+	if got := ps.Available(iron); got != 4 {
+		t.Errorf("got %d available, wanted 4", got)
+	}
+	if got := ps.Remove(iron, 5); got != 5 {
+		t.Errorf("removed reserved quantity, %d not removed", got)
+	}
+
+	if got := ps.CommitReservation(res); got != 0 {
+		t.Errorf("got %d not committed, wanted 0", got)
+	}
+	if got := ps.Quantity(iron); got != 4 {
+		t.Errorf("got quantity %d after commit, wanted 4", got)
+	}
+	ps.Release(res)
+	if got := ps.CommitReservation(res); got != 0 || ps.Quantity(iron) != 4 || ps.Available(iron) != 4 {
+		t.Errorf("finished reservation changed the pool")
+	}
+
+	res, _ = ps.Reserve(iron, 3)
+	ps.Release(res)
+	if got := ps.Available(iron); got != 4 {
+		t.Errorf("got %d available after release, wanted 4", got)
+	}
+}
+
+func TestRunReserved(t *testing.T) {
+	smelt := &Rule{
+		Name:    "smelt",
+		Period:  1,
+		Repeat:  5,
+		Inputs:  []ResourceSpecifier{{Relation: RelationSelf, Resource: ironOre, Quantity: 2}},
+		Outputs: []ResourceSpecifier{{Relation: RelationSelf, Resource: iron, Quantity: 1}},
+	}
+
+	for _, bulk := range []bool{false, true} {
+		self := NewPoolSet(
+			&Pool{Resource: ironOre, Capacity: 20, Quantity: 10},
+			&Pool{Resource: iron, Capacity: 20},
+		)
+		if _, ok := self.Reserve(ironOre, 5); !ok {
+			t.Fatalf("could not reserve ore")
+		}
+		ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self}}
+
+		var opts []RunnerOption
+		if !bulk {
+			opts = append(opts, WithOverflow(Overflow{Policy: OverflowFail}))
+		}
+		res, err := NewRunner(opts...).RunRule(smelt, 1, ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Only the 5 unreserved ore can be smelted
+		if res.Rounds != 2 {
+			t.Errorf("bulk %v: got %d rounds, wanted 2", bulk, res.Rounds)
+		}
+		if got := self.Quantity(ironOre); got != 6 {
+			t.Errorf("bulk %v: got %d ore, wanted 6", bulk, got)
+		}
+	}
+}
+
+func TestDecayReserved(t *testing.T) {
+	milk := &Resource{ID: "milk", Name: Name{Singular: "milk"}, Decay: 3}
+	a := NewAgent("dairy")
+	a.Pools.AddPool(milk, 20, 6)
+	if _, ok := a.Pools.Reserve(milk, 4); !ok {
+		t.Fatalf("could not reserve milk")
+	}
+
+	ru := NewRunner()
+	for tick := int64(1); tick <= 2; tick++ {
+		if _, err := ru.Step(nil, []*Agent{a}, tick); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Only the 2 unreserved milk can spoil
+	if got := a.Pools.Quantity(milk); got != 4 {
+		t.Errorf("got %d milk, wanted the 4 reserved to remain", got)
+	}
+}
@@ -0,0 +1,63 @@
+package rula
+
+// Free movement lets agents roam the map at a Position of their own rather
+// than travelling between the locations of the network. A World moves each
+// agent with a position by its velocity every tick, or towards its target at
+// its speed if it has one. Positions and locations are independent: an agent
+// may have both, such as a ship that is at sea but belongs to a port.
+
+// SetPosition gives the agent a position of its own on the map.
+func (a *Agent) SetPosition(pos Position) {
+	a.Position = &pos
+}
+
+// SteerTo sets the agent moving towards target at its speed. The agent stops
+// when it reaches target. It has no effect on an agent without a position.
+func (a *Agent) SteerTo(target Position) {
+	if a.Position == nil {
+		return
+	}
+	a.Target = &target
+}
+
+// where returns the position of the agent: its own if it has one, otherwise
+// the position of its location, or false if it has neither.
+func (a *Agent) where() (Position, bool) {
+	if a.Position != nil {
+		return *a.Position, true
+	}
+	if a.Location != nil {
+		return a.Location.pos, true
+	}
+	return Position{}, false
+}
+
+// roam moves each agent that has a position by its velocity, or towards its
+// target.
+func (w *World) roam() {
+	for _, a := range w.agents {
+		if a.Position == nil {
+			continue
+		}
+		if a.Target != nil {
+			a.Velocity = Position{}
+			d := a.Position.Distance(*a.Target)
+			if d <= a.Speed {
+				*a.Position = *a.Target
+				a.Target = nil
+				continue
+			}
+			if a.Speed <= 0 {
+				continue
+			}
+			// Move speed along the straight line to the target
+			f := float64(a.Speed) / float64(d)
+			a.Velocity = Position{
+				East:  Length(float64(a.Target.East-a.Position.East) * f),
+				North: Length(float64(a.Target.North-a.Position.North) * f),
+			}
+		}
+		a.Position.East += a.Velocity.East
+		a.Position.North += a.Velocity.North
+	}
+}
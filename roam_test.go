@@ -0,0 +1,47 @@
+package rula
+
+import "testing"
+
+func TestRoam(t *testing.T) {
+	w := NewWorld(nil)
+
+	drifter := NewAgent("drifter")
+	drifter.SetPosition(Position{})
+	drifter.Velocity = Position{East: Kilometre, North: -Kilometre}
+	w.AddAgent(drifter)
+
+	scout := NewAgent("scout")
+	scout.Speed = 3 * Kilometre
+	scout.SetPosition(Position{})
+	scout.SteerTo(Position{East: 6 * Kilometre, North: 8 * Kilometre})
+	w.AddAgent(scout)
+
+	for i := 0; i < 3; i++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := *drifter.Position; got != (Position{East: 3 * Kilometre, North: -3 * Kilometre}) {
+		t.Errorf("got drifter at %v", got)
+	}
+	// 10km at 3km a tick takes 4 ticks
+	if scout.Target == nil || scout.Position.Distance(*scout.Target) != Kilometre {
+		t.Errorf("got scout at %v", scout.Position)
+	}
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scout.Target != nil || *scout.Position != (Position{East: 6 * Kilometre, North: 8 * Kilometre}) {
+		t.Errorf("scout did not stop at its target, at %v", scout.Position)
+	}
+
+	// A roaming agent is near a location agent by position
+	camp := NewAgent("camp")
+	camp.MoveTo(NewNetwork().AddLocation(Position{East: 6 * Kilometre, North: 9 * Kilometre}))
+	scout.AddRelation("camp", camp)
+	rule := NewRule("report").IfNear("camp", Kilometre).Build()
+	res, err := NewRunner().RunRule(rule, 1, scout.RuleContext())
+	if err != nil || !res.Fired() {
+		t.Errorf("got fired %v and error %v, wanted the scout near the camp", res.Fired(), err)
+	}
+}
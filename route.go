@@ -0,0 +1,137 @@
+package rula
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrNoRoute is returned by Route when the locations are not connected.
+var ErrNoRoute = errors.New("no route")
+
+type routeKey struct {
+	from, to int64
+}
+
+type route struct {
+	conns  []*Connection
+	length Length
+	err    error
+}
+
+// Route returns the cheapest route from one location to another, as the
+// connections to follow in order, and its cost. The cost of a connection is
+// its distance weighted by its difficulty, so the cost of a route without
+// difficulties is its length. The route from a location to itself is empty.
+// Routes follow one-way connections only in their direction and avoid
+// impassable connections. It returns an error wrapping ErrNoRoute if there
+// is no route. Routes are
+// cached until the network changes.
+func (g *Graph) Route(from, to int64) ([]*Connection, Length, error) {
+	return g.RouteFor(from, to, nil)
+}
+
+// RouteFor is like Route but takes the difficulty of each connection from
+// fn, if it is not nil. Routes found with fn are not cached.
+func (g *Graph) RouteFor(from, to int64, fn DifficultyFunc) ([]*Connection, Length, error) {
+	if g.locations[from] == nil {
+		return nil, 0, fmt.Errorf("unknown location: %d", from)
+	}
+	if g.locations[to] == nil {
+		return nil, 0, fmt.Errorf("unknown location: %d", to)
+	}
+
+	if fn != nil {
+		r := g.shortest(from, to, fn)
+		return r.conns, r.length, r.err
+	}
+
+	key := routeKey{from, to}
+	r, ok := g.routes[key]
+	if !ok {
+		r = g.shortest(from, to, (*Connection).Difficulty)
+		if g.routes == nil {
+			g.routes = map[routeKey]route{}
+		}
+		g.routes[key] = r
+	}
+	return append([]*Connection(nil), r.conns...), r.length, r.err
+}
+
+// changed discards the cached routes after the network has changed.
+func (g *Graph) changed() {
+	g.routes = nil
+}
+
+// shortest finds the cheapest route between two locations using Dijkstra's
+// algorithm, with the difficulty of each connection given by fn.
+func (g *Graph) shortest(from, to int64, fn DifficultyFunc) route {
+	dist := map[int64]Length{from: 0}
+	via := map[int64]*Connection{}
+	done := map[int64]bool{}
+	q := &routeQueue{{id: from}}
+	for q.Len() > 0 {
+		item := heap.Pop(q).(routeItem)
+		if done[item.id] {
+			continue
+		}
+		done[item.id] = true
+		if item.id == to {
+			break
+		}
+		here := g.locations[item.id]
+		for _, c := range g.adjacent[item.id] {
+			diff := fn(c)
+			if !c.leads(here) || math.IsInf(diff, 1) {
+				continue
+			}
+			next := c.Other(here).id
+			d := item.dist + c.cost(diff)
+			if best, seen := dist[next]; !done[next] && (!seen || d < best) {
+				dist[next] = d
+				via[next] = c
+				heap.Push(q, routeItem{id: next, dist: d})
+			}
+		}
+	}
+
+	if !done[to] {
+		return route{err: fmt.Errorf("%w from %d to %d", ErrNoRoute, from, to)}
+	}
+	var conns []*Connection
+	for id := to; id != from; {
+		c := via[id]
+		conns = append(conns, c)
+		id = c.Other(g.locations[id]).id
+	}
+	for i, j := 0, len(conns)-1; i < j; i, j = i+1, j-1 {
+		conns[i], conns[j] = conns[j], conns[i]
+	}
+	return route{conns: conns, length: dist[to]}
+}
+
+type routeItem struct {
+	id   int64
+	dist Length
+}
+
+// A routeQueue orders locations by their cost from the start of a route,
+// then by ID so routes of equal cost are chosen consistently.
+type routeQueue []routeItem
+
+func (q routeQueue) Len() int { return len(q) }
+func (q routeQueue) Less(i, j int) bool {
+	if q[i].dist != q[j].dist {
+		return q[i].dist < q[j].dist
+	}
+	return q[i].id < q[j].id
+}
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x interface{}) { *q = append(*q, x.(routeItem)) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
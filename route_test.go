@@ -0,0 +1,103 @@
+package rula
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	n := NewNetwork()
+	a := n.AddLocation(Position{})
+	b := n.AddLocation(Position{})
+	c := n.AddLocation(Position{})
+	d := n.AddLocation(Position{})
+	island := n.AddLocation(Position{})
+
+	ab := n.Connect(a, b, 4*Kilometre)
+	bc := n.Connect(b, c, 3*Kilometre)
+	n.Connect(a, c, 10*Kilometre)
+	cd := n.Connect(d, c, 2*Kilometre)
+
+	conns, length, err := n.Route(a.ID(), d.ID())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if length != 9*Kilometre || len(conns) != 3 || conns[0] != ab || conns[1] != bc || conns[2] != cd {
+		t.Errorf("got route %v of length %d, wanted a-b-c-d of 9km", conns, length)
+	}
+
+	if conns, length, err := n.Route(b.ID(), b.ID()); err != nil || len(conns) != 0 || length != 0 {
+		t.Errorf("got route %v of length %d and error %v to the same location, wanted an empty route", conns, length, err)
+	}
+	if _, _, err := n.Route(a.ID(), island.ID()); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("got error %v, wanted ErrNoRoute", err)
+	}
+	if _, _, err := n.Route(a.ID(), 99); err == nil {
+		t.Errorf("got no error for unknown location, wanted one")
+	}
+
+	// A new connection replaces the cached route
+	ad := n.Connect(a, d, 5*Kilometre)
+	conns, length, _ = n.Route(a.ID(), d.ID())
+	if length != 5*Kilometre || len(conns) != 1 || conns[0] != ad {
+		t.Errorf("got route %v of length %d after connecting, wanted the direct connection", conns, length)
+	}
+	if _, _, err := n.Route(island.ID(), a.ID()); err == nil {
+		t.Errorf("got route from island, wanted none")
+	}
+}
+
+func TestRouteDifficulty(t *testing.T) {
+	n := NewNetwork()
+	port := n.AddLocation(Position{})
+	town := n.AddLocation(Position{})
+	road := n.Connect(port, town, 4*Kilometre)
+	sea := n.Connect(port, town, 6*Kilometre)
+
+	// A flooded road costs three times its distance
+	n.SetDifficulty(road, 2)
+	conns, cost, _ := n.Route(port.ID(), town.ID())
+	if cost != 6*Kilometre || len(conns) != 1 || conns[0] != sea {
+		t.Errorf("got route %v of cost %d, wanted the sea route of 6km", conns, cost)
+	}
+
+	// An amphibious traveller is not slowed by the flood
+	amphibious := func(c *Connection) float64 { return 0 }
+	conns, cost, _ = n.RouteFor(port.ID(), town.ID(), amphibious)
+	if cost != 4*Kilometre || len(conns) != 1 || conns[0] != road {
+		t.Errorf("got route %v of cost %d ignoring difficulty, wanted the road of 4km", conns, cost)
+	}
+
+	n.SetDifficulty(road, 0.25)
+	if _, cost, _ := n.Route(port.ID(), town.ID()); cost != 5*Kilometre {
+		t.Errorf("got cost %d after road repaired, wanted 5km", cost)
+	}
+}
+
+func TestRouteOneWayAndKinds(t *testing.T) {
+	n := NewNetwork()
+	source := n.AddLocation(Position{})
+	mouth := n.AddLocation(Position{})
+	river := n.ConnectOneWay(source, mouth, 10*Kilometre)
+	n.SetKind(river, "river")
+	road := n.Connect(source, mouth, 12*Kilometre)
+	n.SetKind(road, "road")
+
+	if conns, _, _ := n.Route(source.ID(), mouth.ID()); len(conns) != 1 || conns[0] != river {
+		t.Errorf("got route %v downstream, wanted the river", conns)
+	}
+	if conns, _, _ := n.Route(mouth.ID(), source.ID()); len(conns) != 1 || conns[0] != road {
+		t.Errorf("got route %v upstream, wanted the road", conns)
+	}
+
+	boat := NewAgent("boat")
+	boat.Kinds = []string{"river", "sea"}
+	if _, _, err := n.RouteFor(mouth.ID(), source.ID(), boat.routeDifficulty()); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("got error %v for boat upstream, wanted ErrNoRoute", err)
+	}
+
+	n.SetDifficulty(river, Impassable)
+	if conns, _, _ := n.Route(source.ID(), mouth.ID()); len(conns) != 1 || conns[0] != road {
+		t.Errorf("got route %v with the river impassable, wanted the road", conns)
+	}
+}
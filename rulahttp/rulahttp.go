@@ -0,0 +1,329 @@
+// Package rulahttp serves an HTTP API for controlling a running rula World,
+// so that dashboards and remote clients can drive a simulation.
+//
+// The endpoints are:
+//
+//	POST /tick?n=N              advance the world N ticks, 1 if n is omitted
+//	GET  /snapshot              the world as a rula.WorldSnapshot
+//	GET  /pools/OWNER           the pools of an agent, by ID, or of "global"
+//	PUT  /pools/OWNER/RESOURCE  set the quantity of a pool from {"quantity": n}
+//	POST /rules/OWNER/RULE      trigger a rule of an agent or the global rules
+//	GET  /events                a stream of Events as server-sent events
+//
+// Responses are JSON. Errors are reported with an HTTP status and a plain
+// text message.
+package rulahttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/iand/rula"
+)
+
+// An Event is something that happened in the world, sent to clients of the
+// events stream. Type is one of tick, fired, spawn, destroy or trade.
+type Event struct {
+	Type     string `json:"type"`
+	Tick     int64  `json:"tick"`
+	Agent    string `json:"agent,omitempty"`    // the agent spawned or destroyed, or the buyer in a trade
+	Parent   string `json:"parent,omitempty"`   // the agent that spawned the agent
+	Seller   string `json:"seller,omitempty"`   // the seller in a trade
+	Rule     string `json:"rule,omitempty"`     // the rule that fired
+	Rounds   int    `json:"rounds,omitempty"`   // the rounds the rule completed
+	Resource string `json:"resource,omitempty"` // the resource traded
+	Quantity int    `json:"quantity,omitempty"` // the quantity traded
+}
+
+// A Server is an http.Handler that controls a world. Requests are handled one
+// at a time so the world need not be safe for concurrent use, but the world
+// must not be changed elsewhere while the server is in use.
+type Server struct {
+	mu    sync.Mutex // guards world
+	world *rula.World
+	mux   *http.ServeMux
+
+	subsMu sync.Mutex
+	subs   map[chan Event]bool
+}
+
+// eventBuffer is the number of events held for a slow client of the events
+// stream before further events are dropped.
+const eventBuffer = 64
+
+// New returns a server controlling w. It registers hooks on w and its runner
+// to publish events.
+func New(w *rula.World) *Server {
+	s := &Server{
+		world: w,
+		mux:   http.NewServeMux(),
+		subs:  map[chan Event]bool{},
+	}
+	s.mux.HandleFunc("/tick", s.handleTick)
+	s.mux.HandleFunc("/snapshot", s.handleSnapshot)
+	s.mux.HandleFunc("/pools/", s.handlePools)
+	s.mux.HandleFunc("/rules/", s.handleRules)
+	s.mux.HandleFunc("/events", s.handleEvents)
+
+	w.Runner().OnRuleFired(func(res *rula.RuleResult) {
+		s.publish(Event{Type: "fired", Tick: w.Time(), Rule: res.Rule.Name, Rounds: res.Rounds})
+	})
+	w.OnSpawn(func(parent, child *rula.Agent) {
+		e := Event{Type: "spawn", Tick: w.Time(), Agent: child.ID}
+		if parent != nil {
+			e.Parent = parent.ID
+		}
+		s.publish(e)
+	})
+	w.OnDestroy(func(a *rula.Agent) {
+		s.publish(Event{Type: "destroy", Tick: w.Time(), Agent: a.ID})
+	})
+	w.OnTrade(func(t rula.Trade) {
+		s.publish(Event{
+			Type:     "trade",
+			Tick:     w.Time(),
+			Agent:    t.Buyer.ID,
+			Seller:   t.Seller.ID,
+			Resource: label(t.Resource),
+			Quantity: t.Quantity,
+		})
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleTick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n := 1
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid tick count %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		if _, err := s.world.Tick(); err != nil {
+			http.Error(w, fmt.Sprintf("tick %d: %v", s.world.Time(), err), http.StatusInternalServerError)
+			return
+		}
+		s.publish(Event{Type: "tick", Tick: s.world.Time()})
+	}
+	writeJSON(w, map[string]int64{"tick": s.world.Time()})
+}
+
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, s.world.Snapshot())
+}
+
+func (s *Server) handlePools(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/pools/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.pools(parts[0])
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown owner %q", parts[0]), http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, poolSnapshots(parts[0], ps))
+	case len(parts) == 2 && r.Method == http.MethodPut:
+		res := findResource(ps, parts[1])
+		if res == nil {
+			http.Error(w, fmt.Sprintf("unknown pool %q", parts[1]), http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Quantity *int `json:"quantity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Quantity == nil {
+			http.Error(w, "body must be a JSON object with a quantity", http.StatusBadRequest)
+			return
+		}
+		ps.Set(res, *body.Quantity)
+		writeJSON(w, rula.PoolSnapshot{
+			Agent:    agentOf(parts[0]),
+			Resource: parts[1],
+			Quantity: ps.Quantity(res),
+			Capacity: ps.Capacity(res),
+		})
+	case len(parts) > 2:
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rules/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var a *rula.Agent
+	if parts[0] != "global" {
+		var ok bool
+		if a, ok = s.world.Agent(parts[0]); !ok {
+			http.Error(w, fmt.Sprintf("unknown owner %q", parts[0]), http.StatusNotFound)
+			return
+		}
+	}
+	res, err := s.world.Trigger(a, parts[1])
+	if res == nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, struct {
+		Rule   string `json:"rule"`
+		Fired  bool   `json:"fired"`
+		Rounds int    `json:"rounds"`
+	}{res.Rule.Name, res.Fired(), res.Rounds})
+}
+
+// handleEvents streams events to the client until it disconnects.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan Event, eventBuffer)
+	s.subsMu.Lock()
+	s.subs[ch] = true
+	s.subsMu.Unlock()
+	defer func() {
+		s.subsMu.Lock()
+		delete(s.subs, ch)
+		s.subsMu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// publish sends e to every client of the events stream, dropping it for
+// clients that have fallen behind.
+func (s *Server) publish(e Event) {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// pools returns the pools of the named owner, an agent ID or "global".
+func (s *Server) pools(owner string) (*rula.PoolSet, bool) {
+	if owner == "global" {
+		return s.world.Pools(), true
+	}
+	a, ok := s.world.Agent(owner)
+	if !ok {
+		return nil, false
+	}
+	return a.Pools, true
+}
+
+func poolSnapshots(owner string, ps *rula.PoolSet) []rula.PoolSnapshot {
+	pools := []rula.PoolSnapshot{}
+	for _, p := range ps.Snapshot() {
+		pools = append(pools, rula.PoolSnapshot{
+			Agent:    agentOf(owner),
+			Resource: label(p.Resource),
+			Quantity: p.Quantity,
+			Capacity: p.Capacity,
+		})
+	}
+	return pools
+}
+
+// agentOf returns the agent ID recorded in a pool snapshot for owner.
+func agentOf(owner string) string {
+	if owner == "global" {
+		return ""
+	}
+	return owner
+}
+
+func findResource(ps *rula.PoolSet, name string) *rula.Resource {
+	for _, p := range ps.Snapshot() {
+		if label(p.Resource) == name {
+			return p.Resource
+		}
+	}
+	return nil
+}
+
+// label returns the ID of r or, if it has none, its singular name, as used
+// in snapshots.
+func label(r *rula.Resource) string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Name.Singular
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
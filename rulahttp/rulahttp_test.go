@@ -0,0 +1,158 @@
+package rulahttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/iand/rula"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	sc, err := rula.NewScenarioParser().Parse(strings.NewReader(`
+resource grain
+end
+
+rule farm
+	out grain 2
+end
+
+rule harvest
+	in grain 5
+	out global grain 5
+end
+
+global world
+	pool grain 100
+end
+
+agent farmer
+	pool grain 100 1
+	rules farm
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	harvest := sc.Rules[1]
+	harvest.Period, harvest.Manual = 0, true
+	w := rula.NewScenarioWorld(sc)
+	a, _ := w.Agent("farmer")
+	a.AppendRules([]*rula.Rule{harvest})
+
+	srv := httptest.NewServer(New(w))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, method, url, body string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decode %s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	srv := newTestServer(t)
+
+	var tick map[string]int64
+	if code := do(t, "POST", srv.URL+"/tick?n=2", "", &tick); code != http.StatusOK || tick["tick"] != 2 {
+		t.Fatalf("got status %d and %v, wanted tick 2", code, tick)
+	}
+
+	var pools []rula.PoolSnapshot
+	do(t, "GET", srv.URL+"/pools/farmer", "", &pools)
+	want := []rula.PoolSnapshot{{Agent: "farmer", Resource: "grain", Quantity: 5, Capacity: 100}}
+	if diff := cmp.Diff(want, pools); diff != "" {
+		t.Errorf("pools mismatch (-want +got):\n%s", diff)
+	}
+
+	var pool rula.PoolSnapshot
+	do(t, "PUT", srv.URL+"/pools/farmer/grain", `{"quantity": 9}`, &pool)
+	if pool.Quantity != 9 {
+		t.Errorf("got quantity %d after setting, wanted 9", pool.Quantity)
+	}
+
+	var res struct {
+		Fired  bool
+		Rounds int
+	}
+	do(t, "POST", srv.URL+"/rules/farmer/harvest", "", &res)
+	if !res.Fired {
+		t.Errorf("got harvest not fired, wanted it to fire")
+	}
+	do(t, "GET", srv.URL+"/pools/global", "", &pools)
+	if len(pools) != 1 || pools[0].Quantity != 5 {
+		t.Errorf("got global pools %v, wanted 5 grain", pools)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/tick", "", http.StatusMethodNotAllowed},
+		{"POST", "/tick?n=x", "", http.StatusBadRequest},
+		{"GET", "/pools/nobody", "", http.StatusNotFound},
+		{"PUT", "/pools/farmer/iron", `{"quantity": 1}`, http.StatusNotFound},
+		{"PUT", "/pools/farmer/grain", `{}`, http.StatusBadRequest},
+		{"POST", "/rules/farmer/sow", "", http.StatusNotFound},
+	} {
+		if got := do(t, tc.method, srv.URL+tc.path, tc.body, nil); got != tc.want {
+			t.Errorf("%s %s: got status %d, wanted %d", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	srv := newTestServer(t)
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	do(t, "POST", srv.URL+"/tick", "", nil)
+
+	var events []Event
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		events = append(events, e)
+		if e.Type == "tick" {
+			break
+		}
+	}
+
+	want := []Event{
+		{Type: "fired", Tick: 1, Rule: "farm", Rounds: 1},
+		{Type: "tick", Tick: 1},
+	}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,138 @@
+package rulatest
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/iand/rula"
+)
+
+// A Topology describes how the agents of a generated world are related.
+type Topology int
+
+const (
+	// Isolated agents only use their own pools and the global pools.
+	Isolated Topology = iota
+
+	// Ring relates each agent to the next as its neighbour, the last agent
+	// being related to the first.
+	Ring
+
+	// Hub relates every agent to a single hub agent as its location, so all
+	// agents share the hub's pools.
+	Hub
+
+	// Random relates each agent to another chosen at random as its
+	// neighbour.
+	Random
+)
+
+var topologyNames = [...]string{"isolated", "ring", "hub", "random"}
+
+func (t Topology) String() string {
+	if t >= 0 && int(t) < len(topologyNames) {
+		return topologyNames[t]
+	}
+	return fmt.Sprintf("Topology(%d)", int(t))
+}
+
+// RelationNeighbour is the relation between agents in Ring and Random worlds.
+const RelationNeighbour rula.Relation = "neighbour"
+
+// A WorldSpec describes a world to generate.
+type WorldSpec struct {
+	Agents    int // number of agents, not counting the hub of a Hub world
+	Rules     int // number of rules, each run by every agent
+	Resources int // number of resources, at least 2
+	Topology  Topology
+	Seed      int64 // seeds the initial quantities and Random relations
+
+	// Dense gives every agent a pool set created by rula.NewDensePoolSet
+	// sharing one registry of the generated resources.
+	Dense bool
+}
+
+// Generate returns a scenario holding a world built to spec. Every agent
+// holds a pool of each resource. Rule i converts resource i into resource
+// i+1, wrapping around, and every third rule also trades with the global
+// pools or, if the topology relates agents, with the related agent, so the
+// quantities circulate without running down.
+func Generate(spec WorldSpec) *rula.Scenario {
+	if spec.Resources < 2 {
+		spec.Resources = 2
+	}
+	rng := rand.New(rand.NewSource(spec.Seed))
+
+	sc := &rula.Scenario{
+		Global:    rula.NewGlobal(nil),
+		Locations: map[string]*rula.Location{},
+	}
+	for i := 0; i < spec.Resources; i++ {
+		id := fmt.Sprintf("r%d", i)
+		sc.Resources = append(sc.Resources, &rula.Resource{ID: id, Name: rula.Name{Singular: id}})
+	}
+
+	var reg *rula.ResourceRegistry
+	if spec.Dense {
+		reg = rula.NewResourceRegistry(sc.Resources...)
+	}
+	newAgent := func(name string) *rula.Agent {
+		a := rula.NewAgent(name)
+		if reg != nil {
+			a.Pools = rula.NewDensePoolSet(reg)
+		}
+		for _, r := range sc.Resources {
+			a.AddPool(r, 1000, rng.Intn(500))
+		}
+		return a
+	}
+
+	for _, r := range sc.Resources {
+		sc.Global.Pools.AddPool(r, 1<<30, 1<<29)
+	}
+
+	trade := rula.RelationGlobal
+	switch spec.Topology {
+	case Ring, Random:
+		trade = RelationNeighbour
+	case Hub:
+		trade = rula.RelationLocation
+	}
+	for i := 0; i < spec.Rules; i++ {
+		from := sc.Resources[i%spec.Resources]
+		to := sc.Resources[(i+1)%spec.Resources]
+		b := rula.NewRule(fmt.Sprintf("rule%d", i)).
+			In(rula.RelationSelf, from, 2).
+			Out(rula.RelationSelf, to, 2)
+		if i%3 == 2 {
+			b = b.In(trade, to, 1).Out(rula.RelationSelf, to, 1)
+			b = b.In(rula.RelationSelf, from, 1).Out(trade, from, 1)
+		}
+		sc.Rules = append(sc.Rules, b.Build())
+	}
+
+	for i := 0; i < spec.Agents; i++ {
+		a := newAgent(fmt.Sprintf("agent%d", i))
+		a.AppendRules(sc.Rules)
+		sc.Agents = append(sc.Agents, a)
+	}
+
+	switch spec.Topology {
+	case Ring:
+		for i, a := range sc.Agents {
+			a.AddRelation(RelationNeighbour, sc.Agents[(i+1)%len(sc.Agents)])
+		}
+	case Random:
+		for _, a := range sc.Agents {
+			a.AddRelation(RelationNeighbour, sc.Agents[rng.Intn(len(sc.Agents))])
+		}
+	case Hub:
+		hub := newAgent("hub")
+		for _, a := range sc.Agents {
+			a.AddRelation(rula.RelationLocation, hub)
+		}
+		sc.Agents = append(sc.Agents, hub)
+	}
+
+	return sc
+}
@@ -0,0 +1,96 @@
+package rulatest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/iand/rula"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, topo := range []Topology{Isolated, Ring, Hub, Random} {
+		t.Run(fmt.Sprint(topo), func(t *testing.T) {
+			sc := Generate(WorldSpec{Agents: 10, Rules: 6, Resources: 4, Topology: topo, Seed: 1})
+
+			wantAgents := 10
+			if topo == Hub {
+				wantAgents++
+			}
+			if len(sc.Agents) != wantAgents || len(sc.Rules) != 6 || len(sc.Resources) != 4 {
+				t.Fatalf("got %d agents, %d rules and %d resources", len(sc.Agents), len(sc.Rules), len(sc.Resources))
+			}
+			for _, r := range sc.Rules {
+				if err := r.Validate(RelationNeighbour); err != nil {
+					t.Errorf("generated invalid rule: %v", err)
+				}
+			}
+
+			w := rula.NewScenarioWorld(sc)
+			for i := 0; i < 5; i++ {
+				report, err := w.Tick()
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if fired := countFired(report); fired == 0 {
+					t.Errorf("tick %d: no rules fired", w.Time())
+				}
+			}
+		})
+	}
+}
+
+func countFired(report *rula.StepReport) int {
+	n := 0
+	for _, rr := range report.Agents {
+		for _, res := range rr.Results {
+			if res.Fired() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// benchmarkWorld steps a generated world, reporting ticks per second
+// alongside the usual measurements.
+func benchmarkWorld(b *testing.B, spec WorldSpec, opts ...rula.RunnerOption) {
+	w := rula.NewScenarioWorld(Generate(spec), opts...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Tick(); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "ticks/s")
+}
+
+func BenchmarkStep(b *testing.B) {
+	sizes := []struct{ agents, rules, resources int }{
+		{10, 5, 5},
+		{1000, 5, 5},
+		{1000, 20, 50},
+	}
+	for _, size := range sizes {
+		for _, topo := range []Topology{Isolated, Ring, Hub} {
+			spec := WorldSpec{Agents: size.agents, Rules: size.rules, Resources: size.resources, Topology: topo}
+			b.Run(fmt.Sprintf("agents=%d/rules=%d/resources=%d/%s", size.agents, size.rules, size.resources, topo), func(b *testing.B) {
+				benchmarkWorld(b, spec)
+			})
+		}
+	}
+}
+
+func BenchmarkStepDense(b *testing.B) {
+	benchmarkWorld(b, WorldSpec{Agents: 1000, Rules: 20, Resources: 50, Topology: Ring, Dense: true})
+}
+
+func BenchmarkStepParallel(b *testing.B) {
+	benchmarkWorld(b, WorldSpec{Agents: 1000, Rules: 20, Resources: 50}, rula.WithParallel(4))
+}
+
+func BenchmarkStepSkipUnchanged(b *testing.B) {
+	benchmarkWorld(b, WorldSpec{Agents: 1000, Rules: 20, Resources: 50}, rula.WithSkipUnchanged())
+}
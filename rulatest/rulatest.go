@@ -0,0 +1,92 @@
+// Package rulatest provides helpers for testing rules and code built on rula.
+package rulatest
+
+import (
+	"testing"
+
+	"github.com/iand/rula"
+)
+
+// A ContextBuilder builds a rule context one pool at a time.
+//
+//	ctx := rulatest.Context().WithSelf(iron, 10, 100).WithGlobal(food, 50, 50).Build()
+type ContextBuilder struct {
+	ctx rula.RuleContext
+}
+
+// Context starts building a rule context with no pools.
+func Context() *ContextBuilder {
+	return &ContextBuilder{
+		ctx: rula.RuleContext{
+			Pools: map[rula.Relation]*rula.PoolSet{},
+		},
+	}
+}
+
+// With adds a pool of resource r holding quantity q with capacity c to the
+// pool set for the relation, creating the pool set if needed.
+func (b *ContextBuilder) With(rel rula.Relation, r *rula.Resource, q, c int) *ContextBuilder {
+	ps, ok := b.ctx.Pools[rel]
+	if !ok {
+		ps = rula.NewPoolSet()
+		b.ctx.Pools[rel] = ps
+	}
+	ps.AddPool(r, c, q)
+	return b
+}
+
+// WithSelf adds a pool holding quantity q with capacity c to the self pool set.
+func (b *ContextBuilder) WithSelf(r *rula.Resource, q, c int) *ContextBuilder {
+	return b.With(rula.RelationSelf, r, q, c)
+}
+
+// WithGlobal adds a pool holding quantity q with capacity c to the global pool set.
+func (b *ContextBuilder) WithGlobal(r *rula.Resource, q, c int) *ContextBuilder {
+	return b.With(rula.RelationGlobal, r, q, c)
+}
+
+// WithLocation adds a pool holding quantity q with capacity c to the location pool set.
+func (b *ContextBuilder) WithLocation(r *rula.Resource, q, c int) *ContextBuilder {
+	return b.With(rula.RelationLocation, r, q, c)
+}
+
+// WithPoolSet uses ps as the pool set for the relation, replacing any pools
+// already added for it.
+func (b *ContextBuilder) WithPoolSet(rel rula.Relation, ps *rula.PoolSet) *ContextBuilder {
+	b.ctx.Pools[rel] = ps
+	return b
+}
+
+// Build returns the rule context. The context shares its pools with the
+// builder so further pools added to the builder appear in the context.
+func (b *ContextBuilder) Build() rula.RuleContext {
+	return b.ctx
+}
+
+// AssertQuantity reports a test error if the quantity of resource r in the
+// pool set for the relation is not want.
+func AssertQuantity(tb testing.TB, ctx rula.RuleContext, rel rula.Relation, r *rula.Resource, want int) {
+	tb.Helper()
+	ps, ok := ctx.Pools[rel]
+	if !ok {
+		tb.Errorf("no %s pool set in context", rel)
+		return
+	}
+	if got := ps.Quantity(r); got != want {
+		tb.Errorf("got %s %s quantity %d, wanted %d", rel, r, got, want)
+	}
+}
+
+// AssertCapacity reports a test error if the capacity of resource r in the
+// pool set for the relation is not want.
+func AssertCapacity(tb testing.TB, ctx rula.RuleContext, rel rula.Relation, r *rula.Resource, want int) {
+	tb.Helper()
+	ps, ok := ctx.Pools[rel]
+	if !ok {
+		tb.Errorf("no %s pool set in context", rel)
+		return
+	}
+	if got := ps.Capacity(r); got != want {
+		tb.Errorf("got %s %s capacity %d, wanted %d", rel, r, got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package rulatest
+
+import (
+	"testing"
+
+	"github.com/iand/rula"
+)
+
+var (
+	ore  = &rula.Resource{ID: "iron_ore", Name: rula.Name{Singular: "iron_ore"}}
+	iron = &rula.Resource{ID: "iron", Name: rula.Name{Singular: "iron"}}
+)
+
+func TestContext(t *testing.T) {
+	ctx := Context().
+		WithSelf(ore, 10, 100).
+		WithGlobal(iron, 2, 50).
+		Build()
+
+	smelt := rula.NewRule("smelt").In(rula.RelationSelf, ore, 3).Out(rula.RelationGlobal, iron, 1).Build()
+	if _, err := rula.NewRunner().Run([]*rula.Rule{smelt}, 1, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	AssertQuantity(t, ctx, rula.RelationSelf, ore, 7)
+	AssertQuantity(t, ctx, rula.RelationGlobal, iron, 3)
+	AssertCapacity(t, ctx, rula.RelationGlobal, iron, 50)
+}
+
+// recorder counts the errors reported to it.
+type recorder struct {
+	testing.TB
+	errors int
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors++
+}
+
+func TestAssertQuantityFails(t *testing.T) {
+	ctx := Context().WithSelf(ore, 1, 1).Build()
+
+	rec := &recorder{TB: t}
+	AssertQuantity(rec, ctx, rula.RelationSelf, ore, 2)
+	AssertQuantity(rec, ctx, rula.RelationLocation, ore, 1)
+	if rec.errors != 2 {
+		t.Errorf("got %d errors reported, wanted 2", rec.errors)
+	}
+}
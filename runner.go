@@ -1,181 +1,836 @@
 package rula
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"math/rand"
+	"sort"
+	"sync"
 )
 
+// A Logger receives diagnostic messages from a Runner. It is satisfied by
+// *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// A RunnerOption configures a Runner.
+type RunnerOption func(*Runner)
+
+// WithLogger sets the logger used for the runner's diagnostics. By default
+// diagnostics are discarded.
+func WithLogger(l Logger) RunnerOption {
+	return func(ru *Runner) {
+		if l == nil {
+			l = nopLogger{}
+		}
+		ru.logger = l
+	}
+}
+
+// A stateKey identifies the state of a rule run on behalf of an agent. Rules
+// run with a context that has no agent share a nil agent.
+type stateKey struct {
+	agent *Agent
+	rule  *Rule
+}
+
+// WithFixpoint enables fixpoint mode in which Run keeps running the due rules
+// within a tick until none of them fire, so that intermediate products made by
+// one rule are consumed by others in the same tick. At most maxPasses passes
+// are made over the rules.
+func WithFixpoint(maxPasses int) RunnerOption {
+	return func(ru *Runner) {
+		ru.fixpoint = maxPasses
+	}
+}
+
+// WithMaxRounds limits the number of rounds a rule may run each time it is
+// invoked, regardless of its repeat count.
+func WithMaxRounds(n int) RunnerOption {
+	return func(ru *Runner) {
+		ru.maxRounds = n
+	}
+}
+
+// WithTickBudget limits the total number of rounds of all rules that may be
+// run in a tick, across all calls to Run and Step for that tick. Rules that
+// are due once the budget is spent do not run.
+func WithTickBudget(n int) RunnerOption {
+	return func(ru *Runner) {
+		ru.tickBudget = n
+	}
+}
+
 type Runner struct {
-	ruleStates map[*Rule]RuleState
+	ruleStates map[stateKey]RuleState
+	logger     Logger
+	hooks      hooks
+	src        rand.Source
+	rand       *rand.Rand
+	fixpoint   int // maximum passes over the rules per Run, fixpoint mode is off if less than 2
+
+	maxRounds  int   // maximum rounds per rule invocation, unlimited if 0
+	tickBudget int   // maximum rounds over all rules per tick, unlimited if 0
+	budgetTick int64 // the tick budgetUsed applies to
+	budgetUsed int
+
+	parallel int               // number of agents Step may run concurrently
+	shared   map[*PoolSet]bool // pool sets that may be used concurrently during a parallel step
+	sharedMu sync.Mutex        // serializes rules that use shared pool sets
+	mu       sync.Mutex        // guards ruleStates, the budget, rand and counters
+
+	counters counters
+	replay   *replayLog
+	watch    watchers
+	history  *History
+	recorder *Recorder
+
+	overflow   Overflow // policy for rules without their own
+	overflowFn func(OverflowEvent)
+
+	checked map[*Rule]error // result of validating each rule the runner has seen
+
+	blocked map[stateKey]blockedRule // rules to skip while unchanged, nil unless enabled
+
+	rated     bool  // whether resource rates have been applied in a step
+	ratedTick int64 // the last tick resource rates were applied
+
+	stagger bool // whether agents without a phase are given one derived from their ID
+
+	// destination resolves the locations rules move agents to, set when
+	// the runner is used by a World
+	destination func(a *Agent, name string) (*Location, error)
+}
+
+func (ru *Runner) state(key stateKey) RuleState {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	return ru.ruleStates[key]
+}
+
+func (ru *Runner) setState(key stateKey, state RuleState) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	ru.ruleStates[key] = state
+}
+
+// Forget discards the state the runner keeps for the rules of an agent that
+// has left the simulation.
+func (ru *Runner) Forget(a *Agent) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	for key := range ru.ruleStates {
+		if key.agent == a {
+			delete(ru.ruleStates, key)
+		}
+	}
+	for key := range ru.blocked {
+		if key.agent == a {
+			delete(ru.blocked, key)
+		}
+	}
+}
+
+// ForgetRule discards the state the runner keeps for a rule run for an agent,
+// such as when the rule has been removed from the agent.
+func (ru *Runner) ForgetRule(a *Agent, rule *Rule) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	key := stateKey{agent: a, rule: rule}
+	delete(ru.ruleStates, key)
+	delete(ru.blocked, key)
+}
+
+func NewRunner(opts ...RunnerOption) *Runner {
+	ru := &Runner{
+		ruleStates: map[stateKey]RuleState{},
+		logger:     nopLogger{},
+		checked:    map[*Rule]error{},
+	}
+	WithSeed(0)(ru)
+	for _, opt := range opts {
+		opt(ru)
+	}
+	return ru
+}
+
+// A RunReport describes the outcome of running a set of rules for one tick.
+type RunReport struct {
+	Tick    int64
+	Results []*RuleResult // one for each rule with a non-zero period, in order
+
+	// Passes is the number of times the rules were run, which is only
+	// greater than one in fixpoint mode. Exhausted is true if fixpoint
+	// evaluation stopped at the pass limit while rules were still firing.
+	Passes    int
+	Exhausted bool
+
+	// BudgetExhausted is true if any rule was cut short by the runner's
+	// round or tick budget.
+	BudgetExhausted bool
+
+	// Next is the index of the first rule not run, which is the number of
+	// rules unless the run was cancelled or failed.
+	Next int
+}
+
+// Result returns the result for rule or nil if the rule was not run.
+func (rr *RunReport) Result(rule *Rule) *RuleResult {
+	for _, res := range rr.Results {
+		if res.Rule == rule {
+			return res
+		}
+	}
+	return nil
 }
 
-func NewRunner() *Runner {
-	return &Runner{
-		ruleStates: map[*Rule]RuleState{},
+// A RuleResult describes the outcome of an attempt to run a rule.
+type RuleResult struct {
+	Rule    *Rule
+	Due     bool        // false if the rule's period had not elapsed so it was not attempted
+	Rounds  int         // number of rounds completed, over all passes in fixpoint mode
+	Blocked *Block      // what stopped the rule running, nil if every round completed
+	OnFail  *RuleResult // result of running the onfail rule, if it was invoked
+}
+
+// Fired reports whether the rule completed at least one round.
+func (r *RuleResult) Fired() bool {
+	return r.Rounds > 0
+}
+
+// A Block describes what stopped a rule from running. Exactly one of
+// Attr, Near, Condition, Full, Precondition, Input, Overflow, Relation,
+// Chance or Budget is set.
+type Block struct {
+	Attr         *AttrCondition     // an attribute condition that did not hold
+	Value        string             // the value of the attribute found
+	Near         *NearCondition     // a near condition that did not hold
+	Distance     Length             // the distance found for a near condition
+	Missing      bool               // either agent of a near condition had no position or location
+	Condition    Condition          // a custom condition that did not hold
+	Full         *Location          // the location the rule moves the agent to, which has no space for it
+	Precondition *ResourceCondition // a precondition that did not hold
+	Input        *ResourceSpecifier // an input that was not available in sufficient quantity
+	Overflow     *ResourceSpecifier // an output that would exceed its pool's capacity under OverflowFail
+	Relation     Relation           // a relation that had no pool set in the rule context
+	Carry        Relation           // a relation whose pool set's carry limit the round would exceed
+	Chance       bool               // the rule's chance of running did not come up
+	Budget       bool               // the runner's round or tick budget was spent
+	Quantity     int                // the quantity of the resource found in the pool
+}
+
+func (b *Block) String() string {
+	switch {
+	case b.Attr != nil:
+		return fmt.Sprintf("attribute %s not met, found %q", b.Attr, b.Value)
+	case b.Near != nil && b.Missing:
+		return fmt.Sprintf("near condition %s not met, no position", b.Near)
+	case b.Near != nil:
+		return fmt.Sprintf("near condition %s not met, found %s", b.Near, formatLength(b.Distance))
+	case b.Condition != nil:
+		return conditionLabel(b.Condition) + " not met"
+	case b.Full != nil:
+		return fmt.Sprintf("location %d is full, found space for %d", b.Full.ID(), b.Quantity)
+	case b.Precondition != nil:
+		c := b.Precondition
+		return fmt.Sprintf("precondition %s %s %s %d not met, found %d", c.Relation, c.Resource, c.Op, c.Quantity, b.Quantity)
+	case b.Input != nil:
+		return fmt.Sprintf("not enough %s %s, found %d wanted %d", b.Input.Relation, b.Input.Resource, b.Quantity, b.Input.Quantity)
+	case b.Overflow != nil:
+		return fmt.Sprintf("%s %s would overflow, found %d adding %d", b.Overflow.Relation, b.Overflow.Resource, b.Quantity, b.Overflow.Quantity)
+	case b.Carry != "":
+		return b.carryString()
+	case b.Chance:
+		return "chance not met"
+	case b.Budget:
+		return "budget exhausted"
 	}
+	return fmt.Sprintf("no poolset of type %v", b.Relation)
+}
+
+// Run runs each rule with a non-zero period that is due at tick. In fixpoint
+// mode the due rules are run repeatedly until none of them fire.
+func (ru *Runner) Run(rules []*Rule, tick int64, ctx RuleContext) (*RunReport, error) {
+	return ru.RunContext(context.Background(), rules, tick, ctx)
 }
 
-func (ru *Runner) Run(rules []*Rule, tick int64, ctx RuleContext) error {
-	for _, r := range rules {
+// RunContext is like Run but stops before running the next rule once ctx is
+// done, returning the partial report and the context's error. The report's
+// Next field is the index of the first rule that was not run. Since rules
+// that have run are not due again in the same tick, running the same rules
+// again for the same tick resumes where the cancelled run stopped.
+func (ru *Runner) RunContext(ctx context.Context, rules []*Rule, tick int64, rctx RuleContext) (*RunReport, error) {
+	report := &RunReport{Tick: tick, Passes: 1}
+	for i, r := range rules {
+		if err := ctx.Err(); err != nil {
+			report.Next = i
+			return report, err
+		}
 		if r.Period == 0 {
 			continue
 		}
 
-		if err := ru.RunRule(r, tick, ctx); err != nil {
-			return err
+		res, err := ru.RunRule(r, tick, rctx)
+		if res != nil {
+			report.Results = append(report.Results, res)
+			report.noteBudget(res)
+		}
+		if err != nil {
+			report.Next = i
+			return report, err
+		}
+	}
+	report.Next = len(rules)
+
+	if ru.fixpoint > 1 {
+		return report, ru.runFixpoint(ctx, report, rctx)
+	}
+	return report, nil
+}
+
+func (rr *RunReport) noteBudget(res *RuleResult) {
+	if res.Blocked != nil && res.Blocked.Budget {
+		rr.BudgetExhausted = true
+	}
+}
+
+// runFixpoint reruns the rules that were due in the first pass of report
+// until a pass in which none of them fire or the pass limit is reached.
+// Rules that did not come up by chance are not rerun.
+func (ru *Runner) runFixpoint(ctx context.Context, report *RunReport, rctx RuleContext) error {
+	var due []*RuleResult
+	progress := false
+	for _, res := range report.Results {
+		if !res.Due || (res.Blocked != nil && res.Blocked.Chance) {
+			continue
+		}
+		due = append(due, res)
+		progress = progress || res.Fired()
+	}
+
+	for progress {
+		if report.Passes == ru.fixpoint {
+			report.Exhausted = true
+			return nil
+		}
+		report.Passes++
+
+		progress = false
+		for _, res := range due {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rr, err := ru.rerun(res.Rule, report.Tick, rctx)
+			res.Rounds += rr.Rounds
+			res.Blocked = rr.Blocked
+			report.noteBudget(rr)
+			if err != nil {
+				return err
+			}
+			progress = progress || rr.Fired()
 		}
 	}
 	return nil
 }
 
-func (ru *Runner) RunRule(rule *Rule, tick int64, ctx RuleContext) error {
-	state := ru.ruleStates[rule]
-	if state.LastRun+int64(rule.Period) > tick {
-		return nil
+// expand returns rule as it runs in ctx, with its exchanges priced and its
+// shipments taken as inputs.
+func expand(rule *Rule, ctx RuleContext) *Rule {
+	return shipped(priced(rule, ctx))
+}
+
+// rerun runs a rule again within a tick it has already run in. The rule's
+// period, chance and onfail rule are ignored.
+func (ru *Runner) rerun(rule *Rule, tick int64, ctx RuleContext) (*RuleResult, error) {
+	res := &RuleResult{Rule: rule, Due: true}
+	over := ctx.Agent.overridden(rule)
+	unlock := ru.lockShared(over, ctx)
+	tx := newTxn(rule, nil, ctx)
+	limit := ru.limit(tick)
+	eff := expand(over, ctx)
+	_, err := ru.runRounds(tx, eff, ctx, res, limit)
+	ru.spend(tick, limit, res.Rounds)
+	if cerr := ru.commit(tx, tick); err == nil {
+		err = cerr
+	}
+	unlock()
+	if err == nil && res.Fired() {
+		err = applyEffects(eff, ctx, res.Rounds)
+	}
+	ru.recordRounds(rule, res.Rounds)
+	if res.Fired() {
+		ru.hooks.ruleFired(res)
+	}
+	return res, err
+}
+
+// RunRule runs rule if it is due at tick, invoking its onfail rule if the
+// first round cannot run. The first time the runner sees a rule it checks the
+// rule's structure as Rule.Validate does, apart from its relations, and
+// returns a *ValidationError without running it if there are problems. Any
+// override of the rule by the context's agent is applied, its exchanges are
+// priced at the current rates and its shipments are taken as inputs.
+func (ru *Runner) RunRule(rule *Rule, tick int64, ctx RuleContext) (*RuleResult, error) {
+	res := &RuleResult{Rule: rule}
+	if err := ru.check(rule); err != nil {
+		return res, err
 	}
+	key := stateKey{agent: ctx.Agent, rule: rule}
+	over := ctx.Agent.overridden(rule)
+	state := ru.state(key)
+	if ru.nextRun(ctx.Agent, state.LastRun, over.Period) > tick {
+		return res, nil
+	}
+	res.Due = true
 
 	defer func() {
 		state.LastRun = tick
-		ru.ruleStates[rule] = state
+		_, state.each = ctx.Pools[RelationEach]
+		ru.setState(key, state)
+		ru.recordResult(res)
+		ru.hooks.ruleDone(res)
 	}()
 
+	// A rule that does not come up by chance has not failed so its onfail
+	// rule is not run
+	if over.Chance > 0 && !ru.chance(over.Chance) {
+		res.Blocked = &Block{Chance: true}
+		return res, nil
+	}
+
+	var onfail bool
+	var err error
+	if block, invoke, ok := ru.unchanged(key, ctx); ok {
+		res.Blocked = block
+		onfail = invoke
+	} else {
+		// The lock is taken before the exchanges are priced so that the
+		// rates cannot change before the rule runs
+		unlock := ru.lockShared(over, ctx)
+		eff := expand(over, ctx)
+		tx := newTxn(rule, nil, ctx)
+		limit := ru.limit(tick)
+		onfail, err = ru.runRounds(tx, eff, ctx, res, limit)
+		ru.spend(tick, limit, res.Rounds)
+		if cerr := ru.commit(tx, tick); err == nil {
+			err = cerr
+		}
+		unlock()
+		ru.noteBlocked(key, ctx, res, onfail)
+		if err == nil && res.Fired() {
+			err = applyEffects(eff, ctx, res.Rounds)
+		}
+	}
+	if err != nil || !onfail {
+		return res, err
+	}
+
+	res.OnFail, err = ru.RunRule(rule.OnFail, tick, ctx)
+	return res, err
+}
+
+// check validates rule the first time it is seen, remembering the result.
+// Relations are not checked since whether a relation is available depends on
+// the context the rule is run with.
+func (ru *Runner) check(rule *Rule) error {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	err, ok := ru.checked[rule]
+	if !ok {
+		err = rule.validateChain(nil)
+		ru.checked[rule] = err
+	}
+	return err
+}
+
+// A Delta is the net change to the quantity of a resource in one pool.
+type Delta struct {
+	Relation Relation
+	Resource *Resource
+	Quantity int // negative when the resource is consumed
+}
+
+// Preview reports the changes rule would make to the pools in ctx if it were
+// run now, including any repeats and its onfail rule, without changing the
+// pools. As in RunRule, any override of the rule by the context's agent is
+// applied, its exchanges are priced and its shipments are taken as inputs.
+// The rule's period and chance and the runner's tick budget are ignored, and
+// no hooks are called or custom effects applied.
+func (ru *Runner) Preview(rule *Rule, ctx RuleContext) (*RuleResult, []Delta, error) {
+	tx := newTxn(rule, nil, ctx)
+	tx.preview = true
+	res, err := ru.preview(tx, rule, ctx)
+	return res, tx.deltas(), err
+}
+
+func (ru *Runner) preview(tx *txn, rule *Rule, ctx RuleContext) (*RuleResult, error) {
+	res := &RuleResult{Rule: rule, Due: true}
+	limit := -1
+	if ru.maxRounds > 0 {
+		limit = ru.maxRounds
+	}
+	onfail, err := ru.runRounds(tx, expand(ctx.Agent.overridden(rule), ctx), ctx, res, limit)
+	if err != nil || !onfail {
+		return res, err
+	}
+	otx := newTxn(rule.OnFail, tx, ctx)
+	res.OnFail, err = ru.preview(otx, rule.OnFail, ctx)
+	otx.commit(nil)
+	return res, err
+}
+
+// limit returns the maximum number of rounds a rule may run at tick under the
+// runner's budgets, or -1 if there is no limit. The rounds are reserved from
+// the tick budget until spend is called with the number actually run.
+func (ru *Runner) limit(tick int64) int {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	limit := -1
+	if ru.maxRounds > 0 {
+		limit = ru.maxRounds
+	}
+	if ru.tickBudget > 0 {
+		if ru.budgetTick != tick {
+			ru.budgetTick = tick
+			ru.budgetUsed = 0
+		}
+		if remaining := ru.tickBudget - ru.budgetUsed; limit < 0 || remaining < limit {
+			limit = remaining
+		}
+		ru.budgetUsed += limit
+	}
+	return limit
+}
+
+// spend returns the unused part of a limit reserved at tick to the tick
+// budget.
+func (ru *Runner) spend(tick int64, limit, rounds int) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if ru.tickBudget > 0 && ru.budgetTick == tick {
+		ru.budgetUsed -= limit - rounds
+	}
+}
+
+// entryBlock returns a Block for the first of the rule's attribute
+// conditions, near conditions, custom conditions and move that stops it
+// running in ctx, or nil if none do. These are checked once before the rule's
+// rounds.
+func (ru *Runner) entryBlock(rule *Rule, ctx RuleContext) *Block {
+	if block := attrBlock(rule, ctx); block != nil {
+		return block
+	}
+	if block := nearBlock(rule, ctx); block != nil {
+		return block
+	}
+	if block := conditionBlock(rule, ctx); block != nil {
+		return block
+	}
+	return ru.moveBlock(rule, ctx)
+}
+
+// runRounds stages each round of rule in tx, recording the outcome in res.
+// No more than limit rounds are run unless limit is negative. It reports
+// whether the rule's onfail rule should be invoked.
+func (ru *Runner) runRounds(tx *txn, rule *Rule, ctx RuleContext, res *RuleResult, limit int) (bool, error) {
+	if block := ru.entryBlock(rule, ctx); block != nil {
+		ru.logger.Printf("rule %q: cannot run, %s", rule.Name, block)
+		res.Blocked = block
+		return rule.OnFail != nil, nil
+	}
+
 	rounds := 1
 
 	if rule.RepeatFrom != nil {
 		poolset, ok := ctx.Pools[rule.RepeatFrom.Relation]
 		if !ok {
-			log.Printf("rule %q failed: no repeat poolset of type %v", rule.Name, rule.RepeatFrom.Relation)
-			return nil
-		}
-		pool := poolset[rule.RepeatFrom.Resource]
-		if pool == nil {
-			rounds = 0
-		} else {
-			rounds = pool.Quantity
+			ru.logger.Printf("rule %q failed: no repeat poolset of type %v", rule.Name, rule.RepeatFrom.Relation)
+			res.Blocked = &Block{Relation: rule.RepeatFrom.Relation}
+			return false, nil
 		}
-		log.Printf("rule %q rounds: %d", rule.Name, rounds)
+		rounds = tx.quantity(poolset, rule.RepeatFrom.Resource)
+		ru.logger.Printf("rule %q rounds: %d", rule.Name, rounds)
 
 	} else {
 		rounds = rule.Repeat + 1
 	}
 
-	runOnce := false
+	capped := false
+	if limit >= 0 && rounds > limit {
+		rounds = limit
+		capped = true
+	}
+
+	// Rounds that are known to succeed are applied in one batch, leaving
+	// the loop below to find what blocks any remaining rounds. Batching
+	// is only possible when overflow is discarded since other policies
+	// must see the excess of each round.
+	if ru.overflowFor(rule).Policy == OverflowDiscard {
+		if n := bulkRounds(tx, rule, ctx, rounds); n > 1 {
+			batch := newTxn(rule, tx, ctx)
+			applyBulk(batch, rule, ctx, n)
+			batch.commit(nil)
+			res.Rounds += n
+			rounds -= n
+		}
+	}
+
 	for rounds > 0 {
-		ok, err := ru.canRun(rule, ctx)
+		block, err := ru.canRun(tx, rule, ctx)
 		if err != nil {
-			log.Printf("rule %q failed: %v", rule.Name, err)
-			return err
+			ru.logger.Printf("rule %q failed: %v", rule.Name, err)
+			return false, err
 		}
-		if !ok {
-			if !runOnce && rule.OnFail != nil {
-				return ru.RunRule(rule.OnFail, tick, ctx)
-			}
-			return nil
+		if block != nil {
+			res.Blocked = block
+			return res.Rounds == 0 && rule.OnFail != nil, nil
 		}
 
-		runOnce = true
-		// Adjust inputs
-		for _, in := range rule.Inputs {
-			poolset, ok := ctx.Pools[in.Relation]
-			if !ok {
-				log.Printf("rule %q failed: no input poolset of type %v", rule.Name, in.Relation)
-				return nil
-			}
+		// Stage the round's changes so they are applied all together or
+		// not at all
+		round := newTxn(rule, tx, ctx)
+		block = ru.apply(round, rule, ctx)
+		if block == nil {
+			block = ru.checkCarry(round, rule, ctx)
+		}
+		if block != nil {
+			res.Blocked = block
+			return false, nil
+		}
+		round.commit(nil)
 
-			excess := poolset.Remove(in.Resource, in.Quantity)
-			if excess > 0 {
-				log.Printf("rule %q failed: not enough resource of type %v", rule.Name, in.Resource)
-				return nil
-			}
+		res.Rounds++
+		rounds--
+	}
+
+	if capped {
+		res.Blocked = &Block{Budget: true}
+	}
+	return false, nil
+}
+
+// apply stages the inputs, outputs and sets of one round of rule in tx,
+// returning a Block if the round cannot be completed.
+func (ru *Runner) apply(tx *txn, rule *Rule, ctx RuleContext) *Block {
+	// Adjust inputs
+	for i, in := range rule.Inputs {
+		poolset, ok := ctx.Pools[in.Relation]
+		if !ok {
+			ru.logger.Printf("rule %q failed: no input poolset of type %v", rule.Name, in.Relation)
+			return &Block{Relation: in.Relation}
 		}
 
-		// Adjust outputs
-		for _, out := range rule.Outputs {
-			poolset, ok := ctx.Pools[out.Relation]
-			if !ok {
-				// fail, no scope of the required type
-				log.Printf("rule %q failed: no output poolset of type %v", rule.Name, out.Relation)
-				return nil
-			}
+		excess := tx.remove(in.Relation, poolset, in.Resource, in.Quantity)
+		if excess > 0 {
+			ru.logger.Printf("rule %q failed: not enough resource of type %v", rule.Name, in.Resource)
+			return &Block{Input: &rule.Inputs[i], Quantity: tx.quantity(poolset, in.Resource)}
+		}
+	}
 
-			// Any excess is lost
-			poolset.Add(out.Resource, out.Quantity)
+	// Adjust outputs
+	for i, out := range rule.Outputs {
+		poolset, ok := ctx.Pools[out.Relation]
+		if !ok {
+			// fail, no scope of the required type
+			ru.logger.Printf("rule %q failed: no output poolset of type %v", rule.Name, out.Relation)
+			return &Block{Relation: out.Relation}
 		}
 
-		// Adjust outputs
-		for _, s := range rule.Sets {
-			poolset, ok := ctx.Pools[s.Relation]
-			if !ok {
-				// fail, no scope of the required type
-				log.Printf("rule %q failed: no set poolset of type %v", rule.Name, s.Relation)
-				return nil
-			}
+		if block := ru.addOutput(tx, rule, ctx, &rule.Outputs[i], poolset); block != nil {
+			return block
+		}
+	}
 
-			// Any excess is lost
-			poolset.Set(s.Resource, s.Quantity)
+	// Adjust outputs
+	for _, s := range rule.Sets {
+		poolset, ok := ctx.Pools[s.Relation]
+		if !ok {
+			// fail, no scope of the required type
+			ru.logger.Printf("rule %q failed: no set poolset of type %v", rule.Name, s.Relation)
+			return &Block{Relation: s.Relation}
 		}
 
-		rounds--
+		// Any excess is lost
+		tx.set(s.Relation, poolset, s.Resource, s.Quantity)
 	}
 
 	return nil
 }
 
-func (ru *Runner) canRun(rule *Rule, ctx RuleContext) (bool, error) {
-	for _, c := range rule.Preconditions {
+// canRun checks the rule's preconditions and inputs, returning a Block
+// describing the first that is not satisfied or nil if the rule can run.
+func (ru *Runner) canRun(tx *txn, rule *Rule, ctx RuleContext) (*Block, error) {
+	for i, c := range rule.Preconditions {
 		poolset, ok := ctx.Pools[c.Relation]
 		if !ok {
 			// fail, no scope of the required type
-			return false, fmt.Errorf("rule %q failed: no precondition poolset of type %v", rule.Name, c.Relation)
+			return nil, fmt.Errorf("rule %q failed: no precondition poolset of type %v", rule.Name, c.Relation)
 		}
 
-		q := poolset.Quantity(c.Resource)
-		switch c.Op {
-		case OpEquals:
-			if q != c.Quantity {
-				log.Printf("rule %q: cannot run for resource %s, %d != %d", rule.Name, c.Resource, q, c.Quantity)
-				return false, nil
-			}
-		case OpGreaterThan:
-			if !(q > c.Quantity) {
-				log.Printf("rule %q: cannot run for resource %s, %d not > %d", rule.Name, c.Resource, q, c.Quantity)
-				return false, nil
-			}
-		case OpGreaterThanOrEqual:
-			if !(q >= c.Quantity) {
-				log.Printf("rule %q: cannot run for resource %s, %d not >= %d", rule.Name, c.Resource, q, c.Quantity)
-				return false, nil
-			}
-		case OpLessThan:
-			if !(q < c.Quantity) {
-				log.Printf("rule %q: cannot run for resource %s, %d not < %d", rule.Name, c.Resource, q, c.Quantity)
-				return false, nil
-			}
-		case OpLessThanOrEqual:
-			if !(q <= c.Quantity) {
-				log.Printf("rule %q: cannot run for resource %s, %d not <= %d", rule.Name, c.Resource, q, c.Quantity)
-				return false, nil
-			}
-		default:
+		if _, ok := opSymbols[c.Op]; !ok {
 			// fail, unknown operation
-			return false, fmt.Errorf("rule %q failed: unknown operation %v", rule.Name, c.Op)
+			return nil, fmt.Errorf("rule %q failed: unknown operation %v", rule.Name, c.Op)
+		}
+
+		q := tx.quantity(poolset, c.Resource)
+		if !c.Op.holds(q, c.Quantity) {
+			ru.logger.Printf("rule %q: cannot run for resource %s, %d not %s %d", rule.Name, c.Resource, q, c.Op, c.Quantity)
+			return &Block{Precondition: &rule.Preconditions[i], Quantity: q}, nil
 		}
 	}
 
 	// Check inputs are available
-	for _, in := range rule.Inputs {
+	for i, in := range rule.Inputs {
 		poolset, ok := ctx.Pools[in.Relation]
 		if !ok {
 			// fail, no scope of the required type
-			return false, fmt.Errorf("rule %q failed: no input poolset of type %v", rule.Name, in.Relation)
+			return nil, fmt.Errorf("rule %q failed: no input poolset of type %v", rule.Name, in.Relation)
 		}
 
-		if in.Quantity > poolset.Quantity(in.Resource) {
+		if q := tx.quantity(poolset, in.Resource) - poolset.reserved(in.Resource); in.Quantity > q && !poolset.AllowsNegative(in.Resource) {
 			// fail, not enough input
-			log.Printf("rule %q failed: not enough of resource %q, got %d wanted %d", rule.Name, in.Resource, poolset.Quantity(in.Resource), in.Quantity)
-			return false, nil
+			ru.logger.Printf("rule %q failed: not enough of resource %q, got %d wanted %d", rule.Name, in.Resource, q, in.Quantity)
+			return &Block{Input: &rule.Inputs[i], Quantity: q}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// A StepReport describes the outcome of running a tick with Step.
+type StepReport struct {
+	Tick   int64
+	Global *RunReport   // nil if there was no global, see RelationEach for rules run per agent
+	Agents []*RunReport // one for each agent whose rules were run, in the order supplied
+
+	// Triggered lists the watchers whose conditions came to hold during the
+	// step. Halted is true if any of them asked for the simulation to halt.
+	Triggered []*Watcher
+	Halted    bool
+}
+
+// Step applies the decay and regeneration of resources to the global and
+// agent pools, then runs the global rules followed by the rules of each agent
+// in turn for one tick. Global rules that use the each relation are run once
+// for every agent after the other global rules. Each agent's rules are run
+// with the agent's RuleContext, with the global relation referring to the
+// global pools unless the agent has its own global relation. global may be
+// nil.
+func (ru *Runner) Step(global *Global, agents []*Agent, tick int64) (*StepReport, error) {
+	return ru.StepContext(context.Background(), global, agents, tick)
+}
+
+// StepContext is like Step but stops once ctx is done, returning the partial
+// report and the context's error. As with RunContext, stepping again for the
+// same tick resumes where the cancelled step stopped.
+func (ru *Runner) StepContext(ctx context.Context, global *Global, agents []*Agent, tick int64) (*StepReport, error) {
+	report := &StepReport{Tick: tick}
+	if err := ru.step(ctx, global, agents, tick, report); err != nil {
+		return report, err
+	}
+	ru.checkWatchers(report)
+	if ru.history != nil {
+		ru.history.Record(tick)
+	}
+	if ru.recorder != nil {
+		if err := ru.recorder.Record(tick); err != nil {
+			return report, fmt.Errorf("record: %w", err)
+		}
+	}
+	return report, nil
+}
+
+func (ru *Runner) step(ctx context.Context, global *Global, agents []*Agent, tick int64, report *StepReport) error {
+	if err := ru.applyRates(global, agents, tick); err != nil {
+		return err
+	}
+
+	if global != nil {
+		once, each := splitGlobal(global.Rules)
+		rr, err := ru.RunContext(ctx, once, tick, global.RuleContext())
+		report.Global = rr
+		if err != nil {
+			return err
+		}
+		if err := ru.runEach(each, agents, tick, global, rr); err != nil {
+			return err
+		}
+	}
+
+	if ru.parallel > 1 {
+		return ru.stepParallel(ctx, global, agents, tick, report)
+	}
+
+	// The agents are run one at a time so they can share a context
+	var rctx RuleContext
+	for _, a := range agents {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fillAgentContext(&rctx, a, global)
+		rr, err := ru.RunContext(ctx, a.AllRules(), tick, rctx)
+		report.Agents = append(report.Agents, rr)
+		if err != nil {
+			return fmt.Errorf("agent %q: %w", a.Name.Singular, err)
+		}
+	}
+	return nil
+}
+
+// agentContext returns the context used by Step to run an agent's rules.
+func agentContext(a *Agent, global *Global) RuleContext {
+	var ctx RuleContext
+	fillAgentContext(&ctx, a, global)
+	return ctx
+}
+
+func fillAgentContext(ctx *RuleContext, a *Agent, global *Global) {
+	a.FillRuleContext(ctx)
+	if _, ok := ctx.Pools[RelationGlobal]; !ok && global != nil {
+		ctx.Pools[RelationGlobal] = global.Pools
+	}
+}
+
+// A Due is a scheduled attempt to run a rule for an agent.
+type Due struct {
+	Agent *Agent
+	Rule  *Rule
+	Tick  int64
+}
+
+// Upcoming lists the attempts the runner will make to run each agent's rules
+// in the n ticks starting at fromTick, assuming the runner is invoked every
+// tick with each agent's RuleContext. Attempts are ordered by tick, then by
+// the order of agents and rules. Whether an attempt succeeds depends on the
+// state of the pools at the time and is not predicted.
+func (ru *Runner) Upcoming(agents []*Agent, fromTick int64, n int) []Due {
+	var due []Due
+	end := fromTick + int64(n)
+	for _, a := range agents {
+		for _, r := range a.AllRules() {
+			period := int64(a.overridden(r).Period)
+			if period <= 0 {
+				continue
+			}
+			next := ru.nextRun(a, ru.state(stateKey{agent: a, rule: r}).LastRun, int(period))
+			if next < fromTick {
+				next = fromTick
+			}
+			for t := next; t < end; t += period {
+				due = append(due, Due{Agent: a, Rule: r, Tick: t})
+			}
 		}
 	}
 
-	return true, nil
+	// Attempts were gathered by agent and rule so a stable sort by tick
+	// preserves that order within each tick
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].Tick < due[j].Tick
+	})
+	return due
 }
@@ -1,8 +1,13 @@
 package rula
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func BenchmarkRunRule(b *testing.B) {
@@ -25,10 +30,10 @@ end
 	}
 
 	ctx := RuleContext{
-		Pools: map[Relation]PoolSet{
-			RelationSelf: {
-				ironOre: {Resource: ironOre, Capacity: 1<<63 - 1, Quantity: 1000},
-			},
+		Pools: map[Relation]*PoolSet{
+			RelationSelf: NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 1<<63 - 1, Quantity: 1000},
+			),
 		},
 	}
 
@@ -38,3 +43,481 @@ end
 		runner.Run(rules, int64(i), ctx)
 	}
 }
+
+func TestUpcoming(t *testing.T) {
+	every := func(name string, period int) *Rule {
+		return &Rule{Name: name, Period: period}
+	}
+	fast, slow, manual := every("fast", 2), every("slow", 5), every("manual", 0)
+
+	a := NewAgent("a")
+	a.AppendRules([]*Rule{fast, slow, manual})
+	b := NewAgent("b")
+	b.AppendRules([]*Rule{slow})
+
+	runner := NewRunner()
+	if _, err := runner.Run([]*Rule{fast}, 3, a.RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, d := range runner.Upcoming([]*Agent{a, b}, 4, 4) {
+		got = append(got, fmt.Sprintf("%d:%s:%s", d.Tick, d.Agent.Name.Singular, d.Rule.Name))
+	}
+
+	want := []string{
+		"5:a:fast",
+		"5:a:slow",
+		"5:b:slow",
+		"7:a:fast",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Upcoming() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type logRecorder struct {
+	lines []string
+}
+
+func (l *logRecorder) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestRunnerLogger(t *testing.T) {
+	rule := &Rule{
+		Name:    "smelt",
+		Period:  1,
+		Inputs:  []ResourceSpecifier{{Relation: RelationSelf, Resource: ironOre, Quantity: 2}},
+		Outputs: []ResourceSpecifier{{Relation: RelationSelf, Resource: iron, Quantity: 1}},
+	}
+	ctx := RuleContext{
+		Pools: map[Relation]*PoolSet{
+			RelationSelf: NewPoolSet(&Pool{Resource: ironOre, Capacity: 10, Quantity: 1}),
+		},
+	}
+
+	logger := &logRecorder{}
+	if _, err := NewRunner(WithLogger(logger)).Run([]*Rule{rule}, 1, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{`rule "smelt" failed: not enough of resource "iron_ore", got 1 wanted 2`}
+	if diff := cmp.Diff(want, logger.lines); diff != "" {
+		t.Errorf("log mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunTarget(t *testing.T) {
+	p := NewRuleParser([]*Resource{workers})
+	rules, err := p.Parse(strings.NewReader("rule attack\n\tif target workers > 0\n\tout target workers -5\nend\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	attacker := NewAgent("attacker")
+	fort := NewAgent("fort")
+	fort.AddPool(workers, 100, 20)
+	camp := NewAgent("camp")
+	camp.AddPool(workers, 100, 10)
+
+	runner := NewRunner()
+	ctx := attacker.RuleContext()
+	for tick, target := range []*Agent{fort, camp, camp} {
+		if _, err := runner.Run(rules, int64(tick+1), ctx.WithTarget(target)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := fort.Pools.Quantity(workers); got != 15 {
+		t.Errorf("got fort workers %d, wanted 15", got)
+	}
+	if got := camp.Pools.Quantity(workers); got != 0 {
+		t.Errorf("got camp workers %d, wanted 0", got)
+	}
+	if _, ok := ctx.Pools[RelationTarget]; ok {
+		t.Errorf("WithTarget modified the original context")
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	p := NewRuleParser([]*Resource{ironOre, iron, workers})
+	rules, err := p.Parse(strings.NewReader(`
+rule idle
+	every 0
+	set self workers 0
+end
+
+rule smelt
+	if self workers > 0
+	in self iron_ore 2
+	out self iron 1
+	repeat 3
+	onfail idle
+end
+
+rule dig
+	every 5
+	out self iron_ore 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	idle, smelt, dig := rules[0], rules[1], rules[2]
+
+	ps := NewPoolSet(
+		&Pool{Resource: ironOre, Capacity: 100, Quantity: 5},
+		&Pool{Resource: iron, Capacity: 100},
+		&Pool{Resource: workers, Capacity: 100, Quantity: 1},
+	)
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+	runner := NewRunner()
+	report, err := runner.Run(rules, 1, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &RunReport{
+		Tick: 1,
+		Results: []*RuleResult{
+			{
+				Rule:    smelt,
+				Due:     true,
+				Rounds:  2,
+				Blocked: &Block{Input: &smelt.Inputs[0], Quantity: 1},
+			},
+			{Rule: dig},
+		},
+		Passes: 1,
+		Next:   3,
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("report mismatch (-want +got):\n%s", diff)
+	}
+
+	report, err = runner.Run(rules, 2, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res := report.Result(smelt)
+	if res.Fired() {
+		t.Errorf("smelt fired, wanted it blocked")
+	}
+	if res.OnFail == nil || res.OnFail.Rule != idle || !res.OnFail.Fired() {
+		t.Errorf("got onfail result %+v, wanted idle to fire", res.OnFail)
+	}
+	if got, want := res.Blocked.String(), "not enough self iron_ore, found 1 wanted 2"; got != want {
+		t.Errorf("got block %q, wanted %q", got, want)
+	}
+}
+
+func TestRunnerPerAgentState(t *testing.T) {
+	produce := NewRule("produce").Out(RelationSelf, iron, 1).Every(3).Build()
+
+	a := NewAgent("a")
+	a.AddPool(iron, 10, 0)
+	a.AppendRules([]*Rule{produce})
+	b := NewAgent("b")
+	b.AddPool(iron, 10, 0)
+	b.AppendRules([]*Rule{produce})
+
+	runner := NewRunner()
+	for tick := int64(3); tick <= 6; tick++ {
+		// b only starts running at tick 5 so it should not be held back
+		// by a's timer
+		agents := []*Agent{a}
+		if tick >= 5 {
+			agents = append(agents, b)
+		}
+		for _, ag := range agents {
+			if _, err := runner.Run(ag.Rules, tick, ag.RuleContext()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	if got := a.Pools.Quantity(iron); got != 2 {
+		t.Errorf("got a iron %d, wanted 2", got)
+	}
+	if got := b.Pools.Quantity(iron); got != 1 {
+		t.Errorf("got b iron %d, wanted 1", got)
+	}
+}
+
+func TestStep(t *testing.T) {
+	mine := NewRule("mine").In(RelationGlobal, ironOre, 2).Out(RelationSelf, ironOre, 2).Build()
+	regrow := NewRule("regrow").Out(RelationSelf, ironOre, 1).Build()
+
+	global := NewGlobal([]*Rule{regrow})
+	global.Pools.AddPool(ironOre, 100, 3)
+
+	a := NewAgent("a")
+	a.AddPool(ironOre, 10, 0)
+	a.AppendRules([]*Rule{mine})
+	b := NewAgent("b")
+	b.AddPool(ironOre, 10, 0)
+	b.AppendRules([]*Rule{mine})
+
+	runner := NewRunner()
+	report, err := runner.Step(global, []*Agent{a, b}, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Global rules run first so both agents can mine
+	if got := global.Pools.Quantity(ironOre); got != 0 {
+		t.Errorf("got global iron_ore %d, wanted 0", got)
+	}
+	for _, ag := range []*Agent{a, b} {
+		if got := ag.Pools.Quantity(ironOre); got != 2 {
+			t.Errorf("got %s iron_ore %d, wanted 2", ag.Name.Singular, got)
+		}
+	}
+	if len(report.Agents) != 2 || !report.Agents[1].Result(mine).Fired() {
+		t.Errorf("got agent reports %+v, wanted b to mine", report.Agents)
+	}
+	if !report.Global.Result(regrow).Fired() {
+		t.Errorf("global regrow did not fire")
+	}
+}
+
+func TestRunFixpoint(t *testing.T) {
+	logs := &Resource{ID: "logs", Name: Name{Singular: "logs"}}
+	planks := &Resource{ID: "planks", Name: Name{Singular: "planks"}}
+	tables := &Resource{ID: "tables", Name: Name{Singular: "tables"}}
+
+	// Listed in reverse order of the chain so each pass only gets one step
+	// further
+	rules := []*Rule{
+		NewRule("build").In(RelationSelf, planks, 4).Out(RelationSelf, tables, 1).Build(),
+		NewRule("saw").In(RelationSelf, logs, 1).Out(RelationSelf, planks, 2).Build(),
+	}
+
+	testCases := []struct {
+		maxPasses int
+		passes    int
+		exhausted bool
+		tables    int
+	}{
+		{maxPasses: 0, passes: 1, tables: 0},
+		{maxPasses: 3, passes: 3, exhausted: true, tables: 1},
+		{maxPasses: 10, passes: 6, tables: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.maxPasses), func(t *testing.T) {
+			ps := NewPoolSet(
+				&Pool{Resource: logs, Capacity: 10, Quantity: 4},
+				&Pool{Resource: planks, Capacity: 10},
+				&Pool{Resource: tables, Capacity: 10},
+			)
+			ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+			report, err := NewRunner(WithFixpoint(tc.maxPasses)).Run(rules, 1, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if report.Passes != tc.passes || report.Exhausted != tc.exhausted {
+				t.Errorf("got %d passes exhausted %v, wanted %d passes exhausted %v", report.Passes, report.Exhausted, tc.passes, tc.exhausted)
+			}
+			if got := ps.Quantity(tables); got != tc.tables {
+				t.Errorf("got %d tables, wanted %d", got, tc.tables)
+			}
+		})
+	}
+}
+
+func TestRunBudget(t *testing.T) {
+	dig := NewRule("dig").Out(RelationSelf, ironOre, 1).Repeat(9).Build()
+	haul := NewRule("haul").In(RelationSelf, ironOre, 1).Out(RelationSelf, iron, 1).Repeat(9).Build()
+
+	testCases := []struct {
+		name   string
+		opts   []RunnerOption
+		ore    int
+		iron   int
+		capped []string
+	}{
+		{name: "unlimited", ore: 0, iron: 10},
+		{name: "max rounds", opts: []RunnerOption{WithMaxRounds(4)}, ore: 0, iron: 4, capped: []string{"dig", "haul"}},
+		{name: "tick budget", opts: []RunnerOption{WithTickBudget(13)}, ore: 7, iron: 3, capped: []string{"haul"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ps := NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 100},
+				&Pool{Resource: iron, Capacity: 100},
+			)
+			ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+			report, err := NewRunner(tc.opts...).Run([]*Rule{dig, haul}, 1, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var capped []string
+			for _, res := range report.Results {
+				if res.Blocked != nil && res.Blocked.Budget {
+					capped = append(capped, res.Rule.Name)
+				}
+			}
+			if diff := cmp.Diff(tc.capped, capped); diff != "" {
+				t.Errorf("capped rules mismatch (-want +got):\n%s", diff)
+			}
+			if report.BudgetExhausted != (len(tc.capped) > 0) {
+				t.Errorf("got budget exhausted %v", report.BudgetExhausted)
+			}
+			if got := ps.Quantity(ironOre); got != tc.ore {
+				t.Errorf("got iron_ore %d, wanted %d", got, tc.ore)
+			}
+			if got := ps.Quantity(iron); got != tc.iron {
+				t.Errorf("got iron %d, wanted %d", got, tc.iron)
+			}
+		})
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	var rules []*Rule
+	for i := 0; i < 4; i++ {
+		rules = append(rules, NewRule(fmt.Sprintf("r%d", i)).Out(RelationSelf, iron, 1).Build())
+	}
+	ps := NewPoolSet(&Pool{Resource: iron, Capacity: 100})
+	rctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := NewRunner()
+	runner.OnRuleFired(func(res *RuleResult) {
+		if res.Rule == rules[1] {
+			cancel()
+		}
+	})
+
+	report, err := runner.RunContext(ctx, rules, 1, rctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, wanted context.Canceled", err)
+	}
+	if report.Next != 2 || len(report.Results) != 2 {
+		t.Errorf("got next %d with %d results, wanted 2 and 2", report.Next, len(report.Results))
+	}
+
+	// Running again for the same tick only runs the remaining rules
+	report, err = runner.Run(rules, 1, rctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ps.Quantity(iron); got != 4 {
+		t.Errorf("got iron %d, wanted 4", got)
+	}
+	if report.Result(rules[0]).Due || !report.Result(rules[3]).Fired() {
+		t.Errorf("resumed run did not skip completed rules")
+	}
+}
+
+func TestRunNegative(t *testing.T) {
+	morale := &Resource{Name: Name{Singular: "morale"}}
+	// Each round costs 3 morale, which may go into deficit, and yields
+	// one iron while morale stays above -5
+	work := &Rule{
+		Name:          "work",
+		Period:        1,
+		Repeat:        4,
+		Preconditions: []ResourceCondition{{ResourceSpecifier: ResourceSpecifier{Relation: RelationSelf, Resource: morale, Quantity: -5}, Op: OpGreaterThan}},
+		Inputs:        []ResourceSpecifier{{Relation: RelationSelf, Resource: morale, Quantity: 3}},
+		Outputs:       []ResourceSpecifier{{Relation: RelationSelf, Resource: iron, Quantity: 1}},
+	}
+
+	self := NewPoolSet(
+		&Pool{Resource: morale, Capacity: 10, Quantity: 2, AllowNegative: true},
+		&Pool{Resource: iron, Capacity: 10},
+	)
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self}}
+
+	res, err := NewRunner().RunRule(work, 1, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Morale goes 2, -1, -4, -7 at which point the precondition fails
+	if res.Rounds != 3 {
+		t.Errorf("got %d rounds, wanted 3", res.Rounds)
+	}
+	if got := self.Quantity(morale); got != -7 {
+		t.Errorf("got morale %d, wanted -7", got)
+	}
+	if res.Blocked == nil || res.Blocked.Precondition == nil || res.Blocked.Quantity != -7 {
+		t.Errorf("got block %v, wanted precondition with quantity -7", res.Blocked)
+	}
+}
+
+func TestRunRuleInvalid(t *testing.T) {
+	rule := &Rule{
+		Name:          "test",
+		Period:        1,
+		Preconditions: []ResourceCondition{{ResourceSpecifier: ResourceSpecifier{Relation: RelationSelf, Resource: iron, Quantity: 1}, Op: Op(9)}},
+		Outputs:       []ResourceSpecifier{{Relation: RelationSelf, Resource: iron, Quantity: 1}},
+	}
+	self := NewPoolSet(&Pool{Resource: iron, Capacity: 10})
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self}}
+
+	ru := NewRunner()
+	for tick := int64(1); tick <= 2; tick++ {
+		res, err := ru.RunRule(rule, tick, ctx)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("got error %v, wanted a ValidationError", err)
+		}
+		if res.Due {
+			t.Errorf("invalid rule was attempted")
+		}
+	}
+
+	// Relations are not checked as they depend on the context
+	rule.Preconditions = nil
+	rule.Outputs[0].Relation = "employer"
+	if _, err := NewRunner().RunRule(rule, 1, ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRunnerStates(t *testing.T) {
+	mine := &Rule{Name: "mine", Period: 3}
+	grow := &Rule{Name: "grow", Period: 2}
+	global := NewGlobal([]*Rule{grow})
+	a := NewAgent("miner")
+	a.AppendRules([]*Rule{mine})
+
+	ru := NewRunner()
+	if _, err := ru.Step(global, []*Agent{a}, 4); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	states := ru.States()
+	want := []RuleSnapshot{
+		{Rule: "grow", LastRun: 4},
+		{Agent: "miner", Rule: "mine", LastRun: 4},
+	}
+	if diff := cmp.Diff(want, states); diff != "" {
+		t.Fatalf("states mismatch (-want +got):\n%s", diff)
+	}
+
+	// A new runner loaded with the states knows the rules are not yet due
+	loaded := NewRunner()
+	if err := loaded.LoadStates(states, global, []*Agent{a}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var due []string
+	for _, d := range loaded.Upcoming([]*Agent{a}, 5, 3) {
+		due = append(due, fmt.Sprintf("%s@%d", d.Rule.Name, d.Tick))
+	}
+	if diff := cmp.Diff([]string{"mine@7"}, due); diff != "" {
+		t.Errorf("upcoming mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := loaded.LoadStates([]RuleSnapshot{{Agent: "miner", Rule: "grow"}}, global, []*Agent{a}); err == nil {
+		t.Errorf("got no error for unknown rule, wanted one")
+	}
+}
@@ -0,0 +1,215 @@
+package rula
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/iand/loon"
+)
+
+/*
+
+Scenario files use loon syntax (see github.com/iand/loon) and combine
+resource, event and rule declarations, which use the same syntax as resource
+and rule files, with declarations of the global pools, agents and locations
+that make up the initial state of a simulation.
+
+  global <id>
+  	declares the global pools and rules. at most one may be declared
+
+  agent <id>
+  	declares a new agent
+
+  location <id>
+  	declares a new location. a location is also an agent that holds
+  	the pools and rules belonging to the location
+
+  end
+  	ends a declaration
+
+Directives for global, agent and location declarations:
+
+  pool <resource> <capacity> <quantity>? negative?
+  	declares a pool of resource with the given capacity and initial
+  	quantity. the quantity defaults to 0. negative allows the quantity
+  	to fall below zero
+
+  rules <id>+
+  	appends the named rules to the rules of the agent
+
+Directives for agent and location declarations:
+
+  relation <relation> <id>
+  	relates the agent to another agent or location
+
+Directives for location declarations:
+
+  position <east> <north>
+  	the position of the location as distances from the centre of the map,
+  	with an optional unit suffix of mm, cm, m, km, yd or mi
+
+*/
+
+// A Scenario is the initial state of a simulation read from a scenario file.
+type Scenario struct {
+	Resources []*Resource
+	Rules     []*Rule
+	Global    *Global
+	Agents    []*Agent // agents in declaration order, including location agents
+
+	// Locations maps the name of each location agent to its position on the map.
+	Locations map[string]*Location
+}
+
+type ScenarioParser struct {
+	resources []*Resource
+	rules     []*Rule
+	handlers  map[string]DirectiveHandler
+}
+
+func NewScenarioParser() *ScenarioParser {
+	return &ScenarioParser{}
+}
+
+// SetResources makes resources declared in separate resource files available
+// to the scenario. They are included in the scenario's resources before
+// those it declares.
+func (p *ScenarioParser) SetResources(resources []*Resource) {
+	p.resources = resources
+}
+
+// SetRules makes rules declared in separate rule files available to the
+// scenario's agents. They are included in the scenario's rules before those
+// it declares.
+func (p *ScenarioParser) SetRules(rules []*Rule) {
+	p.rules = rules
+}
+
+// RegisterDirective makes the rules declared in the scenario accept a custom
+// directive, as RuleParser.RegisterDirective does.
+func (p *ScenarioParser) RegisterDirective(name string, h DirectiveHandler) {
+	if p.handlers == nil {
+		p.handlers = map[string]DirectiveHandler{}
+	}
+	p.handlers[name] = h
+}
+
+func (p *ScenarioParser) Parse(r io.Reader) (*Scenario, error) {
+	pp := loon.NewParser(r)
+	doc, err := pp.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	var resObjs, ruleObjs, agentObjs []loon.Object
+	var globalObj *loon.Object
+	for i, obj := range doc.Objects {
+		switch obj.Type {
+		case "resource", "event", "currency":
+			resObjs = append(resObjs, obj)
+		case "rule":
+			ruleObjs = append(ruleObjs, obj)
+		case "agent", "location":
+			agentObjs = append(agentObjs, obj)
+		case "global":
+			if globalObj != nil {
+				return nil, fmt.Errorf("duplicate global declaration at line %d", obj.Line)
+			}
+			globalObj = &doc.Objects[i]
+		default:
+			return nil, fmt.Errorf("unexpected token at line %d: %s", obj.Line, obj.Type)
+		}
+	}
+
+	sc := &Scenario{
+		Global:    NewGlobal(nil),
+		Locations: map[string]*Location{},
+	}
+
+	resources, err := NewResourceParser().parseObjects(resObjs)
+	if err != nil {
+		return nil, err
+	}
+	sc.Resources = append(append([]*Resource(nil), p.resources...), resources...)
+
+	rulep := NewRuleParser(sc.Resources)
+	rulep.handlers = p.handlers
+	rules, err := rulep.parseObjects(ruleObjs)
+	if err != nil {
+		return nil, err
+	}
+	sc.Rules = append(append([]*Rule(nil), p.rules...), rules...)
+
+	b := newAgentBuilder(sc.Resources, sc.Rules, nil)
+
+	if globalObj != nil {
+		for _, dir := range globalObj.Directives {
+			switch dir.Name {
+			case "pool":
+				if err := b.addPool(sc.Global.Pools, dir); err != nil {
+					return nil, err
+				}
+			case "rules":
+				rules, err := b.ruleList(dir)
+				if err != nil {
+					return nil, err
+				}
+				sc.Global.Rules = append(sc.Global.Rules, rules...)
+			default:
+				return nil, fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
+			}
+		}
+	}
+
+	// Agents are created before their directives are processed so relations
+	// can refer to agents declared later in the file
+	for _, obj := range agentObjs {
+		name := strings.TrimSpace(obj.Name)
+		if _, exists := b.agents[name]; exists {
+			return nil, fmt.Errorf("duplicate agent at line %d: %s", obj.Line, name)
+		}
+		a := NewAgent(name)
+		b.agents[name] = a
+		sc.Agents = append(sc.Agents, a)
+		if obj.Type == "location" {
+			// The location shares the pools of its agent
+			loc := newLocation(int64(len(sc.Locations)+1), Position{})
+			loc.pools = a.Pools
+			sc.Locations[name] = loc
+		}
+	}
+
+	for i, obj := range agentObjs {
+		a := sc.Agents[i]
+		for _, dir := range obj.Directives {
+			switch dir.Name {
+			case "pool", "rules", "relation":
+				if err := b.directive(a, dir); err != nil {
+					return nil, err
+				}
+			case "position":
+				loc, ok := sc.Locations[a.Name.Singular]
+				if !ok {
+					return nil, fmt.Errorf("position directive at line %d is only valid for a location", dir.Line)
+				}
+				if len(dir.Args) != 2 {
+					return nil, fmt.Errorf("malformed position directive at line %d: %s %s", dir.Line, dir.Name, dir.ArgText)
+				}
+				east, err := ParseLength(dir.Args[0])
+				if err != nil {
+					return nil, fmt.Errorf("invalid position at line %d: %v", dir.Line, err)
+				}
+				north, err := ParseLength(dir.Args[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid position at line %d: %v", dir.Line, err)
+				}
+				loc.pos = Position{East: east, North: north}
+			default:
+				return nil, fmt.Errorf("unknown directive at line %d: %s", dir.Line, dir.Name)
+			}
+		}
+	}
+
+	return sc, nil
+}
@@ -0,0 +1,93 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScenarioParser(t *testing.T) {
+	spec := `
+resource iron_ore
+end
+
+resource iron
+end
+
+rule smelt
+	in iron_ore 2
+	out location iron 1
+end
+
+global world
+	pool iron_ore 1000 500
+end
+
+agent smelter
+	pool iron_ore 10 6
+	relation location town
+	rules smelt
+end
+
+location town
+	position 3km -200m
+	pool iron 100
+end
+`
+
+	sc, err := NewScenarioParser().Parse(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(sc.Resources) != 2 || len(sc.Rules) != 1 || len(sc.Agents) != 2 {
+		t.Fatalf("got %d resources, %d rules, %d agents, wanted 2, 1, 2", len(sc.Resources), len(sc.Rules), len(sc.Agents))
+	}
+	ore, iron := sc.Resources[0], sc.Resources[1]
+
+	if got := sc.Global.Pools.Quantity(ore); got != 500 {
+		t.Errorf("got global iron_ore %d, wanted 500", got)
+	}
+
+	town, ok := sc.Locations["town"]
+	if !ok {
+		t.Fatalf("town location not declared")
+	}
+	if want := (Position{East: 3 * Kilometre, North: -200 * Metre}); town.Position() != want {
+		t.Errorf("got town position %v, wanted %v", town.Position(), want)
+	}
+
+	smelter := sc.Agents[0]
+	runner := NewRunner()
+	for tick := int64(1); tick <= 3; tick++ {
+		if _, err := runner.Run(smelter.Rules, tick, smelter.RuleContext()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if got := smelter.Pools.Quantity(ore); got != 0 {
+		t.Errorf("got smelter iron_ore %d, wanted 0", got)
+	}
+	if got := sc.Agents[1].Pools.Quantity(iron); got != 3 {
+		t.Errorf("got town iron %d, wanted 3", got)
+	}
+}
+
+func TestScenarioParserErrors(t *testing.T) {
+	testCases := []string{
+		"agent a\n\trelation location nowhere\nend\n",
+		"agent a\n\tpool gold 1 1\nend\n",
+		"agent a\n\trules missing\nend\n",
+		"agent a\n\tposition 1m 1m\nend\n",
+		"agent a\nend\nagent a\nend\n",
+		"global a\nend\nglobal b\nend\n",
+		"market a\nend\n",
+	}
+
+	for _, spec := range testCases {
+		t.Run("", func(t *testing.T) {
+			if _, err := NewScenarioParser().Parse(strings.NewReader(spec)); err == nil {
+				t.Errorf("got no error, wanted one")
+			}
+		})
+	}
+}
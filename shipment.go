@@ -0,0 +1,142 @@
+package rula
+
+import "fmt"
+
+// A Shipment is a quantity of a resource sent by a rule's ship directive from
+// the agent the rule is run for to a related agent. The resource leaves the
+// sender's pool when the rule runs and is added to the receiver's pool at the
+// end of the tick given by Arrives. Hooks registered with World.OnTransit can
+// reduce the quantity on the way, to model losses or raids.
+type Shipment struct {
+	From     *Agent
+	To       *Agent
+	Resource *Resource
+	Quantity int
+	Route    []*Connection // empty unless the world has a network and both agents have locations
+	Origin   *Location     // the start of the route, if any
+	Dest     *Location     // the end of the route, if any
+	Departed int64
+	Arrives  int64
+}
+
+// shipped returns the rule with its shipments taken as inputs from the pools
+// of the agent it is run for. It returns rule itself if it ships nothing.
+func shipped(rule *Rule) *Rule {
+	if len(rule.Ships) == 0 {
+		return rule
+	}
+	r := *rule
+	r.Inputs = append([]ResourceSpecifier(nil), rule.Inputs...)
+	for _, s := range rule.Ships {
+		r.Inputs = append(r.Inputs, ResourceSpecifier{Relation: RelationSelf, Resource: s.Resource, Quantity: s.Quantity})
+	}
+	return &r
+}
+
+// ship sends the shipments of a rule that completed rounds for agent a.
+// Shipments travel along the cheapest route for the sender between the
+// agents' locations at the sender's speed and take at least one tick. If any
+// shipment cannot be sent, because a is nil or has no agent in the relation
+// shipped to or there is no route, none are sent and the quantities the rule
+// took for them are returned to the pools they were taken from.
+func (w *World) ship(a *Agent, rule *Rule, rounds int) error {
+	shipments, err := w.newShipments(a, rule, rounds)
+	if err != nil {
+		pools := w.Global.Pools
+		if a != nil {
+			pools = a.Pools
+		}
+		for _, spec := range rule.Ships {
+			pools.Add(spec.Resource, spec.Quantity*rounds)
+		}
+		return err
+	}
+	w.shipments = append(w.shipments, shipments...)
+	return nil
+}
+
+// newShipments returns the shipments of a rule that completed rounds for agent
+// a, or an error if any cannot be sent.
+func (w *World) newShipments(a *Agent, rule *Rule, rounds int) ([]*Shipment, error) {
+	if a == nil {
+		return nil, fmt.Errorf("only agents can ship")
+	}
+	var shipments []*Shipment
+	for _, spec := range rule.Ships {
+		to := a.related(spec.Relation)
+		if to == nil {
+			return nil, fmt.Errorf("no agent to ship to in relation %q", spec.Relation)
+		}
+		s := &Shipment{
+			From:     a,
+			To:       to,
+			Resource: spec.Resource,
+			Quantity: spec.Quantity * rounds,
+			Departed: w.tick,
+			Arrives:  w.tick + 1,
+		}
+		if w.Network != nil && a.Speed > 0 && a.Location != nil && to.Location != nil && a.Location != to.Location {
+			route, d, err := w.Network.RouteFor(a.Location.ID(), to.Location.ID(), a.routeDifficulty())
+			if err != nil {
+				return nil, err
+			}
+			s.Route = route
+			s.Origin, s.Dest = a.Location, to.Location
+			s.Arrives = w.tick + travelTicks(d, a.Speed)
+		}
+		shipments = append(shipments, s)
+	}
+	return shipments, nil
+}
+
+// deliver passes the shipments in transit to the transit hooks and delivers
+// those that arrive at the current tick. Any quantity that does not fit in
+// the receiver's pool is lost.
+func (w *World) deliver() {
+	shipments := w.shipments[:0]
+	for _, s := range w.shipments {
+		for _, fn := range w.onTransit {
+			fn(s)
+		}
+		if s.Arrives > w.tick {
+			shipments = append(shipments, s)
+			continue
+		}
+		if s.Quantity > 0 {
+			s.To.Pools.Add(s.Resource, s.Quantity)
+		}
+		for _, fn := range w.onDeliver {
+			fn(s)
+		}
+	}
+	w.shipments = shipments
+}
+
+// OnTransit registers fn to be called each tick for each shipment in transit,
+// including the tick it arrives, before it is delivered. fn may reduce the
+// shipment's quantity.
+func (w *World) OnTransit(fn func(*Shipment)) {
+	w.onTransit = append(w.onTransit, fn)
+}
+
+// OnDeliver registers fn to be called for each shipment once it has been
+// delivered.
+func (w *World) OnDeliver(fn func(*Shipment)) {
+	w.onDeliver = append(w.onDeliver, fn)
+}
+
+// Shipments returns the shipments in transit in the order they were sent.
+func (w *World) Shipments() []*Shipment {
+	return append([]*Shipment(nil), w.shipments...)
+}
+
+// dropShipments discards the shipments to agent a, which are lost.
+func (w *World) dropShipments(a *Agent) {
+	shipments := w.shipments[:0]
+	for _, s := range w.shipments {
+		if s.To != a {
+			shipments = append(shipments, s)
+		}
+	}
+	w.shipments = shipments
+}
@@ -0,0 +1,102 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShipment(t *testing.T) {
+	resources, err := NewResourceParser().Parse(strings.NewReader(`
+resource grain
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	grain := resources[0]
+
+	rules, err := NewRuleParser(resources).Parse(strings.NewReader(`
+rule send
+	ship market grain 4
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNetwork()
+	farm := n.AddLocation(Position{})
+	town := n.AddLocation(Position{})
+	n.Connect(farm, town, 15*Kilometre)
+
+	w := NewWorld(nil)
+	w.Network = n
+
+	buyer := NewAgent("buyer")
+	buyer.Pools.AddPool(grain, 100, 0)
+	buyer.MoveTo(town)
+	w.AddAgent(buyer)
+
+	farmer := NewAgent("farmer")
+	farmer.Speed = 4 * Kilometre
+	farmer.Pools.AddPool(grain, 100, 6)
+	farmer.Relations = map[Relation]*Agent{"market": buyer}
+	farmer.AppendRules(rules)
+	farmer.MoveTo(farm)
+	w.AddAgent(farmer)
+
+	// Raiders take one unit from each shipment on the tick it sets out
+	w.OnTransit(func(s *Shipment) {
+		if s.Departed == w.tick {
+			s.Quantity--
+		}
+	})
+	var delivered []*Shipment
+	w.OnDeliver(func(s *Shipment) { delivered = append(delivered, s) })
+
+	// 15km at 4km a tick takes 4 ticks, arriving at the end of tick 5
+	for tick := int64(1); tick <= 5; tick++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tick == 1 {
+			if got := farmer.Pools.Quantity(grain); got != 2 {
+				t.Errorf("got farmer grain %d after shipping, wanted 2", got)
+			}
+			if got := w.Shipments(); len(got) != 1 || got[0].Arrives != 5 || len(got[0].Route) != 1 {
+				t.Fatalf("got %d shipments in transit, wanted 1 arriving at tick 5", len(got))
+			}
+		}
+		if tick < 5 && buyer.Pools.Quantity(grain) != 0 {
+			t.Errorf("tick %d: shipment arrived early", tick)
+		}
+	}
+	if got := buyer.Pools.Quantity(grain); got != 3 {
+		t.Errorf("got buyer grain %d, wanted 3", got)
+	}
+	if len(delivered) != 1 || len(w.Shipments()) != 0 {
+		t.Errorf("got %d deliveries and %d shipments in transit, wanted 1 and 0", len(delivered), len(w.Shipments()))
+	}
+}
+
+func TestShipmentFailureRefunds(t *testing.T) {
+	grain := &Resource{ID: "grain", Name: Name{Singular: "grain"}}
+	send := NewRule("send").Build()
+	send.Ships = []ResourceSpecifier{{Relation: "market", Resource: grain, Quantity: 4}}
+
+	w := NewWorld(nil)
+	farmer := NewAgent("farmer")
+	farmer.Pools.AddPool(grain, 100, 6)
+	farmer.AppendRules([]*Rule{send})
+	w.AddAgent(farmer)
+
+	if _, err := w.Tick(); err == nil {
+		t.Errorf("got no error shipping without a market, wanted one")
+	}
+	if got := farmer.Pools.Quantity(grain); got != 6 {
+		t.Errorf("got farmer grain %d after failing to ship, wanted 6", got)
+	}
+	if got := len(w.Shipments()); got != 0 {
+		t.Errorf("got %d shipments in transit, wanted none", got)
+	}
+}
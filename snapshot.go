@@ -0,0 +1,348 @@
+package rula
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// A WorldSnapshot holds the state of a World that changes as it runs: the
+// quantity and capacity of every pool, the locations, positions and movement
+// of agents, when each rule last ran, the number of agents spawned and the
+// state of the runner's random source. It encodes to stable JSON so it can be
+// used to save and load games. Agents are identified by singular name, rules
+// by name and resources by ID, falling back to singular name for resources
+// without an ID.
+//
+// Reservations, journeys under way, shipments in transit and offers on the
+// market are not included, and agents spawned or removed are not recreated,
+// so a snapshot is only complete for a world that holds none of them.
+type WorldSnapshot struct {
+	Tick    int64           `json:"tick"`
+	Rand    *uint64         `json:"rand,omitempty"` // state of the runner's seeded source, nil if a custom source was supplied
+	Pools   []PoolSnapshot  `json:"pools"`
+	Agents  []AgentSnapshot `json:"agents,omitempty"` // agents with a location or position
+	Rules   []RuleSnapshot  `json:"rules,omitempty"`
+	Budget  *BudgetSnapshot `json:"budget,omitempty"`
+	Spawned int             `json:"spawned,omitempty"` // number of agents spawned, used to name them
+}
+
+// An AgentSnapshot records where an agent is and how it is moving.
+type AgentSnapshot struct {
+	Agent    string    `json:"agent"`
+	Location string    `json:"location,omitempty"` // the name the location was added to the world with, or its ID in the world's network
+	Position *Position `json:"position,omitempty"`
+	Velocity *Position `json:"velocity,omitempty"`
+	Target   *Position `json:"target,omitempty"`
+}
+
+// A PoolSnapshot records the state of one pool.
+type PoolSnapshot struct {
+	Agent    string `json:"agent,omitempty"` // empty for the global pools
+	Resource string `json:"resource"`
+	Quantity int    `json:"quantity"`
+	Capacity int    `json:"capacity"`
+}
+
+// A RuleSnapshot records when a rule last ran for an agent. Global rules and
+// rules run with a context that has no agent have an empty agent ID. A global
+// rule run for each agent through the each relation records the agent and
+// has Each set.
+type RuleSnapshot struct {
+	Agent   string `json:"agent,omitempty"` // empty for global rules
+	Rule    string `json:"rule"`
+	LastRun int64  `json:"last_run"`
+	Each    bool   `json:"each,omitempty"` // a global rule run for the agent
+}
+
+// States returns when each rule last ran for each agent, ordered by agent
+// name then rule name, for saving across process restarts.
+func (ru *Runner) States() []RuleSnapshot {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	return ru.states()
+}
+
+func (ru *Runner) states() []RuleSnapshot {
+	var states []RuleSnapshot
+	for key, state := range ru.ruleStates {
+		rs := RuleSnapshot{Rule: key.rule.Name, LastRun: state.LastRun, Each: state.each}
+		if key.agent != nil {
+			rs.Agent = agentLabel(key.agent)
+		}
+		states = append(states, rs)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Agent != states[j].Agent {
+			return states[i].Agent < states[j].Agent
+		}
+		return states[i].Rule < states[j].Rule
+	})
+	return states
+}
+
+// LoadStates replaces the runner's rule states with states saved by States.
+// Rules with an agent ID are looked up among that agent's rules, and others
+// and those with Each set among the global rules, including the onfail rules
+// they invoke. global may
+// be nil. Nothing is changed if an error is returned.
+func (ru *Runner) LoadStates(states []RuleSnapshot, global *Global, agents []*Agent) error {
+	loaded, err := resolveStates(states, global, agents)
+	if err != nil {
+		return err
+	}
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	ru.ruleStates = loaded
+	return nil
+}
+
+func resolveStates(states []RuleSnapshot, global *Global, agents []*Agent) (map[stateKey]RuleState, error) {
+	byName := map[string]*Agent{}
+	for _, a := range agents {
+		byName[agentLabel(a)] = a
+	}
+
+	loaded := map[stateKey]RuleState{}
+	for _, r := range states {
+		var agent *Agent
+		var rules []*Rule
+		if global != nil {
+			rules = global.Rules
+		}
+		if r.Agent != "" {
+			a, ok := byName[r.Agent]
+			if !ok {
+				return nil, fmt.Errorf("unknown agent: %q", r.Agent)
+			}
+			agent = a
+			if !r.Each {
+				rules = a.AllRules()
+			}
+		}
+		rule := findRule(rules, r.Rule)
+		if rule == nil {
+			owner := snapshotOwner(r.Agent)
+			if r.Each {
+				owner = "global"
+			}
+			return nil, fmt.Errorf("unknown rule %q for %s", r.Rule, owner)
+		}
+		loaded[stateKey{agent: agent, rule: rule}] = RuleState{LastRun: r.LastRun, each: r.Each}
+	}
+	return loaded, nil
+}
+
+// A BudgetSnapshot records the rounds spent from the runner's tick budget.
+type BudgetSnapshot struct {
+	Tick int64 `json:"tick"`
+	Used int   `json:"used"`
+}
+
+// Snapshot returns the current state of the world.
+func (w *World) Snapshot() *WorldSnapshot {
+	s := &WorldSnapshot{Tick: w.tick, Spawned: w.spawned}
+	s.Pools = appendPoolSnapshots(s.Pools, "", w.Global.Pools)
+	for _, a := range w.agents {
+		s.Pools = appendPoolSnapshots(s.Pools, agentLabel(a), a.Pools)
+		if as, ok := w.agentSnapshot(a); ok {
+			s.Agents = append(s.Agents, as)
+		}
+	}
+
+	ru := w.runner
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if src, ok := ru.src.(*splitmix64); ok {
+		state := src.state
+		s.Rand = &state
+	}
+	if ru.tickBudget > 0 {
+		s.Budget = &BudgetSnapshot{Tick: ru.budgetTick, Used: ru.budgetUsed}
+	}
+	s.Rules = ru.states()
+	return s
+}
+
+func appendPoolSnapshots(dst []PoolSnapshot, agent string, ps *PoolSet) []PoolSnapshot {
+	for _, pool := range ps.Snapshot() {
+		dst = append(dst, PoolSnapshot{
+			Agent:    agent,
+			Resource: resourceLabel(pool.Resource),
+			Quantity: pool.Quantity,
+			Capacity: pool.Capacity,
+		})
+	}
+	return dst
+}
+
+// agentSnapshot returns the location and movement of a, or false if it has
+// neither a location nor a position.
+func (w *World) agentSnapshot(a *Agent) (AgentSnapshot, bool) {
+	if a.Location == nil && a.Position == nil {
+		return AgentSnapshot{}, false
+	}
+	as := AgentSnapshot{Agent: agentLabel(a), Position: copyPosition(a.Position), Target: copyPosition(a.Target)}
+	if a.Velocity != (Position{}) {
+		v := a.Velocity
+		as.Velocity = &v
+	}
+	if a.Location != nil {
+		as.Location = strconv.FormatInt(a.Location.ID(), 10)
+		for name, l := range w.locations {
+			if l == a.Location {
+				as.Location = name
+				break
+			}
+		}
+	}
+	return as, true
+}
+
+func copyPosition(p *Position) *Position {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	return &c
+}
+
+// location returns the location named in an AgentSnapshot.
+func (w *World) location(name string) (*Location, error) {
+	if l, ok := w.locations[name]; ok {
+		return l, nil
+	}
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil && w.Network != nil {
+		if l := w.Network.Location(id); l != nil {
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown location %q", name)
+}
+
+// Restore returns the world to the state recorded in s. The world must hold
+// the same agents, pools and rules as the world the snapshot was taken from,
+// and agents not recorded with a location or position are left with neither.
+// Restore fails if the world holds reservations, journeys under way or
+// shipments in transit, since a snapshot cannot replace them. Nothing is
+// changed if an error is returned.
+func (w *World) Restore(s *WorldSnapshot) error {
+	if len(w.journeys) > 0 || len(w.shipments) > 0 {
+		return fmt.Errorf("cannot restore a world with journeys or shipments under way")
+	}
+	if w.Global.Pools.hasReservations() {
+		return fmt.Errorf("cannot restore a world with reservations in the global pools")
+	}
+	agents := map[string]*Agent{}
+	for _, a := range w.agents {
+		if a.Pools.hasReservations() {
+			return fmt.Errorf("cannot restore a world with reservations in the pools of agent %q", agentLabel(a))
+		}
+		agents[agentLabel(a)] = a
+	}
+
+	type poolRef struct {
+		ps  *PoolSet
+		res *Resource
+	}
+	pools := make([]poolRef, len(s.Pools))
+	for i, p := range s.Pools {
+		ps := w.Global.Pools
+		if p.Agent != "" {
+			a, ok := agents[p.Agent]
+			if !ok {
+				return fmt.Errorf("unknown agent: %q", p.Agent)
+			}
+			ps = a.Pools
+		}
+		res := findPoolResource(ps, p.Resource)
+		if res == nil {
+			return fmt.Errorf("no pool for resource %q in %s", p.Resource, snapshotOwner(p.Agent))
+		}
+		pools[i] = poolRef{ps: ps, res: res}
+	}
+
+	places := map[*Agent]AgentSnapshot{}
+	locations := map[*Agent]*Location{}
+	for _, as := range s.Agents {
+		a, ok := agents[as.Agent]
+		if !ok {
+			return fmt.Errorf("unknown agent: %q", as.Agent)
+		}
+		places[a] = as
+		if as.Location != "" {
+			l, err := w.location(as.Location)
+			if err != nil {
+				return fmt.Errorf("agent %q: %w", as.Agent, err)
+			}
+			locations[a] = l
+		}
+	}
+
+	states, err := resolveStates(s.Rules, w.Global, w.agents)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range s.Pools {
+		pools[i].ps.SetCapacity(pools[i].res, p.Capacity)
+		pools[i].ps.setQuantity(pools[i].res, p.Quantity)
+	}
+	for _, a := range w.agents {
+		as := places[a]
+		a.MoveTo(locations[a])
+		a.Position, a.Target, a.Velocity = copyPosition(as.Position), copyPosition(as.Target), Position{}
+		if as.Velocity != nil {
+			a.Velocity = *as.Velocity
+		}
+	}
+	w.spawned = s.Spawned
+
+	ru := w.runner
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	ru.ruleStates = states
+	if s.Rand != nil {
+		ru.src = &splitmix64{state: *s.Rand}
+		ru.rand = rand.New(ru.src)
+	}
+	if s.Budget != nil {
+		ru.budgetTick, ru.budgetUsed = s.Budget.Tick, s.Budget.Used
+	}
+	w.tick = s.Tick
+	return nil
+}
+
+func snapshotOwner(agent string) string {
+	if agent == "" {
+		return "global"
+	}
+	return fmt.Sprintf("agent %q", agent)
+}
+
+// findPoolResource returns the resource of the pool in ps whose resource has
+// the given ID or, failing that, singular name.
+func findPoolResource(ps *PoolSet, label string) *Resource {
+	for _, pool := range ps.Snapshot() {
+		if resourceLabel(pool.Resource) == label {
+			return pool.Resource
+		}
+	}
+	return nil
+}
+
+// findRule returns the rule with the given name from rules or the onfail
+// rules they invoke.
+func findRule(rules []*Rule, name string) *Rule {
+	seen := map[*Rule]bool{}
+	for _, r := range rules {
+		for ; r != nil && !seen[r]; r = r.OnFail {
+			seen[r] = true
+			if r.Name == name {
+				return r
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package rula
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWorldSnapshot(t *testing.T) {
+	spec := `
+resource iron_ore
+end
+
+resource iron
+end
+
+rule mine
+	chance 50
+	in global iron_ore 1
+	out iron_ore 1
+end
+
+rule smelt
+	every 2
+	in iron_ore 2
+	out location iron 1
+end
+
+global world
+	pool iron_ore 1000 500
+end
+
+agent smelter
+	pool iron_ore 10
+	relation location town
+	rules mine smelt
+end
+
+location town
+	pool iron 100
+end
+`
+	newWorld := func() *World {
+		sc, err := NewScenarioParser().Parse(strings.NewReader(spec))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return NewScenarioWorld(sc, WithSeed(7))
+	}
+	tick := func(w *World, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := w.Tick(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	w := newWorld()
+	tick(w, 3)
+	data, err := json.Marshal(w.Snapshot())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tick(w, 5)
+	want := w.Snapshot()
+
+	// Restoring into a fresh world and running on reproduces the original
+	var s WorldSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restored := newWorld()
+	if err := restored.Restore(&s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.Time() != 3 {
+		t.Errorf("got time %d, wanted 3", restored.Time())
+	}
+	tick(restored, 5)
+	if diff := cmp.Diff(want, restored.Snapshot()); diff != "" {
+		t.Errorf("snapshot mismatch (-want +got):\n%s", diff)
+	}
+
+	s.Pools[0].Agent = "nobody"
+	if err := newWorld().Restore(&s); err == nil {
+		t.Errorf("got no error for unknown agent, wanted one")
+	}
+}
+
+func TestWorldSnapshotEach(t *testing.T) {
+	spec := `
+resource gold
+end
+
+rule tax
+	every 2
+	in each gold 1
+	out gold 1
+end
+
+global world
+	pool gold 100
+	rules tax
+end
+
+agent ann
+	pool gold 10 5
+end
+`
+	newWorld := func() *World {
+		sc, err := NewScenarioParser().Parse(strings.NewReader(spec))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return NewScenarioWorld(sc)
+	}
+	tick := func(w *World, n int) {
+		for i := 0; i < n; i++ {
+			if _, err := w.Tick(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	w := newWorld()
+	tick(w, 2)
+	s := w.Snapshot()
+	want := []RuleSnapshot{{Agent: "ann", Rule: "tax", LastRun: 2, Each: true}}
+	if diff := cmp.Diff(want, s.Rules); diff != "" {
+		t.Errorf("rule states mismatch (-want +got):\n%s", diff)
+	}
+	tick(w, 3)
+
+	restored := newWorld()
+	if err := restored.Restore(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tick(restored, 3)
+	if diff := cmp.Diff(w.Snapshot(), restored.Snapshot()); diff != "" {
+		t.Errorf("snapshot mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWorldSnapshotAgents(t *testing.T) {
+	grain := &Resource{ID: "grain", Name: Name{Singular: "grain"}}
+	newWorld := func() (*World, *Location, *Location) {
+		n := NewNetwork()
+		farm := n.AddLocation(Position{})
+		town := n.AddLocation(Position{East: Kilometre})
+		w := NewWorld(nil)
+		w.Network = n
+		w.AddLocation("town", town)
+
+		carter := NewAgent("carter")
+		carter.MoveTo(farm)
+		w.AddAgent(carter)
+		hawk := NewAgent("hawk")
+		hawk.SetPosition(Position{})
+		hawk.Velocity = Position{East: 10}
+		w.AddAgent(hawk)
+		return w, farm, town
+	}
+
+	w, _, town := newWorld()
+	carter, _ := w.Agent("carter")
+	carter.MoveTo(town)
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := json.Marshal(w.Snapshot())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var s WorldSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored, farm, town := newWorld()
+	if err := restored.Restore(&s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	carter, _ = restored.Agent("carter")
+	if carter.Location != town || len(farm.Occupants()) != 0 {
+		t.Errorf("got carter at %v, wanted town", carter.Location)
+	}
+	hawk, _ := restored.Agent("hawk")
+	if hawk.Position == nil || *hawk.Position != (Position{East: 10}) || hawk.Velocity != (Position{East: 10}) {
+		t.Errorf("got hawk at %v moving %v, wanted 10 east moving 10 east", hawk.Position, hawk.Velocity)
+	}
+
+	// Reservations cannot be restored
+	carter.Pools.AddPool(grain, 10, 5)
+	if _, ok := carter.Pools.Reserve(grain, 2); !ok {
+		t.Fatalf("reservation failed")
+	}
+	if err := restored.Restore(&s); err == nil {
+		t.Errorf("got no error restoring a world with reservations, wanted one")
+	}
+}
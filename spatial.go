@@ -1,5 +1,12 @@
 package rula
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 // A Length represents the linear distance between two points
 // as an int64 millimetre count
 type Length int64
@@ -13,33 +20,213 @@ const (
 	Mile              = 1609344 * Millimetre
 )
 
+var lengthUnits = []struct {
+	suffix string
+	unit   Length
+}{
+	// Longer suffixes first so that "mm" is not mistaken for "m"
+	{"mm", Millimetre},
+	{"cm", Centimetre},
+	{"km", Kilometre},
+	{"yd", Yard},
+	{"mi", Mile},
+	{"m", Metre},
+}
+
+// ParseLength parses an integer length with an optional unit suffix of
+// mm, cm, m, km, yd or mi. A length without a suffix is in millimetres.
+func ParseLength(s string) (Length, error) {
+	s = strings.TrimSpace(s)
+	unit := Millimetre
+	for _, u := range lengthUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.unit
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid length: %w", err)
+	}
+	return Length(n) * unit, nil
+}
+
 type Position struct {
 	East, North Length // distances from centre of map
 }
 
 // A Location is a physical location that can be occupied by an agent
 type Location struct {
-	id  int64
-	pos Position
+	id        int64
+	pos       Position
+	pools     *PoolSet
+	occupants []*Agent
+	region    *Region
+	capacity  int  // maximum total size of occupants, unlimited if 0
+	incoming  int  // total size of agents on journeys to the location
+	removed   bool // whether the location has been removed from its network
+}
+
+func newLocation(id int64, pos Position) *Location {
+	return &Location{id: id, pos: pos, pools: NewPoolSet()}
+}
+
+func (l *Location) ID() int64 {
+	return l.id
+}
+
+func (l *Location) Position() Position {
+	return l.pos
+}
+
+// Pools returns the pools of the location, which agents at the location
+// reach through the location relation.
+func (l *Location) Pools() *PoolSet {
+	return l.pools
+}
+
+// Occupants returns the agents at the location in the order they arrived.
+func (l *Location) Occupants() []*Agent {
+	return append([]*Agent(nil), l.occupants...)
+}
+
+// MoveTo moves the agent to a location, removing it from the occupants of
+// its previous location and adding it to those of l. The pools of the
+// location are available to the agent's rules through the location relation
+// unless the agent has another agent in that relation. A nil location
+// removes the agent from the map. MoveTo does not check the capacity of l.
+func (a *Agent) MoveTo(l *Location) {
+	if a.Location == l {
+		return
+	}
+	if old := a.Location; old != nil {
+		for i, o := range old.occupants {
+			if o == a {
+				old.occupants = append(old.occupants[:i:i], old.occupants[i+1:]...)
+				break
+			}
+		}
+	}
+	a.Location = l
+	if l != nil {
+		l.occupants = append(l.occupants, a)
+	}
 }
 
 // Connection is a link between two locations, such as a road, river or sea route
 type Connection struct {
-	id       int64
-	from     *Location
-	to       *Location
-	distance Length
-	// Difficulty float64 // 0 is best conditions, e.g. well maintained highway
+	id         int64
+	from       *Location
+	to         *Location
+	distance   Length
+	difficulty float64
+	kind       string // such as road, river or sea
+	oneWay     bool   // travelled only from the from location to the to location
+	removed    bool   // whether the connection has been removed from its network
+}
+
+func (c *Connection) ID() int64 {
+	return c.id
+}
+
+// From and To return the locations at either end of the connection, in the
+// order they were connected.
+func (c *Connection) From() *Location {
+	return c.from
+}
+
+func (c *Connection) To() *Location {
+	return c.to
+}
+
+func (c *Connection) Distance() Length {
+	return c.distance
+}
+
+// Difficulty returns how hard the connection is to travel. 0 is the best
+// conditions, such as a well maintained highway, and each unit of difficulty
+// adds the connection's distance again to the cost of travelling it. See
+// Graph.SetDifficulty.
+func (c *Connection) Difficulty() float64 {
+	return c.difficulty
+}
+
+// A DifficultyFunc returns the difficulty of a connection for a particular
+// traveller.
+type DifficultyFunc func(c *Connection) float64
+
+// Impassable is the difficulty of a connection that cannot be travelled,
+// such as a washed out bridge or, for a ship, a road.
+var Impassable = math.Inf(1)
+
+// cost returns the distance of c weighted by difficulty d. Negative
+// difficulties count as 0.
+func (c *Connection) cost(d float64) Length {
+	if d <= 0 {
+		return c.distance
+	}
+	return Length(float64(c.distance) * (1 + d))
+}
+
+// Kind returns the kind of the connection, such as road, river or sea, or
+// the empty string if it has none. See Graph.SetKind.
+func (c *Connection) Kind() string {
+	return c.kind
+}
+
+// OneWay reports whether the connection can only be travelled from the
+// location returned by From to the one returned by To. See
+// Graph.ConnectOneWay.
+func (c *Connection) OneWay() bool {
+	return c.oneWay
+}
+
+// Removed reports whether the connection has been removed from its network.
+// See Graph.Disconnect.
+func (c *Connection) Removed() bool {
+	return c.removed
+}
+
+// leads reports whether the connection can be travelled from l.
+func (c *Connection) leads(l *Location) bool {
+	return !c.oneWay || c.from == l
+}
+
+// Other returns the location at the other end of the connection from l.
+func (c *Connection) Other(l *Location) *Location {
+	if c.from == l {
+		return c.to
+	}
+	return c.from
 }
 
 type Network interface {
 	// Location returns the location with the given ID if it exists
 	// in the network, and nil otherwise.
-	Location(id int64) Location
+	Location(id int64) *Location
 
 	// Locations returns all the locations in the network.
-	Locations() []Location
+	Locations() []*Location
 
 	// Connection returns all the connections between a and b in the network.
-	Connection(a, b int64) []Connection
+	Connection(a, b int64) []*Connection
+
+	// Route returns the connections along the cheapest route from one
+	// location to another and the route's cost, its length weighted by
+	// the difficulty of its connections.
+	Route(from, to int64) ([]*Connection, Length, error)
+
+	// RouteFor is like Route but takes the difficulty of each connection
+	// from fn, if it is not nil.
+	RouteFor(from, to int64, fn DifficultyFunc) ([]*Connection, Length, error)
+
+	// Nearest returns the location closest to pos in a straight line, or
+	// nil if there are none.
+	Nearest(pos Position) *Location
+
+	// Within returns the locations no further than radius from pos in a
+	// straight line, nearest first.
+	Within(pos Position, radius Length) []*Location
 }
@@ -0,0 +1,45 @@
+package rula
+
+import "hash/fnv"
+
+// WithStagger spreads the runs of periodic rules shared by many agents over
+// the ticks of each rule's period. Agents without a Phase are given one
+// derived from their ID, so the assignment is the same in every run of a
+// simulation.
+func WithStagger() RunnerOption {
+	return func(ru *Runner) {
+		ru.stagger = true
+	}
+}
+
+// phase returns the number of ticks by which the first run of a rule with
+// the given period is delayed for agent a.
+func (ru *Runner) phase(a *Agent, period int) int64 {
+	if a == nil || period <= 1 {
+		return 0
+	}
+	if a.Phase != 0 {
+		p := a.Phase % period
+		if p < 0 {
+			p += period
+		}
+		return int64(p)
+	}
+	if !ru.stagger {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(agentLabel(a)))
+	return int64(h.Sum32() % uint32(period))
+}
+
+// nextRun returns the tick from which a rule with the given period that was
+// last run at lastRun is due for agent a. A rule that has never run is
+// delayed by the agent's phase.
+func (ru *Runner) nextRun(a *Agent, lastRun int64, period int) int64 {
+	next := lastRun + int64(period)
+	if lastRun == 0 {
+		next += ru.phase(a, period)
+	}
+	return next
+}
@@ -0,0 +1,72 @@
+package rula
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAgentPhase(t *testing.T) {
+	harvest := &Rule{Name: "harvest", Period: 4}
+	var agents []*Agent
+	for i := 0; i < 3; i++ {
+		a := NewAgent(fmt.Sprintf("farm%d", i))
+		a.Phase = i * 3
+		a.AppendRules([]*Rule{harvest})
+		agents = append(agents, a)
+	}
+
+	var got []string
+	for _, d := range NewRunner().Upcoming(agents, 1, 10) {
+		got = append(got, fmt.Sprintf("%d:%s", d.Tick, d.Agent.Name.Singular))
+	}
+	want := []string{"4:farm0", "6:farm2", "7:farm1", "8:farm0", "10:farm2"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Upcoming() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithStagger(t *testing.T) {
+	firstRuns := func() map[string]int64 {
+		harvest := &Rule{Name: "harvest", Period: 10}
+		w := NewWorld(nil, WithStagger())
+		for i := 0; i < 20; i++ {
+			a := NewAgent(fmt.Sprintf("farm%d", i))
+			a.AppendRules([]*Rule{harvest})
+			w.AddAgent(a)
+		}
+		first := map[string]int64{}
+		for i := 0; i < 20; i++ {
+			report, err := w.Tick()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for j, rr := range report.Agents {
+				id := w.Agents()[j].ID
+				if _, ok := first[id]; !ok && rr.Results[0].Due {
+					first[id] = w.Time()
+				}
+			}
+		}
+		return first
+	}
+
+	got := firstRuns()
+	ticks := map[int64]bool{}
+	for id, tick := range got {
+		if tick < 10 || tick >= 20 {
+			t.Errorf("%s first ran at tick %d, wanted a tick in [10,20)", id, tick)
+		}
+		ticks[tick] = true
+	}
+	if len(got) != 20 {
+		t.Errorf("got %d agents run, wanted 20", len(got))
+	}
+	if len(ticks) < 2 {
+		t.Errorf("all agents first ran on the same tick")
+	}
+	if diff := cmp.Diff(got, firstRuns()); diff != "" {
+		t.Errorf("phases not deterministic (-first +second):\n%s", diff)
+	}
+}
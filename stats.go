@@ -0,0 +1,188 @@
+package rula
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RuleStats are counters for a rule accumulated over every agent it was run
+// for.
+type RuleStats struct {
+	Rule     *Rule
+	Attempts int               // times the rule was due and attempted
+	Fired    int               // attempts that completed at least one round
+	Blocked  int               // attempts that were stopped before completing every round
+	Rounds   int               // rounds completed, including fixpoint passes
+	Consumed map[*Resource]int // total quantity removed from pools
+	Produced map[*Resource]int // total quantity added to pools
+}
+
+// Stats are the counters for every rule a Runner has attempted, in the order
+// the rules were first attempted.
+type Stats struct {
+	Rules []*RuleStats
+}
+
+// Rule returns the counters for rule or nil if it has not been attempted.
+func (s *Stats) Rule(rule *Rule) *RuleStats {
+	for _, rs := range s.Rules {
+		if rs.Rule == rule {
+			return rs
+		}
+	}
+	return nil
+}
+
+type counters struct {
+	rules map[*Rule]*RuleStats
+	order []*Rule
+}
+
+func (s *counters) rule(r *Rule) *RuleStats {
+	if s.rules == nil {
+		s.rules = map[*Rule]*RuleStats{}
+	}
+	rs, ok := s.rules[r]
+	if !ok {
+		rs = &RuleStats{Rule: r, Consumed: map[*Resource]int{}, Produced: map[*Resource]int{}}
+		s.rules[r] = rs
+		s.order = append(s.order, r)
+	}
+	return rs
+}
+
+// Stats returns a copy of the counters for every rule the runner has
+// attempted.
+func (ru *Runner) Stats() *Stats {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+
+	st := &Stats{}
+	for _, r := range ru.counters.order {
+		rs := *ru.counters.rules[r]
+		rs.Consumed = make(map[*Resource]int, len(rs.Consumed))
+		for res, q := range ru.counters.rules[r].Consumed {
+			rs.Consumed[res] = q
+		}
+		rs.Produced = make(map[*Resource]int, len(rs.Produced))
+		for res, q := range ru.counters.rules[r].Produced {
+			rs.Produced[res] = q
+		}
+		st.Rules = append(st.Rules, &rs)
+	}
+	return st
+}
+
+// ResetStats clears the runner's counters.
+func (ru *Runner) ResetStats() {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	ru.counters = counters{}
+}
+
+// recordResult adds the outcome of an attempt to run a rule to the counters.
+func (ru *Runner) recordResult(res *RuleResult) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	rs := ru.counters.rule(res.Rule)
+	rs.Attempts++
+	if res.Fired() {
+		rs.Fired++
+	}
+	if res.Blocked != nil {
+		rs.Blocked++
+	}
+	rs.Rounds += res.Rounds
+}
+
+// recordRounds adds rounds run outside of a counted attempt, such as in a
+// fixpoint pass, to the counters.
+func (ru *Runner) recordRounds(rule *Rule, rounds int) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	ru.counters.rule(rule).Rounds += rounds
+}
+
+// changed records a change made by a rule, or by decay or regeneration, and
+// reports it to the hooks.
+func (ru *Runner) changed(c ResourceChange) {
+	// Changes made by decay and regeneration are not counted against a rule
+	if c.Rule != nil {
+		ru.mu.Lock()
+		rs := ru.counters.rule(c.Rule)
+		if d := c.New - c.Old; d < 0 {
+			rs.Consumed[c.Resource] -= d
+		} else if d > 0 {
+			rs.Produced[c.Resource] += d
+		}
+		ru.mu.Unlock()
+	}
+
+	ru.hooks.resourceChanged(c)
+}
+
+// WritePrometheus writes the counters in the Prometheus text exposition
+// format. Rules are labelled by name and resources by ID, or by singular name
+// if they have no ID.
+func (s *Stats) WritePrometheus(w io.Writer) error {
+	counters := []struct {
+		name, help string
+		value      func(*RuleStats) int
+	}{
+		{"rula_rule_attempts_total", "Number of times a rule was due and attempted.", func(rs *RuleStats) int { return rs.Attempts }},
+		{"rula_rule_fired_total", "Number of attempts that completed at least one round.", func(rs *RuleStats) int { return rs.Fired }},
+		{"rula_rule_blocked_total", "Number of attempts stopped before completing every round.", func(rs *RuleStats) int { return rs.Blocked }},
+		{"rula_rule_rounds_total", "Number of rounds completed.", func(rs *RuleStats) int { return rs.Rounds }},
+	}
+
+	var b strings.Builder
+	for _, c := range counters {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, rs := range s.Rules {
+			fmt.Fprintf(&b, "%s{rule=\"%s\"} %d\n", c.name, promLabel(rs.Rule.Name), c.value(rs))
+		}
+	}
+
+	flows := []struct {
+		name, help string
+		value      func(*RuleStats) map[*Resource]int
+	}{
+		{"rula_rule_consumed_total", "Quantity of a resource removed from pools by a rule.", func(rs *RuleStats) map[*Resource]int { return rs.Consumed }},
+		{"rula_rule_produced_total", "Quantity of a resource added to pools by a rule.", func(rs *RuleStats) map[*Resource]int { return rs.Produced }},
+	}
+	for _, f := range flows {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", f.name, f.help, f.name)
+		for _, rs := range s.Rules {
+			m := f.value(rs)
+			labels := make([]string, 0, len(m))
+			values := map[string]int{}
+			for r, q := range m {
+				l := resourceLabel(r)
+				labels = append(labels, l)
+				values[l] += q
+			}
+			sort.Strings(labels)
+			for _, l := range labels {
+				fmt.Fprintf(&b, "%s{rule=\"%s\",resource=\"%s\"} %d\n", f.name, promLabel(rs.Rule.Name), promLabel(l), values[l])
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func resourceLabel(r *Resource) string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Name.Singular
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(s string) string {
+	return promEscaper.Replace(s)
+}
@@ -0,0 +1,53 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunnerStats(t *testing.T) {
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Repeat(1).Build()
+	ps := NewPoolSet(
+		&Pool{Resource: ironOre, Capacity: 10, Quantity: 6},
+		&Pool{Resource: iron, Capacity: 10},
+	)
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+	runner := NewRunner()
+	for tick := int64(1); tick <= 3; tick++ {
+		if _, err := runner.Run([]*Rule{smelt}, tick, ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	rs := runner.Stats().Rule(smelt)
+	if rs == nil {
+		t.Fatalf("no stats for smelt")
+	}
+	if rs.Attempts != 3 || rs.Fired != 2 || rs.Blocked != 2 || rs.Rounds != 3 {
+		t.Errorf("got attempts %d fired %d blocked %d rounds %d, wanted 3, 2, 2, 3", rs.Attempts, rs.Fired, rs.Blocked, rs.Rounds)
+	}
+	if rs.Consumed[ironOre] != 6 || rs.Produced[iron] != 3 {
+		t.Errorf("got consumed %v produced %v, wanted 6 iron_ore and 3 iron", rs.Consumed, rs.Produced)
+	}
+
+	var b strings.Builder
+	if err := runner.Stats().WritePrometheus(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE rula_rule_attempts_total counter",
+		`rula_rule_fired_total{rule="smelt"} 2`,
+		`rula_rule_consumed_total{rule="smelt",resource="iron_ore"} 6`,
+		`rula_rule_produced_total{rule="smelt",resource="iron"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("output missing %q:\n%s", line, b.String())
+		}
+	}
+
+	runner.ResetStats()
+	if got := runner.Stats().Rules; len(got) != 0 {
+		t.Errorf("got %d rule stats after reset, wanted 0", len(got))
+	}
+}
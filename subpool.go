@@ -0,0 +1,93 @@
+package rula
+
+import (
+	"strings"
+	"sync"
+)
+
+// subPoolSep separates the resource and pool names of a sub-pool, as in
+// iron@warehouse.
+const subPoolSep = "@"
+
+type subKey struct {
+	base *Resource
+	pool string
+}
+
+// subResources holds the resource of each sub-pool so that Sub returns the
+// same value each time.
+var subResources struct {
+	sync.Mutex
+	m map[subKey]*Resource
+}
+
+// Sub returns the resource identifying the sub-pool of r with the given name,
+// such as the warehouse rather than the market stall stock of a resource. An
+// agent may hold a pool of r and of any number of its sub-pools, each of which
+// rules and pool sets treat as a separate resource. The sub-pool resource has
+// the name of r, r as its Base and an ID made of the ID of r, or its singular
+// name, and the pool name separated by @. Sub returns the same value for the
+// same resource and pool name.
+func (r *Resource) Sub(pool string) *Resource {
+	if r.Base != nil {
+		r = r.Base
+	}
+	subResources.Lock()
+	defer subResources.Unlock()
+	key := subKey{r, pool}
+	if sub, ok := subResources.m[key]; ok {
+		return sub
+	}
+	if subResources.m == nil {
+		subResources.m = map[subKey]*Resource{}
+	}
+	sub := &Resource{
+		ID:       resourceLabel(r) + subPoolSep + pool,
+		Name:     r.Name,
+		Decay:    r.Decay,
+		Regen:    r.Regen,
+		Weight:   r.Weight,
+		Volume:   r.Volume,
+		Event:    r.Event,
+		Currency: r.Currency,
+		Base:     r,
+		Pool:     pool,
+	}
+	subResources.m[key] = sub
+	return sub
+}
+
+// splitSubPool splits a reference such as iron@warehouse into the resource
+// and pool names. The pool name is empty if there is none.
+func splitSubPool(ref string) (string, string) {
+	if i := strings.LastIndex(ref, subPoolSep); i > 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// AddSubPool adds a pool for the named sub-pool of resource r.
+func (p *PoolSet) AddSubPool(r *Resource, pool string, capacity, quantity int) {
+	p.AddPool(r.Sub(pool), capacity, quantity)
+}
+
+// SubPool returns the pool holding the named sub-pool of resource r or nil if
+// there is none.
+func (p *PoolSet) SubPool(r *Resource, pool string) *Pool {
+	return p.Pool(r.Sub(pool))
+}
+
+// SubPools returns the pools in the set holding resource r or any of its
+// sub-pools, in the order they were added.
+func (p *PoolSet) SubPools(r *Resource) []*Pool {
+	if r.Base != nil {
+		r = r.Base
+	}
+	var pools []*Pool
+	for _, pool := range p.Pools() {
+		if pool.Resource.Is(r) || pool.Resource.Base.Is(r) {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
@@ -0,0 +1,51 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubPools(t *testing.T) {
+	stock := &Resource{ID: "stock", Name: Name{Singular: "stock"}}
+	if stock.Sub("stall") != stock.Sub("stall") || stock.Sub("stall").Sub("stall") != stock.Sub("stall") {
+		t.Errorf("Sub returned different values")
+	}
+
+	rules, err := NewRuleParser([]*Resource{stock}).Parse(strings.NewReader(`rule restock
+	in stock@warehouse 2
+	out stock@stall 2
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	agents, err := NewAgentParser([]*Resource{stock}, nil).Parse(strings.NewReader(`agent trader
+	pool stock 10 1
+	pool stock@warehouse 100 5
+	pool stock@stall 3
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ps := agents[0].Pools
+
+	ru := NewRunner()
+	if _, err := ru.RunRule(rules[0], 1, agents[0].RuleContext()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := ps.SubPool(stock, "warehouse").Quantity; got != 3 {
+		t.Errorf("got %d in warehouse, wanted 3", got)
+	}
+	if got := ps.SubPool(stock, "stall").Quantity; got != 2 {
+		t.Errorf("got %d in stall, wanted 2", got)
+	}
+	if got := ps.Quantity(stock); got != 1 {
+		t.Errorf("got %d in main pool, wanted 1", got)
+	}
+	if got := len(ps.SubPools(stock)); got != 3 {
+		t.Errorf("got %d pools of stock, wanted 3", got)
+	}
+}
@@ -0,0 +1,55 @@
+package rula
+
+// An AgentTemplate describes the pools, rules and relations of a kind of
+// agent so that agents of that kind can be created consistently while a
+// simulation runs. Templates can be declared in agent files; see
+// AgentParser.
+type AgentTemplate struct {
+	Name      string
+	Pools     *PoolSet // copied into each agent, including capacities and initial quantities
+	Rules     []*Rule
+	Relations map[Relation]*Agent
+	Parent    *Agent // see Agent.SetParent
+	Attrs     map[string]string
+}
+
+// NewAgentTemplate returns an empty template.
+func NewAgentTemplate(name string) *AgentTemplate {
+	return &AgentTemplate{
+		Name:      name,
+		Pools:     NewPoolSet(),
+		Relations: map[Relation]*Agent{},
+	}
+}
+
+// Spawn returns a new agent with the given name made from the template.
+func (t *AgentTemplate) Spawn(name string) *Agent {
+	a := NewAgent(name)
+	t.apply(a)
+	return a
+}
+
+// apply adds the template's pools, rules, relations and attributes to a,
+// replacing any pools of the same resources.
+func (t *AgentTemplate) apply(a *Agent) {
+	Merge(a.Pools, t.Pools)
+	if weight, volume := t.Pools.CarryLimit(); weight > 0 || volume > 0 {
+		a.SetCarryLimit(weight, volume)
+	}
+	if t.Pools.AutoCreates() {
+		a.Pools.SetAutoCreate(true)
+	}
+	if c := t.Pools.DefaultCapacity(); c != 0 {
+		a.Pools.SetDefaultCapacity(c)
+	}
+	a.AppendRules(t.Rules)
+	for rel, ra := range t.Relations {
+		a.AddRelation(rel, ra)
+	}
+	for name, v := range t.Attrs {
+		a.SetAttr(name, v)
+	}
+	if t.Parent != nil {
+		a.SetParent(t.Parent, t.Pools.Parent() == t.Parent.Pools)
+	}
+}
@@ -0,0 +1,71 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAgentTemplate(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	gold := &Resource{Name: Name{Singular: "gold"}}
+	eat := NewRule("eat").In(RelationSelf, food, 1).Build()
+
+	p := NewAgentParser([]*Resource{food, gold}, map[string][]*Rule{"life": {eat}})
+	agents, err := p.Parse(strings.NewReader(`
+agent lord
+	template peasant
+	pool gold 100 50
+end
+
+agent village
+end
+
+template peasant
+	pool food 10 5
+	pool gold 10 -2 negative
+	relation home village
+	rules life
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lord, village := agents[0], agents[1]
+	if got := lord.Pools.Quantity(gold); got != 50 {
+		t.Errorf("got lord gold %d, wanted 50", got)
+	}
+	if got := lord.Pools.Quantity(food); got != 5 {
+		t.Errorf("got lord food %d, wanted 5", got)
+	}
+
+	tmpl, ok := p.Template("peasant")
+	if !ok {
+		t.Fatalf("template not found")
+	}
+	a := tmpl.Spawn("piers")
+	b := tmpl.Spawn("joan")
+	a.Pools.Remove(food, 3)
+	if got := b.Pools.Quantity(food); got != 5 {
+		t.Errorf("got food %d for second spawned agent, wanted 5", got)
+	}
+	if got := b.Pools.Quantity(gold); got != -2 || !b.Pools.AllowsNegative(gold) {
+		t.Errorf("got gold %d, wanted -2 allowing negative quantities", got)
+	}
+	if b.Relations["home"] != village {
+		t.Errorf("home relation not set")
+	}
+	if len(b.Rules) != 1 || b.Rules[0] != eat {
+		t.Errorf("got rules %v, wanted eat", b.Rules)
+	}
+	if b.Name.Singular != "joan" {
+		t.Errorf("got name %q, wanted joan", b.Name.Singular)
+	}
+
+	// Templates remain available to later files
+	if _, err := p.Parse(strings.NewReader("agent miller\n\ttemplate peasant\nend\n")); err != nil {
+		t.Errorf("unexpected error using template from earlier file: %v", err)
+	}
+	if _, err := p.Parse(strings.NewReader("agent miller\n\ttemplate knight\nend\n")); err == nil {
+		t.Errorf("got no error for unknown template")
+	}
+}
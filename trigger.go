@@ -0,0 +1,35 @@
+package rula
+
+import "fmt"
+
+// Trigger runs the rule with the given name from the rules of agent a, or
+// from the global rules if a is nil, at the world's current tick. It is
+// meant for manual rules, but any rule that is due can be triggered. As in
+// Tick, the agents it spawns are added to the world, its offers are posted,
+// its shipments sent and its agent moved or destroyed, with any failures
+// returned as a *TickError. Its onfail rule is run if it cannot run.
+func (w *World) Trigger(a *Agent, name string) (*RuleResult, error) {
+	var rules []*Rule
+	var ctx RuleContext
+	if a == nil {
+		rules, ctx = w.Global.Rules, w.Global.RuleContext()
+	} else {
+		rules, ctx = a.AllRules(), agentContext(a, w.Global)
+	}
+	rule := findRule(rules, name)
+	if rule == nil {
+		return nil, fmt.Errorf("unknown rule %q", name)
+	}
+
+	res, err := w.runner.RunRule(rule, w.tick, ctx)
+	if err != nil {
+		return res, err
+	}
+	destroy, errs := w.lifecycle(a, &RunReport{Tick: w.tick, Results: []*RuleResult{res}})
+	if destroy && w.RemoveAgent(a) {
+		for _, fn := range w.onDestroy {
+			fn(a)
+		}
+	}
+	return res, tickError(errs)
+}
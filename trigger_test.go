@@ -0,0 +1,42 @@
+package rula
+
+import "testing"
+
+func TestWorldTrigger(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	feast := NewRule("feast").In(RelationSelf, food, 3).Manual().Build()
+	leave := NewRule("leave").Manual().Build()
+	leave.Destroy = true
+
+	guest := NewAgent("guest")
+	guest.AddPool(food, 10, 5)
+	guest.Rules = []*Rule{feast, leave}
+
+	w := NewWorld(nil)
+	w.AddAgent(guest)
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := guest.Pools.Quantity(food); got != 5 {
+		t.Fatalf("got food %d after a tick, wanted manual rule not to run", got)
+	}
+
+	res, err := w.Trigger(guest, "feast")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Fired() || guest.Pools.Quantity(food) != 2 {
+		t.Errorf("got fired %v and food %d, wanted rule to fire leaving 2", res.Fired(), guest.Pools.Quantity(food))
+	}
+
+	if _, err := w.Trigger(guest, "leave"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w.Agents()) != 0 {
+		t.Errorf("got %d agents, wanted guest destroyed", len(w.Agents()))
+	}
+
+	if _, err := w.Trigger(nil, "feast"); err == nil {
+		t.Errorf("got no error triggering an unknown global rule, wanted one")
+	}
+}
@@ -0,0 +1,182 @@
+package rula
+
+// A txn stages changes to pool quantities so that the effects of a rule are
+// applied all together or not at all. Quantities read through the txn
+// reflect the changes staged so far. A txn with a parent commits its changes
+// to the parent rather than the pools.
+type txn struct {
+	rule       *Rule
+	agent      *Agent
+	each       bool // the rule is a global rule run for agent through the each relation
+	preview    bool // the txn is never committed to the pools so must not create them
+	parent     *txn
+	quantities map[txnKey]int
+	changes    []txnChange
+	overflows  []OverflowEvent // excess output to report under OverflowCallback
+}
+
+type txnKey struct {
+	ps *PoolSet
+	r  *Resource
+}
+
+// keyFor returns the key of the pool of resource r in ps, identifying the
+// pool by the pool set holding it and the resource by the value its pool was
+// added with.
+func keyFor(ps *PoolSet, r *Resource) txnKey {
+	ps, r = ps.key(r)
+	return txnKey{ps, r}
+}
+
+type txnChange struct {
+	key    txnKey
+	change ResourceChange
+}
+
+// newTxn returns a txn staging the changes made by rule. A txn without a
+// parent takes the agent the rule is run for from ctx.
+func newTxn(rule *Rule, parent *txn, ctx RuleContext) *txn {
+	t := &txn{
+		rule:       rule,
+		agent:      ctx.Agent,
+		parent:     parent,
+		quantities: map[txnKey]int{},
+	}
+	_, t.each = ctx.Pools[RelationEach]
+	if parent != nil {
+		t.agent, t.each, t.preview = parent.agent, parent.each, parent.preview
+	}
+	return t
+}
+
+// quantity returns the staged quantity of resource r in ps.
+func (t *txn) quantity(ps *PoolSet, r *Resource) int {
+	if q, ok := t.quantities[keyFor(ps, r)]; ok {
+		return q
+	}
+	if t.parent != nil {
+		return t.parent.quantity(ps, r)
+	}
+	return ps.Quantity(r)
+}
+
+// pool returns the pool of resource r in ps that changes are staged against,
+// creating it as ensure does. A preview txn creates no pool and returns the
+// pool that would be created instead.
+func (t *txn) pool(ps *PoolSet, r *Resource) *Pool {
+	if t.preview {
+		return ps.peekPool(r)
+	}
+	return ps.poolFor(r)
+}
+
+// stage records q as the new quantity of resource r in ps, clamping it to the
+// pool's capacity, and to zero unless it allows negative quantities, and
+// returning the excess. It follows the semantics of
+// PoolSet.Set.
+func (t *txn) stage(rel Relation, ps *PoolSet, r *Resource, q int) int {
+	pool := t.pool(ps, r)
+	if pool == nil {
+		return q
+	}
+	excess := 0
+	if capacity := ps.capacityOf(pool); q > capacity {
+		excess = q - capacity
+		q = capacity
+	} else if q < 0 && !ps.AllowsNegative(r) {
+		excess = q
+		q = 0
+	}
+
+	key := keyFor(ps, r)
+	old := t.quantity(ps, r)
+	t.quantities[key] = q
+	t.changes = append(t.changes, txnChange{
+		key:    key,
+		change: ResourceChange{Rule: t.rule, Agent: t.agent, Relation: rel, Resource: r, Old: old, New: q},
+	})
+	return excess
+}
+
+// remove stages the removal of quantity q of resource r following the
+// semantics of PoolSet.Remove.
+func (t *txn) remove(rel Relation, ps *PoolSet, r *Resource, q int) int {
+	if ps.Pool(r) == nil || q < 0 {
+		return q
+	}
+	cur := t.quantity(ps, r)
+	if cur-ps.reserved(r) < q && !ps.AllowsNegative(r) {
+		return q
+	}
+	t.stage(rel, ps, r, cur-q)
+	return 0
+}
+
+// add stages the addition of quantity q of resource r following the
+// semantics of PoolSet.Add.
+func (t *txn) add(rel Relation, ps *PoolSet, r *Resource, q int) int {
+	if t.pool(ps, r) == nil {
+		return q
+	}
+	return t.stage(rel, ps, r, t.quantity(ps, r)+q)
+}
+
+// set stages setting the quantity of resource r following the semantics of
+// PoolSet.Set.
+func (t *txn) set(rel Relation, ps *PoolSet, r *Resource, q int) int {
+	return t.stage(rel, ps, r, q)
+}
+
+// commit applies the staged changes to the pools and passes each change to
+// report. If the txn has a parent the changes are staged in the parent
+// instead and report is not called.
+func (t *txn) commit(report func(ResourceChange)) {
+	if t.parent != nil {
+		for key, q := range t.quantities {
+			t.parent.quantities[key] = q
+		}
+		t.parent.changes = append(t.parent.changes, t.changes...)
+		t.parent.overflows = append(t.parent.overflows, t.overflows...)
+		return
+	}
+	for key, q := range t.quantities {
+		key.ps.setQuantity(key.r, q)
+	}
+	for _, c := range t.changes {
+		report(c.change)
+	}
+}
+
+// net returns the combined change staged for each pool, in the order the
+// pools were first changed, with the quantity before the first change and
+// after the last. Pools with no net change are omitted.
+func (t *txn) net() []ResourceChange {
+	var net []ResourceChange
+	index := map[txnKey]int{}
+	for _, c := range t.changes {
+		if i, ok := index[c.key]; ok {
+			net[i].New = c.change.New
+			continue
+		}
+		index[c.key] = len(net)
+		net = append(net, c.change)
+	}
+
+	changed := net[:0]
+	for _, c := range net {
+		if c.Old != c.New {
+			changed = append(changed, c)
+		}
+	}
+	return changed
+}
+
+// deltas returns the net change staged for each pool, in the order the pools
+// were first changed. Pools with no net change are omitted.
+func (t *txn) deltas() []Delta {
+	var deltas []Delta
+	for _, c := range t.net() {
+		deltas = append(deltas, Delta{Relation: c.Relation, Resource: c.Resource, Quantity: c.New - c.Old})
+	}
+	return deltas
+}
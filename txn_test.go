@@ -0,0 +1,162 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunRuleAtomic(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule *Rule
+	}{
+		{
+			// Each input is available on its own but not both together
+			name: "shared input",
+			rule: NewRule("smelt").In(RelationSelf, ironOre, 2).In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Build(),
+		},
+		{
+			name: "missing output relation",
+			rule: NewRule("ship").In(RelationSelf, ironOre, 2).Out("port", iron, 1).Build(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ps := NewPoolSet(
+				&Pool{Resource: ironOre, Capacity: 10, Quantity: 3},
+				&Pool{Resource: iron, Capacity: 10},
+			)
+			ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+			res, err := NewRunner().RunRule(tc.rule, 1, ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Fired() || res.Blocked == nil {
+				t.Errorf("got result %+v, wanted rule to be blocked", res)
+			}
+			if got := ps.Quantity(ironOre); got != 3 {
+				t.Errorf("got iron_ore %d, wanted 3 after rollback", got)
+			}
+			if got := ps.Quantity(iron); got != 0 {
+				t.Errorf("got iron %d, wanted 0 after rollback", got)
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	idle := NewRule("idle").Manual().Set(RelationSelf, workers, 0).Build()
+	smelt := NewRule("smelt").
+		If(RelationSelf, ironOre, OpGreaterThanOrEqual, 2).
+		In(RelationSelf, ironOre, 2).
+		Out(RelationSelf, iron, 1).
+		Out(RelationGlobal, iron, 1).
+		Repeat(4).
+		OnFail(idle).
+		Build()
+
+	self := NewPoolSet(
+		&Pool{Resource: ironOre, Capacity: 10, Quantity: 5},
+		&Pool{Resource: iron, Capacity: 10},
+		&Pool{Resource: workers, Capacity: 10, Quantity: 3},
+	)
+	global := NewPoolSet(&Pool{Resource: iron, Capacity: 1})
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self, RelationGlobal: global}}
+
+	runner := NewRunner()
+	res, deltas, err := runner.Preview(smelt, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Rounds != 2 {
+		t.Errorf("got %d rounds, wanted 2", res.Rounds)
+	}
+	want := []Delta{
+		{Relation: RelationSelf, Resource: ironOre, Quantity: -4},
+		{Relation: RelationSelf, Resource: iron, Quantity: 2},
+		{Relation: RelationGlobal, Resource: iron, Quantity: 1},
+	}
+	if diff := cmp.Diff(want, deltas); diff != "" {
+		t.Errorf("deltas mismatch (-want +got):\n%s", diff)
+	}
+	if got := self.Quantity(ironOre); got != 5 {
+		t.Errorf("preview changed iron_ore to %d", got)
+	}
+
+	self.Set(ironOre, 1)
+	res, deltas, err = runner.Preview(smelt, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.OnFail == nil || !res.OnFail.Fired() {
+		t.Errorf("got onfail result %+v, wanted idle to fire", res.OnFail)
+	}
+	want = []Delta{{Relation: RelationSelf, Resource: workers, Quantity: -3}}
+	if diff := cmp.Diff(want, deltas); diff != "" {
+		t.Errorf("onfail deltas mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPreviewExchange(t *testing.T) {
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}, Currency: true}
+	price := &Resource{ID: "iron_price", Name: Name{Singular: "iron_price"}}
+	buy := &Rule{
+		Name:      "buy",
+		Period:    1,
+		Exchanges: []Exchange{{Give: gold, Get: iron, Quantity: 2, Rate: ResourceSource{Relation: RelationGlobal, Resource: price}}},
+	}
+	self := NewPoolSet(
+		&Pool{Resource: gold, Capacity: 100, Quantity: 10},
+		&Pool{Resource: iron, Capacity: 10},
+	)
+	global := NewPoolSet(&Pool{Resource: price, Capacity: 10, Quantity: 3})
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self, RelationGlobal: global}}
+
+	res, deltas, err := NewRunner().Preview(buy, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Rounds != 1 {
+		t.Errorf("got %d rounds, wanted 1", res.Rounds)
+	}
+	want := []Delta{
+		{Relation: RelationSelf, Resource: gold, Quantity: -6},
+		{Relation: RelationSelf, Resource: iron, Quantity: 2},
+	}
+	if diff := cmp.Diff(want, deltas); diff != "" {
+		t.Errorf("deltas mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPreviewAutoCreate(t *testing.T) {
+	smelt := NewRule("smelt").In(RelationSelf, ironOre, 2).Out(RelationSelf, iron, 1).Build()
+	self := NewPoolSet(&Pool{Resource: ironOre, Capacity: 10, Quantity: 2})
+	self.SetAutoCreate(true)
+	self.SetDefaultCapacity(5)
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: self}}
+
+	_, deltas, err := NewRunner().Preview(smelt, ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Delta{
+		{Relation: RelationSelf, Resource: ironOre, Quantity: -2},
+		{Relation: RelationSelf, Resource: iron, Quantity: 1},
+	}
+	if diff := cmp.Diff(want, deltas); diff != "" {
+		t.Errorf("deltas mismatch (-want +got):\n%s", diff)
+	}
+	if self.Pool(iron) != nil {
+		t.Errorf("preview created an iron pool")
+	}
+
+	if ex := NewRunner().Explain(smelt, ctx); !ex.CanRun {
+		t.Errorf("got explanation %+v, wanted rule to run", ex)
+	}
+	if self.Pool(iron) != nil {
+		t.Errorf("explain created an iron pool")
+	}
+}
@@ -1,8 +1,13 @@
 package rula
 
+import (
+	"fmt"
+	"sync"
+)
+
 type Name struct {
-	Plural   string
-	Singular string
+	Plural   string `json:"plural,omitempty"`
+	Singular string `json:"singular"`
 }
 
 func (n *Name) String() string {
@@ -11,114 +16,643 @@ func (n *Name) String() string {
 
 // A Resource is something that is used, consumed or produced
 type Resource struct {
-	ID   string
-	Name Name
+	ID   string `json:"id"`
+	Name Name   `json:"name"`
+
+	// Decay and Regen are the quantities removed from and added to every
+	// pool of the resource at the start of each Step, so stocks spoil or
+	// recover without a rule for each agent. Decay does not take a pool
+	// below zero and Regen does not fill it beyond its capacity.
+	Decay int `json:"decay,omitempty"`
+	Regen int `json:"regen,omitempty"`
+
+	// Weight and Volume are the weight and volume of one unit of the
+	// resource, counted against the carry limits of pool sets.
+	Weight int `json:"weight,omitempty"`
+	Volume int `json:"volume,omitempty"`
+
+	// Event marks a resource used as a message between agents, such as an
+	// alarm or an order, declared in resource files with event rather than
+	// resource. Any pool set accepts an event: a pool with
+	// unlimited capacity is created the first time one is added. The
+	// quantity of the pool is the number of events pending, which rules
+	// can test with a condition and consume as an input.
+	Event bool `json:"event,omitempty"`
+
+	// Currency marks a resource used to pay for others, such as gold,
+	// declared in resource files with currency rather than resource. Buy
+	// and sell offers must be priced in a currency.
+	Currency bool `json:"currency,omitempty"`
+
+	// Base and Pool are set for the resource of a sub-pool returned by Sub,
+	// holding the resource it is a sub-pool of and the name of the pool.
+	Base *Resource `json:"-"`
+	Pool string    `json:"-"`
+
+	// Index is the position of the resource in the ResourceRegistry that
+	// registered it, counting from 1. It is 0 for unregistered resources.
+	Index int `json:"-"`
 }
 
 func (r *Resource) String() string {
 	return r.Name.String()
 }
 
+// Is reports whether r and o are the same resource, which they are if they
+// are the same value or have the same non-empty ID. Resources decoded or
+// declared in several places are distinct values with the same ID.
+func (r *Resource) Is(o *Resource) bool {
+	if r == o {
+		return true
+	}
+	return r != nil && o != nil && r.ID != "" && r.ID == o.ID
+}
+
+// CapacityUnlimited is a pool capacity that no quantity can exceed.
+const CapacityUnlimited = int(^uint(0) >> 1)
+
 // A Pool is a store of resources
 type Pool struct {
 	Resource *Resource
 	Quantity int
 	Capacity int
+
+	// AllowNegative lets the quantity fall below zero, for resources such
+	// as debt or morale. Inputs drawn from the pool are always available.
+	AllowNegative bool
+
+	// Reserved is the quantity held by reservations, which cannot be
+	// removed from the pool until they are released. See PoolSet.Reserve.
+	Reserved int
+
+	// Link, if not nil, sets the capacity of the pool from the quantity of
+	// another resource in the same pool set. See PoolSet.LinkCapacity.
+	Link *CapacityLink
+}
+
+// A CapacityLink makes the capacity of a pool Factor times the quantity of
+// the Source resource, such as storage space provided by warehouses.
+type CapacityLink struct {
+	Source *Resource
+	Factor int
+}
+
+// A PoolSet holds at most one pool for each resource. Resources are matched
+// as Resource.Is does, so a pool can be found using any resource value with
+// the same ID as the one it was added with.
+type PoolSet struct {
+	pools map[*Resource]*Pool
+	order []*Resource          // resources in the order their pools were added
+	ids   map[string]*Resource // the key in pools of each resource with an ID
+
+	// A dense pool set holds its pools in a slice indexed by resource
+	// index instead of the map
+	registry *ResourceRegistry
+	dense    []*Pool
+
+	defaultCapacity int
+	autoCreate      bool // create missing pools when they are added to or set
+
+	maxWeight, maxVolume int // carry limits, unlimited if 0
+
+	parent *PoolSet // searched for pools the set does not hold, see SetParent
+
+	mu      *sync.RWMutex // guards the pool set if it was created by NewSyncPoolSet
+	version uint64        // incremented by every change made through the pool set's methods
+}
+
+// NewSyncPoolSet returns a pool set that is safe for concurrent use, so its
+// quantities can be read for display while a Runner updates it in another
+// goroutine. The Pool and Pools methods return the live pools whose fields
+// must not be accessed concurrently; use Quantity, Capacity or Snapshot
+// instead.
+func NewSyncPoolSet(pools ...*Pool) *PoolSet {
+	p := NewPoolSet(pools...)
+	p.mu = &sync.RWMutex{}
+	return p
+}
+
+func nop() {}
+
+// lock locks the pool set for a change, which it counts in the version.
+func (p *PoolSet) lock() func() {
+	// A change may be made to an inherited pool
+	for a := p.parent; a != nil; a = a.parent {
+		a.version++
+	}
+	if p.mu == nil {
+		p.version++
+		return nop
+	}
+	p.mu.Lock()
+	p.version++
+	return p.mu.Unlock
+}
+
+func (p *PoolSet) rlock() func() {
+	if p.mu == nil {
+		return nop
+	}
+	p.mu.RLock()
+	return p.mu.RUnlock
+}
+
+// NewPoolSet returns a pool set holding the supplied pools.
+func NewPoolSet(pools ...*Pool) *PoolSet {
+	p := &PoolSet{
+		pools: make(map[*Resource]*Pool, len(pools)),
+	}
+	for _, pool := range pools {
+		p.putPool(pool)
+	}
+	return p
+}
+
+// NewDensePoolSet returns a pool set that holds its pools in a slice indexed
+// by the Index of each resource rather than in a map, which uses less memory
+// and is faster when many agents hold pools of the same resources. Resources
+// added to the set that are not yet registered are registered with reg, which
+// must not be shared with pool sets being changed concurrently while that
+// happens. Adding a resource registered with a different registry panics.
+func NewDensePoolSet(reg *ResourceRegistry, pools ...*Pool) *PoolSet {
+	p := &PoolSet{
+		registry: reg,
+		dense:    make([]*Pool, reg.Len()),
+	}
+	for _, pool := range pools {
+		p.putPool(pool)
+	}
+	return p
+}
+
+// get returns the pool holding resource r, searching the set's parents if it
+// holds none.
+func (p *PoolSet) get(r *Resource) (*Pool, bool) {
+	pool, ok := p.own(r)
+	if !ok && p.parent != nil {
+		return p.parent.get(r)
+	}
+	return pool, ok
+}
+
+// own returns the pool held by the set for resource r.
+func (p *PoolSet) own(r *Resource) (*Pool, bool) {
+	if p.registry == nil {
+		pool, ok := p.pools[r]
+		if !ok && r != nil && r.ID != "" && p.ids != nil {
+			pool, ok = p.pools[p.ids[r.ID]]
+		}
+		return pool, ok
+	}
+	index := p.registry.indexOf(r)
+	if index == 0 || index > len(p.dense) {
+		return nil, false
+	}
+	pool := p.dense[index-1]
+	return pool, pool != nil
+}
+
+// key returns the pool set holding the pool of r, which is a parent of p if
+// the pool is inherited, and the resource that the pool was added with, or p
+// and r if there is no such pool, so that the same pool is always identified
+// by the same values.
+func (p *PoolSet) key(r *Resource) (*PoolSet, *Resource) {
+	if p == nil {
+		return p, r
+	}
+	if p.mu == nil && p.registry == nil {
+		// Avoid the cost of the full lookup in the common case
+		if _, ok := p.pools[r]; ok {
+			return p, r
+		}
+	}
+	for ps := p; ps != nil; ps = ps.parent {
+		if pool := ps.ownPool(r); pool != nil {
+			return ps, pool.Resource
+		}
+	}
+	return p, r
+}
+
+// ownPool returns the pool held by the set for resource r or nil if there is
+// none.
+func (p *PoolSet) ownPool(r *Resource) *Pool {
+	defer p.rlock()()
+	pool, _ := p.own(r)
+	return pool
+}
+
+func (p *PoolSet) putPool(pool *Pool) {
+	if pool.Resource == nil {
+		panic("nil resource supplied")
+	}
+	existing, exists := p.own(pool.Resource)
+	if !exists {
+		p.order = append(p.order, pool.Resource)
+	}
+	if p.registry == nil {
+		if exists {
+			// Replace the pool of the same resource under its original key
+			p.pools[existing.Resource] = pool
+			pool.Resource = existing.Resource
+			return
+		}
+		p.pools[pool.Resource] = pool
+		if id := pool.Resource.ID; id != "" {
+			if p.ids == nil {
+				p.ids = map[string]*Resource{}
+			}
+			p.ids[id] = pool.Resource
+		}
+		return
+	}
+	index := p.registry.indexOf(pool.Resource)
+	if index == 0 {
+		if err := p.registry.Register(pool.Resource); err != nil {
+			panic(err.Error())
+		}
+		index = pool.Resource.Index
+	}
+	if exists {
+		pool.Resource = existing.Resource
+	}
+	for len(p.dense) < index {
+		p.dense = append(p.dense, nil)
+	}
+	p.dense[index-1] = pool
+}
+
+// rebind replaces the resource of each pool with the resource in index that
+// has the same ID.
+func (p *PoolSet) rebind(index *resourceIndex) {
+	defer p.lock()()
+	for i, r := range p.order {
+		known, ok := index.id(r.ID)
+		if r.ID == "" || !ok || known == r {
+			continue
+		}
+		pool, _ := p.own(r)
+		pool.Resource = known
+		p.order[i] = known
+		if p.registry == nil {
+			delete(p.pools, r)
+			p.pools[known] = pool
+			p.ids[known.ID] = known
+		}
+	}
+}
+
+// Pool returns the pool holding resource r or nil if there is none.
+func (p *PoolSet) Pool(r *Resource) *Pool {
+	if p == nil || r == nil {
+		return nil
+	}
+	defer p.rlock()()
+	pool, _ := p.get(r)
+	return pool
+}
+
+// SetParent makes the set fall through to parent for any resource it holds no
+// pool for, so that quantities can be read from, added to and removed from the
+// pools of the parent, and in turn of its parents. Pools the set holds itself
+// take precedence. SetCapacity, LinkCapacity, SetAllowNegative and
+// AddUnlimited never change an inherited pool; they give the set an empty pool
+// of its own instead, which then shadows the parent's. Only the set itself is
+// locked when an inherited pool is changed, so a parent must not be changed
+// concurrently through other pool sets. A nil parent removes the fall through.
+// SetParent panics if parent is the set or one of its descendants.
+func (p *PoolSet) SetParent(parent *PoolSet) {
+	for a := parent; a != nil; a = a.parent {
+		if a == p {
+			panic("pool set hierarchy contains a cycle")
+		}
+	}
+	defer p.lock()()
+	p.parent = parent
+}
+
+// Parent returns the pool set the set falls through to, or nil if there is
+// none.
+func (p *PoolSet) Parent() *PoolSet {
+	defer p.rlock()()
+	return p.parent
+}
+
+// Pools returns the pools in the set in the order they were added. Pools
+// inherited from a parent are not included.
+func (p *PoolSet) Pools() []*Pool {
+	if p == nil {
+		return nil
+	}
+	defer p.rlock()()
+	pools := make([]*Pool, len(p.order))
+	for i, r := range p.order {
+		pools[i], _ = p.own(r)
+	}
+	return pools
+}
+
+// Snapshot returns copies of the pools in the set in the order they were
+// added.
+func (p *PoolSet) Snapshot() []Pool {
+	if p == nil {
+		return nil
+	}
+	defer p.rlock()()
+	pools := make([]Pool, len(p.order))
+	for i, r := range p.order {
+		pool, _ := p.own(r)
+		pools[i] = *pool
+	}
+	return pools
+}
+
+// changes returns the version of the pool set, which changes whenever a pool
+// in the set or its parents is changed through the sets' methods.
+func (p *PoolSet) changes() uint64 {
+	var v uint64
+	for a := p.parent; a != nil; a = a.parent {
+		v += a.changes()
+	}
+	defer p.rlock()()
+	return v + p.version
+}
+
+// Len returns the number of pools in the set.
+func (p *PoolSet) Len() int {
+	if p == nil {
+		return 0
+	}
+	defer p.rlock()()
+	return len(p.order)
+}
+
+// SetDefaultCapacity sets the capacity of pools in the set that have a
+// capacity of zero, which is treated as unset. By default the capacity of
+// such pools is zero and nothing can be added to them.
+func (p *PoolSet) SetDefaultCapacity(c int) {
+	defer p.lock()()
+	p.defaultCapacity = c
+}
+
+// DefaultCapacity returns the capacity used for pools with an unset capacity.
+func (p *PoolSet) DefaultCapacity() int {
+	if p == nil {
+		return 0
+	}
+	defer p.rlock()()
+	return p.defaultCapacity
+}
+
+// SetAutoCreate sets whether adding to or setting the quantity of a resource
+// that has no pool in the set, whether through Add and Set or by running a
+// rule, creates a pool for it. Otherwise the quantity is discarded. Created
+// pools have an unset capacity so they take the set's default capacity,
+// which can be CapacityUnlimited. A rule that is blocked after staging an
+// output may leave an empty pool behind.
+func (p *PoolSet) SetAutoCreate(auto bool) {
+	defer p.lock()()
+	p.autoCreate = auto
+}
+
+// AutoCreates reports whether the pool set creates missing pools when they
+// are added to or set.
+func (p *PoolSet) AutoCreates() bool {
+	if p == nil {
+		return false
+	}
+	defer p.rlock()()
+	return p.autoCreate
+}
+
+// ensure returns the pool holding resource r, creating it if there is none
+// and the pool set auto-creates pools or r is an event. The pool set must be
+// locked.
+func (p *PoolSet) ensure(r *Resource) (*Pool, bool) {
+	if pool, ok := p.get(r); ok || (!p.autoCreate && !r.Event) {
+		return pool, ok
+	}
+	pool := &Pool{Resource: r}
+	if r.Event {
+		pool.Capacity = CapacityUnlimited
+	}
+	p.putPool(pool)
+	return pool, true
+}
+
+// poolFor returns the pool holding resource r, creating it as ensure does,
+// or nil if there is none.
+func (p *PoolSet) poolFor(r *Resource) *Pool {
+	if pool := p.Pool(r); pool != nil || (!p.AutoCreates() && !r.Event) {
+		return pool
+	}
+	defer p.lock()()
+	pool, _ := p.ensure(r)
+	return pool
 }
 
-type PoolSet map[*Resource]*Pool
+// peekPool returns the pool holding resource r or, if there is none and
+// ensure would create one, a pool like the one it would create without
+// adding it to the set. It returns nil otherwise.
+func (p *PoolSet) peekPool(r *Resource) *Pool {
+	if pool := p.Pool(r); pool != nil || (!p.AutoCreates() && !r.Event) {
+		return pool
+	}
+	pool := &Pool{Resource: r}
+	if r.Event {
+		pool.Capacity = CapacityUnlimited
+	}
+	return pool
+}
+
+// capacityOf returns the effective capacity of pool in the set.
+func (p *PoolSet) capacityOf(pool *Pool) int {
+	defer p.rlock()()
+	return p.capacity(pool)
+}
+
+// capacity returns the effective capacity of a pool in the set.
+func (p *PoolSet) capacity(pool *Pool) int {
+	if pool.Link != nil {
+		if src, ok := p.get(pool.Link.Source); ok {
+			return src.Quantity * pool.Link.Factor
+		}
+		return 0
+	}
+	if pool.Capacity == 0 {
+		return p.defaultCapacity
+	}
+	return pool.Capacity
+}
 
-func (p PoolSet) SetCapacity(r *Resource, c int) {
-	pool, ok := p[r]
+// SetCapacity sets the capacity of the set's pool of resource r, adding an
+// empty pool if the set has none of its own.
+func (p *PoolSet) SetCapacity(r *Resource, c int) {
+	defer p.lock()()
+	pool, ok := p.own(r)
 	if !ok {
-		p[r] = &Pool{Resource: r, Capacity: c}
+		p.putPool(&Pool{Resource: r, Capacity: c})
 		return
 	}
 	pool.Capacity = c
 }
 
-func (p PoolSet) AddPool(r *Resource, capacity, quantity int) {
+// LinkCapacity makes the capacity of the set's pool of resource r factor times
+// the quantity of resource source in the set, replacing its own capacity, so
+// that it follows every change to the source. The capacity is zero while the
+// set has no pool of source. A pool left holding more than its capacity when
+// the source falls keeps its quantity but cannot be added to. A nil source
+// removes the link. An empty pool of r is added if the set has none of its
+// own.
+func (p *PoolSet) LinkCapacity(r, source *Resource, factor int) {
+	defer p.lock()()
+	pool, ok := p.own(r)
+	if !ok {
+		if source == nil {
+			return
+		}
+		pool = &Pool{Resource: r}
+		p.putPool(pool)
+	}
+	if source == nil {
+		pool.Link = nil
+		return
+	}
+	pool.Link = &CapacityLink{Source: source, Factor: factor}
+}
+
+// SetAllowNegative sets whether the quantity of the set's pool of resource r
+// may fall below zero, adding an empty pool if the set has none of its own.
+func (p *PoolSet) SetAllowNegative(r *Resource, allow bool) {
+	defer p.lock()()
+	pool, ok := p.own(r)
+	if !ok {
+		p.putPool(&Pool{Resource: r, AllowNegative: allow})
+		return
+	}
+	pool.AllowNegative = allow
+}
+
+// AllowsNegative reports whether the quantity of the pool holding resource r
+// may fall below zero.
+func (p *PoolSet) AllowsNegative(r *Resource) bool {
+	if p == nil || r == nil {
+		return false
+	}
+	defer p.rlock()()
+	pool, ok := p.get(r)
+	return ok && pool.AllowNegative
+}
+
+func (p *PoolSet) AddPool(r *Resource, capacity, quantity int) {
 	if r == nil {
 		panic("nil resource supplied")
 	}
-	p[r] = &Pool{Resource: r, Capacity: capacity, Quantity: quantity}
+	defer p.lock()()
+	p.putPool(&Pool{Resource: r, Capacity: capacity, Quantity: quantity})
 }
 
-func (p PoolSet) Quantity(r *Resource) int {
+// AddUnlimited gives the set's pool of resource r unlimited capacity, adding
+// an empty pool if the set has none of its own.
+func (p *PoolSet) AddUnlimited(r *Resource) {
+	if r == nil {
+		panic("nil resource supplied")
+	}
+	defer p.lock()()
+	if pool, ok := p.own(r); ok {
+		pool.Capacity = CapacityUnlimited
+		return
+	}
+	p.putPool(&Pool{Resource: r, Capacity: CapacityUnlimited})
+}
+
+func (p *PoolSet) Quantity(r *Resource) int {
 	if p == nil || r == nil {
 		return 0
 	}
-	pool, ok := p[r]
+	defer p.rlock()()
+	pool, ok := p.get(r)
 	if !ok {
 		return 0
 	}
 	return pool.Quantity
 }
 
-func (p PoolSet) Capacity(r *Resource) int {
+// Capacity returns the capacity of the pool holding resource r, using the
+// default capacity if the pool's capacity is unset.
+func (p *PoolSet) Capacity(r *Resource) int {
 	if p == nil || r == nil {
 		return 0
 	}
-	pool, ok := p[r]
+	defer p.rlock()()
+	pool, ok := p.get(r)
 	if !ok {
 		return 0
 	}
-	return pool.Capacity
+	return p.capacity(pool)
 }
 
 // Add adds quantity q of resource r to the poolset returning the amount that
-// could not be added. This will be 0 if there was a pool with sufficient capacity
-func (p PoolSet) Add(r *Resource, q int) int {
+// could not be added. This will be 0 if there was a pool with sufficient capacity,
+// or one was created because the pool set auto-creates pools. A negative q
+// removes from the pool, stopping at zero unless the pool allows negative
+// quantities, and the negative amount that could not be removed is returned.
+func (p *PoolSet) Add(r *Resource, q int) int {
 	if p == nil || r == nil {
 		return q
 	}
-	pool, ok := p[r]
+	defer p.lock()()
+	pool, ok := p.ensure(r)
 	if !ok {
 		return q
 	}
-	pool.Quantity += q
-
-	if pool.Quantity > pool.Capacity {
-		excess := pool.Quantity - pool.Capacity
-		pool.Quantity = pool.Capacity
-		return excess
-	}
-	return 0
+	return p.clamp(pool, pool.Quantity+q)
 }
 
 // Set sets the quantity of resource r to be q  returning the amount that
-// could not be added. This will be 0 if there was a pool with sufficient capacity
-func (p PoolSet) Set(r *Resource, q int) int {
+// could not be added. This will be 0 if there was a pool with sufficient capacity.
+// A negative q sets the quantity to zero unless the pool allows negative
+// quantities, returning q.
+func (p *PoolSet) Set(r *Resource, q int) int {
 	if p == nil || r == nil {
 		return q
 	}
-	pool, ok := p[r]
+	defer p.lock()()
+	pool, ok := p.ensure(r)
 	if !ok {
 		return q
 	}
-	pool.Quantity = q
+	return p.clamp(pool, q)
+}
 
-	if pool.Quantity > pool.Capacity {
-		excess := pool.Quantity - pool.Capacity
-		pool.Quantity = pool.Capacity
-		return excess
+// clamp sets the quantity of pool to q limited to its capacity and, unless it
+// allows negative quantities, to zero, returning the difference between q and
+// the quantity set.
+func (p *PoolSet) clamp(pool *Pool, q int) int {
+	pool.Quantity = q
+	if capacity := p.capacity(pool); q > capacity {
+		pool.Quantity = capacity
+	} else if q < 0 && !pool.AllowNegative {
+		pool.Quantity = 0
 	}
-	return 0
+	return q - pool.Quantity
 }
 
 // Remove removes quantity q of resource r from the poolset returning the amount that
-// could not be removed. This will be 0 if there was a pool with sufficient quantity. This
-// method does not split the removal quantity, it will either remove all of q or 0.
-func (p PoolSet) Remove(r *Resource, q int) int {
-	if p == nil || r == nil {
+// could not be removed. This will be 0 if there was a pool with sufficient unreserved
+// quantity or the pool allows negative quantities. This method does not split the removal quantity,
+// it will either remove all of q or 0. Nothing is removed if q is negative.
+func (p *PoolSet) Remove(r *Resource, q int) int {
+	if p == nil || r == nil || q < 0 {
 		return q
 	}
-	pool, ok := p[r]
+	defer p.lock()()
+	pool, ok := p.get(r)
 	if !ok {
 		return q
 	}
 
-	if pool.Quantity < q {
+	if pool.Quantity-pool.Reserved < q && !pool.AllowNegative {
 		return q
 	}
 
@@ -127,21 +661,136 @@ func (p PoolSet) Remove(r *Resource, q int) int {
 	return 0
 }
 
-func NewPoolSet() PoolSet {
-	return map[*Resource]*Pool{}
+// transferMu serializes transfers between pool sets that are safe for
+// concurrent use so that holding both of their locks cannot deadlock.
+var transferMu sync.Mutex
+
+// Transfer moves up to quantity q of resource r from the poolset to dst as a
+// single change, returning the amount that could not be moved. The amount
+// moved is limited by the unreserved quantity in the source pool, unless it allows
+// negative quantities, and by the space left in the destination pool, so
+// nothing is lost when the destination is full. Nothing is moved if either
+// pool does not exist or dst is the same pool set.
+func (p *PoolSet) Transfer(dst *PoolSet, r *Resource, q int) int {
+	if p == nil || dst == nil || r == nil || p == dst || q <= 0 {
+		return q
+	}
+	if p.mu != nil && dst.mu != nil {
+		transferMu.Lock()
+		defer transferMu.Unlock()
+	}
+	defer p.lock()()
+	defer dst.lock()()
+
+	from, ok := p.get(r)
+	if !ok {
+		return q
+	}
+	to, ok := dst.get(r)
+	if !ok {
+		return q
+	}
+
+	n := q
+	if avail := from.Quantity - from.Reserved; !from.AllowNegative && avail < n {
+		n = avail
+	}
+	if space := dst.capacity(to) - to.Quantity; space < n {
+		n = space
+	}
+	if n <= 0 {
+		return q
+	}
+	from.Quantity -= n
+	to.Quantity += n
+	return q - n
+}
+
+// setQuantity sets the quantity of an existing pool without regard to its
+// capacity.
+func (p *PoolSet) setQuantity(r *Resource, q int) {
+	defer p.lock()()
+	if pool, ok := p.get(r); ok {
+		pool.Quantity = q
+	}
 }
 
 // An Agent is something that consumes or produces resources. It could be a person, a building
 // or even an entire country.
 type Agent struct {
+	ID        string // identifies the agent, unique within a World and not changed while it is in one
 	Name      Name
-	Pools     PoolSet
+	Pools     *PoolSet
 	Rules     []*Rule
 	Relations map[Relation]*Agent
+
+	// Parent is the agent above this one in a hierarchy, such as the
+	// household of a worker or the city of a household. See SetParent.
+	Parent *Agent
+
+	// RelationFuncs resolve relations each time the agent's rule context
+	// is built. See AddRelationFunc.
+	RelationFuncs map[Relation]func() *Agent
+
+	// Group is the group the agent belongs to, whose rules are run for the
+	// agent before its own. See AgentGroup.
+	Group *AgentGroup
+
+	// Overrides change how shared rules run for the agent. See Override.
+	Overrides map[*Rule]*RuleOverride
+
+	// Inverses lists the agents related to this one by relations added
+	// with AddRelationInverse, by the name of the inverse relation.
+	// InverseOf holds the name of the inverse of each such relation of
+	// this agent.
+	Inverses  map[Relation][]*Agent
+	InverseOf map[Relation]Relation
+
+	// Phase delays the first run of each of the agent's periodic rules by
+	// that many ticks, modulo the rule's period, so that agents with the
+	// same rules do not all run them on the same ticks. See WithStagger.
+	Phase int
+
+	// Attrs holds traits of the agent that are not quantities, such as its
+	// culture or profession. See SetAttr.
+	Attrs map[string]string
+
+	// Location is where the agent is, whose pools are available to rules
+	// through the location relation. See MoveTo.
+	Location *Location
+
+	// Speed is the distance the agent travels each tick when a rule moves
+	// it. An agent without a speed moves at once. Journey is set while the
+	// agent travels. See World.
+	Speed   Length
+	Journey *Journey
+
+	// Difficulty, if set, gives the difficulty of each connection for the
+	// agent in place of the connection's own, so that a ship can ignore the
+	// state of the roads. Kinds, if not empty, lists the kinds of connection
+	// the agent can travel, so that a ship keeps to rivers and the sea.
+	Difficulty DifficultyFunc
+	Kinds      []string
+
+	// Size is the space the agent takes up at a location with a capacity,
+	// such as the number of people in a household. An agent without a size
+	// counts as 1. See Location.SetCapacity.
+	Size int
+
+	// Position, if set, is where the agent is on the map independent of
+	// the network, for agents that roam freely. A World moves it by
+	// Velocity each tick, or towards Target at the agent's speed while
+	// Target is set. See SetPosition and SteerTo.
+	Position *Position
+	Velocity Position // the distance moved east and north each tick
+	Target   *Position
 }
 
+// NewAgent returns an agent with no pools or rules whose ID and singular name
+// are name.
 func NewAgent(name string) *Agent {
 	return &Agent{
+		ID:        name,
 		Name:      Name{Singular: name},
 		Pools:     NewPoolSet(),
 		Rules:     []*Rule{},
@@ -149,6 +798,15 @@ func NewAgent(name string) *Agent {
 	}
 }
 
+// agentLabel returns the ID of the agent or, if it has none, its singular
+// name, which identifies the agent in files and snapshots.
+func agentLabel(a *Agent) string {
+	if a.ID != "" {
+		return a.ID
+	}
+	return a.Name.Singular
+}
+
 func (a *Agent) PrependRules(rules []*Rule) {
 	nrules := append([]*Rule(nil), rules...)
 	nrules = append(nrules, a.Rules...)
@@ -159,35 +817,140 @@ func (a *Agent) AppendRules(rules []*Rule) {
 	a.Rules = append(a.Rules, rules...)
 }
 
+// HasRule reports whether the agent has a rule with the given name, not
+// counting the rules of its group.
+func (a *Agent) HasRule(name string) bool {
+	for _, r := range a.Rules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveRule removes the first rule with the given name from the agent's
+// rules and returns it, or returns nil if there is none. Pass the rule to
+// Runner.ForgetRule to discard the runner's state for it.
+func (a *Agent) RemoveRule(name string) *Rule {
+	for i, r := range a.Rules {
+		if r.Name == name {
+			// The slice may share its array with other agents so it is
+			// copied rather than changed in place
+			a.Rules = append(a.Rules[:i:i], a.Rules[i+1:]...)
+			return r
+		}
+	}
+	return nil
+}
+
+// ReplaceRule replaces old in the agent's rules with new, keeping its place
+// in the order the rules are run. It reports whether old was found. The
+// runner's state for old is not carried over to new, so new runs as soon
+// as it is due; pass old to Runner.ForgetRule to discard that state.
+func (a *Agent) ReplaceRule(old, new *Rule) bool {
+	for i, r := range a.Rules {
+		if r == old {
+			rules := append([]*Rule(nil), a.Rules...)
+			rules[i] = new
+			a.Rules = rules
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Agent) SetCapacity(r *Resource, c int) {
 	a.Pools.SetCapacity(r, c)
 }
 
+// SetCarryLimit limits the total weight and volume of the agent's resources.
+// See PoolSet.SetCarryLimit.
+func (a *Agent) SetCarryLimit(weight, volume int) {
+	a.Pools.SetCarryLimit(weight, volume)
+}
+
 func (a *Agent) AddPool(r *Resource, capacity, quantity int) {
 	a.Pools.AddPool(r, capacity, quantity)
 }
 
+// AddRelation relates the agent to c, replacing any agent in relation r.
 func (a *Agent) AddRelation(r Relation, c *Agent) {
+	a.dropInverse(r)
+	delete(a.RelationFuncs, r)
 	a.Relations[r] = c
 }
 
-func (a *Agent) RuleContext() RuleContext {
-	rc := RuleContext{
-		Pools: map[Relation]PoolSet{
-			RelationSelf: a.Pools,
-		},
+// AddRelationFunc relates the agent to the agent returned by fn each time its
+// rule context is built, such as the nearest market, so that the relation
+// follows the agent as it moves. The relation is omitted from the context
+// while fn returns nil. It replaces any relation added by AddRelation.
+func (a *Agent) AddRelationFunc(r Relation, fn func() *Agent) {
+	a.dropInverse(r)
+	delete(a.Relations, r)
+	if a.RelationFuncs == nil {
+		a.RelationFuncs = map[Relation]func() *Agent{}
 	}
+	a.RelationFuncs[r] = fn
+}
 
-	for r, ra := range a.Relations {
-		rc.Pools[r] = ra.Pools
+// SetParent places the agent below parent in a hierarchy. The parent's pools
+// are available to the agent's rules through the parent relation unless the
+// agent has another agent in that relation. If inherit is true, the agent's
+// pool set falls through to the parent's for resources the agent holds no
+// pool for, so rules using the pools of the agent, or of agents related to
+// it, draw on the pools of the nearest ancestor holding the resource. A nil
+// parent removes the agent from its hierarchy.
+func (a *Agent) SetParent(parent *Agent, inherit bool) {
+	a.Parent = parent
+	if parent != nil && inherit {
+		a.Pools.SetParent(parent.Pools)
+		return
 	}
+	a.Pools.SetParent(nil)
+}
 
+func (a *Agent) RuleContext() RuleContext {
+	var rc RuleContext
+	a.FillRuleContext(&rc)
 	return rc
 }
 
+// FillRuleContext sets ctx to the agent's rule context, reusing the map held
+// by ctx to avoid allocating a new one each time rules are run for an agent.
+// Any pools in the map that are not related to the agent are removed.
+func (a *Agent) FillRuleContext(ctx *RuleContext) {
+	if ctx.Pools == nil {
+		ctx.Pools = make(map[Relation]*PoolSet, len(a.Relations)+1)
+	} else {
+		for r := range ctx.Pools {
+			delete(ctx.Pools, r)
+		}
+	}
+	ctx.Pools[RelationSelf] = a.Pools
+	ctx.Agent = a
+
+	for r, ra := range a.Relations {
+		ctx.Pools[r] = ra.Pools
+	}
+	for r, fn := range a.RelationFuncs {
+		if ra := fn(); ra != nil {
+			ctx.Pools[r] = ra.Pools
+		}
+	}
+	if _, ok := ctx.Pools[RelationParent]; !ok && a.Parent != nil {
+		ctx.Pools[RelationParent] = a.Parent.Pools
+	}
+	if _, ok := ctx.Pools[RelationLocation]; !ok && a.Location != nil {
+		ctx.Pools[RelationLocation] = a.Location.pools
+	}
+	if _, ok := ctx.Pools[RelationRegion]; !ok && a.Location != nil && a.Location.region != nil {
+		ctx.Pools[RelationRegion] = a.Location.region.Pools
+	}
+}
+
 // A Global set of pools
 type Global struct {
-	Pools PoolSet
+	Pools *PoolSet
 	Rules []*Rule
 }
 
@@ -202,19 +965,51 @@ func (g *Global) SetCapacity(r *Resource, c int) {
 	g.Pools.SetCapacity(r, c)
 }
 
+// RuleContext returns the context for running global rules, in which both the
+// self and global relations refer to the global pools.
+func (g *Global) RuleContext() RuleContext {
+	return RuleContext{
+		Pools: map[Relation]*PoolSet{
+			RelationSelf:   g.Pools,
+			RelationGlobal: g.Pools,
+		},
+	}
+}
+
 // Rules operate on resources
 type Rule struct {
-	Name          string
-	Period        int                 // Number of ticks between occurrences of the rule
-	Preconditions []ResourceCondition // conjunctive, all must apply
-	Inputs        []ResourceSpecifier
-	Outputs       []ResourceSpecifier // Increments or decrements a resource
-	Sets          []ResourceSpecifier // Sets a resource quantity to a specific value
+	Name           string              `json:"name"`
+	Period         int                 `json:"period"`                   // Number of ticks between occurrences of the rule
+	Preconditions  []ResourceCondition `json:"preconditions,omitempty"`  // conjunctive, all must apply
+	AttrConditions []AttrCondition     `json:"attrConditions,omitempty"` // conjunctive, checked before the preconditions
+	NearConditions []NearCondition     `json:"nearConditions,omitempty"` // conjunctive, checked after the attribute conditions
+	Inputs         []ResourceSpecifier `json:"inputs,omitempty"`
+	Outputs        []ResourceSpecifier `json:"outputs,omitempty"`   // Increments or decrements a resource
+	Sets           []ResourceSpecifier `json:"sets,omitempty"`      // Sets a resource quantity to a specific value
+	Exchanges      []Exchange          `json:"exchanges,omitempty"` // trades at rates held in pools, applied after the inputs and outputs
+
+	Chance     int             `json:"chance,omitempty"`     // percentage chance that the rule runs each time it is due, 0 means it always runs
+	Manual     bool            `json:"manual,omitempty"`     // true if this rule can only be triggered manually, such as being target of an OnFail
+	Repeat     int             `json:"repeat,omitempty"`     // number of times to repeat the rule if possible
+	RepeatFrom *ResourceSource `json:"repeatFrom,omitempty"` // number of times to repeat the rule based on a resource count
+	OnFail     *Rule           `json:"-"`                    // a rule to trigger if a precondition fails or an input is missing, only triggered if first run of rule fails, not repeats
+	Overflow   *Overflow       `json:"overflow,omitempty"`   // what happens to output that exceeds a pool's capacity, the runner's policy is used if nil
 
-	Manual     bool            // true if this rule can only be triggered manually, such as being target of an OnFail
-	Repeat     int             // number of times to repeat the rule if possible
-	RepeatFrom *ResourceSource // number of times to repeat the rule based on a resource count
-	OnFail     *Rule           // a rule to trigger if a precondition fails or an input is missing, only triggered if first run of rule fails, not repeats
+	Spawns  []SpawnSpecifier    `json:"spawns,omitempty"`  // agents to create each round, see World
+	Destroy bool                `json:"destroy,omitempty"` // true if the agent the rule is run for is destroyed once the rule fires, see World
+	Offers  []OfferSpecifier    `json:"offers,omitempty"`  // offers to post to the market each round, see World
+	Move    string              `json:"move,omitempty"`    // a relation or named location the agent moves to once the rule fires, see World
+	Ships   []ResourceSpecifier `json:"ships,omitempty"`   // resources taken as inputs and shipped to the related agents each round, see World
+
+	CustomConditions []Condition `json:"-"` // conjunctive, checked after the near conditions, see Condition
+	CustomEffects    []Effect    `json:"-"` // applied once the rule fires, see Effect
+}
+
+// A SpawnSpecifier creates Count agents from the named template each time a
+// rule completes a round.
+type SpawnSpecifier struct {
+	Template string
+	Count    int
 }
 
 type ResourceSource struct {
@@ -243,8 +1038,52 @@ const (
 	OpLessThanOrEqual    Op = 4
 )
 
+var opSymbols = map[Op]string{
+	OpEquals:             "=",
+	OpGreaterThan:        ">",
+	OpGreaterThanOrEqual: ">=",
+	OpLessThan:           "<",
+	OpLessThanOrEqual:    "<=",
+}
+
+// String returns the symbol used for the operator in rule files.
+func (o Op) String() string {
+	if s, ok := opSymbols[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// parseOp returns the operator for a rule file symbol.
+func parseOp(s string) (Op, bool) {
+	for op, sym := range opSymbols {
+		if sym == s {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+// holds reports whether a op b is true.
+func (o Op) holds(a, b int) bool {
+	switch o {
+	case OpEquals:
+		return a == b
+	case OpGreaterThan:
+		return a > b
+	case OpGreaterThanOrEqual:
+		return a >= b
+	case OpLessThan:
+		return a < b
+	case OpLessThanOrEqual:
+		return a <= b
+	}
+	return false
+}
+
 type RuleState struct {
 	LastRun int64
+	each    bool // the rule is a global rule run for the agent through the each relation
 }
 
 type Relation string
@@ -253,8 +1092,36 @@ const (
 	RelationSelf     Relation = "self"
 	RelationGlobal   Relation = "global"
 	RelationLocation Relation = "location"
+	RelationParent   Relation = "parent" // the agent's parent, see Agent.SetParent
+
+	// RelationTarget refers to an agent chosen each time a rule is run,
+	// such as the opponent in a fight. See RuleContext.WithTarget.
+	RelationTarget Relation = "target"
 )
 
 type RuleContext struct {
-	Pools map[Relation]PoolSet
+	Pools map[Relation]*PoolSet
+
+	// Agent is the agent the rules are run for, if any. The Runner keeps
+	// separate timing state for each agent so that agents sharing the same
+	// rules are scheduled independently.
+	Agent *Agent
+}
+
+// WithTarget returns a copy of the context in which the target relation refers
+// to the pools of the target agent. A nil target removes the target relation.
+// The receiver is not modified so the same context can be reused with
+// different targets.
+func (ctx RuleContext) WithTarget(target *Agent) RuleContext {
+	pools := make(map[Relation]*PoolSet, len(ctx.Pools)+1)
+	for rel, ps := range ctx.Pools {
+		pools[rel] = ps
+	}
+	if target != nil {
+		pools[RelationTarget] = target.Pools
+	} else {
+		delete(pools, RelationTarget)
+	}
+	ctx.Pools = pools
+	return ctx
 }
@@ -0,0 +1,535 @@
+package rula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPoolSetDefaultCapacity(t *testing.T) {
+	ps := NewPoolSet(&Pool{Resource: iron}, &Pool{Resource: ironOre, Capacity: 5})
+
+	if excess := ps.Add(iron, 3); excess != 3 {
+		t.Errorf("got excess %d with no default capacity, wanted 3", excess)
+	}
+
+	ps.SetDefaultCapacity(10)
+	if excess := ps.Add(iron, 12); excess != 2 {
+		t.Errorf("got excess %d, wanted 2", excess)
+	}
+	if got := ps.Quantity(iron); got != 10 {
+		t.Errorf("got quantity %d, wanted 10", got)
+	}
+	if got := ps.Capacity(iron); got != 10 {
+		t.Errorf("got capacity %d, wanted 10", got)
+	}
+
+	// An explicit capacity takes precedence over the default
+	if excess := ps.Set(ironOre, 8); excess != 3 {
+		t.Errorf("got excess %d, wanted 3", excess)
+	}
+}
+
+func TestPoolSetAutoCreate(t *testing.T) {
+	ps := NewPoolSet()
+	if excess := ps.Add(iron, 3); excess != 3 || ps.Pool(iron) != nil {
+		t.Errorf("got excess %d adding to missing pool, wanted 3 and no pool", excess)
+	}
+
+	ps.SetAutoCreate(true)
+	ps.SetDefaultCapacity(CapacityUnlimited)
+	if excess := ps.Add(iron, 3); excess != 0 {
+		t.Errorf("got excess %d, wanted 0", excess)
+	}
+
+	rule := NewRule("smelt").Out(RelationSelf, ironOre, 1<<40).Build()
+	if _, err := NewRunner().RunRule(rule, 1, RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ps.Quantity(ironOre); got != 1<<40 {
+		t.Errorf("got ore %d, wanted %d", got, 1<<40)
+	}
+
+	ps.SetDefaultCapacity(0)
+	ps.AddUnlimited(iron)
+	if excess := ps.Add(iron, 1<<40); excess != 0 || ps.Capacity(iron) != CapacityUnlimited {
+		t.Errorf("got excess %d, wanted 0 with unlimited capacity", excess)
+	}
+}
+
+func TestPoolSetLinkCapacity(t *testing.T) {
+	warehouse := &Resource{ID: "warehouse", Name: Name{Singular: "warehouse"}}
+	ps := NewPoolSet(
+		&Pool{Resource: warehouse, Capacity: 10, Quantity: 2},
+		&Pool{Resource: iron},
+	)
+	ps.LinkCapacity(iron, warehouse, 100)
+
+	if excess := ps.Add(iron, 250); excess != 50 {
+		t.Errorf("got excess %d, wanted 50", excess)
+	}
+
+	// Building a warehouse raises the capacity for later rules
+	build := NewRule("build").Out(RelationSelf, warehouse, 1).Build()
+	store := NewRule("store").Out(RelationSelf, iron, 80).Build()
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+	if _, err := NewRunner().Run([]*Rule{build, store}, 1, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ps.Capacity(iron); got != 300 {
+		t.Errorf("got capacity %d, wanted 300", got)
+	}
+	if got := ps.Quantity(iron); got != 280 {
+		t.Errorf("got quantity %d, wanted 280", got)
+	}
+
+	ps.LinkCapacity(iron, nil, 0)
+	if got := ps.Capacity(iron); got != 0 {
+		t.Errorf("got capacity %d after unlinking, wanted 0", got)
+	}
+}
+
+func TestSyncPoolSet(t *testing.T) {
+	ps := NewSyncPoolSet(&Pool{Resource: iron, Capacity: 1000})
+	rule := NewRule("smelt").Out(RelationSelf, iron, 1).Build()
+	ctx := RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runner := NewRunner()
+		for tick := int64(1); tick <= 500; tick++ {
+			if _, err := runner.Run([]*Rule{rule}, tick, ctx); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+
+	// Reads race with the runner and must see a consistent quantity
+	last := 0
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		q := ps.Quantity(iron)
+		if q < last {
+			t.Fatalf("quantity went backwards from %d to %d", last, q)
+		}
+		last = q
+		if snap := ps.Snapshot(); len(snap) != 1 {
+			t.Fatalf("got %d pools in snapshot, wanted 1", len(snap))
+		}
+	}
+
+	if got := ps.Quantity(iron); got != 500 {
+		t.Errorf("got iron %d, wanted 500", got)
+	}
+}
+
+func TestPoolSetAllowNegative(t *testing.T) {
+	ps := NewPoolSet(
+		&Pool{Resource: iron, Capacity: 10, Quantity: 2},
+		&Pool{Resource: ironOre, Capacity: 10, Quantity: 2, AllowNegative: true},
+	)
+
+	if got := ps.Remove(iron, 5); got != 5 {
+		t.Errorf("got %d not removed from iron, wanted 5", got)
+	}
+	if got := ps.Remove(ironOre, 5); got != 0 {
+		t.Errorf("got %d not removed from iron_ore, wanted 0", got)
+	}
+	if got := ps.Quantity(ironOre); got != -3 {
+		t.Errorf("got iron_ore %d, wanted -3", got)
+	}
+
+	ps.SetAllowNegative(iron, true)
+	if !ps.AllowsNegative(iron) {
+		t.Errorf("iron does not allow negative quantities")
+	}
+	if ps.AllowsNegative(workers) {
+		t.Errorf("missing pool allows negative quantities")
+	}
+}
+
+func TestPoolSetNegativeGuards(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pool    Pool
+		op      func(*PoolSet) int
+		want    int
+		wantQty int
+	}{
+		{
+			name:    "add negative stops at zero",
+			pool:    Pool{Capacity: 10, Quantity: 3},
+			op:      func(ps *PoolSet) int { return ps.Add(iron, -5) },
+			want:    -2,
+			wantQty: 0,
+		},
+		{
+			name:    "add negative allowed",
+			pool:    Pool{Capacity: 10, Quantity: 3, AllowNegative: true},
+			op:      func(ps *PoolSet) int { return ps.Add(iron, -5) },
+			wantQty: -2,
+		},
+		{
+			name:    "set negative",
+			pool:    Pool{Capacity: 10, Quantity: 3},
+			op:      func(ps *PoolSet) int { return ps.Set(iron, -4) },
+			want:    -4,
+			wantQty: 0,
+		},
+		{
+			name:    "set negative allowed",
+			pool:    Pool{Capacity: 10, Quantity: 3, AllowNegative: true},
+			op:      func(ps *PoolSet) int { return ps.Set(iron, -4) },
+			wantQty: -4,
+		},
+		{
+			name:    "remove negative",
+			pool:    Pool{Capacity: 10, Quantity: 9},
+			op:      func(ps *PoolSet) int { return ps.Remove(iron, -4) },
+			want:    -4,
+			wantQty: 9,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.pool.Resource = iron
+			ps := NewPoolSet(&tc.pool)
+			if got := tc.op(ps); got != tc.want {
+				t.Errorf("got %d not applied, wanted %d", got, tc.want)
+			}
+			if got := ps.Quantity(iron); got != tc.wantQty {
+				t.Errorf("got quantity %d, wanted %d", got, tc.wantQty)
+			}
+		})
+	}
+
+	// Rules decrementing a resource follow the same semantics
+	ps := NewPoolSet(&Pool{Resource: iron, Capacity: 10, Quantity: 3})
+	rule := NewRule("rust").Repeat(2).Out(RelationSelf, iron, -2).Build()
+	if _, err := NewRunner().RunRule(rule, 1, RuleContext{Pools: map[Relation]*PoolSet{RelationSelf: ps}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ps.Quantity(iron); got != 0 {
+		t.Errorf("got quantity %d after decrementing rule, wanted 0", got)
+	}
+}
+
+func TestAgentParent(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	coin := &Resource{Name: Name{Singular: "coin"}}
+	p := NewAgentParser([]*Resource{food, coin}, map[string][]*Rule{
+		"work": {
+			NewRule("eat").In(RelationSelf, food, 1).Out(RelationSelf, coin, 2).Build(),
+			NewRule("tax").In(RelationSelf, coin, 1).Out(RelationParent, coin, 1).Build(),
+		},
+	})
+	agents, err := p.Parse(strings.NewReader(`
+agent worker
+	pool coin 10
+	parent household inherit
+	rules work
+end
+
+agent household
+	parent city inherit
+end
+
+agent city
+	pool food 10 3
+	pool coin 100
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	worker, household, city := agents[0], agents[1], agents[2]
+	if worker.Parent != household || household.Parent != city {
+		t.Fatalf("parents not set")
+	}
+
+	if _, err := NewRunner().Step(nil, agents, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The worker eats the city's food, keeps a coin and pays one to the
+	// household, which holds no coin pool so passes it on to the city
+	if got := city.Pools.Quantity(food); got != 2 {
+		t.Errorf("got city food %d, wanted 2", got)
+	}
+	if got := worker.Pools.Quantity(coin); got != 1 {
+		t.Errorf("got worker coin %d, wanted 1", got)
+	}
+	if got := city.Pools.Quantity(coin); got != 1 {
+		t.Errorf("got city coin %d, wanted 1", got)
+	}
+	if household.Pools.Len() != 0 {
+		t.Errorf("household holds %d pools, wanted none", household.Pools.Len())
+	}
+
+	worker.SetParent(household, false)
+	if got := worker.Pools.Quantity(food); got != 0 {
+		t.Errorf("got food %d without inheritance, wanted 0", got)
+	}
+}
+
+func TestPoolSetParentConfig(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	coin := &Resource{Name: Name{Singular: "coin"}}
+	debt := &Resource{Name: Name{Singular: "debt"}}
+	houses := &Resource{Name: Name{Singular: "houses"}}
+	parent := NewPoolSet(
+		&Pool{Resource: food, Capacity: 10, Quantity: 5},
+		&Pool{Resource: coin, Capacity: 10, Quantity: 5},
+		&Pool{Resource: debt, Capacity: 10},
+		&Pool{Resource: houses, Capacity: 10, Quantity: 2},
+	)
+	child := NewPoolSet()
+	child.SetParent(parent)
+
+	child.SetCapacity(food, 3)
+	child.AddUnlimited(coin)
+	child.SetAllowNegative(debt, true)
+	child.LinkCapacity(houses, food, 2)
+
+	for _, r := range []*Resource{food, coin, debt, houses} {
+		if pool := parent.Pool(r); pool.Capacity != 10 || pool.AllowNegative || pool.Link != nil {
+			t.Errorf("parent %s pool changed to %+v", r.Name.Singular, pool)
+		}
+		if child.ownPool(r) == nil {
+			t.Errorf("child has no pool of its own of %s", r.Name.Singular)
+		}
+	}
+	if got := child.Capacity(food); got != 3 {
+		t.Errorf("got child food capacity %d, wanted 3", got)
+	}
+	if got := child.Capacity(coin); got != CapacityUnlimited {
+		t.Errorf("got child coin capacity %d, wanted unlimited", got)
+	}
+	if !child.AllowsNegative(debt) || parent.AllowsNegative(debt) {
+		t.Errorf("got debt allowing negative in child %v and parent %v, wanted only the child", child.AllowsNegative(debt), parent.AllowsNegative(debt))
+	}
+	if got := child.Quantity(food); got != 0 {
+		t.Errorf("got child food %d, wanted the new pool to be empty", got)
+	}
+}
+
+func TestAgentRelationFunc(t *testing.T) {
+	near := NewAgent("near")
+	far := NewAgent("far")
+	a := NewAgent("trader")
+	a.AddRelation("market", far)
+
+	var market *Agent
+	a.AddRelationFunc("market", func() *Agent { return market })
+	if _, ok := a.RuleContext().Pools["market"]; ok {
+		t.Errorf("got market relation while function returns nil")
+	}
+
+	for _, m := range []*Agent{near, far} {
+		market = m
+		if got := a.RuleContext().Pools["market"]; got != m.Pools {
+			t.Errorf("market relation not resolved to %s", m.Name.Singular)
+		}
+	}
+
+	a.AddRelation("market", near)
+	market = far
+	if got := a.RuleContext().Pools["market"]; got != near.Pools {
+		t.Errorf("market relation not replaced by AddRelation")
+	}
+}
+
+func TestAgentRemoveReplaceRule(t *testing.T) {
+	mill := NewRule("mill").In(RelationSelf, ironOre, 1).Out(RelationSelf, iron, 1).Build()
+	forge := NewRule("forge").In(RelationSelf, ironOre, 1).Out(RelationSelf, iron, 2).Build()
+	mine := NewRule("mine").Out(RelationSelf, ironOre, 1).Build()
+
+	shared := []*Rule{mine, mill}
+	a := NewAgent("a")
+	a.Rules = shared
+	a.AddPool(ironOre, 10, 5)
+	a.AddPool(iron, 10, 0)
+
+	ru := NewRunner()
+	if _, err := ru.Step(nil, []*Agent{a}, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !a.ReplaceRule(mill, forge) {
+		t.Fatalf("mill not replaced")
+	}
+	ru.ForgetRule(a, mill)
+	if a.HasRule("mill") || !a.HasRule("forge") {
+		t.Errorf("got rules %v, wanted forge in place of mill", a.Rules)
+	}
+	if shared[1] != mill {
+		t.Errorf("replacing a rule changed a shared slice")
+	}
+	if got := ru.States(); len(got) != 1 || got[0].Rule != "mine" {
+		t.Errorf("got states %v, wanted only mine", got)
+	}
+
+	if _, err := ru.Step(nil, []*Agent{a}, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := a.Pools.Quantity(iron); got != 3 {
+		t.Errorf("got iron %d, wanted 3", got)
+	}
+
+	if removed := a.RemoveRule("mine"); removed != mine {
+		t.Errorf("got removed rule %v, wanted mine", removed)
+	}
+	if a.RemoveRule("mine") != nil {
+		t.Errorf("removed a rule that was not there")
+	}
+	if len(a.Rules) != 1 || a.Rules[0] != forge {
+		t.Errorf("got rules %v, wanted only forge", a.Rules)
+	}
+}
+
+func TestDensePoolSet(t *testing.T) {
+	gold := &Resource{ID: "gold", Name: Name{Singular: "gold"}}
+	silver := &Resource{ID: "silver", Name: Name{Singular: "silver"}}
+	copper := &Resource{ID: "copper", Name: Name{Singular: "copper"}}
+	reg := NewResourceRegistry(gold, silver)
+
+	ps := NewDensePoolSet(reg, &Pool{Resource: silver, Capacity: 10, Quantity: 4})
+	ps.AddPool(copper, 5, 1)
+	ps.SetCapacity(gold, 8)
+
+	if copper.Index != 3 || reg.Len() != 3 {
+		t.Errorf("got copper index %d and %d registered, wanted 3 and 3", copper.Index, reg.Len())
+	}
+	if got := ps.Add(silver, 9); got != 3 {
+		t.Errorf("got %d not added to silver, wanted 3", got)
+	}
+	if got := ps.Remove(copper, 1); got != 0 {
+		t.Errorf("got %d not removed from copper, wanted 0", got)
+	}
+
+	want := []Pool{
+		{Resource: silver, Capacity: 10, Quantity: 10},
+		{Resource: copper, Capacity: 5},
+		{Resource: gold, Capacity: 8},
+	}
+	if diff := cmp.Diff(want, ps.Snapshot()); diff != "" {
+		t.Errorf("pools mismatch (-want +got):\n%s", diff)
+	}
+
+	// Pool sets sharing a registry hold their own pools
+	other := NewDensePoolSet(reg)
+	if other.Pool(gold) != nil || other.Quantity(silver) != 0 {
+		t.Errorf("empty dense pool set has pools")
+	}
+
+	if err := NewResourceRegistry().Register(gold); err == nil {
+		t.Errorf("got no error registering with a second registry, wanted one")
+	}
+}
+
+func TestAgentFillRuleContext(t *testing.T) {
+	town := NewAgent("town")
+	smelter := NewAgent("smelter")
+	smelter.AddRelation(RelationLocation, town)
+	miner := NewAgent("miner")
+
+	var ctx RuleContext
+	smelter.FillRuleContext(&ctx)
+	if ctx.Agent != smelter || ctx.Pools[RelationSelf] != smelter.Pools || ctx.Pools[RelationLocation] != town.Pools {
+		t.Errorf("context not filled for smelter")
+	}
+
+	miner.FillRuleContext(&ctx)
+	if ctx.Agent != miner || len(ctx.Pools) != 1 || ctx.Pools[RelationSelf] != miner.Pools {
+		t.Errorf("context not refilled for miner: %v", ctx.Pools)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		smelter.FillRuleContext(&ctx)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations refilling a context, wanted 0", allocs)
+	}
+}
+
+func TestPoolSetTransfer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		src, dst Pool
+		q        int
+		want     int
+		wantSrc  int
+		wantDst  int
+	}{
+		{
+			name:    "all",
+			src:     Pool{Capacity: 10, Quantity: 6},
+			dst:     Pool{Capacity: 10, Quantity: 2},
+			q:       4,
+			wantSrc: 2,
+			wantDst: 6,
+		},
+		{
+			name:    "destination full",
+			src:     Pool{Capacity: 10, Quantity: 6},
+			dst:     Pool{Capacity: 5, Quantity: 2},
+			q:       4,
+			want:    1,
+			wantSrc: 3,
+			wantDst: 5,
+		},
+		{
+			name:    "source short",
+			src:     Pool{Capacity: 10, Quantity: 1},
+			dst:     Pool{Capacity: 10},
+			q:       4,
+			want:    3,
+			wantDst: 1,
+		},
+		{
+			name:    "negative source",
+			src:     Pool{Capacity: 10, Quantity: 1, AllowNegative: true},
+			dst:     Pool{Capacity: 10},
+			q:       4,
+			wantSrc: -3,
+			wantDst: 4,
+		},
+		{
+			name:    "nothing moves",
+			src:     Pool{Capacity: 10},
+			dst:     Pool{Capacity: 10, Quantity: 10},
+			q:       4,
+			want:    4,
+			wantDst: 10,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.src.Resource, tc.dst.Resource = iron, iron
+			src, dst := NewSyncPoolSet(&tc.src), NewSyncPoolSet(&tc.dst)
+
+			if got := src.Transfer(dst, iron, tc.q); got != tc.want {
+				t.Errorf("got %d not transferred, wanted %d", got, tc.want)
+			}
+			if got := src.Quantity(iron); got != tc.wantSrc {
+				t.Errorf("got source quantity %d, wanted %d", got, tc.wantSrc)
+			}
+			if got := dst.Quantity(iron); got != tc.wantDst {
+				t.Errorf("got destination quantity %d, wanted %d", got, tc.wantDst)
+			}
+		})
+	}
+
+	if got := NewPoolSet(&Pool{Resource: iron, Capacity: 5, Quantity: 5}).Transfer(NewPoolSet(), iron, 2); got != 2 {
+		t.Errorf("got %d not transferred to missing pool, wanted 2", got)
+	}
+}
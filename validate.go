@@ -0,0 +1,285 @@
+package rula
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A ValidationError lists the problems found when validating a value.
+type ValidationError struct {
+	Subject  string // describes the value that was validated, such as rule "smelt"
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Subject, strings.Join(e.Problems, "; "))
+}
+
+func (e *ValidationError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+func (e *ValidationError) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks that the resource can be referred to by ID and written to a
+// resource file. It returns a *ValidationError describing any problems.
+func (r *Resource) Validate() error {
+	verr := &ValidationError{Subject: fmt.Sprintf("resource %q", r.ID)}
+
+	if r.ID == "" {
+		verr.addf("empty id")
+	} else if strings.IndexFunc(r.ID, unicode.IsSpace) != -1 {
+		verr.addf("id contains whitespace")
+	}
+	if r.Base == nil && strings.Contains(r.ID, subPoolSep) {
+		verr.addf("id contains %s, which names a sub-pool", subPoolSep)
+	}
+	if r.Name.Singular == "" {
+		verr.addf("empty singular name")
+	}
+	if r.Decay < 0 {
+		verr.addf("negative decay %d", r.Decay)
+	}
+	if r.Regen < 0 {
+		verr.addf("negative regen %d", r.Regen)
+	}
+	if r.Weight < 0 {
+		verr.addf("negative weight %d", r.Weight)
+	}
+	if r.Volume < 0 {
+		verr.addf("negative volume %d", r.Volume)
+	}
+
+	return verr.err()
+}
+
+// Validate checks the structural invariants of a rule that the parser would
+// otherwise guarantee. Relations must be self, global, location, parent,
+// target, each, region or one of the supplied relations. The rule's onfail
+// chain is validated too and must not loop back on itself. It returns a
+// *ValidationError describing any problems.
+func (r *Rule) Validate(relations ...Relation) error {
+	known := map[Relation]bool{
+		RelationSelf:     true,
+		RelationGlobal:   true,
+		RelationLocation: true,
+		RelationParent:   true,
+		RelationTarget:   true,
+		RelationEach:     true,
+		RelationRegion:   true,
+	}
+	for _, rel := range relations {
+		known[rel] = true
+	}
+	return r.validateChain(known)
+}
+
+// validateChain validates the rule and its onfail chain. Any relation is
+// accepted if known is nil.
+func (r *Rule) validateChain(known map[Relation]bool) error {
+	verr := &ValidationError{Subject: fmt.Sprintf("rule %q", r.Name)}
+
+	seen := map[*Rule]bool{}
+	for rule := r; rule != nil; rule = rule.OnFail {
+		if seen[rule] {
+			verr.addf("onfail cycle through rule %q", rule.Name)
+			break
+		}
+		seen[rule] = true
+
+		// Problems with rules further down the onfail chain name the rule
+		prefix := ""
+		if rule != r {
+			prefix = fmt.Sprintf("onfail rule %q: ", rule.Name)
+		}
+		rule.validate(known, prefix, verr)
+	}
+
+	return verr.err()
+}
+
+func (r *Rule) validate(known map[Relation]bool, prefix string, verr *ValidationError) {
+	if r.Name == "" {
+		verr.addf("%sempty name", prefix)
+	}
+	if r.Period < 0 {
+		verr.addf("%snegative period %d", prefix, r.Period)
+	}
+	if r.Repeat < 0 {
+		verr.addf("%snegative repeat %d", prefix, r.Repeat)
+	}
+	if r.Chance < 0 || r.Chance > 100 {
+		verr.addf("%schance %d out of range", prefix, r.Chance)
+	}
+	if r.Repeat != 0 && r.RepeatFrom != nil {
+		verr.addf("%sboth repeat and repeat using are set", prefix)
+	}
+
+	checkRel := func(kind string, rel Relation) {
+		if rel == "" {
+			verr.addf("%s%s has no relation", prefix, kind)
+		} else if known != nil && !known[rel] {
+			verr.addf("%s%s has unknown relation %q", prefix, kind, rel)
+		}
+	}
+
+	for i, c := range r.AttrConditions {
+		kind := fmt.Sprintf("attribute condition %d", i+1)
+		checkRel(kind, c.Relation)
+		if c.Attr == "" {
+			verr.addf("%s%s has no attribute", prefix, kind)
+		}
+	}
+
+	for i, c := range r.NearConditions {
+		kind := fmt.Sprintf("near condition %d", i+1)
+		checkRel(kind, c.Relation)
+		if c.Within < 0 {
+			verr.addf("%s%s has negative distance %d", prefix, kind, c.Within)
+		}
+	}
+
+	for i, c := range r.Preconditions {
+		kind := fmt.Sprintf("precondition %d", i+1)
+		checkRel(kind, c.Relation)
+		if c.Resource == nil {
+			verr.addf("%s%s has no resource", prefix, kind)
+		}
+		if _, ok := opSymbols[c.Op]; !ok {
+			verr.addf("%s%s has unknown operation %d", prefix, kind, int(c.Op))
+		}
+	}
+
+	specs := []struct {
+		kind  string
+		specs []ResourceSpecifier
+	}{
+		{"input", r.Inputs},
+		{"output", r.Outputs},
+		{"set", r.Sets},
+		{"ship", r.Ships},
+	}
+	for _, group := range specs {
+		for i, s := range group.specs {
+			kind := fmt.Sprintf("%s %d", group.kind, i+1)
+			checkRel(kind, s.Relation)
+			if s.Resource == nil {
+				verr.addf("%s%s has no resource", prefix, kind)
+			}
+			// Outputs may be negative to decrement a resource
+			if group.kind != "output" && s.Quantity < 0 {
+				verr.addf("%s%s has negative quantity %d", prefix, kind, s.Quantity)
+			}
+		}
+	}
+
+	if r.RepeatFrom != nil {
+		checkRel("repeat using", r.RepeatFrom.Relation)
+		if r.RepeatFrom.Resource == nil {
+			verr.addf("%srepeat using has no resource", prefix)
+		}
+	}
+
+	for i, s := range r.Spawns {
+		if s.Template == "" {
+			verr.addf("%sspawn %d has no template", prefix, i+1)
+		}
+		if s.Count < 1 {
+			verr.addf("%sspawn %d has count %d, wanted at least 1", prefix, i+1, s.Count)
+		}
+	}
+
+	for i, x := range r.Exchanges {
+		kind := fmt.Sprintf("exchange %d", i+1)
+		if x.Give == nil || x.Get == nil {
+			verr.addf("%s%s is missing a resource", prefix, kind)
+		}
+		if x.Quantity < 1 {
+			verr.addf("%s%s has quantity %d, wanted at least 1", prefix, kind, x.Quantity)
+		}
+		checkRel(kind+" rate", x.Rate.Relation)
+		if x.Rate.Resource == nil {
+			verr.addf("%s%s rate has no resource", prefix, kind)
+		}
+	}
+
+	for i, o := range r.Offers {
+		kind := fmt.Sprintf("offer %d", i+1)
+		if o.Resource == nil {
+			verr.addf("%s%s has no resource", prefix, kind)
+		}
+		if o.Currency == nil {
+			verr.addf("%s%s has no currency", prefix, kind)
+		}
+		if o.Quantity < 1 {
+			verr.addf("%s%s has quantity %d, wanted at least 1", prefix, kind, o.Quantity)
+		}
+		if o.Price < 0 {
+			verr.addf("%s%s has negative price %d", prefix, kind, o.Price)
+		}
+	}
+
+	if o := r.Overflow; o != nil {
+		if _, ok := overflowNames[o.Policy]; !ok {
+			verr.addf("%sunknown overflow policy %d", prefix, int(o.Policy))
+		} else if o.Policy == OverflowSpill {
+			checkRel("overflow spill", o.Spill)
+		}
+	}
+}
+
+// ValidateCapacity checks that every pool the agents' rules add to or set has
+// a non-zero capacity, taking the pool set's default capacity into account.
+// Rules that fill a missing pool, unless the pool set creates it, or one with
+// zero capacity lose everything they produce. It returns a *ValidationError
+// describing any problems.
+func ValidateCapacity(agents []*Agent) error {
+	verr := &ValidationError{Subject: "pool capacities"}
+
+	for _, a := range agents {
+		ctx := a.RuleContext()
+		for _, r := range a.AllRules() {
+			seen := map[*Rule]bool{}
+			for rule := r; rule != nil && !seen[rule]; rule = rule.OnFail {
+				seen[rule] = true
+				checkFill := func(kind string, i int, s ResourceSpecifier) {
+					if s.Quantity <= 0 || s.Resource == nil {
+						return
+					}
+					poolset, ok := ctx.Pools[s.Relation]
+					if !ok {
+						// Relations may be supplied by the caller when the rule is run
+						return
+					}
+					// Pools created on first use take the default capacity
+					capacity := poolset.DefaultCapacity()
+					if poolset.Pool(s.Resource) != nil {
+						capacity = poolset.Capacity(s.Resource)
+					} else if s.Resource.Event {
+						return
+					} else if !poolset.AutoCreates() {
+						verr.addf("agent %q rule %q: %s %d fills %s %s which has no pool", a.Name.Singular, rule.Name, kind, i+1, s.Relation, s.Resource)
+						return
+					}
+					if capacity == 0 {
+						verr.addf("agent %q rule %q: %s %d fills %s %s pool with zero capacity", a.Name.Singular, rule.Name, kind, i+1, s.Relation, s.Resource)
+					}
+				}
+				for i, s := range rule.Outputs {
+					checkFill("output", i, s)
+				}
+				for i, s := range rule.Sets {
+					checkFill("set", i, s)
+				}
+			}
+		}
+	}
+
+	return verr.err()
+}
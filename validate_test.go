@@ -0,0 +1,125 @@
+package rula
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuleValidate(t *testing.T) {
+	loop := &Rule{Name: "loop", Period: 1}
+	loop.OnFail = &Rule{Name: "back", Period: 0, OnFail: loop}
+
+	testCases := []struct {
+		name      string
+		rule      *Rule
+		relations []Relation
+		problems  []string
+	}{
+		{
+			name: "valid",
+			rule: &Rule{
+				Name:          "smelt",
+				Period:        5,
+				Preconditions: []ResourceCondition{{ResourceSpecifier: ResourceSpecifier{Relation: RelationGlobal, Resource: ironOre, Quantity: 6}, Op: OpGreaterThan}},
+				Inputs:        []ResourceSpecifier{{Relation: RelationSelf, Resource: ironOre, Quantity: 3}},
+				Outputs:       []ResourceSpecifier{{Relation: "employer", Resource: iron, Quantity: -1}},
+				Sets:          []ResourceSpecifier{{Relation: RelationParent, Resource: workers}},
+				RepeatFrom:    &ResourceSource{Relation: RelationSelf, Resource: workers},
+			},
+			relations: []Relation{"employer"},
+		},
+		{
+			name: "invalid",
+			rule: &Rule{
+				Period:        -1,
+				Repeat:        2,
+				Preconditions: []ResourceCondition{{ResourceSpecifier: ResourceSpecifier{Relation: RelationSelf, Resource: ironOre}, Op: Op(9)}},
+				Inputs:        []ResourceSpecifier{{Relation: "employer", Resource: ironOre, Quantity: -3}},
+				Sets:          []ResourceSpecifier{{Resource: iron}},
+				RepeatFrom:    &ResourceSource{Relation: RelationSelf},
+				OnFail:        &Rule{Name: "fallback", Outputs: []ResourceSpecifier{{Relation: RelationSelf}}},
+			},
+			problems: []string{
+				"empty name",
+				"negative period -1",
+				"both repeat and repeat using are set",
+				"precondition 1 has unknown operation 9",
+				`input 1 has unknown relation "employer"`,
+				"input 1 has negative quantity -3",
+				"set 1 has no relation",
+				"repeat using has no resource",
+				`onfail rule "fallback": output 1 has no resource`,
+			},
+		},
+		{
+			name:     "onfail cycle",
+			rule:     loop,
+			problems: []string{`onfail cycle through rule "loop"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.Validate(tc.relations...)
+			if tc.problems == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("got error %v, wanted *ValidationError", err)
+			}
+			if diff := cmp.Diff(tc.problems, verr.Problems); diff != "" {
+				t.Errorf("problems mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResourceValidate(t *testing.T) {
+	if err := (&Resource{ID: "iron_ore", Name: Name{Singular: "iron ore"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (&Resource{ID: "iron ore"}).Validate(); err == nil {
+		t.Errorf("got no error, wanted one")
+	}
+}
+
+func TestValidateCapacity(t *testing.T) {
+	town := NewAgent("town")
+	town.AddPool(iron, 0, 0)
+
+	mine := NewAgent("mine")
+	mine.AddPool(ironOre, 100, 0)
+	mine.AddRelation("town", town)
+	mine.Rules = []*Rule{
+		NewRule("dig").Out(RelationSelf, ironOre, 2).Build(),
+		NewRule("smelt").Out("town", iron, 1).Set(RelationSelf, workers, 3).OnFail(
+			NewRule("idle").Out("town", iron, -1).Build(),
+		).Build(),
+	}
+
+	err := ValidateCapacity([]*Agent{town, mine})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got error %v, wanted *ValidationError", err)
+	}
+	want := []string{
+		`agent "mine" rule "smelt": output 1 fills town iron pool with zero capacity`,
+		`agent "mine" rule "smelt": set 1 fills self workers which has no pool`,
+	}
+	if diff := cmp.Diff(want, verr.Problems); diff != "" {
+		t.Errorf("problems mismatch (-want +got):\n%s", diff)
+	}
+
+	town.Pools.SetDefaultCapacity(50)
+	mine.AddPool(workers, 10, 0)
+	if err := ValidateCapacity([]*Agent{town, mine}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
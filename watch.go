@@ -0,0 +1,138 @@
+package rula
+
+import "sort"
+
+// A Watcher is notified when the quantity of a resource in a pool set comes to
+// satisfy a condition, such as a global population falling to zero. Watchers
+// are checked at the end of each Step, but only when a rule has changed the
+// watched pool since the previous check, so a large number of watchers adds
+// little cost to steps that do not touch their pools. Changes made to a pool
+// other than by rules are not seen until a rule next changes it.
+type Watcher struct {
+	Name     string // identifies the watcher in reports
+	Pools    *PoolSet
+	Resource *Resource
+	Op       Op
+	Quantity int
+
+	// Halt marks the step report as halted when the watcher is triggered so
+	// the caller can stop advancing the simulation.
+	Halt bool
+
+	// Fn, if not nil, is called with the watcher and the quantity found each
+	// time the watcher is triggered.
+	Fn func(w *Watcher, quantity int)
+
+	held bool // whether the condition held at the last check
+	seq  int  // order of registration
+}
+
+type watchers struct {
+	byPool  map[txnKey][]*Watcher
+	dirty   map[txnKey]bool
+	pending []*Watcher // watchers not yet checked
+	seq     int
+}
+
+// Watch registers w to be checked at the end of each Step. A watcher is
+// triggered when its condition holds and did not hold at the previous check,
+// so it fires once each time the condition becomes true. The condition is
+// first checked at the end of the next Step, regardless of whether the pool
+// changed.
+func (ru *Runner) Watch(w *Watcher) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if ru.watch.byPool == nil {
+		ru.watch.byPool = map[txnKey][]*Watcher{}
+		ru.watch.dirty = map[txnKey]bool{}
+	}
+	ru.watch.seq++
+	w.seq = ru.watch.seq
+	key := keyFor(w.Pools, w.Resource)
+	ru.watch.byPool[key] = append(ru.watch.byPool[key], w)
+	ru.watch.pending = append(ru.watch.pending, w)
+}
+
+// Unwatch removes a watcher registered with Watch.
+func (ru *Runner) Unwatch(w *Watcher) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	key := keyFor(w.Pools, w.Resource)
+	ru.watch.byPool[key] = removeWatcher(ru.watch.byPool[key], w)
+	if len(ru.watch.byPool[key]) == 0 {
+		delete(ru.watch.byPool, key)
+	}
+	ru.watch.pending = removeWatcher(ru.watch.pending, w)
+}
+
+func removeWatcher(ws []*Watcher, w *Watcher) []*Watcher {
+	for i := range ws {
+		if ws[i] == w {
+			return append(ws[:i:i], ws[i+1:]...)
+		}
+	}
+	return ws
+}
+
+// touch marks the pools changed by a root txn as needing their watchers
+// checked.
+func (ru *Runner) touch(tx *txn) {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	if len(ru.watch.byPool) == 0 {
+		return
+	}
+	for key := range tx.quantities {
+		if _, ok := ru.watch.byPool[key]; ok {
+			ru.watch.dirty[key] = true
+		}
+	}
+}
+
+// checkWatchers checks the watchers of pools changed since the last check,
+// recording those triggered in report and calling their functions.
+func (ru *Runner) checkWatchers(report *StepReport) {
+	ru.mu.Lock()
+	var check []*Watcher
+	seen := map[*Watcher]bool{}
+	for _, w := range ru.watch.pending {
+		seen[w] = true
+		check = append(check, w)
+	}
+	for key := range ru.watch.dirty {
+		for _, w := range ru.watch.byPool[key] {
+			if !seen[w] {
+				seen[w] = true
+				check = append(check, w)
+			}
+		}
+		delete(ru.watch.dirty, key)
+	}
+	ru.watch.pending = nil
+	sort.Slice(check, func(i, j int) bool {
+		return check[i].seq < check[j].seq
+	})
+
+	type trigger struct {
+		w *Watcher
+		q int
+	}
+	var triggered []trigger
+	for _, w := range check {
+		q := w.Pools.Quantity(w.Resource)
+		held := w.held
+		w.held = w.Op.holds(q, w.Quantity)
+		if w.held && !held {
+			triggered = append(triggered, trigger{w, q})
+		}
+	}
+	ru.mu.Unlock()
+
+	for _, t := range triggered {
+		report.Triggered = append(report.Triggered, t.w)
+		report.Halted = report.Halted || t.w.Halt
+		if t.w.Fn != nil {
+			t.w.Fn(t.w, t.q)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package rula
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatch(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	people := &Resource{Name: Name{Singular: "person", Plural: "people"}}
+
+	eat := &Rule{
+		Name:   "eat",
+		Period: 1,
+		Inputs: []ResourceSpecifier{{Relation: RelationSelf, Resource: food, Quantity: 1}},
+	}
+	global := NewGlobal([]*Rule{eat})
+	global.Pools.AddPool(food, 10, 3)
+	global.Pools.AddPool(people, 10, 5)
+
+	var calls []int
+	ru := NewRunner()
+	starving := &Watcher{
+		Name:     "starving",
+		Pools:    global.Pools,
+		Resource: food,
+		Op:       OpLessThanOrEqual,
+		Quantity: 1,
+		Fn: func(w *Watcher, q int) {
+			calls = append(calls, q)
+		},
+	}
+	extinct := &Watcher{
+		Name:     "extinct",
+		Pools:    global.Pools,
+		Resource: people,
+		Op:       OpEquals,
+		Quantity: 0,
+		Halt:     true,
+	}
+	ru.Watch(starving)
+	ru.Watch(extinct)
+
+	var triggered [][]string
+	halted := -1
+	for tick := int64(1); tick <= 4; tick++ {
+		if tick == 3 {
+			global.Pools.Set(people, 0)
+		}
+		report, err := ru.Step(global, nil, tick)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for _, w := range report.Triggered {
+			names = append(names, w.Name)
+		}
+		triggered = append(triggered, names)
+		if report.Halted && halted < 0 {
+			halted = int(tick)
+		}
+	}
+
+	// Food falls to 1 at tick 2 and the starving watcher fires once. The
+	// population was changed directly rather than by a rule so the extinct
+	// watcher only sees it when first checked or when a rule touches the
+	// pool; here it is first checked at tick 1 while people remain.
+	want := [][]string{nil, {"starving"}, nil, nil}
+	if diff := cmp.Diff(want, triggered); diff != "" {
+		t.Errorf("triggered mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{1}, calls); diff != "" {
+		t.Errorf("calls mismatch (-want +got):\n%s", diff)
+	}
+	if halted != -1 {
+		t.Errorf("got halt at tick %d, wanted none", halted)
+	}
+
+	// A newly registered watcher is checked at the end of the next step
+	ru.Unwatch(extinct)
+	ru.Watch(extinct)
+	report, err := ru.Step(global, nil, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !report.Halted || len(report.Triggered) != 1 || report.Triggered[0] != extinct {
+		t.Errorf("got halted %v triggered %v, wanted halt by extinct", report.Halted, report.Triggered)
+	}
+}
@@ -0,0 +1,337 @@
+package rula
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A World is a complete simulation: the global pools, the agents and the map
+// they occupy, advanced one tick at a time by a Runner.
+type World struct {
+	Global  *Global
+	Network Network // the spatial network, may be nil
+	Market  *Market // cleared at the end of each tick, may be nil
+
+	runner *Runner
+	agents []*Agent
+	tick   int64
+
+	templates map[string]*AgentTemplate
+	locations map[string]*Location // locations named for rules that move agents
+	spawned   int                  // number of agents spawned, used to name them
+	onSpawn   []func(parent, child *Agent)
+	onDestroy []func(*Agent)
+	onTrade   []func(Trade)
+	journeys  []*Journey
+	shipments []*Shipment
+	onTransit []func(*Shipment)
+	onDeliver []func(*Shipment)
+	onCut     []func(*Journey, *Shipment)
+
+	index worldIndex
+}
+
+// NewWorld returns a world with the given global pools and rules and no
+// agents. A nil global is replaced with an empty one. The options configure
+// the world's Runner.
+func NewWorld(global *Global, opts ...RunnerOption) *World {
+	if global == nil {
+		global = NewGlobal(nil)
+	}
+	w := &World{
+		Global: global,
+		runner: NewRunner(opts...),
+	}
+	w.runner.destination = w.destination
+	return w
+}
+
+// NewScenarioWorld returns a world holding the global, agents and named
+// locations of a scenario.
+func NewScenarioWorld(sc *Scenario, opts ...RunnerOption) *World {
+	w := NewWorld(sc.Global, opts...)
+	for _, a := range sc.Agents {
+		// The scenario parser rejects duplicate agents
+		w.AddAgent(a)
+	}
+	for name, l := range sc.Locations {
+		w.AddLocation(name, l)
+	}
+	return w
+}
+
+// AddAgent adds an agent to the world. Its rules are run after those of the
+// agents already added. An agent without an ID is given its singular name as
+// its ID. It returns an error, without adding the agent, if the world already
+// holds an agent with the same ID.
+func (w *World) AddAgent(a *Agent) error {
+	if a.ID == "" {
+		a.ID = a.Name.Singular
+	}
+	if _, exists := w.index.byID[a.ID]; exists {
+		return fmt.Errorf("duplicate agent id: %q", a.ID)
+	}
+	w.agents = append(w.agents, a)
+	w.index.add(a)
+	return nil
+}
+
+// RemoveAgent removes an agent from the world and from its location or
+// journey, detaches it from the agents it has relations with inverses to, as
+// Agent.Detach does, and discards the runner's state for its rules, its
+// offers on the market and the shipments to it. It reports whether the agent was in the
+// world.
+func (w *World) RemoveAgent(a *Agent) bool {
+	for i, wa := range w.agents {
+		if wa == a {
+			w.agents = append(w.agents[:i], w.agents[i+1:]...)
+			w.index.remove(a)
+			w.runner.Forget(a)
+			a.Detach()
+			a.MoveTo(nil)
+			w.abandon(a)
+			w.dropShipments(a)
+			if w.Market != nil {
+				w.Market.Withdraw(a)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// AddTemplate makes a template available to rules that spawn agents,
+// replacing any template with the same name.
+func (w *World) AddTemplate(t *AgentTemplate) {
+	if w.templates == nil {
+		w.templates = map[string]*AgentTemplate{}
+	}
+	w.templates[t.Name] = t
+}
+
+// AddLocation names a location so that rules can move agents to it.
+func (w *World) AddLocation(name string, l *Location) {
+	if w.locations == nil {
+		w.locations = map[string]*Location{}
+	}
+	w.locations[name] = l
+}
+
+// OnSpawn registers fn to be called for each agent spawned by a rule, once it
+// has been added to the world. parent is the agent the rule was run for, or
+// nil for a global rule.
+func (w *World) OnSpawn(fn func(parent, child *Agent)) {
+	w.onSpawn = append(w.onSpawn, fn)
+}
+
+// OnDestroy registers fn to be called for each agent destroyed by a rule,
+// once it has been removed from the world.
+func (w *World) OnDestroy(fn func(*Agent)) {
+	w.onDestroy = append(w.onDestroy, fn)
+}
+
+// OnTrade registers fn to be called for each trade made when the world's
+// market is cleared.
+func (w *World) OnTrade(fn func(Trade)) {
+	w.onTrade = append(w.onTrade, fn)
+}
+
+// Agents returns the agents in the world in the order they were added.
+func (w *World) Agents() []*Agent {
+	return append([]*Agent(nil), w.agents...)
+}
+
+// Pools returns the global pools.
+func (w *World) Pools() *PoolSet {
+	return w.Global.Pools
+}
+
+// Runner returns the runner used to run the world's rules.
+func (w *World) Runner() *Runner {
+	return w.runner
+}
+
+// Time returns the number of ticks that have been run.
+func (w *World) Time() int64 {
+	return w.tick
+}
+
+// Tick advances the world by one tick, running the global rules and then the
+// rules of each agent. Once the rules have run, agents spawned by rules are
+// added to the world, to run from the next tick, agents are moved or set out
+// on journeys, agents with positions roam, journeys and shipments that end
+// are completed, agents destroyed by rules are removed and the market, if
+// any, is cleared. Agents on a journey ignore rules that move them until they
+// arrive. A spawn, offer, shipment or move that fails does not stop the rest
+// of the tick; the failures are returned together as a *TickError.
+func (w *World) Tick() (*StepReport, error) {
+	w.tick++
+	agents := append([]*Agent(nil), w.agents...)
+	report, err := w.runner.Step(w.Global, agents, w.tick)
+	if err != nil {
+		return report, err
+	}
+
+	var destroyed []*Agent
+	var errs []error
+	if report.Global != nil {
+		_, gerrs := w.lifecycle(nil, report.Global)
+		errs = append(errs, gerrs...)
+	}
+	for i, rr := range report.Agents {
+		destroy, aerrs := w.lifecycle(agents[i], rr)
+		errs = append(errs, aerrs...)
+		if destroy {
+			destroyed = append(destroyed, agents[i])
+		}
+	}
+	w.roam()
+	w.reroute()
+	w.arrive()
+	w.deliver()
+
+	for _, a := range destroyed {
+		if w.RemoveAgent(a) {
+			for _, fn := range w.onDestroy {
+				fn(a)
+			}
+		}
+	}
+
+	if w.Market != nil {
+		for _, t := range w.Market.Clear() {
+			for _, fn := range w.onTrade {
+				fn(t)
+			}
+		}
+	}
+	return report, tickError(errs)
+}
+
+// A TickError reports the spawns, offers, shipments and moves requested by
+// rules that failed during a tick.
+type TickError struct {
+	Errs []error
+}
+
+func (e *TickError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the failures.
+func (e *TickError) Unwrap() []error {
+	return e.Errs
+}
+
+// tickError returns a *TickError holding errs, or nil if there are none.
+func tickError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &TickError{Errs: errs}
+}
+
+// destination returns the location named by a move directive for agent a:
+// the location of the agent related to a by that name, or else the location
+// added to the world with that name.
+func (w *World) destination(a *Agent, name string) (*Location, error) {
+	if a == nil {
+		return nil, fmt.Errorf("only agents can move")
+	}
+	if ra := a.related(Relation(strings.ToLower(name))); ra != nil {
+		if ra.Location == nil {
+			return nil, fmt.Errorf("agent %q has no location", agentLabel(ra))
+		}
+		return ra.Location, nil
+	}
+	if l, ok := w.locations[name]; ok && !l.removed {
+		return l, nil
+	}
+	return nil, fmt.Errorf("unknown location %q", name)
+}
+
+// spawnID returns an unused ID for an agent spawned from t.
+func (w *World) spawnID(t *AgentTemplate) string {
+	for {
+		w.spawned++
+		id := fmt.Sprintf("%s-%d", t.Name, w.spawned)
+		if _, exists := w.index.byID[id]; !exists {
+			return id
+		}
+	}
+}
+
+// lifecycle spawns the agents requested by the rules that fired in rr on
+// behalf of parent, posts their offers to the market and moves parent. It
+// reports whether any of the rules destroy the agent and returns the failures,
+// carrying on past each one.
+func (w *World) lifecycle(parent *Agent, rr *RunReport) (bool, []error) {
+	destroy := false
+	var errs []error
+	fail := func(err error) {
+		errs = append(errs, err)
+	}
+	var visit func(res *RuleResult)
+	visit = func(res *RuleResult) {
+		if res == nil {
+			return
+		}
+		if res.Fired() {
+			destroy = destroy || res.Rule.Destroy
+			for _, s := range res.Rule.Spawns {
+				t, ok := w.templates[s.Template]
+				if !ok {
+					fail(fmt.Errorf("rule %q: unknown template %q", res.Rule.Name, s.Template))
+					continue
+				}
+				for n := s.Count * res.Rounds; n > 0; n-- {
+					child := t.Spawn(w.spawnID(t))
+					if err := w.AddAgent(child); err != nil {
+						fail(err)
+						break
+					}
+					for _, fn := range w.onSpawn {
+						fn(parent, child)
+					}
+				}
+			}
+			if len(res.Rule.Offers) > 0 && (w.Market == nil || parent == nil) {
+				fail(fmt.Errorf("rule %q: offers need an agent and a world with a market", res.Rule.Name))
+			} else {
+				for _, s := range res.Rule.Offers {
+					w.Market.Post(Offer{
+						Agent:    parent,
+						Sell:     s.Sell,
+						Resource: s.Resource,
+						Quantity: s.Quantity * res.Rounds,
+						Price:    s.Price,
+						Currency: s.Currency,
+					})
+				}
+			}
+			if len(res.Rule.Ships) > 0 {
+				if err := w.ship(parent, res.Rule, res.Rounds); err != nil {
+					fail(fmt.Errorf("rule %q: %w", res.Rule.Name, err))
+				}
+			}
+			if res.Rule.Move != "" && (parent == nil || parent.Journey == nil) {
+				l, err := w.destination(parent, res.Rule.Move)
+				if err == nil {
+					err = w.travel(parent, l)
+				}
+				if err != nil {
+					fail(fmt.Errorf("rule %q: %w", res.Rule.Name, err))
+				}
+			}
+		}
+		visit(res.OnFail)
+	}
+	for _, res := range rr.Results {
+		visit(res)
+	}
+	return destroy && parent != nil, errs
+}
@@ -0,0 +1,260 @@
+package rula
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWorld(t *testing.T) {
+	spec := `
+resource iron_ore
+end
+
+resource iron
+end
+
+rule mine
+	in global iron_ore 1
+	out iron_ore 1
+end
+
+rule smelt
+	every 2
+	in iron_ore 2
+	out location iron 1
+end
+
+global world
+	pool iron_ore 1000 500
+end
+
+agent smelter
+	pool iron_ore 10
+	relation location town
+	rules mine smelt
+end
+
+location town
+	pool iron 100
+end
+`
+
+	sc, err := NewScenarioParser().Parse(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ore, iron := sc.Resources[0], sc.Resources[1]
+
+	w := NewScenarioWorld(sc)
+	for i := 0; i < 4; i++ {
+		if _, err := w.Tick(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if w.Time() != 4 {
+		t.Errorf("got time %d, wanted 4", w.Time())
+	}
+	if got := w.Pools().Quantity(ore); got != 496 {
+		t.Errorf("got global iron_ore %d, wanted 496", got)
+	}
+	town := w.Agents()[1]
+	if got := town.Pools.Quantity(iron); got != 2 {
+		t.Errorf("got town iron %d, wanted 2", got)
+	}
+}
+
+func TestWorldSpawnDestroy(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	rules, err := NewRuleParser([]*Resource{food}).Parse(strings.NewReader(`
+rule breed
+	if food >= 4
+	in food 4
+	spawn rabbit 2
+end
+
+rule starve
+	if food = 0
+	destroy self
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rabbit := NewAgentTemplate("rabbit")
+	rabbit.Pools.AddPool(food, 10, 0)
+	rabbit.Rules = rules
+
+	w := NewWorld(nil)
+	w.AddTemplate(rabbit)
+	var spawned, destroyed []string
+	w.OnSpawn(func(parent, child *Agent) {
+		spawned = append(spawned, parent.Name.Singular+">"+child.Name.Singular)
+	})
+	w.OnDestroy(func(a *Agent) {
+		destroyed = append(destroyed, a.Name.Singular)
+	})
+
+	doe := rabbit.Spawn("doe")
+	doe.Pools.Set(food, 5)
+	w.AddAgent(doe)
+
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"doe>rabbit-1", "doe>rabbit-2"}; strings.Join(spawned, " ") != strings.Join(want, " ") {
+		t.Errorf("got spawned %v, wanted %v", spawned, want)
+	}
+	if got := len(w.Agents()); got != 3 {
+		t.Errorf("got %d agents, wanted 3", got)
+	}
+
+	// The young rabbits have no food and starve on the next tick
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"rabbit-1", "rabbit-2"}; strings.Join(destroyed, " ") != strings.Join(want, " ") {
+		t.Errorf("got destroyed %v, wanted %v", destroyed, want)
+	}
+	if agents := w.Agents(); len(agents) != 1 || agents[0] != doe {
+		t.Errorf("got %d agents, wanted only doe", len(agents))
+	}
+}
+
+func TestWorldAgentIDs(t *testing.T) {
+	w := NewWorld(nil)
+	smith := NewAgent("smith")
+	if err := w.AddAgent(smith); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := w.AddAgent(NewAgent("smith")); err == nil {
+		t.Errorf("got no error adding a duplicate agent id")
+	}
+
+	unnamed := &Agent{Name: Name{Singular: "miller"}, Pools: NewPoolSet()}
+	if err := w.AddAgent(unnamed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if unnamed.ID != "miller" {
+		t.Errorf("got id %q, wanted miller", unnamed.ID)
+	}
+
+	for _, id := range []string{"smith", "miller"} {
+		if a, ok := w.Agent(id); !ok || a.ID != id {
+			t.Errorf("agent %q not found", id)
+		}
+	}
+	if got := len(w.Agents()); got != 2 {
+		t.Errorf("got %d agents, wanted 2", got)
+	}
+}
+
+func TestWorldMove(t *testing.T) {
+	food := &Resource{ID: "food", Name: Name{Singular: "food"}}
+	rules, err := NewRuleParser([]*Resource{food}).Parse(strings.NewReader(`
+rule forage
+	in location food 1
+	onfail migrate
+end
+
+rule migrate
+	every 0
+	move valley
+end
+
+rule follow
+	move leader
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := NewNetwork()
+	hills, valley := n.AddLocation(Position{}), n.AddLocation(Position{})
+	valley.Pools().AddPool(food, 100, 100)
+	w := NewWorld(nil)
+	w.AddLocation("valley", valley)
+
+	leader := NewAgent("leader")
+	leader.AppendRules(rules[:1])
+	leader.MoveTo(hills)
+	follower := NewAgent("follower")
+	follower.AddRelation("leader", leader)
+	follower.AppendRules(rules[2:])
+	w.AddAgent(leader)
+	w.AddAgent(follower)
+
+	// The leader finds no food in the hills so migrates to the valley.
+	// Agents move in order so the follower follows in the same tick.
+	if _, err := w.Tick(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if leader.Location != valley || follower.Location != valley {
+		t.Errorf("got leader at %v and follower at %v, wanted both in valley", leader.Location, follower.Location)
+	}
+	if got := hills.Occupants(); len(got) != 0 {
+		t.Errorf("got %d agents in hills, wanted none", len(got))
+	}
+	if got := valley.Occupants(); len(got) != 2 {
+		t.Errorf("got %d agents in valley, wanted 2", len(got))
+	}
+
+	follower.AddRelation("leader", NewAgent("nomad"))
+	if _, err := w.Tick(); err == nil {
+		t.Errorf("got no error following an agent without a location, wanted one")
+	}
+}
+
+func TestWorldGlobalMove(t *testing.T) {
+	rules, err := NewRuleParser(nil).Parse(strings.NewReader("rule wander\n\tmove valley\nend\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := NewWorld(NewGlobal(rules))
+	if _, err := w.Tick(); err == nil || !strings.Contains(err.Error(), "only agents can move") {
+		t.Errorf("got error %v, wanted only agents can move", err)
+	}
+}
+
+func TestWorldLifecycleErrors(t *testing.T) {
+	food := &Resource{Name: Name{Singular: "food"}}
+	rules, err := NewRuleParser([]*Resource{food}).Parse(strings.NewReader(`
+rule conjure
+	spawn unicorn 1
+end
+
+rule breed
+	in food 4
+	spawn rabbit 1
+end
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conjure, breed := rules[0], rules[1]
+
+	rabbit := NewAgentTemplate("rabbit")
+	w := NewWorld(nil)
+	w.AddTemplate(rabbit)
+
+	wizard := NewAgent("wizard")
+	wizard.AppendRules([]*Rule{conjure})
+	w.AddAgent(wizard)
+	doe := NewAgent("doe")
+	doe.Pools.AddPool(food, 10, 5)
+	doe.AppendRules([]*Rule{breed})
+	w.AddAgent(doe)
+
+	// The wizard's failure does not stop the doe's spawn
+	_, err = w.Tick()
+	var terr *TickError
+	if !errors.As(err, &terr) || len(terr.Errs) != 1 || !strings.Contains(err.Error(), `unknown template "unicorn"`) {
+		t.Errorf("got error %v, wanted a TickError for the unknown template", err)
+	}
+	if got := len(w.Agents()); got != 3 {
+		t.Errorf("got %d agents, wanted the doe's offspring to be added", got)
+	}
+}
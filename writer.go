@@ -0,0 +1,69 @@
+package rula
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/iand/loon"
+)
+
+// A ResourceWriter writes resources in the syntax read by ResourceParser.
+type ResourceWriter struct{}
+
+func NewResourceWriter() *ResourceWriter {
+	return &ResourceWriter{}
+}
+
+func (w *ResourceWriter) Write(out io.Writer, resources []*Resource) error {
+	doc := &loon.Doc{Version: 1}
+	for _, r := range resources {
+		doc.Objects = append(doc.Objects, resourceObject(r))
+	}
+	_, err := out.Write(loon.Print(doc))
+	return err
+}
+
+// resourceObject returns the loon object that declares r. Directives are
+// omitted when they would repeat the parser's defaults. A resource without an
+// ID is declared using its singular name.
+func resourceObject(r *Resource) loon.Object {
+	id := r.ID
+	if id == "" {
+		id = r.Name.Singular
+	}
+
+	obj := loon.Object{
+		Type: "resource",
+		Name: id,
+	}
+	if r.Event {
+		obj.Type = "event"
+	} else if r.Currency {
+		obj.Type = "currency"
+	}
+
+	if r.Name.Singular != id {
+		obj.Directives = append(obj.Directives, directive("singular", r.Name.Singular))
+	}
+	if r.Name.Plural != id && r.Name.Plural != "" {
+		obj.Directives = append(obj.Directives, directive("plural", r.Name.Plural))
+	}
+	if r.Decay != 0 {
+		obj.Directives = append(obj.Directives, directive("decay", strconv.Itoa(r.Decay)))
+	}
+	if r.Regen != 0 {
+		obj.Directives = append(obj.Directives, directive("regen", strconv.Itoa(r.Regen)))
+	}
+	if r.Weight != 0 {
+		obj.Directives = append(obj.Directives, directive("weight", strconv.Itoa(r.Weight)))
+	}
+	if r.Volume != 0 {
+		obj.Directives = append(obj.Directives, directive("volume", strconv.Itoa(r.Volume)))
+	}
+
+	return obj
+}
+
+func directive(name string, argText string) loon.Directive {
+	return loon.Directive{Name: name, ArgText: argText}
+}
@@ -0,0 +1,55 @@
+package rula
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResourceWriterRoundtrip(t *testing.T) {
+	spec := `resource iron_ore
+	singular Iron Ore
+	plural Iron Ores
+end
+
+resource workers
+	singular worker
+end
+
+resource food
+	decay 2
+	regen 1
+end
+
+event alarm
+end
+
+currency gold
+end
+`
+
+	resources, err := NewResourceParser().Parse(strings.NewReader(spec))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := NewResourceWriter().Write(&buf, resources); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+
+	if diff := cmp.Diff(strings.TrimSpace(spec), strings.TrimSpace(buf.String())); diff != "" {
+		t.Errorf("Write() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := NewResourceParser().Parse(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(resources, got); diff != "" {
+		t.Errorf("round trip mismatch (-want +got):\n%s", diff)
+	}
+}